@@ -1,12 +1,14 @@
 package api
 
 import (
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"project-bee/core"
+	"project-bee/crypto"
 	"project-bee/types"
 
 	"github.com/go-kit/log"
@@ -18,19 +20,113 @@ type TxResponse struct {
 	Hashes  []string
 }
 
+type TxProof struct {
+	TxHash    string
+	BlockHash string
+	Height    uint32
+	DataHash  string
+	Index     int
+	Total     int
+	Proof     []string
+}
+
+type Receipt struct {
+	TxHash      string
+	Status      string
+	Code        string
+	Error       string
+	BlockHeight uint32
+	Index       uint32
+	Fee         uint64
+	StorageKeys []string
+}
+
+// Account 中的 Nonce 是账户下一笔交易应该使用的 nonce, Bonded 和 Unbonding 不包括在 Balance 中
+type Account struct {
+	Address   string
+	Balance   uint64
+	Nonce     uint64
+	Bonded    uint64
+	Unbonding uint64
+}
+
+type Delegation struct {
+	Delegator string
+	Amount    uint64
+}
+
+type ValidatorStake struct {
+	Validator   string
+	SelfStake   uint64
+	TotalStake  uint64
+	Delegations []Delegation
+}
+
+type Supply struct {
+	Supply      uint64
+	MaxSupply   uint64
+	BlockReward uint64
+}
+
+// ValidatorSet 是出高度为 Height 的区块的验证者, Validators 为压缩公钥(hex)
+type ValidatorSet struct {
+	Height      uint32
+	Epoch       uint32
+	EpochLength uint32
+	Validators  []string
+}
+
+type ValidatorProposal struct {
+	Hash            string
+	Action          string
+	Validator       string
+	Proposer        string
+	Height          uint32
+	Approvals       []string
+	Rejections      []string
+	Status          string
+	EffectiveHeight uint32
+	ExpiryHeight    uint32
+}
+
+// AddressTx 是地址参与的一笔交易, 从最新的交易开始排列
+type AddressTx struct {
+	Hash        string
+	BlockHeight uint32
+	Index       uint32
+	Status      string
+	Sent        bool
+	Received    bool
+	Minted      bool
+}
+
+type AddressTxs struct {
+	Address string
+	Total   int
+	Offset  int
+	Limit   int
+	Txs     []AddressTx
+}
+
 type APIError struct {
 	Error string
 }
 
 type Block struct {
-	Hash          string
-	Version       uint32
-	DataHash      string
-	PrevBlockHash string
-	Height        uint32
-	Timestamp     int64
-	Validator     string
-	Signature     string
+	Hash           string
+	Version        uint32
+	DataHash       string
+	StateRoot      string
+	ReceiptsRoot   string
+	PrevBlockHash  string
+	ValidatorsHash string
+	Height         uint32
+	Round          uint32
+	Difficulty     uint64
+	Nonce          uint64
+	Timestamp      int64
+	Validator      string
+	Signature      string
 
 	TxResponse TxResponse
 }
@@ -38,8 +134,15 @@ type Block struct {
 type ServerConfig struct {
 	Logger     log.Logger
 	ListenAddr string
+	// Indexer 为 nil 时不提供地址的交易历史
+	Indexer *core.TxIndexer
 }
 
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
 type Server struct {
 	txChan chan *core.Transaction
 	ServerConfig
@@ -59,6 +162,14 @@ func (s *Server) Start() error {
 
 	e.GET("/block/:hashorid", s.handleGetBlock)
 	e.GET("/tx/:hash", s.handleGetTx)
+	e.GET("/tx/:hash/proof", s.handleGetTxProof)
+	e.GET("/receipt/:hash", s.handleGetReceipt)
+	e.GET("/account/:addr", s.handleGetAccount)
+	e.GET("/account/:addr/txs", s.handleGetAccountTxs)
+	e.GET("/supply", s.handleGetSupply)
+	e.GET("/validators/:height", s.handleGetValidators)
+	e.GET("/proposal/:hash", s.handleGetProposal)
+	e.GET("/stake/:addr", s.handleGetStake)
 	e.POST("/tx", s.handlePostTx)
 
 	return e.Start(s.ListenAddr)
@@ -66,7 +177,7 @@ func (s *Server) Start() error {
 
 func (s *Server) handlePostTx(c echo.Context) error {
 	tx := &core.Transaction{}
-	if err := gob.NewDecoder(c.Request().Body).Decode(tx); err != nil {
+	if err := tx.Decode(core.NewBinaryTxDecoder(c.Request().Body)); err != nil {
 		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
 	}
 	s.txChan <- tx
@@ -90,6 +201,184 @@ func (s *Server) handleGetTx(c echo.Context) error {
 	return c.JSON(http.StatusOK, tx)
 }
 
+func (s *Server) handleGetTxProof(c echo.Context) error {
+	hash := c.Param("hash")
+
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid tx hash"})
+	}
+
+	proof, err := s.bc.GetTxProof(types.HashFromBytes(b))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, intoJSONTxProof(proof))
+}
+
+func (s *Server) handleGetReceipt(c echo.Context) error {
+	hash := c.Param("hash")
+
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid tx hash"})
+	}
+
+	receipt, err := s.bc.GetReceipt(types.HashFromBytes(b))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, intoJSONReceipt(receipt))
+}
+
+func (s *Server) handleGetAccount(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("addr"))
+	if err != nil || len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid address"})
+	}
+
+	address := types.AddressFromBytes(b)
+	account, err := s.bc.GetAccount(address)
+	if errors.Is(err, core.ErrAccountNotFound) {
+		// 没有上链记录的账户从 nonce 0 开始
+		account = &core.Account{Address: address}
+	} else if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	bonded, unbonding := s.bc.Bonded(address)
+
+	return c.JSON(http.StatusOK, Account{
+		Address:   account.Address.String(),
+		Balance:   account.Balance,
+		Nonce:     account.Nonce,
+		Bonded:    bonded,
+		Unbonding: unbonding,
+	})
+}
+
+// handleGetAccountTxs 分页返回地址的交易历史, 参数 offset 和 limit 可选
+func (s *Server) handleGetAccountTxs(c echo.Context) error {
+	if s.Indexer == nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: "tx history not indexed"})
+	}
+
+	b, err := hex.DecodeString(c.Param("addr"))
+	if err != nil || len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid address"})
+	}
+
+	offset, limit := 0, defaultHistoryLimit
+	if v := c.QueryParam("offset"); len(v) > 0 {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, APIError{Error: "invalid offset"})
+		}
+	}
+	if v := c.QueryParam("limit"); len(v) > 0 {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxHistoryLimit {
+			return c.JSON(http.StatusBadRequest, APIError{Error: fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)})
+		}
+	}
+
+	address := types.AddressFromBytes(b)
+	txs, total := s.Indexer.AddressTxs(address, offset, limit)
+
+	resp := AddressTxs{
+		Address: address.String(),
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Txs:     make([]AddressTx, len(txs)),
+	}
+	for i, tx := range txs {
+		resp.Txs[i] = AddressTx{
+			Hash:        tx.Hash.String(),
+			BlockHeight: tx.BlockHeight,
+			Index:       tx.Index,
+			Status:      tx.Status.String(),
+			Sent:        tx.Sent,
+			Received:    tx.Received,
+			Minted:      tx.Minted,
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) handleGetSupply(c echo.Context) error {
+	return c.JSON(http.StatusOK, Supply{
+		Supply:      s.bc.Supply(),
+		MaxSupply:   s.bc.MaxSupply(),
+		BlockReward: s.bc.BlockReward(),
+	})
+}
+
+func (s *Server) handleGetValidators(c echo.Context) error {
+	height, err := strconv.ParseUint(c.Param("height"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid height"})
+	}
+
+	// 只有下一个区块之前的验证者集合是确定的
+	if uint32(height) > s.bc.Height()+1 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: fmt.Sprintf("given height (%d) too high", height)})
+	}
+
+	validators := s.bc.ValidatorsAt(uint32(height))
+	set := ValidatorSet{
+		Height:      uint32(height),
+		Epoch:       uint32(height) / s.bc.EpochLength(),
+		EpochLength: s.bc.EpochLength(),
+		Validators:  make([]string, len(validators)),
+	}
+	for i, v := range validators {
+		set.Validators[i] = v.String()
+	}
+
+	return c.JSON(http.StatusOK, set)
+}
+
+func (s *Server) handleGetProposal(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("hash"))
+	if err != nil || len(b) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid proposal hash"})
+	}
+
+	hash := types.HashFromBytes(b)
+	p, err := s.bc.GetValidatorProposal(hash)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, intoJSONProposal(hash, p))
+}
+
+// handleGetStake 返回验证者地址名下的抵押和委托
+func (s *Server) handleGetStake(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("addr"))
+	if err != nil || len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid address"})
+	}
+
+	stake := s.bc.ValidatorStake(types.AddressFromBytes(b))
+	resp := ValidatorStake{
+		Validator:   stake.Validator.String(),
+		SelfStake:   stake.SelfStake,
+		TotalStake:  stake.TotalStake,
+		Delegations: make([]Delegation, len(stake.Delegations)),
+	}
+	for i, d := range stake.Delegations {
+		resp.Delegations[i] = Delegation{
+			Delegator: d.Delegator.String(),
+			Amount:    d.Amount,
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
 func (s *Server) handleGetBlock(c echo.Context) error {
 	hashOrID := c.Param("hashorid")
 
@@ -128,15 +417,88 @@ func intoJSONBlock(block *core.Block) Block {
 		txResponse.Hashes[i] = block.Transactions[i].Hash(core.TxHasher{}).String()
 	}
 
+	// 创世区块没有签名和验证者, 这两个字段留空
+	var validator, signature string
+	if len(block.Validator) > 0 {
+		validator = block.Validator.Address().String()
+	}
+	if block.Signature != nil {
+		signature = block.Signature.String()
+	}
+
 	return Block{
-		Hash:          block.Hash(core.BlockHasher{}).String(),
-		Version:       block.Header.Version,
-		Height:        block.Header.Height,
-		DataHash:      block.Header.DataHash.String(),
-		PrevBlockHash: block.Header.PrevBlockHash.String(),
-		Timestamp:     block.Header.Timestamp,
-		Validator:     block.Validator.Address().String(),
-		Signature:     block.Signature.String(),
-		TxResponse:    txResponse,
+		Hash:           block.Hash(core.BlockHasher{}).String(),
+		Version:        block.Header.Version,
+		Height:         block.Header.Height,
+		Round:          block.Header.Round,
+		Difficulty:     block.Header.Difficulty,
+		Nonce:          block.Header.Nonce,
+		DataHash:       block.Header.DataHash.String(),
+		StateRoot:      block.Header.StateRoot.String(),
+		ReceiptsRoot:   block.Header.ReceiptsRoot.String(),
+		PrevBlockHash:  block.Header.PrevBlockHash.String(),
+		ValidatorsHash: block.Header.ValidatorsHash.String(),
+		Timestamp:      block.Header.Timestamp,
+		Validator:      validator,
+		Signature:      signature,
+		TxResponse:     txResponse,
+	}
+}
+
+func intoJSONTxProof(proof *core.TxProof) TxProof {
+	hashes := make([]string, len(proof.Proof.Hashes))
+	for i, h := range proof.Proof.Hashes {
+		hashes[i] = h.String()
+	}
+
+	return TxProof{
+		TxHash:    proof.TxHash.String(),
+		BlockHash: proof.BlockHash.String(),
+		Height:    proof.Header.Height,
+		DataHash:  proof.Header.DataHash.String(),
+		Index:     proof.Proof.Index,
+		Total:     proof.Proof.Total,
+		Proof:     hashes,
+	}
+}
+
+func intoJSONReceipt(receipt *core.Receipt) Receipt {
+	keys := make([]string, len(receipt.StorageKeys))
+	for i, k := range receipt.StorageKeys {
+		keys[i] = hex.EncodeToString(k)
+	}
+
+	return Receipt{
+		TxHash:      receipt.TxHash.String(),
+		Status:      receipt.Status.String(),
+		Code:        receipt.Code.String(),
+		Error:       receipt.Error,
+		BlockHeight: receipt.BlockHeight,
+		Index:       receipt.Index,
+		Fee:         receipt.Fee,
+		StorageKeys: keys,
+	}
+}
+
+func intoJSONProposal(hash types.Hash, p *core.ValidatorProposal) ValidatorProposal {
+	keys := func(ks []crypto.PublicKey) []string {
+		out := make([]string, len(ks))
+		for i, k := range ks {
+			out[i] = k.String()
+		}
+		return out
+	}
+
+	return ValidatorProposal{
+		Hash:            hash.String(),
+		Action:          p.Action.String(),
+		Validator:       p.Validator.String(),
+		Proposer:        p.Proposer.String(),
+		Height:          p.Height,
+		Approvals:       keys(p.Approvals),
+		Rejections:      keys(p.Rejections),
+		Status:          p.Status.String(),
+		EffectiveHeight: p.EffectiveHeight,
+		ExpiryHeight:    p.ExpiryHeight,
 	}
 }
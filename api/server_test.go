@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"project-bee/core"
+	"project-bee/crypto"
+
+	"github.com/go-kit/log"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGenesisBlock(t *testing.T) {
+	validator := crypto.GeneratePrivateKey().PublicKey()
+	g := &core.Genesis{Validators: []string{validator.String()}}
+	bc, err := core.NewBlockchainFromGenesis(log.NewNopLogger(), core.NewMemorystore(), g)
+	assert.Nil(t, err)
+
+	s := NewServer(ServerConfig{Logger: log.NewNopLogger()}, bc, make(chan *core.Transaction))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/block/0", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/block/:hashorid")
+	c.SetParamNames("hashorid")
+	c.SetParamValues("0")
+
+	assert.Nil(t, s.handleGetBlock(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// 创世区块没有签名, 验证者和签名为空
+	var block Block
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &block))
+	assert.Equal(t, uint32(0), block.Height)
+	assert.Empty(t, block.Validator)
+	assert.Empty(t, block.Signature)
+
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash(core.BlockHasher{}).String(), block.Hash)
+}
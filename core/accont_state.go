@@ -16,6 +16,8 @@ var (
 type Account struct {
 	Address types.Address
 	Balance uint64
+	// 下一笔交易的 nonce, 即账户已经执行过的交易数量
+	Nonce uint64
 }
 
 func (a *Account) String() string {
@@ -25,6 +27,8 @@ func (a *Account) String() string {
 type AccountState struct {
 	mu       sync.RWMutex
 	accounts map[types.Address]*Account
+	// 不为 nil 时记录每次账户修改之前的值
+	journal *journal
 }
 
 func NewAccountState() *AccountState {
@@ -37,11 +41,44 @@ func (s *AccountState) CreateAccount(address types.Address) *Account {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.record(address)
+
 	acc := &Account{Address: address}
 	s.accounts[address] = acc
 	return acc
 }
 
+// 记录账户修改之前的拷贝, 调用者需要持有 mu
+func (s *AccountState) record(address types.Address) {
+	if s.journal == nil {
+		return
+	}
+
+	var prev *Account
+	if acc, ok := s.accounts[address]; ok {
+		cp := *acc
+		prev = &cp
+	}
+
+	s.journal.append(accountChange{address: address, prev: prev})
+}
+
+// setAccount 用 acc 的拷贝替换账户, acc 为 nil 时删除账户
+func (s *AccountState) setAccount(address types.Address, acc *Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record(address)
+
+	if acc == nil {
+		delete(s.accounts, address)
+		return
+	}
+
+	cp := *acc
+	s.accounts[address] = &cp
+}
+
 func (s *AccountState) GetAccount(address types.Address) (*Account, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -57,6 +94,48 @@ func (s *AccountState) getAccountWithoutLock(address types.Address) (*Account, e
 	return account, nil
 }
 
+// Account 返回账户的拷贝, 账户不存在时返回余额和 nonce 都为 0 的账户
+func (s *AccountState) Account(address types.Address) *Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acc, ok := s.accounts[address]
+	if !ok {
+		return &Account{Address: address}
+	}
+
+	cp := *acc
+	return &cp
+}
+
+// Nonce 返回账户下一笔交易的 nonce, 账户不存在时为 0
+func (s *AccountState) Nonce(address types.Address) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acc, ok := s.accounts[address]
+	if !ok {
+		return 0
+	}
+
+	return acc.Nonce
+}
+
+// IncrementNonce 账户不存在时自动创建
+func (s *AccountState) IncrementNonce(address types.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record(address)
+
+	acc, ok := s.accounts[address]
+	if !ok {
+		acc = &Account{Address: address}
+		s.accounts[address] = acc
+	}
+	acc.Nonce++
+}
+
 func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -69,6 +148,43 @@ func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
 	return balance.Balance, nil
 }
 
+// AddBalance 给账户增加余额, 账户不存在时自动创建
+func (s *AccountState) AddBalance(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record(address)
+
+	acc, ok := s.accounts[address]
+	if !ok {
+		acc = &Account{Address: address}
+		s.accounts[address] = acc
+	}
+
+	if acc.Balance+amount < acc.Balance {
+		return fmt.Errorf("balance overflow for account (%s)", address)
+	}
+	acc.Balance += amount
+
+	return nil
+}
+
+// SubBalance 从账户扣除余额, 余额不足时不修改账户
+func (s *AccountState) SubBalance(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[address]
+	if !ok || acc.Balance < amount {
+		return ErrInsufficientBalance
+	}
+
+	s.record(address)
+	acc.Balance -= amount
+
+	return nil
+}
+
 func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -78,15 +194,14 @@ func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 		return err
 	}
 
-	if fromAccount.Address.String() != "996fb92427ae41e4649b934ca495991b7852b855" {
-		if fromAccount.Balance < amount {
-			return ErrInsufficientBalance
-		}
+	if fromAccount.Balance < amount {
+		return ErrInsufficientBalance
 	}
 
-	if fromAccount.Balance != 0 {
-		fromAccount.Balance -= amount
-	}
+	s.record(from)
+	s.record(to)
+
+	fromAccount.Balance -= amount
 
 	if s.accounts[to] == nil {
 		s.accounts[to] = &Account{
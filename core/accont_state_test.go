@@ -54,3 +54,15 @@ func TestTransferSuccessEmpyToAccount(t *testing.T) {
 	assert.Equal(t, accountAlice.Balance, amount)
 	assert.Equal(t, accountBob.Balance, uint64(0))
 }
+
+func TestTransferFromEmptyAccount(t *testing.T) {
+	state := NewAccountState()
+
+	addressFrom := crypto.GeneratePrivateKey().PublicKey().Address()
+	addressTo := crypto.GeneratePrivateKey().PublicKey().Address()
+	state.CreateAccount(addressFrom)
+
+	assert.ErrorIs(t, state.Transfer(addressFrom, addressTo, 1), ErrInsufficientBalance)
+	_, err := state.GetAccount(addressTo)
+	assert.Equal(t, ErrAccountNotFound, err)
+}
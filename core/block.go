@@ -2,8 +2,6 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
 	"fmt"
 	"time"
 
@@ -11,19 +9,32 @@ import (
 	"project-bee/types"
 )
 
+// StateRoot 是执行完区块交易之后的状态树根, ReceiptsRoot 是交易执行结果的 Merkle 根
+// 区块中所有交易的 ChainID 必须与区块头相同
 type Header struct {
 	Version       uint32
+	ChainID       uint32
 	DataHash      types.Hash
+	StateRoot     types.Hash
+	ReceiptsRoot  types.Hash
 	PrevBlockHash types.Hash
 	Height        uint32
 	Timestamp     int64
+	// Round 是区块被提议时的共识轮次, 决定这个区块的出块者
+	Round uint32
+	// PoW 区块的难度和满足难度的 Nonce, PoA 区块都为 0
+	Difficulty uint64
+	Nonce      uint64
+	// ValidatorsHash 是执行完区块之后出下一个区块的验证者集合的 hash, 轻节点用它跟踪验证者集合的变化
+	ValidatorsHash types.Hash
 }
 
-// header 头序列化成2进制[]byte
+// header 头序列化成2进制[]byte, 是计算区块 hash 的规范编码
 func (h *Header) Bytes() []byte {
 	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	w := newCodecWriter(buf)
+	w.u8(CodecVersion)
+	encodeHeader(w, h)
 
 	return buf.Bytes()
 }
@@ -54,6 +65,7 @@ func NewBlockFromPrevHeader(prevHeader *Header, txs []*Transaction) (*Block, err
 
 	header := &Header{
 		Version:       1,
+		ChainID:       prevHeader.ChainID,
 		Height:        prevHeader.Height + 1, // 新高度
 		DataHash:      dataHash,
 		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
@@ -70,7 +82,7 @@ func (b *Block) AddTransaction(tx *Transaction) {
 }
 
 func (b *Block) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+	sig, err := privKey.Sign(signingHash(blockSigningDomain, BlockHasher{}.Hash(b.Header)))
 	if err != nil {
 		return err
 	}
@@ -87,12 +99,12 @@ func (b *Block) Verify() error {
 		return fmt.Errorf("block has no signature")
 	}
 
-	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
+	if !b.Signature.Verify(b.Validator, signingHash(blockSigningDomain, BlockHasher{}.Hash(b.Header))) {
 		return fmt.Errorf("block has invalid signature")
 	}
 
 	for _, tx := range b.Transactions {
-		if err := tx.Verify(); err != nil {
+		if err := tx.Verify(b.ChainID); err != nil {
 			return err
 		}
 	}
@@ -103,8 +115,6 @@ func (b *Block) Verify() error {
 		return err
 	}
 	if dataHash != b.DataHash {
-		fmt.Printf("dataHash: %v", dataHash)
-		fmt.Printf("b.DataHash: %v", b.DataHash)
 		return fmt.Errorf("block (%s) has an invalid data hash", b.Hash(BlockHasher{}))
 	}
 
@@ -127,14 +137,34 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 
 	return b.hash
 }
-func CalculateDataHash(txs []*Transaction) (hash types.Hash, err error) {
-	buf := &bytes.Buffer{}
 
-	for _, tx := range txs {
-		if err = tx.Encode(NewGobTxEncoder(buf)); err != nil {
-			return
-		}
+// CalculateDataHash 以交易 hash 为叶子计算 Merkle 根, 交易 hash 包括 TxInner 的内容
+func CalculateDataHash(txs []*Transaction) (hash types.Hash, err error) {
+	leaves := make([]types.Hash, len(txs))
+	for i, tx := range txs {
+		leaves[i] = tx.Hash(TxHasher{})
 	}
-	hash = sha256.Sum256(buf.Bytes())
+
+	hash = MerkleRoot(leaves)
 	return
-}
\ No newline at end of file
+}
+
+// NewTxProof 生成第 index 个交易包含在区块中的证明
+func (b *Block) NewTxProof(index int) (*TxProof, error) {
+	leaves := make([]types.Hash, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		leaves[i] = tx.Hash(TxHasher{})
+	}
+
+	proof, err := NewMerkleProof(leaves, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxProof{
+		TxHash:    leaves[index],
+		BlockHash: b.Hash(BlockHasher{}),
+		Header:    b.Header,
+		Proof:     proof,
+	}, nil
+}
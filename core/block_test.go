@@ -42,22 +42,45 @@ func TestVerifyBlock(t *testing.T) {
 	assert.NotNil(t, b.Verify())
 }
 
+func TestBlockSignatureDomain(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 0, types.Hash{})
+
+	// 用交易签名的前缀签区块头, 不能通过区块签名校验
+	sig, err := privKey.Sign(signingHash(txSigningDomain, BlockHasher{}.Hash(b.Header)))
+	assert.Nil(t, err)
+	b.Validator = privKey.PublicKey()
+	b.Signature = sig
+	assert.NotNil(t, b.Verify())
+}
+
+func TestVerifyBlockTxChainID(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 0, types.Hash{})
+	b.ChainID = 1
+	b.hash = types.Hash{}
+	assert.Nil(t, b.Sign(privKey))
+
+	assert.ErrorIs(t, b.Verify(), ErrInvalidChainID)
+}
+
 // 对区块解码编码
 func TestDecodeEncodeBlock(t *testing.T) {
 	b := randomBlock(t, 1, types.Hash{})
 	buf := &bytes.Buffer{}
-	assert.Nil(t, b.Encode(NewGobBlockEncoder(buf))) // 编码
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(buf))) // 编码
 
 	bDecode := new(Block)
-	assert.Nil(t, bDecode.Decode(NewGobBlockDecoder(buf))) // 解码
-
-	assert.Equal(t, b, bDecode) // 结果判断相同
+	assert.Nil(t, bDecode.Decode(NewBinaryBlockDecoder(buf))) // 解码
 
+	// hash 缓存不参与编码
 	for i := 0; i < len(b.Transactions); i++ {
 		b.Transactions[i].hash = types.Hash{}
 		assert.Equal(t, b.Transactions[i], bDecode.Transactions[i])
 	}
 
+	assert.Equal(t, b, bDecode) // 结果判断相同
+
 	assert.Equal(t, b.Validator, bDecode.Validator)
 	assert.Equal(t, b.Signature, bDecode.Signature)
 }
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
@@ -19,6 +20,20 @@ type Blockchain struct {
 	blocks     []*Block
 	txStore    map[types.Hash]*Transaction
 	blockStore map[types.Hash]*Block
+	// tx hash => 包含该交易的区块 hash
+	txBlocks map[types.Hash]types.Hash
+	// 只保留最近 pruneDepth 个区块的区块体, retainFrom 是保留区块体的最低高度
+	pruneDepth uint32
+	retainFrom uint32
+	// 区块树, 包括侧链上的区块; tip 是规范链的最高区块
+	nodes map[types.Hash]*blockNode
+	tip   *blockNode
+	// 最高的最终区块, 只能在它之后出块和重组
+	finalized    *blockNode
+	reorgHandler func(dropped []*Transaction)
+	// 轮次由已经校验的 BFT 提议或者证书确定的区块, 值是提议或者证书的轮次
+	certified map[types.Hash]uint32
+	listeners []BlockListener
 
 	accountState *AccountState
 
@@ -26,44 +41,328 @@ type Blockchain struct {
 	collectionState map[types.Hash]*CollectionTx
 	mintState       map[types.Hash]*MintTx
 	validator       Validator
+	engine          ConsensusEngine
+	// 验证者集合的历史, 按生效高度排序, 第一个来自创世配置
+	validatorLock sync.RWMutex
+	validatorSets []validatorSet
+	proposals     map[types.Hash]*ValidatorProposal
+	epochLength   uint32
+	// 抵押和解绑中的余额, 解绑按 ReleaseHeight 排序
+	bonds           map[bondKey]uint64
+	unbonding       []Unbonding
+	unbondingPeriod uint32
+	// 已经因为双签被罚没的验证者和高度
+	slashed       map[slashKey]bool
+	slashFraction uint64
+	signGuard     *SignGuard
 	// TODO: make this an interface.
 	contractState *State
+	// 来自创世区块, 所有区块和交易必须使用相同的 ChainID
+	chainID uint32
+	// 已经发行的代币总量, 只能通过创世分配和出块奖励增加
+	supply      uint64
+	maxSupply   uint64
+	blockReward uint64
+	// 规范链上每个区块的撤销日志, journal 是当前正在记录的日志
+	journals map[types.Hash]*journal
+	journal  *journal
+	// 当前状态的状态树, 执行和撤销区块时按 journal 中修改过的 key 更新
+	trie *SparseMerkleTree
 }
 
 func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
-	// TODO: read this from disk later on
-	accountState := NewAccountState()
+	return NewBlockchainWithStorage(l, NewMemorystore(), genesis)
+}
+
+// NewBlockchainWithStorage 如果 store 中已经有区块, 从 store 重建链和状态, 否则从创世区块开始
+func NewBlockchainWithStorage(l log.Logger, store Storage, genesis *Block) (*Blockchain, error) {
+	bc := newBlockchain(l, store)
+	if err := bc.init(genesis); err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// NewBlockchainFromGenesis 根据创世配置写入初始余额, 验证者和 NFT collection
+func NewBlockchainFromGenesis(l log.Logger, store Storage, g *Genesis) (*Blockchain, error) {
+	genesis, err := g.Block()
+	if err != nil {
+		return nil, err
+	}
 
-	coinbase := crypto.PublicKey{}
-	accountState.CreateAccount(coinbase.Address()) // coinbase 账户建立
+	bc := newBlockchain(l, store)
+	if err := bc.applyGenesis(g); err != nil {
+		return nil, err
+	}
+
+	if err := bc.init(genesis); err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+func newBlockchain(l log.Logger, store Storage) *Blockchain {
+	accountState := NewAccountState()
 
 	bc := &Blockchain{
 		contractState:   NewState(),
 		headers:         []*Header{},
-		store:           NewMemorystore(),
+		store:           store,
 		logger:          l,
 		accountState:    accountState,
 		collectionState: make(map[types.Hash]*CollectionTx),
 		mintState:       make(map[types.Hash]*MintTx),
+		validatorSets:   []validatorSet{{height: 0}},
+		proposals:       make(map[types.Hash]*ValidatorProposal),
+		epochLength:     DefaultEpochLength,
+		bonds:           make(map[bondKey]uint64),
+		unbondingPeriod: DefaultUnbondingPeriod,
+		slashed:         make(map[slashKey]bool),
+		slashFraction:   DefaultSlashFraction,
 		blockStore:      make(map[types.Hash]*Block),
 		txStore:         make(map[types.Hash]*Transaction),
+		txBlocks:        make(map[types.Hash]types.Hash),
+		nodes:           make(map[types.Hash]*blockNode),
+		journals:        make(map[types.Hash]*journal),
+		certified:       make(map[types.Hash]uint32),
 	}
 	bc.validator = NewBlockchainValidator(bc) // type BlockValidator struct { bc *Blockchain}
+	bc.engine = NewPoAEngine()
+	bc.rebuildTrie()
 
-	err := bc.addBlockWithoutValidation(genesis) // 创世区块
-	return bc, err
+	return bc
+}
+
+func (bc *Blockchain) applyGenesis(g *Genesis) error {
+	alloc, err := g.alloc()
+	if err != nil {
+		return err
+	}
+
+	engine, err := g.Engine()
+	if err != nil {
+		return err
+	}
+	bc.engine = engine
+
+	bc.maxSupply = g.MaxSupply
+	bc.blockReward = g.BlockReward
+	for _, acc := range alloc {
+		if err := bc.mint(acc.Address, acc.Balance); err != nil {
+			return err
+		}
+	}
+
+	validators, err := g.validators()
+	if err != nil {
+		return err
+	}
+	bc.validatorSets = []validatorSet{newValidatorSet(0, validators, nil)}
+	if g.EpochLength > 0 {
+		bc.epochLength = g.EpochLength
+	}
+	if g.UnbondingPeriod > 0 {
+		bc.unbondingPeriod = g.UnbondingPeriod
+	}
+	if g.SlashFraction > 0 {
+		bc.slashFraction = uint64(g.SlashFraction)
+	}
+
+	for i, c := range g.Collections {
+		bc.setCollection(g.CollectionHash(i), &CollectionTx{
+			Fee:      c.Fee,
+			MetaData: []byte(c.MetaData),
+		})
+	}
+
+	// 创世状态不经过 journal 写入, 直接重建状态树
+	bc.rebuildTrie()
+
+	return nil
+}
+
+func (bc *Blockchain) init(genesis *Block) error {
+	bc.chainID = genesis.ChainID
+
+	stored, err := bc.store.GetByHeight(0)
+	if err != nil {
+		if err := bc.addBlockWithoutValidation(genesis); err != nil { // 创世区块
+			return err
+		}
+		bc.loadFinalized()
+		return nil
+	}
+
+	if stored.Hash(BlockHasher{}) != genesis.Hash(BlockHasher{}) {
+		return fmt.Errorf("stored genesis (%s) does not match given genesis (%s)", stored.Hash(BlockHasher{}), genesis.Hash(BlockHasher{}))
+	}
+
+	if err := bc.store.Iterate(bc.applyBlock); err != nil {
+		return err
+	}
+	bc.loadFinalized()
+
+	bc.logger.Log("msg", "blockchain loaded from storage", "height", bc.Height())
+
+	return nil
 }
 
 func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+func (bc *Blockchain) Engine() ConsensusEngine {
+	return bc.engine
+}
+
+// AddBlock 把区块加入区块树, 父区块是规范链最高区块时直接执行,
+// 在侧链上且累计权重超过规范链时触发重组
 func (bc *Blockchain) AddBlock(b *Block) error {
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
 
-	return bc.addBlockWithoutValidation(b)
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	node, err := bc.insertNode(b)
+	if err != nil {
+		return err
+	}
+
+	bc.lock.RLock()
+	tip := bc.tip
+	bc.lock.RUnlock()
+
+	if node.parent != tip {
+		if node.weight <= tip.weight {
+			bc.logger.Log("msg", "new side chain block", "hash", node.hash(), "height", b.Height)
+			return nil
+		}
+
+		return bc.reorg(node)
+	}
+
+	j, receipts, err := bc.connectBlock(b)
+	if err != nil {
+		bc.removeNode(node)
+		return err
+	}
+
+	return bc.commitBlock(b, j, receipts)
+}
+
+// RevertTo 撤销 height 之后的所有规范链区块, 被撤销的区块从区块树中删除,
+// 其中的交易交给重组回调
+func (bc *Blockchain) RevertTo(height uint32) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	if height > bc.Height() {
+		return fmt.Errorf("given height (%d) too high", height)
+	}
+
+	if finalized := bc.FinalizedHeight(); height < finalized {
+		return fmt.Errorf("revert to height (%d) => finalized height (%d): %w", height, finalized, ErrFinalityConflict)
+	}
+
+	if err := bc.checkRevert(height); err != nil {
+		return err
+	}
+
+	reverted := bc.revertTo(height)
+	if err := bc.store.Truncate(height); err != nil {
+		return err
+	}
+
+	dropped := []*Transaction{}
+	for _, b := range reverted {
+		if node, ok := bc.getNode(b.Hash(BlockHasher{})); ok {
+			bc.removeNode(node)
+		}
+		dropped = append(dropped, b.Transactions...)
+	}
+
+	if len(dropped) > 0 && bc.reorgHandler != nil {
+		bc.reorgHandler(dropped)
+	}
+
+	bc.logger.Log("msg", "reverted chain", "height", height, "blocks", len(reverted))
+
+	return nil
+}
+
+// SealBlock 在父区块的状态上执行区块, 写入执行后的 StateRoot, ReceiptsRoot 并签名, 执行完之后撤销修改
+// 会使区块无效的交易 (nonce 不正确, 余额不足以支付金额和手续费) 在签名之前从区块中去掉
+func (bc *Blockchain) SealBlock(b *Block, privKey crypto.PrivateKey) error {
+	// 手续费支付给 Validator, 执行之前设置
+	b.Validator = privKey.PublicKey()
+	if err := bc.engine.Prepare(bc, b); err != nil {
+		return err
+	}
+
+	bc.stateLock.Lock()
+	restore, err := bc.switchToBranch(b.PrevBlockHash)
+	if err != nil {
+		bc.stateLock.Unlock()
+		return err
+	}
+
+	var (
+		j        *journal
+		receipts []*Receipt
+	)
+	for {
+		j, receipts, err = bc.executeBlock(b)
+
+		// 去掉会使区块无效的交易后重新执行
+		var txErr *InvalidTxError
+		if errors.As(err, &txErr) {
+			bc.logger.Log("msg", "dropping invalid transaction", "hash", b.Transactions[txErr.Index].Hash(TxHasher{}), "error", txErr.Err)
+
+			txs := make([]*Transaction, 0, len(b.Transactions)-1)
+			txs = append(txs, b.Transactions[:txErr.Index]...)
+			b.Transactions = append(txs, b.Transactions[txErr.Index+1:]...)
+			continue
+		}
+
+		if err != nil {
+			restore()
+			bc.stateLock.Unlock()
+			return err
+		}
+		break
+	}
+
+	stateRoot := bc.trie.Root()
+	validatorsHash := bc.validatorSetAt(b.Height + 1).Hash()
+	j.undo(bc)
+	restore()
+	bc.stateLock.Unlock()
+
+	dataHash, err := CalculateDataHash(b.Transactions)
+	if err != nil {
+		return err
+	}
+
+	b.DataHash = dataHash
+	b.StateRoot = stateRoot
+	b.ReceiptsRoot = CalculateReceiptsRoot(receipts)
+	b.ValidatorsHash = validatorsHash
+	b.hash = types.Hash{}
+
+	if err := bc.engine.Seal(bc, b, privKey); err != nil {
+		return err
+	}
+
+	// 签名在返回之前检查, 冲突时区块被丢弃, 不会发送出去
+	if bc.signGuard != nil {
+		return bc.signGuard.Check(b)
+	}
+
+	return nil
 }
 
 func (bc *Blockchain) handleNativeTransfer(tx *Transaction) error {
@@ -81,14 +380,14 @@ func (bc *Blockchain) handleNativeNFT(tx *Transaction) error {
 
 	switch t := tx.TxInner.(type) {
 	case CollectionTx:
-		bc.collectionState[hash] = &t
+		bc.setCollection(hash, &t)
 		bc.logger.Log("msg", "create a new NFT collection", "hash", hash)
 	case MintTx:
 		_, ok := bc.collectionState[t.Collection]
 		if !ok {
 			return fmt.Errorf("collection (%s) does not exist on the blockchain", t.Collection)
 		}
-		bc.mintState[hash] = &t
+		bc.setMint(hash, &t)
 
 		bc.logger.Log("msg", "created new NFT mint", "NFT", t.NFT, "collection", t.Collection)
 	default:
@@ -104,6 +403,9 @@ func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
 
 	block, ok := bc.blockStore[hash]
 	if !ok {
+		if node, ok := bc.nodes[hash]; ok && node.block.Height < bc.retainFrom {
+			return nil, fmt.Errorf("block with hash (%s): %w", hash, ErrBlockPruned)
+		}
 		return nil, fmt.Errorf("block with hash (%s) not found", hash)
 	}
 
@@ -118,6 +420,10 @@ func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
+	if height < bc.retainFrom {
+		return nil, fmt.Errorf("block with height (%d) => retained height (%d): %w", height, bc.retainFrom, ErrBlockPruned)
+	}
+
 	return bc.blocks[height], nil
 }
 
@@ -131,6 +437,52 @@ func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
 	return bc.headers[height], nil
 }
 
+// GetAccount 返回账户的拷贝
+func (bc *Blockchain) GetAccount(address types.Address) (*Account, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	acc, err := bc.accountState.GetAccount(address)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := *acc
+	return &cp, nil
+}
+
+// ValidatePendingTx 用规范链当前的状态检查交易能否进入交易池
+// nonce 可以大于账户的下一个 nonce, 等待前面的交易上链
+func (bc *Blockchain) ValidatePendingTx(tx *Transaction) error {
+	bc.stateLock.RLock()
+	sender := bc.accountState.Account(tx.From.Address())
+	bc.stateLock.RUnlock()
+
+	hash := tx.Hash(TxHasher{})
+	if tx.Nonce < sender.Nonce {
+		return fmt.Errorf("tx (%s) with nonce (%d) => account nonce (%d): %w", hash, tx.Nonce, sender.Nonce, ErrInvalidNonce)
+	}
+
+	fee, err := tx.TotalFee()
+	if err != nil {
+		return fmt.Errorf("tx (%s): %w", hash, err)
+	}
+
+	if cost := tx.Value + fee; cost < fee || sender.Balance < cost {
+		return fmt.Errorf("tx (%s) costs value (%d) + fee (%d) => balance (%d): %w", hash, tx.Value, fee, sender.Balance, ErrInsufficientBalance)
+	}
+
+	return nil
+}
+
+// NextNonce 返回账户下一笔交易应该使用的 nonce
+func (bc *Blockchain) NextNonce(address types.Address) uint64 {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.accountState.Nonce(address)
+}
+
 func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
@@ -143,6 +495,38 @@ func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
 	return tx, nil
 }
 
+func (bc *Blockchain) ChainID() uint32 {
+	return bc.chainID
+}
+
+// GenesisHash 用于节点之间确认是同一条链
+func (bc *Blockchain) GenesisHash() types.Hash {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return BlockHasher{}.Hash(bc.headers[0])
+}
+
+// GetTxProof 返回交易包含在区块中的 Merkle 证明
+func (bc *Blockchain) GetTxProof(hash types.Hash) (*TxProof, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	blockHash, ok := bc.txBlocks[hash]
+	if !ok {
+		return nil, fmt.Errorf("could not find tx with hash (%s)", hash)
+	}
+
+	block := bc.blockStore[blockHash]
+	for i, tx := range block.Transactions {
+		if tx.Hash(TxHasher{}) == hash {
+			return block.NewTxProof(i)
+		}
+	}
+
+	return nil, fmt.Errorf("tx (%s) not found in block (%s)", hash, blockHash)
+}
+
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= bc.Height()
 }
@@ -155,68 +539,233 @@ func (bc *Blockchain) Height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
-func (bc *Blockchain) handleTransaction(tx *Transaction) error {
+func (bc *Blockchain) handleTransaction(tx *Transaction, height uint32) error {
 	if len(tx.Data) > 0 {
 		bc.logger.Log("msg", "executing code", "len", len(tx.Data), "hash", tx.Hash(&TxHasher{}))
 
-		vm := NewVM(tx.Data, bc.contractState)
-		if err := vm.Run(); err != nil {
-			return err
+		if err := bc.runContract(tx.Data); err != nil {
+			return &TxFailure{Code: ReceiptCodeContract, Err: err}
 		}
 	}
 
-	// 处理 NFT 交易
-	if tx.TxInner != nil {
+	// 处理 NFT, 治理, 抵押和证据交易
+	switch tx.TxInner.(type) {
+	case nil:
+	case ValidatorProposalTx, ValidatorVoteTx:
+		if err := bc.handleGovernance(tx, height); err != nil {
+			return &TxFailure{Code: ReceiptCodeGovernance, Err: err}
+		}
+	case StakeTx, DelegateTx, UnstakeTx:
+		if err := bc.handleStaking(tx, height); err != nil {
+			return &TxFailure{Code: ReceiptCodeStaking, Err: err}
+		}
+	case EvidenceTx:
+		if err := bc.handleEvidence(tx, height); err != nil {
+			return &TxFailure{Code: ReceiptCodeEvidence, Err: err}
+		}
+	default:
 		if err := bc.handleNativeNFT(tx); err != nil {
-			return err
+			return &TxFailure{Code: ReceiptCodeNFT, Err: err}
 		}
 	}
 
 	// 处理native token 转账
 	if tx.Value > 0 {
 		if err := bc.handleNativeTransfer(tx); err != nil {
-			return err
+			return &TxFailure{Code: ReceiptCodeTransfer, Err: err}
 		}
 	}
 	return nil
 }
 
+// VM 遇到非法字节码时会 panic, 这里把 panic 转换成交易失败
+func (bc *Blockchain) runContract(data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrContractPanic, r)
+		}
+	}()
+
+	return NewVM(data, bc.contractState).Run()
+}
+
 // 添加 txHash 到 txScore， header 到 headers， block 到 blocks，
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
 	bc.stateLock.Lock()
-	for i := 0; i < len(b.Transactions); i++ {
-		if err := bc.handleTransaction(b.Transactions[i]); err != nil {
-			bc.logger.Log("error", err.Error())
+	defer bc.stateLock.Unlock()
 
-			b.Transactions[i] = b.Transactions[len(b.Transactions)-1]
-			b.Transactions = b.Transactions[:len(b.Transactions)-1]
+	if _, err := bc.insertNode(b); err != nil {
+		return err
+	}
 
-			continue
+	j, receipts, err := bc.executeBlock(b)
+	if err != nil {
+		return err
+	}
+
+	return bc.commitBlock(b, j, receipts)
+}
+
+func (bc *Blockchain) commitBlock(b *Block, j *journal, receipts []*Receipt) error {
+	bc.linkBlock(b, j, receipts)
+
+	bc.logger.Log(
+		"msg", "new block",
+		"hash", b.Hash(BlockHasher{}),
+		"height", b.Height,
+		"transactions", len(b.Transactions),
+	)
+
+	if err := bc.store.Put(b); err != nil {
+		return err
+	}
+
+	return bc.store.PutReceipts(b.Hash(BlockHasher{}), receipts)
+}
+
+// 执行区块并建立内存索引, 不写入 store
+func (bc *Blockchain) applyBlock(b *Block) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	if _, err := bc.insertNode(b); err != nil {
+		return err
+	}
+	j, receipts, err := bc.executeBlock(b)
+	if err != nil {
+		return err
+	}
+	bc.linkBlock(b, j, receipts)
+
+	// 区块写入之后, 执行结果写入之前崩溃时补齐执行结果
+	hash := b.Hash(BlockHasher{})
+	if _, err := bc.store.GetReceipts(hash); err != nil {
+		return bc.store.PutReceipts(hash, receipts)
+	}
+
+	return nil
+}
+
+// 执行区块并校验执行之后的状态, 不通过时撤销区块的修改, 调用者需要持有 stateLock
+func (bc *Blockchain) connectBlock(b *Block) (*journal, []*Receipt, error) {
+	j, receipts, err := bc.executeBlock(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := bc.validator.ValidateState(b, bc.trie.Root()); err != nil {
+		j.undo(bc)
+		return nil, nil, err
+	}
+
+	if err := bc.validator.ValidateReceipts(b, receipts); err != nil {
+		j.undo(bc)
+		return nil, nil, err
+	}
+
+	if err := bc.validator.ValidateValidators(b, bc.validatorSetAt(b.Height+1).Hash()); err != nil {
+		j.undo(bc)
+		return nil, nil, err
+	}
+
+	return j, receipts, nil
+}
+
+// 执行区块中的交易, 返回区块的撤销日志和每笔交易的执行结果, 调用者需要持有 stateLock
+// 每笔交易在快照上执行, 失败时撤销到快照, 区块内容保持不变
+// 交易 nonce 不正确时整个区块无效, 撤销区块的全部修改并返回错误
+func (bc *Blockchain) executeBlock(b *Block) (*journal, []*Receipt, error) {
+	j := newJournal()
+	bc.setJournal(j)
+	defer bc.setJournal(nil)
+
+	receipts := make([]*Receipt, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		from := tx.From.Address()
+		if err := bc.validator.ValidateTx(tx, bc.accountState.Account(from)); err != nil {
+			j.revertToSnapshot(bc, 0)
+			return nil, nil, &InvalidTxError{Index: i, Err: err}
+		}
+
+		// 交易执行失败时 nonce 同样增加, 手续费同样收取, 防止失败的交易被重放或者免费占用区块
+		bc.accountState.IncrementNonce(from)
+		fee, err := bc.chargeFee(tx, b.Validator.Address())
+		if err != nil {
+			j.revertToSnapshot(bc, 0)
+			return nil, nil, &InvalidTxError{Index: i, Err: err}
+		}
+
+		receipt := &Receipt{
+			TxHash:      tx.Hash(TxHasher{}),
+			Status:      ReceiptSuccess,
+			BlockHeight: b.Height,
+			Index:       uint32(i),
+			Fee:         fee,
 		}
+
+		snapshot := j.snapshot()
+		err = bc.handleTransaction(tx, b.Height)
+		receipt.StorageKeys = j.storageKeys(snapshot)
+
+		if err != nil {
+			bc.logger.Log("msg", "transaction failed", "hash", receipt.TxHash, "error", err)
+
+			j.revertToSnapshot(bc, snapshot)
+			receipt.Status = ReceiptFailed
+			receipt.Code = receiptCode(err)
+			receipt.Error = err.Error()
+		}
+
+		receipts[i] = receipt
 	}
 
-	bc.stateLock.Unlock()
+	if err := bc.releaseUnbonding(b.Height); err != nil {
+		j.revertToSnapshot(bc, 0)
+		return nil, nil, err
+	}
+	bc.applyEpoch(b.Height)
+
+	if err := bc.engine.Finalize(bc, b); err != nil {
+		j.revertToSnapshot(bc, 0)
+		return nil, nil, err
+	}
+	j.updateTrie(bc)
+
+	return j, receipts, nil
+}
+
+// 从发送者扣除手续费并支付给验证者, 调用者需要持有 stateLock
+func (bc *Blockchain) chargeFee(tx *Transaction, validator types.Address) (uint64, error) {
+	fee, err := tx.TotalFee()
+	if err != nil || fee == 0 {
+		return 0, err
+	}
+
+	if err := bc.accountState.Transfer(tx.From.Address(), validator, fee); err != nil {
+		return 0, err
+	}
 
-	// fmt.Println("========ACCOUNT STATE==============")
-	// fmt.Printf("%+v\n", bc.accountState.accounts)
-	// fmt.Println("========ACCOUNT STATE==============")
+	return fee, nil
+}
 
+func (bc *Blockchain) linkBlock(b *Block, j *journal, receipts []*Receipt) {
 	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
 	bc.headers = append(bc.headers, b.Header)
 	bc.blocks = append(bc.blocks, b)
 	bc.blockStore[b.Hash(BlockHasher{})] = b
+	bc.journals[b.Hash(BlockHasher{})] = j
+	bc.tip = bc.nodes[b.Hash(BlockHasher{})]
 
 	for _, tx := range b.Transactions {
 		bc.txStore[tx.Hash(TxHasher{})] = tx
+		bc.txBlocks[tx.Hash(TxHasher{})] = b.Hash(BlockHasher{})
 	}
-	bc.lock.Unlock()
 
-	bc.logger.Log(
-		"msg", "new block",
-		"hash", b.Hash(BlockHasher{}),
-		"height", b.Height,
-		"transactions", len(b.Transactions),
-	)
+	for _, l := range bc.listeners {
+		l.OnCommit(b, receipts)
+	}
 
-	return bc.store.Put(b)
+	bc.prune()
 }
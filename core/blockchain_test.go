@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"project-bee/crypto"
 	"project-bee/types"
@@ -13,10 +14,9 @@ import (
 
 func TestSendNativeTransferTamper(t *testing.T) {
 		bc := newBlockchainWithGenesis(t)
-		signer := crypto.GeneratePrivateKey()
+		signer := testValidator
 
 		block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-		assert.Nil(t, block.Sign(signer))
 
 		privKeyBob := crypto.GeneratePrivateKey()
 		privKeyAlice := crypto.GeneratePrivateKey()
@@ -36,6 +36,7 @@ func TestSendNativeTransferTamper(t *testing.T) {
 		tx.To = hackerPrivKey.PublicKey()
 
 		block.AddTransaction(tx)
+		sealBlock(t, bc, block, signer)
 		assert.NotNil(t, bc.AddBlock(block)) // this should fail
 
 	fmt.Printf("alice account %+v\n", privKeyAlice.PublicKey().Address())
@@ -51,10 +52,9 @@ func TestSendNativeTransferTamper(t *testing.T) {
 
 func TestSendNativeTransferInsuffientBalance(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
-	signer := crypto.GeneratePrivateKey()
+	signer := testValidator
 
 	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
 
 	privKeyBob := crypto.GeneratePrivateKey()
 	privKeyAlice := crypto.GeneratePrivateKey()
@@ -74,23 +74,25 @@ func TestSendNativeTransferInsuffientBalance(t *testing.T) {
 	fmt.Printf("bob => %s\n", privKeyBob.PublicKey().Address())
 
 	block.AddTransaction(tx)
+	sealBlock(t, bc, block, signer)
 	assert.Nil(t, bc.AddBlock(block))
 
 	_, err := bc.accountState.GetAccount(privKeyAlice.PublicKey().Address())
 	assert.NotNil(t, err)
 
+	// 余额不足以支付的交易在打包时被去掉
 	hash := tx.Hash(TxHasher{})
 	_, err = bc.GetTxByHash(hash)
 	assert.NotNil(t, err)
+	assert.Equal(t, uint64(99), accountBob.Balance)
 }
 
 func TestSendNativeTransferSuccess(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
-	signer := crypto.GeneratePrivateKey()
+	signer := testValidator
 
 	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
 
 	privKeyBob := crypto.GeneratePrivateKey()
 	privKeyAlice := crypto.GeneratePrivateKey()
@@ -105,6 +107,7 @@ func TestSendNativeTransferSuccess(t *testing.T) {
 	tx.Value = amount
 	tx.Sign(privKeyBob)
 	block.AddTransaction(tx)
+	sealBlock(t, bc, block, signer)
 
 	assert.Nil(t, bc.AddBlock(block))
 
@@ -118,7 +121,7 @@ func TestAddBlock(t *testing.T) {
 
 	lenBlocks := 1000
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := nextBlock(t, bc)
 		assert.Nil(t, bc.AddBlock(block))
 	}
 
@@ -145,7 +148,7 @@ func TestGetBlock(t *testing.T) {
 	lenBlocks := 100
 
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := nextBlock(t, bc)
 		assert.Nil(t, bc.AddBlock(block))
 
 		fetchedBlock, err := bc.GetBlock(block.Height)
@@ -159,7 +162,7 @@ func TestGetHeader(t *testing.T) {
 	lenBlocks := 1000
 
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := nextBlock(t, bc)
 		assert.Nil(t, bc.AddBlock(block))
 		header, err := bc.GetHeader(block.Height)
 		assert.Nil(t, err)
@@ -170,14 +173,200 @@ func TestGetHeader(t *testing.T) {
 func TestAddBlockToHigh(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
-	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, getPrevBlockHash(t, bc, uint32(1))))) // 添加高度 1
-	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))                    // 添加高度不正确
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))                    // 添加高度 1
+	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{}))) // 添加高度不正确
+}
+
+func TestAddBlockInvalidStateRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 100
+	// 直接写入的状态不经过 journal, 需要重建状态树
+	bc.rebuildTrie()
+
+	tx := NewTransaction(nil)
+	tx.To = privKeyAlice.PublicKey()
+	tx.Value = 100
+	assert.Nil(t, tx.Sign(privKeyBob))
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	block.StateRoot = types.Hash{}
+	assert.Nil(t, block.Sign(signer))
+
+	stateRoot := bc.StateRoot()
+	assert.ErrorIs(t, bc.AddBlock(block), ErrInvalidStateRoot)
+	assert.Equal(t, stateRoot, bc.StateRoot())
+	assert.Equal(t, uint32(0), bc.Height())
+
+	balance, err := bc.accountState.GetBalance(privKeyBob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+
+	sealBlock(t, bc, block, signer)
+	assert.Nil(t, bc.AddBlock(block))
+	assert.NotEqual(t, stateRoot, bc.StateRoot())
+}
+
+func TestReplayTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 100
+
+	tx := NewTransaction(nil)
+	tx.To = privKeyAlice.PublicKey()
+	tx.Value = 10
+	assert.Nil(t, tx.Sign(privKeyBob))
+
+	b1 := randomBlock(t, 1, bc.GenesisHash())
+	b1.AddTransaction(tx)
+	sealBlock(t, bc, b1, signer)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, uint64(1), bc.NextNonce(privKeyBob.PublicKey().Address()))
+
+	// 把同一笔交易放进下一个区块, 打包时被去掉
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	b2.AddTransaction(tx)
+	sealBlock(t, bc, b2, signer)
+	assert.Equal(t, 1, len(b2.Transactions))
+
+	// 其他验证者打包的区块包含重放的交易
+	b2.AddTransaction(tx)
+	b2.hash = types.Hash{}
+	assert.Nil(t, b2.Sign(signer))
+	assert.ErrorIs(t, bc.AddBlock(b2), ErrDuplicateTx)
+	assert.Equal(t, uint32(1), bc.Height())
+
+	balance, err := bc.accountState.GetBalance(privKeyAlice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), balance)
+}
+
+func TestDuplicateNonceInBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+	privKey := crypto.GeneratePrivateKey()
+
+	b1 := randomBlock(t, 1, bc.GenesisHash())
+	for i := 0; i < 2; i++ {
+		tx := NewTransaction([]byte{byte(i)})
+		assert.Nil(t, tx.Sign(privKey))
+		b1.AddTransaction(tx)
+	}
+	b1.hash = types.Hash{}
+	assert.Nil(t, b1.Sign(signer))
+
+	assert.ErrorIs(t, bc.AddBlock(b1), ErrInvalidNonce)
+}
+
+func TestTransactionFee(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 1000
+
+	transferTx := NewTransaction(nil)
+	transferTx.To = privKeyAlice.PublicKey()
+	transferTx.Value = 100
+	transferTx.Fee = 10
+	assert.Nil(t, transferTx.Sign(privKeyBob))
+
+	// 执行失败的合约交易同样收取手续费
+	contractTx := NewTransaction([]byte{0x0b})
+	contractTx.Nonce = 1
+	contractTx.Fee = 20
+	assert.Nil(t, contractTx.Sign(privKeyBob))
+
+	collectionTx := NewTransaction(nil)
+	collectionTx.TxInner = CollectionTx{Fee: 200, MetaData: []byte("collection")}
+	collectionTx.Nonce = 2
+	collectionTx.Fee = 5
+	assert.Nil(t, collectionTx.Sign(privKeyBob))
+
+	block := randomBlock(t, 1, bc.GenesisHash())
+	block.AddTransaction(transferTx)
+	block.AddTransaction(contractTx)
+	block.AddTransaction(collectionTx)
+	sealBlock(t, bc, block, signer)
+	assert.Nil(t, bc.AddBlock(block))
+
+	balance, err := bc.accountState.GetBalance(privKeyBob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000-100-10-20-205), balance)
+
+	balance, err = bc.accountState.GetBalance(signer.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10+20+205), balance)
+
+	receipt, err := bc.GetReceipt(contractTx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+	assert.Equal(t, uint64(20), receipt.Fee)
+
+	receipt, err = bc.GetReceipt(collectionTx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(205), receipt.Fee)
+}
+
+func TestTransactionFeeInsufficientBalance(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 100
+
+	tx := NewTransaction(nil)
+	tx.To = crypto.GeneratePrivateKey().PublicKey()
+	tx.Value = 100
+	tx.Fee = 1
+	assert.Nil(t, tx.Sign(privKeyBob))
+	assert.ErrorIs(t, bc.ValidatePendingTx(tx), ErrInsufficientBalance)
+
+	// 其他验证者打包了余额不足的交易, 整个区块无效
+	block := randomBlock(t, 1, bc.GenesisHash())
+	block.AddTransaction(tx)
+	block.hash = types.Hash{}
+	assert.Nil(t, block.Sign(signer))
+
+	assert.ErrorIs(t, bc.AddBlock(block), ErrInsufficientBalance)
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.Equal(t, uint64(100), accountBob.Balance)
+	assert.Equal(t, uint64(0), bc.NextNonce(privKeyBob.PublicKey().Address()))
+}
+
+func TestAddBlockWrongChainID(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+
+	b := nextBlock(t, bc)
+	b.ChainID = 7
+	b.hash = types.Hash{}
+	assert.Nil(t, b.Sign(signer))
+
+	assert.ErrorIs(t, bc.AddBlock(b), ErrInvalidChainID)
 }
 
+// testValidator 是 newBlockchainWithGenesis 中唯一的验证者, 测试区块都由它出块
+var testValidator = crypto.GeneratePrivateKey()
+
 func newBlockchainWithGenesis(t *testing.T) *Blockchain {
 	logger := log.NewNopLogger()
 
-	bc, err := NewBlockchain(logger, randomBlock(t, 0, types.Hash{}))
+	g := &Genesis{Validators: []string{testValidator.PublicKey().String()}}
+	bc, err := NewBlockchainFromGenesis(logger, NewMemorystore(), g)
 	assert.Nil(t, err)
 
 	return bc
@@ -189,3 +378,30 @@ func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
 
 	return BlockHasher{}.Hash(prevHeader)
 }
+
+// 按链上当前状态执行区块, 写入 StateRoot 并签名
+func sealBlock(t *testing.T, bc *Blockchain, b *Block, privKey crypto.PrivateKey) {
+	assert.Nil(t, bc.SealBlock(b, privKey))
+}
+
+// 在最高区块之后生成一个已经打包的区块, 区块和交易使用链的 ChainID
+func nextBlock(t *testing.T, bc *Blockchain) *Block {
+	tx := NewTransaction([]byte("foo"))
+	tx.ChainID = bc.ChainID()
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	height := bc.Height() + 1
+	header := &Header{
+		Version:       1,
+		ChainID:       bc.ChainID(),
+		PrevBlockHash: getPrevBlockHash(t, bc, height),
+		Height:        height,
+		Timestamp:     time.Now().UnixNano(),
+	}
+
+	b, err := NewBlock(header, []*Transaction{tx})
+	assert.Nil(t, err)
+	sealBlock(t, bc, b, testValidator)
+
+	return b
+}
@@ -0,0 +1,922 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+// CodecVersion 是二进制编码的版本, 写在每个顶层编码的第一个字节
+// 编码格式改变时必须增加版本号, 旧版本的数据会被拒绝
+const CodecVersion byte = 1
+
+// 单个变长字段的最大长度, 防止恶意数据导致大量内存分配
+const maxCodecFieldSize = 1 << 24
+
+var (
+	ErrCodecVersion   = errors.New("unsupported codec version")
+	ErrCodecTooLarge  = errors.New("codec field too large")
+	ErrUnknownTxInner = errors.New("unknown tx inner type")
+	// 签名的 R, S 为空或者为 0, 公钥不在曲线上
+	ErrInvalidSignature = errors.New("invalid signature encoding")
+	ErrInvalidPublicKey = errors.New("invalid public key")
+)
+
+// codecWriter 按大端序写入字段, 第一个错误之后的写入全部忽略
+type codecWriter struct {
+	w   io.Writer
+	err error
+}
+
+func newCodecWriter(w io.Writer) *codecWriter {
+	return &codecWriter{w: w}
+}
+
+func (w *codecWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(b)
+}
+
+func (w *codecWriter) u8(v byte) {
+	w.write([]byte{v})
+}
+
+func (w *codecWriter) bool(v bool) {
+	if v {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+}
+
+func (w *codecWriter) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.write(b[:])
+}
+
+func (w *codecWriter) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.write(b[:])
+}
+
+func (w *codecWriter) i64(v int64) {
+	w.u64(uint64(v))
+}
+
+// 变长字段: [u32 长度][内容]
+func (w *codecWriter) bytes(b []byte) {
+	if len(b) > maxCodecFieldSize {
+		if w.err == nil {
+			w.err = fmt.Errorf("field of (%d) bytes: %w", len(b), ErrCodecTooLarge)
+		}
+		return
+	}
+	w.u32(uint32(len(b)))
+	w.write(b)
+}
+
+func (w *codecWriter) hash(h types.Hash) {
+	w.write(h[:])
+}
+
+func (w *codecWriter) address(a types.Address) {
+	w.write(a[:])
+}
+
+// nil 和 0 编码相同, 解码时都得到 nil
+func (w *codecWriter) bigInt(n *big.Int) {
+	var b []byte
+	if n != nil {
+		b = n.Bytes()
+	}
+	w.bytes(b)
+}
+
+// 签名: [0] 表示没有签名, 否则为 [1][R][S]
+func (w *codecWriter) signature(sig *crypto.Signature) {
+	if sig == nil {
+		w.u8(0)
+		return
+	}
+	w.u8(1)
+	w.bigInt(sig.R)
+	w.bigInt(sig.S)
+}
+
+type codecReader struct {
+	r   io.Reader
+	err error
+}
+
+func newCodecReader(r io.Reader) *codecReader {
+	return &codecReader{r: r}
+}
+
+func (r *codecReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+		return nil
+	}
+	return b
+}
+
+func (r *codecReader) u8() byte {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// 只接受 0 和 1, 同一个值只有一种编码
+func (r *codecReader) bool() bool {
+	switch r.u8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid bool")
+		}
+		return false
+	}
+}
+
+func (r *codecReader) u32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *codecReader) u64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *codecReader) i64() int64 {
+	return int64(r.u64())
+}
+
+// 长度为 0 的变长字段解码为 nil
+func (r *codecReader) bytes() []byte {
+	n := r.u32()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	if n > maxCodecFieldSize {
+		r.err = fmt.Errorf("field of (%d) bytes: %w", n, ErrCodecTooLarge)
+		return nil
+	}
+	return r.read(int(n))
+}
+
+func (r *codecReader) hash() types.Hash {
+	var h types.Hash
+	copy(h[:], r.read(len(h)))
+	return h
+}
+
+func (r *codecReader) address() types.Address {
+	var a types.Address
+	copy(a[:], r.read(len(a)))
+	return a
+}
+
+func (r *codecReader) bigInt() *big.Int {
+	b := r.bytes()
+	if b == nil {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+func (r *codecReader) signature() *crypto.Signature {
+	switch r.u8() {
+	case 0:
+		return nil
+	case 1:
+		sig := &crypto.Signature{
+			R: r.bigInt(),
+			S: r.bigInt(),
+		}
+		if r.err == nil {
+			r.err = checkSignature(sig)
+		}
+		if r.err != nil {
+			return nil
+		}
+		return sig
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid signature flag")
+		}
+		return nil
+	}
+}
+
+// 公钥可以为空, 不为空时必须是曲线上的压缩公钥
+func (r *codecReader) publicKey() crypto.PublicKey {
+	k := crypto.PublicKey(r.bytes())
+	if r.err == nil {
+		r.err = checkPublicKey(k)
+	}
+	return k
+}
+
+func checkSignature(sig *crypto.Signature) error {
+	if sig.R == nil || sig.S == nil || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func checkPublicKey(k crypto.PublicKey) error {
+	if len(k) > 0 && !k.Valid() {
+		return fmt.Errorf("public key of (%d) bytes: %w", len(k), ErrInvalidPublicKey)
+	}
+	return nil
+}
+
+func (r *codecReader) version() {
+	if v := r.u8(); r.err == nil && v != CodecVersion {
+		r.err = fmt.Errorf("codec version (%d) => (%d): %w", v, CodecVersion, ErrCodecVersion)
+	}
+}
+
+func encodeHeader(w *codecWriter, h *Header) {
+	w.u32(h.Version)
+	w.u32(h.ChainID)
+	w.hash(h.DataHash)
+	w.hash(h.StateRoot)
+	w.hash(h.ReceiptsRoot)
+	w.hash(h.PrevBlockHash)
+	w.u32(h.Height)
+	w.i64(h.Timestamp)
+	w.u32(h.Round)
+	w.u64(h.Difficulty)
+	w.u64(h.Nonce)
+	w.hash(h.ValidatorsHash)
+}
+
+func decodeHeader(r *codecReader) *Header {
+	return &Header{
+		Version:        r.u32(),
+		ChainID:        r.u32(),
+		DataHash:       r.hash(),
+		StateRoot:      r.hash(),
+		ReceiptsRoot:   r.hash(),
+		PrevBlockHash:  r.hash(),
+		Height:         r.u32(),
+		Timestamp:      r.i64(),
+		Round:          r.u32(),
+		Difficulty:     r.u64(),
+		Nonce:          r.u64(),
+		ValidatorsHash: r.hash(),
+	}
+}
+
+// TxInner: [0] 表示没有, 否则为 [1][TxType][内容]
+// 未知类型只在计算 hash 时写成 [1][0xff], 编码交易时返回错误
+func encodeTxInner(w *codecWriter, inner any) {
+	switch t := inner.(type) {
+	case nil:
+		w.u8(0)
+	case CollectionTx:
+		w.u8(1)
+		w.u8(byte(TxTypeCollection))
+		w.i64(t.Fee)
+		w.bytes(t.MetaData)
+	case MintTx:
+		w.u8(1)
+		w.u8(byte(TxTypeMint))
+		w.i64(t.Fee)
+		w.hash(t.NFT)
+		w.hash(t.Collection)
+		w.bytes(t.MetaData)
+		w.bytes(t.CollectionOwner)
+		w.bigInt(t.Signature.R)
+		w.bigInt(t.Signature.S)
+	case ValidatorProposalTx:
+		w.u8(1)
+		w.u8(byte(TxTypeValidatorProposal))
+		w.u8(byte(t.Action))
+		w.bytes(t.Validator)
+	case ValidatorVoteTx:
+		w.u8(1)
+		w.u8(byte(TxTypeValidatorVote))
+		w.hash(t.Proposal)
+		w.bool(t.Approve)
+	case StakeTx:
+		w.u8(1)
+		w.u8(byte(TxTypeStake))
+		w.u64(t.Amount)
+	case UnstakeTx:
+		w.u8(1)
+		w.u8(byte(TxTypeUnstake))
+		w.bytes(t.Validator)
+		w.u64(t.Amount)
+	case DelegateTx:
+		w.u8(1)
+		w.u8(byte(TxTypeDelegate))
+		w.bytes(t.Validator)
+		w.u64(t.Amount)
+	case EvidenceTx:
+		w.u8(1)
+		w.u8(byte(TxTypeEvidence))
+		encodeSignedHeader(w, t.First)
+		encodeSignedHeader(w, t.Second)
+	default:
+		w.u8(1)
+		w.u8(0xff)
+	}
+}
+
+func decodeTxInner(r *codecReader) any {
+	if r.u8() == 0 {
+		return nil
+	}
+
+	switch t := TxType(r.u8()); t {
+	case TxTypeCollection:
+		return CollectionTx{
+			Fee:      r.i64(),
+			MetaData: r.bytes(),
+		}
+	case TxTypeMint:
+		return MintTx{
+			Fee:             r.i64(),
+			NFT:             r.hash(),
+			Collection:      r.hash(),
+			MetaData:        r.bytes(),
+			CollectionOwner: r.publicKey(),
+			Signature: crypto.Signature{
+				R: r.bigInt(),
+				S: r.bigInt(),
+			},
+		}
+	case TxTypeValidatorProposal:
+		return ValidatorProposalTx{
+			Action:    ValidatorAction(r.u8()),
+			Validator: r.bytes(),
+		}
+	case TxTypeValidatorVote:
+		return ValidatorVoteTx{
+			Proposal: r.hash(),
+			Approve:  r.bool(),
+		}
+	case TxTypeStake:
+		return StakeTx{
+			Amount: r.u64(),
+		}
+	case TxTypeUnstake:
+		return UnstakeTx{
+			Validator: r.bytes(),
+			Amount:    r.u64(),
+		}
+	case TxTypeDelegate:
+		return DelegateTx{
+			Validator: r.bytes(),
+			Amount:    r.u64(),
+		}
+	case TxTypeEvidence:
+		return EvidenceTx{
+			First:  decodeSignedHeader(r),
+			Second: decodeSignedHeader(r),
+		}
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("tx inner type (%d): %w", t, ErrUnknownTxInner)
+		}
+		return nil
+	}
+}
+
+// 没有区块头的证据只在计算 hash 时编码为空区块头
+func encodeSignedHeader(w *codecWriter, h SignedHeader) {
+	header := h.Header
+	if header == nil {
+		header = &Header{}
+	}
+
+	encodeHeader(w, header)
+	w.bytes(h.Validator)
+	w.signature(h.Signature)
+}
+
+func decodeSignedHeader(r *codecReader) SignedHeader {
+	return SignedHeader{
+		Header:    decodeHeader(r),
+		Validator: r.publicKey(),
+		Signature: r.signature(),
+	}
+}
+
+func checkTxInner(inner any) error {
+	switch t := inner.(type) {
+	case nil, CollectionTx, MintTx, ValidatorProposalTx, ValidatorVoteTx, StakeTx, UnstakeTx, DelegateTx:
+		return nil
+	case EvidenceTx:
+		if t.First.Header == nil || t.Second.Header == nil {
+			return fmt.Errorf("evidence without header: %w", ErrInvalidEvidence)
+		}
+		return nil
+	default:
+		return fmt.Errorf("tx inner (%T): %w", inner, ErrUnknownTxInner)
+	}
+}
+
+// 交易中参与签名的全部字段, 不包括签名本身
+func encodeTxBody(w *codecWriter, tx *Transaction) {
+	w.u32(tx.ChainID)
+	encodeTxInner(w, tx.TxInner)
+	w.bytes(tx.Data)
+	w.bytes(tx.To)
+	w.u64(tx.Value)
+	w.bytes(tx.From)
+	w.u64(tx.Nonce)
+	w.u64(tx.Fee)
+}
+
+func encodeTx(w *codecWriter, tx *Transaction) {
+	encodeTxBody(w, tx)
+	w.signature(tx.Signature)
+}
+
+func decodeTx(r *codecReader, tx *Transaction) {
+	*tx = Transaction{
+		ChainID: r.u32(),
+		TxInner: decodeTxInner(r),
+		Data:    r.bytes(),
+		To:      r.bytes(),
+		Value:   r.u64(),
+		From:    r.publicKey(),
+		Nonce:   r.u64(),
+		Fee:     r.u64(),
+	}
+	tx.Signature = r.signature()
+}
+
+func encodeBlock(w *codecWriter, b *Block) {
+	w.u8(CodecVersion)
+	encodeHeader(w, b.Header)
+	w.u32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		encodeTx(w, tx)
+	}
+	w.bytes(b.Validator)
+	w.signature(b.Signature)
+}
+
+type BinaryTxEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryTxEncoder(w io.Writer) *BinaryTxEncoder {
+	return &BinaryTxEncoder{
+		w: w,
+	}
+}
+
+// 编码: [版本][交易]
+func (enc *BinaryTxEncoder) Encode(tx *Transaction) error {
+	if err := checkTxInner(tx.TxInner); err != nil {
+		return err
+	}
+
+	w := newCodecWriter(enc.w)
+	w.u8(CodecVersion)
+	encodeTx(w, tx)
+
+	return w.err
+}
+
+type BinaryTxDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryTxDecoder(r io.Reader) *BinaryTxDecoder {
+	return &BinaryTxDecoder{
+		r: r,
+	}
+}
+
+func (dec *BinaryTxDecoder) Decode(tx *Transaction) error {
+	r := newCodecReader(dec.r)
+	r.version()
+	if r.err != nil {
+		return r.err
+	}
+	decodeTx(r, tx)
+
+	return r.err
+}
+
+type BinaryBlockEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryBlockEncoder(w io.Writer) *BinaryBlockEncoder {
+	return &BinaryBlockEncoder{
+		w: w,
+	}
+}
+
+// 编码: [版本][区块头][u32 交易数][交易...][验证者公钥][签名]
+func (enc *BinaryBlockEncoder) Encode(b *Block) error {
+	if err := checkBlock(b); err != nil {
+		return err
+	}
+
+	w := newCodecWriter(enc.w)
+	encodeBlock(w, b)
+
+	return w.err
+}
+
+type BinaryBlockDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryBlockDecoder(r io.Reader) *BinaryBlockDecoder {
+	return &BinaryBlockDecoder{
+		r: r,
+	}
+}
+
+func (dec *BinaryBlockDecoder) Decode(b *Block) error {
+	r := newCodecReader(dec.r)
+	decodeBlock(r, b)
+
+	return r.err
+}
+
+func checkBlock(b *Block) error {
+	if b.Header == nil {
+		return fmt.Errorf("block has no header")
+	}
+	for _, tx := range b.Transactions {
+		if err := checkTxInner(tx.TxInner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeBlock(r *codecReader, b *Block) {
+	r.version()
+	if r.err != nil {
+		return
+	}
+
+	header := decodeHeader(r)
+	n := r.u32()
+
+	// 交易数来自不可信的数据, 不按它预先分配内存
+	var txs []*Transaction
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		tx := new(Transaction)
+		decodeTx(r, tx)
+		txs = append(txs, tx)
+	}
+
+	validator := r.publicKey()
+	sig := r.signature()
+	if r.err != nil {
+		return
+	}
+
+	*b = Block{
+		Header:       header,
+		Transactions: txs,
+		Validator:    validator,
+		Signature:    sig,
+	}
+}
+
+func encodeVote(w *codecWriter, v *Vote) {
+	w.u8(byte(v.Type))
+	w.u32(v.ChainID)
+	w.u32(v.Height)
+	w.u32(v.Round)
+	w.hash(v.BlockHash)
+	w.bytes(v.Validator)
+	w.signature(v.Signature)
+}
+
+func decodeVote(r *codecReader, v *Vote) {
+	*v = Vote{
+		Type:      VoteType(r.u8()),
+		ChainID:   r.u32(),
+		Height:    r.u32(),
+		Round:     r.u32(),
+		BlockHash: r.hash(),
+		Validator: r.publicKey(),
+	}
+	v.Signature = r.signature()
+}
+
+// 提议: [高度][轮次][POL 轮次][区块][提议者公钥][签名]
+func encodeProposal(w *codecWriter, p *Proposal) {
+	if w.err == nil {
+		w.err = checkBlock(p.Block)
+	}
+	w.u32(p.Height)
+	w.u32(p.Round)
+	w.u32(uint32(p.POLRound))
+	encodeBlock(w, p.Block)
+	w.bytes(p.Proposer)
+	w.signature(p.Signature)
+}
+
+func decodeProposal(r *codecReader, p *Proposal) {
+	*p = Proposal{
+		Height:   r.u32(),
+		Round:    r.u32(),
+		POLRound: int32(r.u32()),
+		Block:    new(Block),
+	}
+	decodeBlock(r, p.Block)
+	p.Proposer = r.publicKey()
+	p.Signature = r.signature()
+}
+
+// 证书: [高度][轮次][区块 hash][u32 投票数][投票...]
+func encodeCommit(w *codecWriter, c *CommitCertificate) {
+	w.u32(c.Height)
+	w.u32(c.Round)
+	w.hash(c.BlockHash)
+	w.u32(uint32(len(c.Precommits)))
+	for _, v := range c.Precommits {
+		encodeVote(w, v)
+	}
+}
+
+func decodeCommit(r *codecReader, c *CommitCertificate) {
+	*c = CommitCertificate{
+		Height:    r.u32(),
+		Round:     r.u32(),
+		BlockHash: r.hash(),
+	}
+
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		v := new(Vote)
+		decodeVote(r, v)
+		c.Precommits = append(c.Precommits, v)
+	}
+}
+
+// 验证者集合: [u32 数量]([公钥][u64 权重])...
+func encodeValidatorSet(w *codecWriter, s *ValidatorSet) {
+	if w.err == nil && len(s.Validators) != len(s.Weights) {
+		w.err = fmt.Errorf("validator set with (%d) validators and (%d) weights: %w", len(s.Validators), len(s.Weights), ErrInvalidValidators)
+	}
+	w.u32(uint32(len(s.Validators)))
+	for i, v := range s.Validators {
+		w.bytes(v)
+		w.u64(s.Weights[i])
+	}
+}
+
+func decodeValidatorSet(r *codecReader) *ValidatorSet {
+	s := &ValidatorSet{}
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		s.Validators = append(s.Validators, r.bytes())
+		s.Weights = append(s.Weights, r.u64())
+	}
+	return s
+}
+
+// 轻节点区块头: [区块头][出块者公钥][签名][u8 是否有验证者集合][验证者集合][u8 是否有证书][证书]
+func encodeLightHeader(w *codecWriter, h *LightHeader) {
+	if h.Header == nil {
+		if w.err == nil {
+			w.err = fmt.Errorf("light header without header: %w", ErrInvalidProof)
+		}
+		return
+	}
+	encodeSignedHeader(w, h.SignedHeader)
+
+	w.bool(h.NextValidators != nil)
+	if h.NextValidators != nil {
+		encodeValidatorSet(w, h.NextValidators)
+	}
+
+	w.bool(h.Commit != nil)
+	if h.Commit != nil {
+		encodeCommit(w, h.Commit)
+	}
+}
+
+func decodeLightHeader(r *codecReader, h *LightHeader) {
+	*h = LightHeader{SignedHeader: decodeSignedHeader(r)}
+
+	if r.bool() {
+		h.NextValidators = decodeValidatorSet(r)
+	}
+
+	if r.bool() {
+		h.Commit = new(CommitCertificate)
+		decodeCommit(r, h.Commit)
+	}
+}
+
+// 交易证明: [交易 hash][区块 hash][区块头][u32 Index][u32 Total][u32 数量][hash...]
+func encodeTxProof(w *codecWriter, p *TxProof) {
+	if p.Header == nil || p.Proof == nil {
+		if w.err == nil {
+			w.err = fmt.Errorf("tx proof without header or proof: %w", ErrInvalidProof)
+		}
+		return
+	}
+	w.hash(p.TxHash)
+	w.hash(p.BlockHash)
+	encodeHeader(w, p.Header)
+	w.u32(uint32(p.Proof.Index))
+	w.u32(uint32(p.Proof.Total))
+	w.u32(uint32(len(p.Proof.Hashes)))
+	for _, h := range p.Proof.Hashes {
+		w.hash(h)
+	}
+}
+
+func decodeTxProof(r *codecReader, p *TxProof) {
+	*p = TxProof{
+		TxHash:    r.hash(),
+		BlockHash: r.hash(),
+		Header:    decodeHeader(r),
+		Proof: &MerkleProof{
+			Index:  int(r.u32()),
+			Total:  int(r.u32()),
+			Hashes: []types.Hash{},
+		},
+	}
+
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		p.Proof.Hashes = append(p.Proof.Hashes, r.hash())
+	}
+}
+
+// 账户证明: [区块头][地址][余额][nonce][u32 数量][兄弟节点 hash...]
+func encodeAccountProof(w *codecWriter, p *AccountProof) {
+	if p.Header == nil || p.Account == nil || p.Proof == nil {
+		if w.err == nil {
+			w.err = fmt.Errorf("account proof without header, account or proof: %w", ErrInvalidProof)
+		}
+		return
+	}
+	encodeHeader(w, p.Header)
+	w.address(p.Account.Address)
+	w.u64(p.Account.Balance)
+	w.u64(p.Account.Nonce)
+	w.u32(uint32(len(p.Proof.Siblings)))
+	for _, h := range p.Proof.Siblings {
+		w.hash(h)
+	}
+}
+
+func decodeAccountProof(r *codecReader, p *AccountProof) {
+	*p = AccountProof{
+		Header: decodeHeader(r),
+		Account: &Account{
+			Address: r.address(),
+			Balance: r.u64(),
+			Nonce:   r.u64(),
+		},
+		Proof: &SMTProof{Siblings: []types.Hash{}},
+	}
+
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		p.Proof.Siblings = append(p.Proof.Siblings, r.hash())
+	}
+}
+
+// binaryEncoder 在 encode 写入的内容前加上版本号
+type binaryEncoder[T any] struct {
+	w      io.Writer
+	encode func(*codecWriter, T)
+}
+
+func (enc *binaryEncoder[T]) Encode(v T) error {
+	w := newCodecWriter(enc.w)
+	w.u8(CodecVersion)
+	enc.encode(w, v)
+
+	return w.err
+}
+
+type binaryDecoder[T any] struct {
+	r      io.Reader
+	decode func(*codecReader, T)
+}
+
+func (dec *binaryDecoder[T]) Decode(v T) error {
+	r := newCodecReader(dec.r)
+	r.version()
+	if r.err != nil {
+		return r.err
+	}
+	dec.decode(r, v)
+
+	return r.err
+}
+
+func NewBinaryVoteEncoder(w io.Writer) Encoder[*Vote] {
+	return &binaryEncoder[*Vote]{w: w, encode: encodeVote}
+}
+
+func NewBinaryVoteDecoder(r io.Reader) Decoder[*Vote] {
+	return &binaryDecoder[*Vote]{r: r, decode: decodeVote}
+}
+
+func NewBinaryProposalEncoder(w io.Writer) Encoder[*Proposal] {
+	return &binaryEncoder[*Proposal]{w: w, encode: encodeProposal}
+}
+
+func NewBinaryProposalDecoder(r io.Reader) Decoder[*Proposal] {
+	return &binaryDecoder[*Proposal]{r: r, decode: decodeProposal}
+}
+
+func NewBinaryCommitEncoder(w io.Writer) Encoder[*CommitCertificate] {
+	return &binaryEncoder[*CommitCertificate]{w: w, encode: encodeCommit}
+}
+
+func NewBinaryCommitDecoder(r io.Reader) Decoder[*CommitCertificate] {
+	return &binaryDecoder[*CommitCertificate]{r: r, decode: decodeCommit}
+}
+
+func NewBinaryLightHeaderEncoder(w io.Writer) Encoder[*LightHeader] {
+	return &binaryEncoder[*LightHeader]{w: w, encode: encodeLightHeader}
+}
+
+func NewBinaryLightHeaderDecoder(r io.Reader) Decoder[*LightHeader] {
+	return &binaryDecoder[*LightHeader]{r: r, decode: decodeLightHeader}
+}
+
+func NewBinaryTxProofEncoder(w io.Writer) Encoder[*TxProof] {
+	return &binaryEncoder[*TxProof]{w: w, encode: encodeTxProof}
+}
+
+func NewBinaryTxProofDecoder(r io.Reader) Decoder[*TxProof] {
+	return &binaryDecoder[*TxProof]{r: r, decode: decodeTxProof}
+}
+
+func NewBinaryAccountProofEncoder(w io.Writer) Encoder[*AccountProof] {
+	return &binaryEncoder[*AccountProof]{w: w, encode: encodeAccountProof}
+}
+
+func NewBinaryAccountProofDecoder(r io.Reader) Decoder[*AccountProof] {
+	return &binaryDecoder[*AccountProof]{r: r, decode: decodeAccountProof}
+}
+
+// 只统计写入的字节数
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += len(b)
+	return len(b), nil
+}
+
+// Size 是交易二进制编码的字节数, 不包括版本号
+func (tx *Transaction) Size() int {
+	cw := &countingWriter{}
+	encodeTx(newCodecWriter(cw), tx)
+
+	return cw.n
+}
+
+// Size 是区块二进制编码的字节数
+func (b *Block) Size() int {
+	cw := &countingWriter{}
+	encodeBlock(newCodecWriter(cw), b)
+
+	return cw.n
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryCodecTxInner(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	sig, err := privKey.Sign([]byte("collection"))
+	assert.Nil(t, err)
+	a, b := doubleSign(t, privKey, &Header{Version: 1, Height: 4, Timestamp: 100})
+
+	inners := []any{
+		nil,
+		CollectionTx{Fee: 10, MetaData: []byte("collection")},
+		MintTx{
+			Fee:             20,
+			NFT:             types.Hash{0x01},
+			Collection:      types.Hash{0x02},
+			MetaData:        []byte("mint"),
+			CollectionOwner: privKey.PublicKey(),
+			Signature:       *sig,
+		},
+		MintTx{Fee: 1},
+		ValidatorProposalTx{Action: ValidatorRemove, Validator: privKey.PublicKey()},
+		ValidatorVoteTx{Proposal: types.Hash{0x03}, Approve: true},
+		StakeTx{Amount: 100},
+		UnstakeTx{Validator: privKey.PublicKey(), Amount: 50},
+		DelegateTx{Validator: privKey.PublicKey(), Amount: 25},
+		NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b)),
+	}
+
+	for _, inner := range inners {
+		tx := NewTransaction([]byte("foo"))
+		tx.TxInner = inner
+		tx.Nonce = 3
+		tx.Fee = 7
+		assert.Nil(t, tx.Sign(privKey))
+		hash := tx.Hash(TxHasher{})
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
+		encoded := append([]byte(nil), buf.Bytes()...)
+
+		txDecoded := new(Transaction)
+		assert.Nil(t, txDecoded.Decode(NewBinaryTxDecoder(buf)))
+		assert.Equal(t, inner, txDecoded.TxInner)
+		assert.Equal(t, hash, txDecoded.Hash(TxHasher{}))
+		assert.Nil(t, txDecoded.Verify(0))
+
+		// 相同的交易编码结果相同
+		again := &bytes.Buffer{}
+		assert.Nil(t, txDecoded.Encode(NewBinaryTxEncoder(again)))
+		assert.Equal(t, encoded, again.Bytes())
+	}
+}
+
+func TestBinaryCodecRejectsInvalidInput(t *testing.T) {
+	tx := randomTxWithSignature(t)
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
+	data := buf.Bytes()
+
+	// 截断的数据
+	for _, n := range []int{0, 1, len(data) / 2, len(data) - 1} {
+		assert.NotNil(t, new(Transaction).Decode(NewBinaryTxDecoder(bytes.NewReader(data[:n]))))
+	}
+
+	// 未知的版本
+	wrongVersion := append([]byte{CodecVersion + 1}, data[1:]...)
+	err := new(Transaction).Decode(NewBinaryTxDecoder(bytes.NewReader(wrongVersion)))
+	assert.ErrorIs(t, err, ErrCodecVersion)
+
+	// 未知的 TxInner 不能编码
+	tx.TxInner = "unknown"
+	assert.ErrorIs(t, tx.Encode(NewBinaryTxEncoder(&bytes.Buffer{})), ErrUnknownTxInner)
+
+	b := randomBlock(t, 1, types.Hash{})
+	blockBuf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(blockBuf)))
+	blockData := blockBuf.Bytes()
+	assert.NotNil(t, new(Block).Decode(NewBinaryBlockDecoder(bytes.NewReader(blockData[:len(blockData)-1]))))
+}
+
+func TestCodecsRejectInvalidSignatureAndKey(t *testing.T) {
+	encoders := map[string]func(*Transaction, *bytes.Buffer) error{
+		"binary": func(tx *Transaction, buf *bytes.Buffer) error { return tx.Encode(NewBinaryTxEncoder(buf)) },
+		"proto":  func(tx *Transaction, buf *bytes.Buffer) error { return tx.Encode(NewProtoTxEncoder(buf)) },
+	}
+	decoders := map[string]func(*Transaction, *bytes.Buffer) error{
+		"binary": func(tx *Transaction, buf *bytes.Buffer) error { return tx.Decode(NewBinaryTxDecoder(buf)) },
+		"proto":  func(tx *Transaction, buf *bytes.Buffer) error { return tx.Decode(NewProtoTxDecoder(buf)) },
+	}
+
+	for name, encode := range encoders {
+		// R, S 为空的签名
+		tx := randomTxWithSignature(t)
+		tx.Signature = &crypto.Signature{R: big.NewInt(0), S: big.NewInt(0)}
+		buf := &bytes.Buffer{}
+		assert.Nil(t, encode(&tx, buf))
+		assert.ErrorIs(t, decoders[name](new(Transaction), buf), ErrInvalidSignature, name)
+
+		// 不在曲线上的公钥
+		tx = randomTxWithSignature(t)
+		tx.From = append([]byte{0x02}, bytes.Repeat([]byte{0xff}, 32)...)
+		buf = &bytes.Buffer{}
+		assert.Nil(t, encode(&tx, buf))
+		assert.ErrorIs(t, decoders[name](new(Transaction), buf), ErrInvalidPublicKey, name)
+	}
+
+	// 没有经过解码的签名同样不能使校验 panic
+	tx := randomTxWithSignature(t)
+	tx.Signature = &crypto.Signature{}
+	assert.NotNil(t, tx.Verify(0))
+}
+
+func TestHeaderBytesDeterministic(t *testing.T) {
+	h := &Header{
+		Version:   1,
+		ChainID:   2,
+		Height:    3,
+		Timestamp: 4,
+		DataHash:  types.Hash{0x05},
+	}
+	copied := *h
+
+	assert.Equal(t, h.Bytes(), copied.Bytes())
+	assert.Equal(t, CodecVersion, h.Bytes()[0])
+
+	copied.Timestamp = 5
+	assert.NotEqual(t, BlockHasher{}.Hash(h), BlockHasher{}.Hash(&copied))
+}
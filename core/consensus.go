@@ -0,0 +1,197 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+var (
+	ErrInvalidDifficulty = errors.New("invalid block difficulty")
+	ErrInvalidPoW        = errors.New("block hash does not meet difficulty")
+	ErrUncertifiedRound  = errors.New("block round not certified by a proposal or commit")
+)
+
+const (
+	ConsensusPoA = "poa"
+	ConsensusPoW = "pow"
+)
+
+// ChainReader 是校验区块头时需要的链上信息, Blockchain 和 LightChain 都实现了它
+type ChainReader interface {
+	ProposerAt(height, round uint32) (crypto.PublicKey, bool)
+	IsProposer(height, round uint32, pubKey crypto.PublicKey) bool
+	// CertifiedRound 判断区块头的轮次是否由已经校验的 BFT 提议或者证书确定
+	CertifiedRound(h *Header) bool
+}
+
+// ConsensusEngine 决定区块怎样打包, 签名, 校验和参与分叉选择
+type ConsensusEngine interface {
+	// Prepare 在执行交易之前设置区块头中与共识相关的字段, b.Validator 已经设置为出块者
+	Prepare(bc *Blockchain, b *Block) error
+	// Seal 在区块执行完成, 状态根写入之后对区块签名
+	Seal(bc *Blockchain, b *Block, privKey crypto.PrivateKey) error
+	// VerifyHeader 校验区块头中与共识相关的字段, signer 是区块的出块者
+	VerifyHeader(chain ChainReader, h *Header, parent *Header, signer crypto.PublicKey) error
+	// Finalize 在区块的交易执行完之后调用, 调用者持有 stateLock
+	Finalize(bc *Blockchain, b *Block) error
+	// Weight 是区块在分叉选择中的权重
+	Weight(h *Header) uint64
+}
+
+// PoAEngine 由创世配置中的验证者按 ProposerAt 的顺序轮流出块, 每个区块的权重相同
+type PoAEngine struct{}
+
+func NewPoAEngine() *PoAEngine {
+	return &PoAEngine{}
+}
+
+// Prepare 不检查是否轮到出块者, 由调用者和 VerifyHeader 负责
+func (e *PoAEngine) Prepare(bc *Blockchain, b *Block) error {
+	b.Difficulty = 0
+	b.Nonce = 0
+
+	return nil
+}
+
+func (e *PoAEngine) Seal(bc *Blockchain, b *Block, privKey crypto.PrivateKey) error {
+	return b.Sign(privKey)
+}
+
+func (e *PoAEngine) VerifyHeader(chain ChainReader, h *Header, parent *Header, signer crypto.PublicKey) error {
+	hash := BlockHasher{}.Hash(h)
+
+	if h.Difficulty != 0 || h.Nonce != 0 {
+		return fmt.Errorf("block (%s) with difficulty (%d) nonce (%d) in poa chain: %w", hash, h.Difficulty, h.Nonce, ErrInvalidDifficulty)
+	}
+
+	// 出块者不能自己选择轮次, 否则可以选一个轮到自己的位置
+	if h.Round != 0 && !chain.CertifiedRound(h) {
+		return fmt.Errorf("block (%s) with height (%d) round (%d): %w", hash, h.Height, h.Round, ErrUncertifiedRound)
+	}
+
+	if !chain.IsProposer(h.Height, h.Round, signer) {
+		proposer, _ := chain.ProposerAt(h.Height, h.Round)
+		return fmt.Errorf("block (%s) with height (%d) round (%d) signed by (%s) => proposer (%s): %w", hash, h.Height, h.Round, signer, proposer, ErrWrongProposer)
+	}
+
+	return nil
+}
+
+func (e *PoAEngine) Finalize(bc *Blockchain, b *Block) error {
+	return bc.mintBlockReward(b)
+}
+
+func (e *PoAEngine) Weight(h *Header) uint64 {
+	return 1
+}
+
+// 每个区块的难度最多调整父区块难度的 1/difficultyBoundDivisor
+const difficultyBoundDivisor = 16
+
+// PoWEngine 任何节点都可以出块, 区块 hash 必须不大于 2^256/Difficulty
+// 每个区块按与父区块的时间间隔调整难度, 分叉选择取累计难度最大的分支
+type PoWEngine struct {
+	TargetBlockTime time.Duration
+	MinDifficulty   uint64
+}
+
+func NewPoWEngine(targetBlockTime time.Duration, minDifficulty uint64) *PoWEngine {
+	if minDifficulty == 0 {
+		minDifficulty = 1
+	}
+
+	return &PoWEngine{
+		TargetBlockTime: targetBlockTime,
+		MinDifficulty:   minDifficulty,
+	}
+}
+
+// CalcDifficulty 出块间隔比目标短时提高难度, 否则降低难度
+func (e *PoWEngine) CalcDifficulty(parent *Header, timestamp int64) uint64 {
+	difficulty := parent.Difficulty
+	step := difficulty / difficultyBoundDivisor
+	if step == 0 {
+		step = 1
+	}
+
+	if time.Duration(timestamp-parent.Timestamp) < e.TargetBlockTime {
+		if difficulty <= math.MaxUint64-step {
+			difficulty += step
+		}
+	} else if difficulty > step {
+		difficulty -= step
+	}
+
+	if difficulty < e.MinDifficulty {
+		difficulty = e.MinDifficulty
+	}
+
+	return difficulty
+}
+
+func (e *PoWEngine) Prepare(bc *Blockchain, b *Block) error {
+	parent, ok := bc.getNode(b.PrevBlockHash)
+	if !ok {
+		return fmt.Errorf("block with height (%d): %w", b.Height, ErrUnknownParent)
+	}
+
+	b.Difficulty = e.CalcDifficulty(parent.block.Header, b.Timestamp)
+
+	return nil
+}
+
+// Seal 从 0 开始尝试 Nonce, 直到区块头 hash 满足难度
+func (e *PoWEngine) Seal(bc *Blockchain, b *Block, privKey crypto.PrivateKey) error {
+	for nonce := uint64(0); ; nonce++ {
+		b.Nonce = nonce
+		if meetsDifficulty(BlockHasher{}.Hash(b.Header), b.Difficulty) {
+			break
+		}
+		if nonce == math.MaxUint64 {
+			return fmt.Errorf("no nonce meets difficulty (%d): %w", b.Difficulty, ErrInvalidPoW)
+		}
+	}
+	b.hash = types.Hash{}
+
+	return b.Sign(privKey)
+}
+
+func (e *PoWEngine) VerifyHeader(chain ChainReader, h *Header, parent *Header, signer crypto.PublicKey) error {
+	hash := BlockHasher{}.Hash(h)
+
+	if expected := e.CalcDifficulty(parent, h.Timestamp); h.Difficulty != expected {
+		return fmt.Errorf("block (%s) with difficulty (%d) => expected (%d): %w", hash, h.Difficulty, expected, ErrInvalidDifficulty)
+	}
+
+	if !meetsDifficulty(hash, h.Difficulty) {
+		return fmt.Errorf("block (%s) with difficulty (%d): %w", hash, h.Difficulty, ErrInvalidPoW)
+	}
+
+	return nil
+}
+
+func (e *PoWEngine) Finalize(bc *Blockchain, b *Block) error {
+	return bc.mintBlockReward(b)
+}
+
+func (e *PoWEngine) Weight(h *Header) uint64 {
+	return h.Difficulty
+}
+
+var maxTarget = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// hash 作为大端序整数不大于 2^256/difficulty
+func meetsDifficulty(hash types.Hash, difficulty uint64) bool {
+	if difficulty == 0 {
+		return false
+	}
+
+	target := new(big.Int).Div(maxTarget, new(big.Int).SetUint64(difficulty))
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) <= 0
+}
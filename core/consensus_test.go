@@ -0,0 +1,153 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func powGenesis(validator crypto.PublicKey) *Genesis {
+	g := testGenesis(validator)
+	g.Validators = nil
+	g.Consensus = ConsensusPoW
+	g.Difficulty = 16
+	g.TargetBlockTime = 1000
+	g.BlockReward = 10
+	g.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+	return g
+}
+
+// 在 parent 之后按 timestamp 挖一个空区块
+func powBlock(t *testing.T, bc *Blockchain, parent *Header, timestamp int64, privKey crypto.PrivateKey) *Block {
+	header := &Header{
+		Version:       1,
+		ChainID:       bc.ChainID(),
+		PrevBlockHash: BlockHasher{}.Hash(parent),
+		Height:        parent.Height + 1,
+		Timestamp:     timestamp,
+	}
+
+	b, err := NewBlock(header, nil)
+	assert.Nil(t, err)
+	sealBlock(t, bc, b, privKey)
+
+	return b
+}
+
+func TestPoWCalcDifficulty(t *testing.T) {
+	e := NewPoWEngine(time.Second, 16)
+	parent := &Header{Timestamp: 0, Difficulty: 320}
+
+	// 出块太快提高 1/16, 太慢降低 1/16
+	assert.Equal(t, uint64(340), e.CalcDifficulty(parent, int64(time.Millisecond)))
+	assert.Equal(t, uint64(300), e.CalcDifficulty(parent, int64(2*time.Second)))
+
+	// 不低于最低难度, 难度很小时每次至少调整 1
+	parent.Difficulty = 16
+	assert.Equal(t, uint64(16), e.CalcDifficulty(parent, int64(2*time.Second)))
+	assert.Equal(t, uint64(17), e.CalcDifficulty(parent, int64(time.Millisecond)))
+}
+
+func TestPoWSealAndVerify(t *testing.T) {
+	miner := crypto.GeneratePrivateKey()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), powGenesis(miner.PublicKey()))
+	assert.Nil(t, err)
+	assert.IsType(t, &PoWEngine{}, bc.Engine())
+
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(16), genesis.Difficulty)
+
+	b := powBlock(t, bc, genesis, genesis.Timestamp+int64(time.Millisecond), miner)
+	assert.Equal(t, uint64(17), b.Difficulty)
+	assert.True(t, meetsDifficulty(b.Hash(BlockHasher{}), b.Difficulty))
+
+	// 不满足难度的 nonce
+	tampered := *b.Header
+	for !meetsDifficulty(BlockHasher{}.Hash(&tampered), tampered.Difficulty) {
+		tampered.Nonce++
+	}
+	for meetsDifficulty(BlockHasher{}.Hash(&tampered), tampered.Difficulty) {
+		tampered.Nonce++
+	}
+	bad, err := NewBlock(&tampered, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, bad.Sign(miner))
+	assert.ErrorIs(t, bc.AddBlock(bad), ErrInvalidPoW)
+
+	// 难度与父区块推算的不一致
+	wrong := *b.Header
+	wrong.Difficulty = 16
+	bad, err = NewBlock(&wrong, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, bc.Engine().Seal(bc, bad, miner))
+	assert.ErrorIs(t, bc.AddBlock(bad), ErrInvalidDifficulty)
+
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+
+	// 出块奖励给挖出区块的账户
+	balance, err := bc.accountState.GetBalance(miner.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1010), balance)
+}
+
+func TestPoWForkChoiceByDifficulty(t *testing.T) {
+	miner := crypto.GeneratePrivateKey()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), powGenesis(miner.PublicKey()))
+	assert.Nil(t, err)
+
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+
+	// 两个分支高度相同, 出块更快的分支难度更高
+	slow := powBlock(t, bc, genesis, genesis.Timestamp+int64(2*time.Second), miner)
+	fast := powBlock(t, bc, genesis, genesis.Timestamp+int64(time.Millisecond), miner)
+	assert.Less(t, slow.Difficulty, fast.Difficulty)
+
+	assert.Nil(t, bc.AddBlock(slow))
+	assert.Nil(t, bc.AddBlock(fast))
+
+	head, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, fast.Hash(BlockHasher{}), BlockHasher{}.Hash(head))
+}
+
+func TestPoAEngineRejectsDifficulty(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), testGenesis(validator.PublicKey()))
+	assert.Nil(t, err)
+	assert.IsType(t, &PoAEngine{}, bc.Engine())
+
+	b := nextBlock(t, bc)
+	sealBlock(t, bc, b, validator)
+	b.Difficulty = 1
+	b.hash = types.Hash{}
+	assert.Nil(t, b.Sign(validator))
+
+	assert.ErrorIs(t, bc.AddBlock(b), ErrInvalidDifficulty)
+}
+
+func TestGenesisConsensus(t *testing.T) {
+	g := powGenesis(crypto.GeneratePrivateKey().PublicKey())
+	assert.Nil(t, g.Validate())
+
+	g.Difficulty = 0
+	assert.NotNil(t, g.Validate())
+
+	g.Consensus = "pos"
+	assert.NotNil(t, g.Validate())
+
+	// 共识配置不同的创世区块 hash 不同
+	a := testGenesis(crypto.GeneratePrivateKey().PublicKey())
+	b := *a
+	b.Consensus = ConsensusPoW
+	b.Difficulty = 16
+	b.TargetBlockTime = 1000
+	assert.NotEqual(t, a.Hash(), b.Hash())
+}
@@ -0,0 +1,536 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"project-bee/types"
+)
+
+const (
+	blockFileName   = "blocks.dat"
+	indexFileName   = "index.dat"
+	receiptFileName = "receipts.dat"
+	commitFileName  = "commits.dat"
+
+	// height(4) + hash(32) + offset(8) + size(4)
+	indexRecordSize = 48
+
+	truncateOffset int64 = -1
+)
+
+// 索引记录, offset 指向区块文件中长度前缀的位置
+// offset 为 truncateOffset 的记录表示丢弃高于 height 的高度索引
+type indexEntry struct {
+	height uint32
+	hash   types.Hash
+	offset int64
+	size   uint32
+}
+
+func (e indexEntry) bytes() []byte {
+	buf := make([]byte, indexRecordSize)
+	binary.BigEndian.PutUint32(buf[0:4], e.height)
+	copy(buf[4:36], e.hash[:])
+	binary.BigEndian.PutUint64(buf[36:44], uint64(e.offset))
+	binary.BigEndian.PutUint32(buf[44:48], e.size)
+
+	return buf
+}
+
+func indexEntryFromBytes(b []byte) indexEntry {
+	return indexEntry{
+		height: binary.BigEndian.Uint32(b[0:4]),
+		hash:   types.HashFromBytes(b[4:36]),
+		offset: int64(binary.BigEndian.Uint64(b[36:44])),
+		size:   binary.BigEndian.Uint32(b[44:48]),
+	}
+}
+
+// DiskStore 把区块追加写入 blocks.dat, 每条记录为 [4 字节长度][编码后的区块]
+// index.dat 保存定长的高度/hash 索引记录, 启动时加载并与区块文件对齐
+// receipts.dat 和 commits.dat 保存区块的执行结果和最终性证书, 见 recordFile
+type DiskStore struct {
+	lock sync.RWMutex
+
+	blockFile *os.File
+	indexFile *os.File
+	blockSize int64
+
+	heights []indexEntry
+	hashes  map[types.Hash]indexEntry
+
+	receipts *recordFile
+	commits  *recordFile
+}
+
+// recordFile 是按区块 hash 查询的追加写文件, 每条记录为 [4 字节长度][区块 hash][数据]
+// 同一个 hash 写入多次时以最后一次为准
+type recordFile struct {
+	file *os.File
+	size int64
+	// 区块 hash => 记录在文件中的位置
+	offsets map[types.Hash]int64
+}
+
+// 打开文件并扫描建立索引, 截断写了一半的记录
+func openRecordFile(path string) (*recordFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &recordFile{
+		file:    file,
+		offsets: make(map[types.Hash]int64),
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.size = info.Size()
+
+	var offset int64
+	for offset < f.size {
+		prefix := make([]byte, 4+32)
+		if _, err := file.ReadAt(prefix, offset); err != nil {
+			break
+		}
+
+		size := binary.BigEndian.Uint32(prefix[:4])
+		if offset+4+32+int64(size) > f.size {
+			break
+		}
+
+		f.offsets[types.HashFromBytes(prefix[4:])] = offset
+		offset += 4 + 32 + int64(size)
+	}
+
+	if offset < f.size {
+		f.size = offset
+		if err := file.Truncate(offset); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (f *recordFile) put(hash types.Hash, data []byte) error {
+	record := make([]byte, 4+32+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], hash[:])
+	copy(record[4+32:], data)
+
+	if _, err := f.file.WriteAt(record, f.size); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	f.offsets[hash] = f.size
+	f.size += int64(len(record))
+
+	return nil
+}
+
+func (f *recordFile) get(hash types.Hash) ([]byte, bool, error) {
+	offset, ok := f.offsets[hash]
+	if !ok {
+		return nil, false, nil
+	}
+
+	prefix := make([]byte, 4)
+	if _, err := f.file.ReadAt(prefix, offset); err != nil {
+		return nil, true, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(prefix))
+	if _, err := f.file.ReadAt(data, offset+4+32); err != nil {
+		return nil, true, err
+	}
+
+	return data, true, nil
+}
+
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	blockFile, err := os.OpenFile(filepath.Join(dir, blockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	indexFile, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		blockFile.Close()
+		return nil, err
+	}
+
+	s := &DiskStore{
+		blockFile: blockFile,
+		indexFile: indexFile,
+		heights:   []indexEntry{},
+		hashes:    make(map[types.Hash]indexEntry),
+	}
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.receipts, err = openRecordFile(filepath.Join(dir, receiptFileName)); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.commits, err = openRecordFile(filepath.Join(dir, commitFileName)); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// 加载索引, 丢弃写了一半的记录, 并补齐区块文件中尚未写入索引的区块
+func (s *DiskStore) load() error {
+	info, err := s.blockFile.Stat()
+	if err != nil {
+		return err
+	}
+	s.blockSize = info.Size()
+
+	raw, err := io.ReadAll(io.NewSectionReader(s.indexFile, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+
+	var (
+		valid int64
+		next  int64
+	)
+	for i := 0; i+indexRecordSize <= len(raw); i += indexRecordSize {
+		entry := indexEntryFromBytes(raw[i : i+indexRecordSize])
+		if entry.offset == truncateOffset {
+			s.apply(entry)
+			valid = int64(i + indexRecordSize)
+			continue
+		}
+
+		if entry.offset+4+int64(entry.size) > s.blockSize {
+			break
+		}
+
+		s.apply(entry)
+		valid = int64(i + indexRecordSize)
+		if end := entry.offset + 4 + int64(entry.size); end > next {
+			next = end
+		}
+	}
+
+	if err := s.indexFile.Truncate(valid); err != nil {
+		return err
+	}
+
+	return s.recover(next)
+}
+
+// 从 offset 开始扫描区块文件, 把没有索引的区块重新写入索引
+func (s *DiskStore) recover(offset int64) error {
+	for offset < s.blockSize {
+		b, size, err := s.readBlock(offset)
+		if err != nil {
+			// 区块只写了一部分, 截断文件
+			s.blockSize = offset
+			return s.blockFile.Truncate(offset)
+		}
+
+		entry := indexEntry{
+			height: b.Height,
+			hash:   b.Hash(BlockHasher{}),
+			offset: offset,
+			size:   size,
+		}
+		if int(entry.height) > len(s.heights) {
+			return fmt.Errorf("block file is corrupted at offset (%d)", offset)
+		}
+		if err := s.writeIndex(entry); err != nil {
+			return err
+		}
+		s.apply(entry)
+
+		offset += 4 + int64(size)
+	}
+
+	return nil
+}
+
+func (s *DiskStore) apply(entry indexEntry) {
+	if entry.offset == truncateOffset {
+		s.truncate(entry.height + 1)
+		return
+	}
+
+	// 同一高度的新区块替换旧的区块
+	s.truncate(entry.height)
+	s.heights = append(s.heights, entry)
+	s.hashes[entry.hash] = entry
+}
+
+// truncate 丢弃从 height 开始的区块, 被丢弃的区块不能再按 hash 查询
+func (s *DiskStore) truncate(height uint32) {
+	if int(height) >= len(s.heights) {
+		return
+	}
+
+	for _, e := range s.heights[height:] {
+		delete(s.hashes, e.hash)
+	}
+	s.heights = s.heights[:height]
+}
+
+func (s *DiskStore) writeIndex(entry indexEntry) error {
+	info, err := s.indexFile.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := s.indexFile.WriteAt(entry.bytes(), info.Size()); err != nil {
+		return err
+	}
+
+	return s.indexFile.Sync()
+}
+
+func (s *DiskStore) readBlock(offset int64) (*Block, uint32, error) {
+	prefix := make([]byte, 4)
+	if _, err := s.blockFile.ReadAt(prefix, offset); err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix)
+	if offset+4+int64(size) > s.blockSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, size)
+	if _, err := s.blockFile.ReadAt(data, offset+4); err != nil {
+		return nil, 0, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewBinaryBlockDecoder(bytes.NewReader(data))); err != nil {
+		return nil, 0, err
+	}
+
+	return b, size, nil
+}
+
+func (s *DiskStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(b.Height) > len(s.heights) {
+		return fmt.Errorf("cannot store block with height (%d) => stored height (%d)", b.Height, len(s.heights)-1)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewBinaryBlockEncoder(buf)); err != nil {
+		return err
+	}
+
+	record := make([]byte, 4+buf.Len())
+	binary.BigEndian.PutUint32(record, uint32(buf.Len()))
+	copy(record[4:], buf.Bytes())
+
+	if _, err := s.blockFile.WriteAt(record, s.blockSize); err != nil {
+		return err
+	}
+	if err := s.blockFile.Sync(); err != nil {
+		return err
+	}
+
+	entry := indexEntry{
+		height: b.Height,
+		hash:   b.Hash(BlockHasher{}),
+		offset: s.blockSize,
+		size:   uint32(buf.Len()),
+	}
+	s.blockSize += int64(len(record))
+
+	if err := s.writeIndex(entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+
+	return nil
+}
+
+func (s *DiskStore) Truncate(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry := indexEntry{
+		height: height,
+		offset: truncateOffset,
+	}
+	if err := s.writeIndex(entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+
+	return nil
+}
+
+// 重启时按 blocks.dat 中的区块重建状态, 所以不删除区块体, 内存中只有索引
+func (s *DiskStore) Prune(height uint32) error {
+	return nil
+}
+
+func (s *DiskStore) PutReceipts(hash types.Hash, receipts []*Receipt) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(receipts); err != nil {
+		return err
+	}
+
+	return s.receipts.put(hash, buf.Bytes())
+}
+
+func (s *DiskStore) GetReceipts(hash types.Hash) ([]*Receipt, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data, ok, err := s.receipts.get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("receipts of block (%s) not found", hash)
+	}
+
+	receipts := []*Receipt{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&receipts); err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+func (s *DiskStore) PutCommit(hash types.Hash, c *CommitCertificate) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	if err := c.Encode(NewBinaryCommitEncoder(buf)); err != nil {
+		return err
+	}
+
+	return s.commits.put(hash, buf.Bytes())
+}
+
+func (s *DiskStore) GetCommit(hash types.Hash) (*CommitCertificate, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data, ok, err := s.commits.get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("commit of block (%s) not found", hash)
+	}
+
+	c := new(CommitCertificate)
+	if err := c.Decode(NewBinaryCommitDecoder(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *DiskStore) Get(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	entry, ok := s.hashes[hash]
+	if !ok {
+		return nil, fmt.Errorf("block with hash (%s) not found", hash)
+	}
+
+	b, _, err := s.readBlock(entry.offset)
+	return b, err
+}
+
+func (s *DiskStore) GetByHeight(height uint32) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.heights) {
+		return nil, fmt.Errorf("block with height (%d) not found", height)
+	}
+
+	b, _, err := s.readBlock(s.heights[height].offset)
+	return b, err
+}
+
+func (s *DiskStore) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.hashes[hash]
+	return ok
+}
+
+func (s *DiskStore) Iterate(fn func(*Block) error) error {
+	s.lock.RLock()
+	entries := make([]indexEntry, len(s.heights))
+	copy(entries, s.heights)
+	s.lock.RUnlock()
+
+	for _, entry := range entries {
+		s.lock.RLock()
+		b, _, err := s.readBlock(entry.offset)
+		s.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DiskStore) Close() error {
+	files := []*os.File{s.blockFile, s.indexFile}
+	if s.receipts != nil {
+		files = append(files, s.receipts.file)
+	}
+	if s.commits != nil {
+		files = append(files, s.commits.file)
+	}
+
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -6,8 +6,9 @@ import (
 )
 
 //
-// For now we GOB encoding is used for fast bootstrapping of the project
-// in a later phase I'm considering using Protobuffers as default encoding / decoding.
+// 哈希, 存储和网络默认使用 codec.go 中带版本的 binary 编码
+// protobuf (proto_codec.go) 和 GOB 是可以协商的网络编码, 迁移期间使用不同编码的节点可以互相通信,
+// 所以每种网络消息都需要这三种编码
 //
 
 type Encoder[T any] interface {
@@ -74,4 +75,69 @@ func NewGobBlockDecoder(r io.Reader) *GobBlockDecoder {
 // 反序列化区块
 func (dec *GobBlockDecoder) Decode(b *Block) error {
 	return gob.NewDecoder(dec.r).Decode(b)
-}
\ No newline at end of file
+}
+
+// gobEncoder 用于除交易和区块以外的网络消息
+type gobEncoder[T any] struct {
+	w io.Writer
+}
+
+func (enc *gobEncoder[T]) Encode(v T) error {
+	return gob.NewEncoder(enc.w).Encode(v)
+}
+
+type gobDecoder[T any] struct {
+	r io.Reader
+}
+
+func (dec *gobDecoder[T]) Decode(v T) error {
+	return gob.NewDecoder(dec.r).Decode(v)
+}
+
+func NewGobVoteEncoder(w io.Writer) Encoder[*Vote] {
+	return &gobEncoder[*Vote]{w: w}
+}
+
+func NewGobVoteDecoder(r io.Reader) Decoder[*Vote] {
+	return &gobDecoder[*Vote]{r: r}
+}
+
+func NewGobProposalEncoder(w io.Writer) Encoder[*Proposal] {
+	return &gobEncoder[*Proposal]{w: w}
+}
+
+func NewGobProposalDecoder(r io.Reader) Decoder[*Proposal] {
+	return &gobDecoder[*Proposal]{r: r}
+}
+
+func NewGobCommitEncoder(w io.Writer) Encoder[*CommitCertificate] {
+	return &gobEncoder[*CommitCertificate]{w: w}
+}
+
+func NewGobCommitDecoder(r io.Reader) Decoder[*CommitCertificate] {
+	return &gobDecoder[*CommitCertificate]{r: r}
+}
+
+func NewGobLightHeaderEncoder(w io.Writer) Encoder[*LightHeader] {
+	return &gobEncoder[*LightHeader]{w: w}
+}
+
+func NewGobLightHeaderDecoder(r io.Reader) Decoder[*LightHeader] {
+	return &gobDecoder[*LightHeader]{r: r}
+}
+
+func NewGobTxProofEncoder(w io.Writer) Encoder[*TxProof] {
+	return &gobEncoder[*TxProof]{w: w}
+}
+
+func NewGobTxProofDecoder(r io.Reader) Decoder[*TxProof] {
+	return &gobDecoder[*TxProof]{r: r}
+}
+
+func NewGobAccountProofEncoder(w io.Writer) Encoder[*AccountProof] {
+	return &gobEncoder[*AccountProof]{w: w}
+}
+
+func NewGobAccountProofDecoder(r io.Reader) Decoder[*AccountProof] {
+	return &gobDecoder[*AccountProof]{r: r}
+}
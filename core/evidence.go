@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+var (
+	ErrInvalidEvidence = errors.New("invalid double sign evidence")
+	ErrDoubleSign      = errors.New("refusing to sign a second block at the same height and round")
+)
+
+// 创世配置没有指定时, 双签的验证者被罚没的抵押百分比
+const DefaultSlashFraction = 10
+
+// SignedHeader 是验证者签名的区块头, 不包括交易
+type SignedHeader struct {
+	Header    *Header
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+func SignedHeaderOf(b *Block) SignedHeader {
+	return SignedHeader{
+		Header:    b.Header,
+		Validator: b.Validator,
+		Signature: b.Signature,
+	}
+}
+
+func (h SignedHeader) Hash() types.Hash {
+	return BlockHasher{}.Hash(h.Header)
+}
+
+func (h SignedHeader) Verify() error {
+	if h.Header == nil || h.Signature == nil {
+		return fmt.Errorf("signed header without header or signature: %w", ErrInvalidEvidence)
+	}
+
+	if !h.Signature.Verify(h.Validator, signingHash(blockSigningDomain, h.Hash())) {
+		return fmt.Errorf("header (%s) with invalid signature: %w", h.Hash(), ErrInvalidEvidence)
+	}
+
+	return nil
+}
+
+// EvidenceTx 证明同一个验证者在同一高度和轮次签名了两个不同的区块
+// 不同轮次的区块可以不同, 共识进入下一轮之后出块者可以提议新的区块
+type EvidenceTx struct {
+	First  SignedHeader
+	Second SignedHeader
+}
+
+// NewEvidence 按区块头 hash 排序, 同一对区块总是得到相同的证据
+func NewEvidence(a, b SignedHeader) EvidenceTx {
+	ha, hb := a.Hash(), b.Hash()
+	if bytes.Compare(ha[:], hb[:]) > 0 {
+		a, b = b, a
+	}
+
+	return EvidenceTx{First: a, Second: b}
+}
+
+func (e EvidenceTx) Offender() crypto.PublicKey {
+	return e.First.Validator
+}
+
+func (e EvidenceTx) Height() uint32 {
+	return e.First.Header.Height
+}
+
+// Verify 校验两个区块头属于 chainID, 由同一个验证者在同一高度和轮次签名, 并且内容不同
+func (e EvidenceTx) Verify(chainID uint32) error {
+	if err := e.First.Verify(); err != nil {
+		return err
+	}
+	if err := e.Second.Verify(); err != nil {
+		return err
+	}
+
+	a, b := e.First.Header, e.Second.Header
+	if a.ChainID != chainID || b.ChainID != chainID {
+		return fmt.Errorf("evidence with chain id (%d, %d) => chain id (%d): %w", a.ChainID, b.ChainID, chainID, ErrInvalidEvidence)
+	}
+
+	if !bytes.Equal(e.First.Validator, e.Second.Validator) {
+		return fmt.Errorf("evidence signed by (%s) and (%s): %w", e.First.Validator, e.Second.Validator, ErrInvalidEvidence)
+	}
+
+	if a.Height != b.Height || a.Round != b.Round {
+		return fmt.Errorf("evidence at height (%d, %d) round (%d, %d): %w", a.Height, b.Height, a.Round, b.Round, ErrInvalidEvidence)
+	}
+
+	if e.First.Hash() == e.Second.Hash() {
+		return fmt.Errorf("evidence with the same header (%s): %w", e.First.Hash(), ErrInvalidEvidence)
+	}
+
+	return nil
+}
+
+// 每个验证者在每个高度最多被罚没一次
+type slashKey struct {
+	Validator types.Address
+	Height    uint32
+}
+
+// 执行高度为 height 的区块中的证据交易, 罚没双签验证者的抵押, 并在下一个 epoch 把它移出验证者集合
+// 调用者需要持有 stateLock
+func (bc *Blockchain) handleEvidence(tx *Transaction, height uint32) error {
+	hash := tx.Hash(TxHasher{})
+	e := tx.TxInner.(EvidenceTx)
+
+	if err := e.Verify(bc.chainID); err != nil {
+		return fmt.Errorf("tx (%s): %w", hash, err)
+	}
+
+	offender := e.Offender()
+	if e.Height() >= height {
+		return fmt.Errorf("tx (%s) with evidence at height (%d) => height (%d): %w", hash, e.Height(), height, ErrInvalidEvidence)
+	}
+	// 解绑期之前的抵押可能已经取回, 不再接受
+	if height-e.Height() > bc.unbondingPeriod {
+		return fmt.Errorf("tx (%s) with evidence at height (%d) older than unbonding period (%d): %w", hash, e.Height(), bc.unbondingPeriod, ErrInvalidEvidence)
+	}
+	if !containsKey(bc.ValidatorsAt(e.Height()), offender) {
+		return fmt.Errorf("tx (%s) with evidence against (%s) which was not a validator: %w", hash, offender, ErrInvalidEvidence)
+	}
+
+	key := slashKey{Validator: offender.Address(), Height: e.Height()}
+	if bc.slashed[key] {
+		return fmt.Errorf("tx (%s) validator (%s) already slashed at height (%d): %w", hash, offender, e.Height(), ErrInvalidEvidence)
+	}
+	bc.setSlashed(key, true)
+
+	slashed := bc.slash(key.Validator)
+	bc.setSupply(bc.supply - slashed)
+
+	if containsKey(bc.ValidatorsAt(height), offender) {
+		bc.setProposal(hash, &ValidatorProposal{
+			Action:          ValidatorRemove,
+			Validator:       offender,
+			Proposer:        tx.From,
+			Height:          height,
+			Status:          ProposalApproved,
+			EffectiveHeight: bc.EpochStart(height),
+		})
+	}
+
+	bc.logger.Log("msg", "validator slashed for double signing", "validator", offender, "height", e.Height(), "slashed", slashed)
+
+	return nil
+}
+
+// slash 按比例罚没验证者名下的全部抵押, 包括解绑中的部分, 返回罚没的总量
+func (bc *Blockchain) slash(validator types.Address) uint64 {
+	cut := func(amount uint64) uint64 {
+		return amount/100*bc.slashFraction + amount%100*bc.slashFraction/100
+	}
+
+	var total uint64
+	for key, amount := range bc.bonds {
+		if key.Validator != validator {
+			continue
+		}
+		c := cut(amount)
+		bc.setBond(key, amount-c)
+		total += c
+	}
+
+	unbonding := make([]Unbonding, len(bc.unbonding))
+	for i, u := range bc.unbonding {
+		if u.Validator == validator {
+			c := cut(u.Amount)
+			u.Amount -= c
+			total += c
+		}
+		unbonding[i] = u
+	}
+	bc.setUnbonding(unbonding)
+
+	return total
+}
+
+type signSlot struct {
+	signer string
+	height uint32
+	round  uint32
+}
+
+// 只保留最近这么多个高度的签名记录
+const signGuardWindow = 64
+
+// SignGuard 记录本节点签名过的区块, 拒绝在同一高度和轮次签名第二个不同的区块
+// 记录只在内存中, 重启之后从空开始
+type SignGuard struct {
+	lock   sync.Mutex
+	signed map[signSlot]types.Hash
+}
+
+func NewSignGuard() *SignGuard {
+	return &SignGuard{
+		signed: make(map[signSlot]types.Hash),
+	}
+}
+
+// Check 记录区块的签名, 同一高度和轮次已经签名过不同的区块时返回 ErrDoubleSign
+func (g *SignGuard) Check(b *Block) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	slot := signSlot{signer: string(b.Validator), height: b.Height, round: b.Round}
+	hash := b.Hash(BlockHasher{})
+
+	if prev, ok := g.signed[slot]; ok && prev != hash {
+		return fmt.Errorf("block (%s) at height (%d) round (%d) => signed (%s): %w", hash, b.Height, b.Round, prev, ErrDoubleSign)
+	}
+	g.signed[slot] = hash
+
+	for s := range g.signed {
+		if s.height+signGuardWindow < b.Height {
+			delete(g.signed, s)
+		}
+	}
+
+	return nil
+}
+
+// SetSignGuard 设置之后 SealBlock 不会在同一高度和轮次签名两个不同的区块
+func (bc *Blockchain) SetSignGuard(g *SignGuard) {
+	bc.signGuard = g
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"project-bee/crypto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 用 key 在 parent 之后签名两个时间戳不同的区块
+func doubleSign(t *testing.T, key crypto.PrivateKey, parent *Header) (*Block, *Block) {
+	blocks := make([]*Block, 2)
+	for i := range blocks {
+		b, err := NewBlockFromPrevHeader(parent, nil)
+		assert.Nil(t, err)
+		b.Timestamp = parent.Timestamp + int64(i+1)
+		assert.Nil(t, b.Sign(key))
+		blocks[i] = b
+	}
+
+	return blocks[0], blocks[1]
+}
+
+func TestEvidenceVerify(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	parent := &Header{Version: 1, ChainID: 7, Height: 3, Timestamp: 100}
+	a, b := doubleSign(t, key, parent)
+
+	e := NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b))
+	assert.Nil(t, e.Verify(7))
+	assert.Equal(t, key.PublicKey(), e.Offender())
+	assert.Equal(t, uint32(4), e.Height())
+
+	// 顺序不影响证据
+	assert.Equal(t, e, NewEvidence(SignedHeaderOf(b), SignedHeaderOf(a)))
+
+	assert.ErrorIs(t, e.Verify(8), ErrInvalidEvidence)
+	assert.ErrorIs(t, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(a)).Verify(7), ErrInvalidEvidence)
+
+	// 不同轮次可以提议不同的区块
+	next, err := NewBlockFromPrevHeader(parent, nil)
+	assert.Nil(t, err)
+	next.Round = 1
+	assert.Nil(t, next.Sign(key))
+	assert.ErrorIs(t, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(next)).Verify(7), ErrInvalidEvidence)
+
+	// 两个验证者各自签名
+	other, _ := doubleSign(t, crypto.GeneratePrivateKey(), parent)
+	assert.ErrorIs(t, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(other)).Verify(7), ErrInvalidEvidence)
+
+	// 签名之后修改的区块头
+	tampered := SignedHeaderOf(b)
+	header := *tampered.Header
+	header.Timestamp++
+	tampered.Header = &header
+	assert.ErrorIs(t, NewEvidence(SignedHeaderOf(a), tampered).Verify(7), ErrInvalidEvidence)
+}
+
+func TestEvidenceSlashesValidator(t *testing.T) {
+	c := newGovernanceChain(t, 3, 1)
+	offender, reporter, delegator := c.keys[0], c.keys[1], c.keys[3]
+	address := offender.PublicKey().Address()
+
+	c.addBlock(t,
+		c.tx(t, offender, StakeTx{Amount: 500}),
+		c.tx(t, delegator, DelegateTx{Validator: offender.PublicKey(), Amount: 200}),
+	)
+	c.addBlock(t, c.tx(t, delegator, UnstakeTx{Validator: offender.PublicKey(), Amount: 100}))
+
+	parent, err := c.bc.GetHeader(1)
+	assert.Nil(t, err)
+	a, b := doubleSign(t, offender, parent)
+	evidence := NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b))
+
+	supply := c.bc.Supply()
+	tx := c.tx(t, reporter, evidence)
+	again := c.tx(t, reporter, evidence)
+	c.addBlock(t, tx, again)
+
+	receipt, err := c.bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptSuccess, receipt.Status)
+
+	// 同一个高度只罚没一次
+	receipt, err = c.bc.GetReceipt(again.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+
+	// 抵押和解绑中的部分都罚没 10%
+	vs := c.bc.ValidatorStake(address)
+	assert.Equal(t, uint64(450), vs.SelfStake)
+	assert.Equal(t, uint64(540), vs.TotalStake)
+	_, unbonding := c.bc.Bonded(delegator.PublicKey().Address())
+	assert.Equal(t, uint64(90), unbonding)
+	assert.Equal(t, supply-70, c.bc.Supply())
+
+	// 在下一个 epoch 移出验证者集合
+	assert.True(t, c.bc.IsValidator(3, offender.PublicKey()))
+	c.addBlock(t)
+	assert.False(t, c.bc.IsValidator(4, offender.PublicKey()))
+	assert.Len(t, c.bc.ValidatorsAt(4), 2)
+
+	// 撤销恢复罚没之前的状态
+	assert.Nil(t, c.bc.RevertTo(2))
+	vs = c.bc.ValidatorStake(address)
+	assert.Equal(t, uint64(600), vs.TotalStake)
+	assert.Equal(t, supply, c.bc.Supply())
+	assert.True(t, c.bc.IsValidator(4, offender.PublicKey()))
+}
+
+func TestEvidenceRejected(t *testing.T) {
+	c := newGovernanceChain(t, 2, 1)
+	outsider, reporter := c.keys[2], c.keys[1]
+
+	c.addBlock(t)
+	parent, err := c.bc.GetHeader(0)
+	assert.Nil(t, err)
+
+	// 不是验证者的账户双签不算
+	a, b := doubleSign(t, outsider, parent)
+	notValidator := c.tx(t, reporter, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b)))
+
+	// 还没有出块的高度
+	header, err := c.bc.GetHeader(1)
+	assert.Nil(t, err)
+	a, b = doubleSign(t, c.keys[0], header)
+	future := c.tx(t, reporter, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b)))
+	c.addBlock(t, notValidator, future)
+
+	for _, tx := range []*Transaction{notValidator, future} {
+		receipt, err := c.bc.GetReceipt(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, ReceiptFailed, receipt.Status)
+	}
+
+	// 超过解绑期的证据
+	for c.bc.Height() < 6 {
+		c.addBlock(t)
+	}
+	old := c.tx(t, reporter, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b)))
+	c.addBlock(t, old)
+	receipt, err := c.bc.GetReceipt(old.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+}
+
+func TestSignGuard(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	a, b := doubleSign(t, key, &Header{Version: 1, Height: 1, Timestamp: 100})
+
+	g := NewSignGuard()
+	assert.Nil(t, g.Check(a))
+	assert.Nil(t, g.Check(a))
+	assert.ErrorIs(t, g.Check(b), ErrDoubleSign)
+
+	// 下一轮可以签名不同的区块
+	b.Round = 1
+	assert.Nil(t, b.Sign(key))
+	assert.Nil(t, g.Check(b))
+}
+
+func TestSealBlockSignGuard(t *testing.T) {
+	c := newGovernanceChain(t, 1, 0)
+	c.bc.SetSignGuard(NewSignGuard())
+
+	header, err := c.bc.GetHeader(0)
+	assert.Nil(t, err)
+	a, b := doubleSign(t, c.keys[0], header)
+
+	assert.Nil(t, c.bc.SealBlock(a, c.keys[0]))
+	assert.ErrorIs(t, c.bc.SealBlock(b, c.keys[0]), ErrDoubleSign)
+}
+
+func TestSealOnSideBranchKeepsSlash(t *testing.T) {
+	c := newGovernanceChain(t, 3, 0)
+	offender, reporter := c.keys[0], c.keys[1]
+
+	c.addBlock(t)
+	genesis, err := c.bc.GetHeader(0)
+	assert.Nil(t, err)
+	a, b := doubleSign(t, offender, genesis)
+	c.addBlock(t, c.tx(t, reporter, NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b))))
+
+	key := slashKey{Validator: offender.PublicKey().Address(), Height: 1}
+	assert.True(t, c.bc.slashed[key])
+	root := c.bc.StateRoot()
+
+	// 在规范链最高区块之前的父区块上打包, 撤销规范链区块之后要恢复罚没记录
+	parent, err := c.bc.GetHeader(1)
+	assert.Nil(t, err)
+	side, err := NewBlockFromPrevHeader(parent, nil)
+	assert.Nil(t, err)
+	proposer, ok := c.bc.ProposerAt(side.Height, 0)
+	assert.True(t, ok)
+	for _, k := range c.keys {
+		if bytes.Equal(k.PublicKey(), proposer) {
+			assert.Nil(t, c.bc.SealBlock(side, k))
+		}
+	}
+	assert.NotNil(t, side.Signature)
+
+	assert.True(t, c.bc.slashed[key])
+	assert.Equal(t, root, c.bc.StateRoot())
+	assert.Equal(t, root, rebuiltStateRoot(c.bc))
+
+	c.addBlock(t)
+	assert.Equal(t, uint32(3), c.bc.Height())
+}
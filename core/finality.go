@@ -0,0 +1,199 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"project-bee/types"
+)
+
+var ErrFinalityConflict = errors.New("block conflicts with finalized block")
+
+// Finalize 把证书对应的区块设为最终区块, 最终区块和它的祖先不会再被重组
+// 区块在侧链上时先切换到它所在的分支
+func (bc *Blockchain) Finalize(c *CommitCertificate) error {
+	if err := c.Verify(bc.chainID, bc.ValidatorsAt(c.Height)); err != nil {
+		return err
+	}
+
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	node, ok := bc.getNode(c.BlockHash)
+	if !ok {
+		return fmt.Errorf("commit for unknown block (%s)", c.BlockHash)
+	}
+
+	if node.block.Height != c.Height {
+		return fmt.Errorf("commit for height (%d) => block (%s) height (%d): %w", c.Height, c.BlockHash, node.block.Height, ErrInvalidCommit)
+	}
+
+	bc.lock.RLock()
+	finalized := bc.finalized
+	bc.lock.RUnlock()
+
+	if node == finalized {
+		return nil
+	}
+
+	if !bc.extendsFinalized(node) {
+		return fmt.Errorf("block (%s) with height (%d) => finalized (%s) height (%d): %w", c.BlockHash, c.Height, finalized.hash(), finalized.block.Height, ErrFinalityConflict)
+	}
+
+	if !bc.isCanonical(node) {
+		if err := bc.reorg(node); err != nil {
+			return err
+		}
+	}
+
+	if err := bc.store.PutCommit(c.BlockHash, c); err != nil {
+		return err
+	}
+
+	bc.lock.Lock()
+	bc.finalized = node
+	bc.lock.Unlock()
+
+	bc.logger.Log("msg", "block finalized", "hash", c.BlockHash, "height", c.Height, "round", c.Round)
+
+	return nil
+}
+
+// AddCommittedBlock 校验区块的证书, 把区块加入链并设为最终区块
+// 区块的轮次由证书确定, 不能高于证书的轮次
+func (bc *Blockchain) AddCommittedBlock(b *Block, c *CommitCertificate) error {
+	hash := b.Hash(BlockHasher{})
+	if c.BlockHash != hash || c.Height != b.Height || b.Round > c.Round {
+		return fmt.Errorf("commit for block (%s) height (%d) round (%d) => block (%s) height (%d) round (%d): %w", c.BlockHash, c.Height, c.Round, hash, b.Height, b.Round, ErrInvalidCommit)
+	}
+
+	if err := c.Verify(bc.chainID, bc.ValidatorsAt(c.Height)); err != nil {
+		return err
+	}
+
+	defer bc.certifyRound(hash, c.Round)()
+
+	if err := bc.AddBlock(b); err != nil && !errors.Is(err, ErrBlockKnown) {
+		return err
+	}
+
+	return bc.Finalize(c)
+}
+
+// CheckProposal 校验提议由该轮的出块者签名, 然后在父区块的状态上执行提议的区块
+func (bc *Blockchain) CheckProposal(p *Proposal) error {
+	if err := p.Verify(); err != nil {
+		return err
+	}
+
+	if !bc.IsProposer(p.Height, p.Round, p.Proposer) {
+		proposer, _ := bc.ProposerAt(p.Height, p.Round)
+		return fmt.Errorf("proposal for height (%d) round (%d) from (%s) => proposer (%s): %w", p.Height, p.Round, p.Proposer, proposer, ErrWrongProposer)
+	}
+
+	defer bc.certifyRound(p.Block.Hash(BlockHasher{}), p.Round)()
+
+	return bc.CheckBlock(p.Block)
+}
+
+func (bc *Blockchain) CertifiedRound(h *Header) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	round, ok := bc.certified[BlockHasher{}.Hash(h)]
+	return ok && h.Round <= round
+}
+
+// certifyRound 在校验区块期间记录区块的轮次已经确定, 返回的函数删除记录
+func (bc *Blockchain) certifyRound(hash types.Hash, round uint32) func() {
+	bc.lock.Lock()
+	bc.certified[hash] = round
+	bc.lock.Unlock()
+
+	return func() {
+		bc.lock.Lock()
+		delete(bc.certified, hash)
+		bc.lock.Unlock()
+	}
+}
+
+// FinalizedHeight 是最高的最终区块的高度, 创世区块总是最终的
+func (bc *Blockchain) FinalizedHeight() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if bc.finalized == nil {
+		return 0
+	}
+
+	return bc.finalized.block.Height
+}
+
+// GetCommit 返回区块的最终性证书
+func (bc *Blockchain) GetCommit(hash types.Hash) (*CommitCertificate, error) {
+	return bc.store.GetCommit(hash)
+}
+
+// CheckBlock 校验区块并在父区块的状态上执行, 执行结果不保留
+func (bc *Blockchain) CheckBlock(b *Block) error {
+	if err := bc.validator.ValidateBlock(b); err != nil {
+		return err
+	}
+
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	restore, err := bc.switchToBranch(b.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	j, _, err := bc.connectBlock(b)
+	if err != nil {
+		return err
+	}
+	j.undo(bc)
+
+	return nil
+}
+
+// extendsFinalized 判断节点是否是最终区块或者它的后代
+func (bc *Blockchain) extendsFinalized(node *blockNode) bool {
+	bc.lock.RLock()
+	finalized := bc.finalized
+	bc.lock.RUnlock()
+
+	if finalized == nil {
+		return true
+	}
+
+	for node != nil && node.block.Height > finalized.block.Height {
+		node = node.parent
+	}
+
+	return node == finalized
+}
+
+func (bc *Blockchain) isCanonical(node *blockNode) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	height := int(node.block.Height)
+	return height < len(bc.blocks) && bc.blocks[height] == node.block
+}
+
+// 从 store 中恢复最高的最终区块, 证书在写入时已经校验过
+func (bc *Blockchain) loadFinalized() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	for i := len(bc.blocks) - 1; i > 0; i-- {
+		hash := bc.blocks[i].Hash(BlockHasher{})
+		if _, err := bc.store.GetCommit(hash); err == nil {
+			bc.finalized = bc.nodes[hash]
+			return
+		}
+	}
+	bc.finalized = bc.nodes[bc.blocks[0].Hash(BlockHasher{})]
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinalizePreventsReorg(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), testGenesis(validator.PublicKey()))
+	assert.Nil(t, err)
+
+	a1 := validatorBlock(t, bc, bc.GenesisHash(), 1, validator)
+	assert.Nil(t, bc.AddBlock(a1))
+	b1 := validatorBlock(t, bc, bc.GenesisHash(), 1, validator)
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := validatorBlock(t, bc, b1.Hash(BlockHasher{}), 2, validator)
+
+	// 证书签名者不在验证者集合中
+	other := crypto.GeneratePrivateKey()
+	assert.ErrorIs(t, bc.Finalize(testCommit(t, bc.ChainID(), 1, 0, a1.Hash(BlockHasher{}), other)), ErrInvalidCommit)
+	assert.Equal(t, uint32(0), bc.FinalizedHeight())
+
+	c := testCommit(t, bc.ChainID(), 1, 0, a1.Hash(BlockHasher{}), validator)
+	assert.Nil(t, bc.Finalize(c))
+	assert.Nil(t, bc.Finalize(c))
+	assert.Equal(t, uint32(1), bc.FinalizedHeight())
+
+	stored, err := bc.GetCommit(a1.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, c, stored)
+
+	// 更长的侧链也不能替换最终区块
+	assert.ErrorIs(t, bc.AddBlock(b2), ErrFinalityConflict)
+	assert.ErrorIs(t, bc.Finalize(testCommit(t, bc.ChainID(), 1, 1, b1.Hash(BlockHasher{}), validator)), ErrFinalityConflict)
+	assert.ErrorIs(t, bc.RevertTo(0), ErrFinalityConflict)
+
+	fetched, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, a1.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+}
+
+func TestFinalizeSideChain(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), testGenesis(validator.PublicKey()))
+	assert.Nil(t, err)
+
+	a1 := validatorBlock(t, bc, bc.GenesisHash(), 1, validator)
+	assert.Nil(t, bc.AddBlock(a1))
+	a2 := validatorBlock(t, bc, a1.Hash(BlockHasher{}), 2, validator)
+	assert.Nil(t, bc.AddBlock(a2))
+	b1 := validatorBlock(t, bc, bc.GenesisHash(), 1, validator)
+	assert.Nil(t, bc.AddBlock(b1))
+
+	assert.Nil(t, bc.Finalize(testCommit(t, bc.ChainID(), 1, 0, b1.Hash(BlockHasher{}), validator)))
+	assert.Equal(t, uint32(1), bc.Height())
+	fetched, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+}
+
+func TestFinalizedHeightReload(t *testing.T) {
+	dir := t.TempDir()
+	validator := crypto.GeneratePrivateKey()
+	g := testGenesis(validator.PublicKey())
+
+	store, err := NewDiskStore(dir)
+	assert.Nil(t, err)
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), store, g)
+	assert.Nil(t, err)
+
+	b1 := validatorBlock(t, bc, bc.GenesisHash(), 1, validator)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Nil(t, bc.Finalize(testCommit(t, bc.ChainID(), 1, 0, b1.Hash(BlockHasher{}), validator)))
+	assert.Nil(t, bc.AddBlock(validatorBlock(t, bc, b1.Hash(BlockHasher{}), 2, validator)))
+	assert.Nil(t, store.Close())
+
+	store, err = NewDiskStore(dir)
+	assert.Nil(t, err)
+	defer store.Close()
+	bc, err = NewBlockchainFromGenesis(log.NewNopLogger(), store, g)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.Equal(t, uint32(1), bc.FinalizedHeight())
+	assert.ErrorIs(t, bc.RevertTo(0), ErrFinalityConflict)
+}
+
+func TestCheckBlock(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), testGenesis(validator.PublicKey()))
+	assert.Nil(t, err)
+
+	b1 := validatorBlock(t, bc, bc.GenesisHash(), 1, validator)
+	assert.Nil(t, bc.CheckBlock(b1))
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.False(t, bc.HasBlockHash(b1.Hash(BlockHasher{})))
+
+	b1.StateRoot = types.Hash{0x01}
+	b1.hash = types.Hash{}
+	assert.Nil(t, b1.Sign(validator))
+	assert.ErrorIs(t, bc.CheckBlock(b1), ErrInvalidStateRoot)
+}
+
+func TestBlockRoundNeedsCertificate(t *testing.T) {
+	c := newGovernanceChain(t, 2, 0)
+	bc := c.bc
+
+	// 第 1 轮的出块者
+	var signer, other crypto.PrivateKey
+	proposer, ok := bc.ProposerAt(1, 1)
+	assert.True(t, ok)
+	for _, key := range c.keys {
+		if key.PublicKey().String() == proposer.String() {
+			signer = key
+		} else {
+			other = key
+		}
+	}
+
+	header, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(header, nil)
+	assert.Nil(t, err)
+	b.Round = 1
+	sealBlock(t, bc, b, signer)
+	hash := b.Hash(BlockHasher{})
+
+	// 没有提议或者证书时出块者不能自己选择轮次
+	assert.ErrorIs(t, bc.AddBlock(b), ErrUncertifiedRound)
+	assert.ErrorIs(t, bc.CheckBlock(b), ErrUncertifiedRound)
+
+	p := &Proposal{Height: 1, Round: 1, POLRound: -1, Block: b}
+	assert.Nil(t, p.Sign(other))
+	assert.ErrorIs(t, bc.CheckProposal(p), ErrWrongProposer)
+	assert.Nil(t, p.Sign(signer))
+	assert.Nil(t, bc.CheckProposal(p))
+	assert.Equal(t, uint32(0), bc.Height())
+
+	// 证书的轮次低于区块的轮次
+	assert.ErrorIs(t, bc.AddCommittedBlock(b, testCommit(t, bc.ChainID(), 1, 0, hash, c.keys...)), ErrInvalidCommit)
+	assert.ErrorIs(t, bc.AddCommittedBlock(b, testCommit(t, bc.ChainID(), 1, 1, hash, signer)), ErrInvalidCommit)
+	assert.Equal(t, uint32(0), bc.Height())
+
+	assert.Nil(t, bc.AddCommittedBlock(b, testCommit(t, bc.ChainID(), 1, 2, hash, c.keys...)))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, uint32(1), bc.FinalizedHeight())
+	assert.Empty(t, bc.certified)
+}
+
+// 由 privKey 在 parent 之后出的块, 使用链的 ChainID
+func validatorBlock(t *testing.T, bc *Blockchain, parent types.Hash, height uint32, privKey crypto.PrivateKey) *Block {
+	header := &Header{
+		Version:       1,
+		ChainID:       bc.ChainID(),
+		PrevBlockHash: parent,
+		Height:        height,
+		Timestamp:     time.Now().UnixNano(),
+	}
+
+	b, err := NewBlock(header, nil)
+	assert.Nil(t, err)
+	sealBlock(t, bc, b, privKey)
+
+	return b
+}
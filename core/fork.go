@@ -0,0 +1,343 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"project-bee/types"
+)
+
+var ErrUnknownParent = errors.New("unknown parent block")
+
+// blockNode 是区块树中的节点, 同一个父区块下可以有多个竞争的子区块
+type blockNode struct {
+	block  *Block
+	parent *blockNode
+	// 从创世区块到当前区块的累计权重, 分叉选择取权重最大的分支
+	weight uint64
+}
+
+func (n *blockNode) hash() types.Hash {
+	return n.block.Hash(BlockHasher{})
+}
+
+// SetReorgHandler 设置重组时的回调, 回调收到旧分支中没有被新分支包含的交易
+func (bc *Blockchain) SetReorgHandler(fn func(dropped []*Transaction)) {
+	bc.reorgHandler = fn
+}
+
+// BlockListener 接收规范链的变化, 在持有链的锁时按顺序调用, 不能再调用 Blockchain 的方法
+type BlockListener interface {
+	// OnCommit 在区块成为规范链最高区块之后调用, receipts 是区块中交易的执行结果
+	OnCommit(b *Block, receipts []*Receipt)
+	// OnRevert 在区块从规范链上撤销之后调用, 从高到低
+	OnRevert(b *Block)
+}
+
+// Subscribe 注册规范链变化的监听者, 只收到注册之后的变化
+func (bc *Blockchain) Subscribe(l BlockListener) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.listeners = append(bc.listeners, l)
+}
+
+// 每个区块的权重由共识引擎决定
+func (bc *Blockchain) blockWeight(b *Block) uint64 {
+	return bc.engine.Weight(b.Header)
+}
+
+func (bc *Blockchain) getNode(hash types.Hash) (*blockNode, bool) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	node, ok := bc.nodes[hash]
+	return node, ok
+}
+
+// HasBlockHash 区块是否已经在区块树中, 包括侧链
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	_, ok := bc.getNode(hash)
+	return ok
+}
+
+func (bc *Blockchain) insertNode(b *Block) (*blockNode, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	hash := b.Hash(BlockHasher{})
+	if _, ok := bc.nodes[hash]; ok {
+		return nil, ErrBlockKnown
+	}
+
+	node := &blockNode{
+		block:  b,
+		weight: bc.blockWeight(b),
+	}
+
+	if b.Height > 0 {
+		parent, ok := bc.nodes[b.PrevBlockHash]
+		if !ok {
+			return nil, fmt.Errorf("block (%s) with height (%d): %w", hash, b.Height, ErrUnknownParent)
+		}
+		node.parent = parent
+		node.weight += parent.weight
+	}
+
+	bc.nodes[hash] = node
+
+	return node, nil
+}
+
+// 从区块树中删除节点以及它的所有后代
+func (bc *Blockchain) removeNode(node *blockNode) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	removed := map[*blockNode]bool{node: true}
+	for changed := true; changed; {
+		changed = false
+		for _, n := range bc.nodes {
+			if !removed[n] && n.parent != nil && removed[n.parent] {
+				removed[n] = true
+				changed = true
+			}
+		}
+	}
+
+	for n := range removed {
+		delete(bc.nodes, n.hash())
+	}
+}
+
+// findAncestorTx 返回 hashes 中已经包含在 node 或它的祖先区块中的交易
+func (bc *Blockchain) findAncestorTx(node *blockNode, hashes []types.Hash) (types.Hash, bool) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	// 侧链部分的交易不在 txBlocks 中, 需要逐个区块检查
+	sideTxs := make(map[types.Hash]bool)
+	for ; node != nil; node = node.parent {
+		if h := int(node.block.Height); h < len(bc.blocks) && bc.blocks[h] == node.block {
+			break
+		}
+		for _, tx := range node.block.Transactions {
+			sideTxs[tx.Hash(TxHasher{})] = true
+		}
+	}
+
+	for _, hash := range hashes {
+		if sideTxs[hash] {
+			return hash, true
+		}
+
+		// 规范链上不高于分叉点的区块都是祖先
+		blockHash, ok := bc.txBlocks[hash]
+		if ok && node != nil && bc.blockStore[blockHash].Height <= node.block.Height {
+			return hash, true
+		}
+	}
+
+	return types.Hash{}, false
+}
+
+// 两个节点的最近公共祖先
+func commonAncestor(a, b *blockNode) *blockNode {
+	for a.block.Height > b.block.Height {
+		a = a.parent
+	}
+	for b.block.Height > a.block.Height {
+		b = b.parent
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+
+	return a
+}
+
+// 从 ancestor(不含) 到 node(含) 的区块, 按高度从低到高
+func branch(ancestor, node *blockNode) []*Block {
+	blocks := []*Block{}
+	for n := node; n != ancestor; n = n.parent {
+		blocks = append([]*Block{n.block}, blocks...)
+	}
+
+	return blocks
+}
+
+// reorg 切换到权重更大的分支, 调用者需要持有 stateLock
+// 撤销到分叉点后依次执行新分支的区块, 任何一个区块执行失败都会恢复旧分支
+func (bc *Blockchain) reorg(newTip *blockNode) error {
+	bc.lock.RLock()
+	oldTip := bc.tip
+	bc.lock.RUnlock()
+
+	var (
+		fork      = commonAncestor(oldTip, newTip)
+		newBranch = branch(fork, newTip)
+	)
+
+	if err := bc.checkRevert(fork.block.Height); err != nil {
+		return err
+	}
+
+	bc.logger.Log(
+		"msg", "reorganizing chain",
+		"fork", fork.hash(),
+		"forkHeight", fork.block.Height,
+		"oldTip", oldTip.hash(),
+		"newTip", newTip.hash(),
+	)
+
+	oldBranch := bc.revertTo(fork.block.Height)
+
+	var (
+		journals = []*journal{}
+		receipts = [][]*Receipt{}
+	)
+	for _, b := range newBranch {
+		j, r, err := bc.connectBlock(b)
+		if err != nil {
+			for i := len(journals) - 1; i >= 0; i-- {
+				journals[i].undo(bc)
+			}
+			// 旧分支在同样的状态上执行过, 不会失败
+			for _, old := range oldBranch {
+				oldJournal, oldReceipts, _ := bc.executeBlock(old)
+				bc.linkBlock(old, oldJournal, oldReceipts)
+			}
+
+			if node, ok := bc.getNode(b.Hash(BlockHasher{})); ok {
+				bc.removeNode(node)
+			}
+			return err
+		}
+
+		journals = append(journals, j)
+		receipts = append(receipts, r)
+	}
+
+	for i, b := range newBranch {
+		if err := bc.commitBlock(b, journals[i], receipts[i]); err != nil {
+			return err
+		}
+	}
+
+	included := make(map[types.Hash]bool)
+	for _, b := range newBranch {
+		for _, tx := range b.Transactions {
+			included[tx.Hash(TxHasher{})] = true
+		}
+	}
+
+	dropped := []*Transaction{}
+	for _, b := range oldBranch {
+		for _, tx := range b.Transactions {
+			if !included[tx.Hash(TxHasher{})] {
+				dropped = append(dropped, tx)
+			}
+		}
+	}
+
+	if len(dropped) > 0 && bc.reorgHandler != nil {
+		bc.reorgHandler(dropped)
+	}
+
+	return nil
+}
+
+// 用撤销日志把状态退回到规范链 height 高度, 返回被撤销的区块, 按高度从低到高
+// 区块仍然保留在区块树中, 调用者需要持有 stateLock
+func (bc *Blockchain) revertTo(height uint32) []*Block {
+	bc.lock.RLock()
+	reverted := make([]*Block, len(bc.blocks)-int(height)-1)
+	copy(reverted, bc.blocks[height+1:])
+	bc.lock.RUnlock()
+
+	for i := len(reverted) - 1; i >= 0; i-- {
+		bc.journals[reverted[i].Hash(BlockHasher{})].undo(bc)
+	}
+
+	bc.unlinkBlocks(height)
+
+	return reverted
+}
+
+// 把状态切换到 hash 所在分支执行完该区块之后的状态, 返回恢复到规范链状态的函数
+// 规范链的撤销日志保持不变, 调用者需要持有 stateLock
+func (bc *Blockchain) switchToBranch(hash types.Hash) (func(), error) {
+	node, ok := bc.getNode(hash)
+	if !ok {
+		return nil, fmt.Errorf("block (%s): %w", hash, ErrUnknownParent)
+	}
+
+	bc.lock.RLock()
+	tip := bc.tip
+	canonical := make([]*Block, len(bc.blocks))
+	copy(canonical, bc.blocks)
+	bc.lock.RUnlock()
+
+	if node == tip {
+		return func() {}, nil
+	}
+
+	fork := commonAncestor(tip, node)
+	if err := bc.checkRevert(fork.block.Height); err != nil {
+		return nil, err
+	}
+
+	// 撤销规范链区块的操作记录到 undone 中, 恢复时再撤销回来
+	undone := newJournal()
+	bc.setJournal(undone)
+	for i := len(canonical) - 1; i > int(fork.block.Height); i-- {
+		bc.journals[canonical[i].Hash(BlockHasher{})].undo(bc)
+	}
+	bc.setJournal(nil)
+
+	applied := []*journal{}
+	restore := func() {
+		for i := len(applied) - 1; i >= 0; i-- {
+			applied[i].undo(bc)
+		}
+		undone.undo(bc)
+	}
+
+	for _, b := range branch(fork, node) {
+		j, _, err := bc.executeBlock(b)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		applied = append(applied, j)
+	}
+
+	return restore, nil
+}
+
+// 删除 height 之后的规范链区块索引和撤销日志, 区块仍然保留在区块树中
+func (bc *Blockchain) unlinkBlocks(height uint32) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	for _, b := range bc.blocks[height+1:] {
+		delete(bc.blockStore, b.Hash(BlockHasher{}))
+		delete(bc.journals, b.Hash(BlockHasher{}))
+
+		for _, tx := range b.Transactions {
+			delete(bc.txStore, tx.Hash(TxHasher{}))
+			delete(bc.txBlocks, tx.Hash(TxHasher{}))
+		}
+	}
+
+	for i := len(bc.blocks) - 1; i > int(height); i-- {
+		for _, l := range bc.listeners {
+			l.OnRevert(bc.blocks[i])
+		}
+	}
+
+	bc.headers = bc.headers[:height+1]
+	bc.blocks = bc.blocks[:height+1]
+	bc.tip = bc.nodes[bc.blocks[height].Hash(BlockHasher{})]
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSideChainBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	a1 := childBlock(t, bc, bc.GenesisHash(), 1)
+	b1 := childBlock(t, bc, bc.GenesisHash(), 1)
+
+	assert.Nil(t, bc.AddBlock(a1))
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.ErrorIs(t, bc.AddBlock(b1), ErrBlockKnown)
+
+	// 权重相同时保留先收到的区块
+	assert.Equal(t, uint32(1), bc.Height())
+	fetched, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, a1.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+	assert.True(t, bc.HasBlockHash(b1.Hash(BlockHasher{})))
+}
+
+func TestReorg(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 100
+
+	tx := NewTransaction(nil)
+	tx.To = privKeyAlice.PublicKey()
+	tx.Value = 100
+	assert.Nil(t, tx.Sign(privKeyBob))
+
+	a1 := randomBlock(t, 1, bc.GenesisHash())
+	a1.AddTransaction(tx)
+	sealBlock(t, bc, a1, testValidator)
+	assert.Nil(t, bc.AddBlock(a1))
+
+	balance, err := bc.accountState.GetBalance(privKeyAlice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+
+	var dropped []*Transaction
+	bc.SetReorgHandler(func(txs []*Transaction) {
+		dropped = txs
+	})
+
+	b1 := childBlock(t, bc, bc.GenesisHash(), 1)
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := childBlock(t, bc, b1.Hash(BlockHasher{}), 2)
+	assert.Nil(t, bc.AddBlock(b2))
+
+	assert.Equal(t, uint32(2), bc.Height())
+	fetched, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+
+	// 旧分支的转账被撤销
+	_, err = bc.accountState.GetAccount(privKeyAlice.PublicKey().Address())
+	assert.Equal(t, ErrAccountNotFound, err)
+	balance, err = bc.accountState.GetBalance(privKeyBob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+
+	_, err = bc.GetTxByHash(tx.Hash(TxHasher{}))
+	assert.NotNil(t, err)
+
+	// 旧分支的交易
+	hashes := []types.Hash{}
+	for _, tx := range dropped {
+		hashes = append(hashes, tx.Hash(TxHasher{}))
+	}
+	assert.Contains(t, hashes, tx.Hash(TxHasher{}))
+
+	// 旧分支重新变重时切换回去
+	a2 := childBlock(t, bc, a1.Hash(BlockHasher{}), 2)
+	assert.Nil(t, bc.AddBlock(a2))
+	a3 := childBlock(t, bc, a2.Hash(BlockHasher{}), 3)
+	assert.Nil(t, bc.AddBlock(a3))
+
+	assert.Equal(t, uint32(3), bc.Height())
+	balance, err = bc.accountState.GetBalance(privKeyAlice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+}
+
+func TestReorgInvalidBranch(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	a1 := childBlock(t, bc, bc.GenesisHash(), 1)
+	assert.Nil(t, bc.AddBlock(a1))
+	stateRoot := bc.StateRoot()
+
+	b1 := childBlock(t, bc, bc.GenesisHash(), 1)
+	assert.Nil(t, bc.AddBlock(b1))
+
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	b2.StateRoot = types.Hash{}
+	assert.Nil(t, b2.Sign(testValidator))
+	assert.ErrorIs(t, bc.AddBlock(b2), ErrInvalidStateRoot)
+
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, stateRoot, bc.StateRoot())
+	assert.False(t, bc.HasBlockHash(b2.Hash(BlockHasher{})))
+	assert.True(t, bc.HasBlockHash(b1.Hash(BlockHasher{})))
+}
+
+func TestAddBlockUnknownParent(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	b := randomBlock(t, 1, types.Hash{1})
+	assert.ErrorIs(t, bc.AddBlock(b), ErrUnknownParent)
+}
+
+// 在 parent 所在分支上生成下一个区块
+func childBlock(t *testing.T, bc *Blockchain, parent types.Hash, height uint32) *Block {
+	b := randomBlock(t, height, parent)
+	sealBlock(t, bc, b, testValidator)
+
+	return b
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+)
+
+type GenesisCollection struct {
+	Fee      int64  `json:"fee"`
+	MetaData string `json:"metadata"`
+}
+
+// Genesis 描述创世区块和初始状态, 相同的配置在每个节点上得到相同的创世 hash
+type Genesis struct {
+	ChainID   uint32 `json:"chainId"`
+	Timestamp int64  `json:"timestamp"`
+	// 地址(hex) => 初始余额
+	Alloc map[string]uint64 `json:"alloc"`
+	// 初始验证者的压缩公钥(hex)
+	Validators  []string            `json:"validators"`
+	Collections []GenesisCollection `json:"collections"`
+	// 每个区块铸造给出块验证者的奖励
+	BlockReward uint64 `json:"blockReward"`
+	// 代币总量上限, 0 表示没有上限
+	MaxSupply uint64 `json:"maxSupply"`
+	// Consensus 是共识引擎, ConsensusPoA (默认) 或 ConsensusPoW
+	Consensus string `json:"consensus"`
+	// PoW 创世区块的难度, 也是最低难度
+	Difficulty uint64 `json:"difficulty"`
+	// PoW 的目标出块间隔, 单位毫秒
+	TargetBlockTime uint64 `json:"targetBlockTime"`
+	// 验证者集合变化生效的间隔, 0 使用 DefaultEpochLength
+	EpochLength uint32 `json:"epochLength"`
+	// 解除抵押之后经过多少个区块回到余额, 0 使用 DefaultUnbondingPeriod
+	UnbondingPeriod uint32 `json:"unbondingPeriod"`
+	// 双签时罚没的抵押百分比, 0 使用 DefaultSlashFraction
+	SlashFraction uint32 `json:"slashFraction"`
+}
+
+func LoadGenesis(path string) (*Genesis, error) {
+	g, err := ReadGenesis(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// ReadGenesis 只解码创世配置不做校验, 调用者修改配置之后需要调用 Validate
+func ReadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g := new(Genesis)
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("failed to decode genesis file %s: %s", path, err)
+	}
+
+	return g, nil
+}
+
+func (g *Genesis) Validate() error {
+	alloc, err := g.alloc()
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	for _, acc := range alloc {
+		if total+acc.Balance < total {
+			return fmt.Errorf("genesis alloc overflows")
+		}
+		total += acc.Balance
+	}
+	if g.MaxSupply > 0 && total > g.MaxSupply {
+		return fmt.Errorf("genesis alloc (%d) exceeds max supply (%d): %w", total, g.MaxSupply, ErrMaxSupply)
+	}
+
+	validators, err := g.validators()
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.Engine(); err != nil {
+		return err
+	}
+
+	// 没有验证者时 PoA 链上没有节点可以出块
+	if g.Consensus != ConsensusPoW && len(validators) == 0 {
+		return fmt.Errorf("poa genesis without validators")
+	}
+
+	if g.SlashFraction > 100 {
+		return fmt.Errorf("genesis slash fraction (%d) above 100 percent", g.SlashFraction)
+	}
+
+	return nil
+}
+
+// Engine 返回创世配置选择的共识引擎
+func (g *Genesis) Engine() (ConsensusEngine, error) {
+	switch g.Consensus {
+	case "", ConsensusPoA:
+		return NewPoAEngine(), nil
+	case ConsensusPoW:
+		if g.Difficulty == 0 || g.TargetBlockTime == 0 {
+			return nil, fmt.Errorf("pow genesis needs a difficulty and a target block time")
+		}
+		return NewPoWEngine(time.Duration(g.TargetBlockTime)*time.Millisecond, g.Difficulty), nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine %s", g.Consensus)
+	}
+}
+
+// Hash 对创世配置做确定性编码后进行 hash, 作为创世区块的 DataHash
+func (g *Genesis) Hash() types.Hash {
+	buf := new(bytes.Buffer)
+
+	binary.Write(buf, binary.BigEndian, g.ChainID)
+	binary.Write(buf, binary.BigEndian, g.Timestamp)
+
+	alloc, _ := g.alloc()
+	binary.Write(buf, binary.BigEndian, uint32(len(alloc)))
+	for _, acc := range alloc {
+		buf.Write(acc.Address.ToSlice())
+		binary.Write(buf, binary.BigEndian, acc.Balance)
+	}
+
+	validators, _ := g.validators()
+	binary.Write(buf, binary.BigEndian, uint32(len(validators)))
+	for _, v := range validators {
+		binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		buf.Write(v)
+	}
+
+	binary.Write(buf, binary.BigEndian, uint32(len(g.Collections)))
+	for i := range g.Collections {
+		buf.Write(g.CollectionHash(i).ToSlice())
+	}
+
+	binary.Write(buf, binary.BigEndian, g.BlockReward)
+	binary.Write(buf, binary.BigEndian, g.MaxSupply)
+
+	binary.Write(buf, binary.BigEndian, uint32(len(g.Consensus)))
+	buf.WriteString(g.Consensus)
+	binary.Write(buf, binary.BigEndian, g.Difficulty)
+	binary.Write(buf, binary.BigEndian, g.TargetBlockTime)
+	binary.Write(buf, binary.BigEndian, g.EpochLength)
+	binary.Write(buf, binary.BigEndian, g.UnbondingPeriod)
+	binary.Write(buf, binary.BigEndian, g.SlashFraction)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+// CollectionHash 返回第 i 个创世 NFT collection 的 hash
+func (g *Genesis) CollectionHash(i int) types.Hash {
+	c := g.Collections[i]
+	buf := new(bytes.Buffer)
+
+	buf.WriteString("genesis-collection")
+	binary.Write(buf, binary.BigEndian, g.ChainID)
+	binary.Write(buf, binary.BigEndian, uint32(i))
+	binary.Write(buf, binary.BigEndian, c.Fee)
+	buf.WriteString(c.MetaData)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+// Block 创世区块没有交易也没有签名, 初始状态由 Blockchain 直接写入
+func (g *Genesis) Block() (*Block, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	state := newBlockchain(log.NewNopLogger(), NewMemorystore())
+	if err := state.applyGenesis(g); err != nil {
+		return nil, err
+	}
+
+	header := &Header{
+		Version:        1,
+		ChainID:        g.ChainID,
+		DataHash:       g.Hash(),
+		StateRoot:      state.StateRoot(),
+		Height:         0,
+		Timestamp:      g.Timestamp,
+		ValidatorsHash: state.validatorSetAt(1).Hash(),
+	}
+	if g.Consensus == ConsensusPoW {
+		header.Difficulty = g.Difficulty
+	}
+
+	return NewBlock(header, nil)
+}
+
+// 按地址排序的初始账户
+func (g *Genesis) alloc() ([]*Account, error) {
+	accounts := make([]*Account, 0, len(g.Alloc))
+
+	for addr, balance := range g.Alloc {
+		b, err := hex.DecodeString(addr)
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("invalid genesis alloc address %s", addr)
+		}
+
+		accounts = append(accounts, &Account{
+			Address: types.AddressFromBytes(b),
+			Balance: balance,
+		})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i].Address.ToSlice(), accounts[j].Address.ToSlice()) < 0
+	})
+
+	return accounts, nil
+}
+
+func (g *Genesis) validators() ([]crypto.PublicKey, error) {
+	validators := make([]crypto.PublicKey, len(g.Validators))
+
+	seen := make(map[string]bool)
+	for i, v := range g.Validators {
+		b, err := hex.DecodeString(v)
+		if err != nil || len(b) != 33 {
+			return nil, fmt.Errorf("invalid genesis validator public key %s", v)
+		}
+
+		// 重复的验证者会在轮换中多次出块
+		if seen[string(b)] {
+			return nil, fmt.Errorf("duplicate genesis validator %s", v)
+		}
+		seen[string(b)] = true
+
+		validators[i] = crypto.PublicKey(b)
+	}
+
+	return validators, nil
+}
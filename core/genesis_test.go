@@ -0,0 +1,94 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"project-bee/crypto"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenesisHashDeterministic(t *testing.T) {
+	validator := crypto.GeneratePrivateKey().PublicKey()
+	g := testGenesis(validator)
+
+	a, err := g.Block()
+	assert.Nil(t, err)
+	b, err := testGenesis(validator).Block()
+	assert.Nil(t, err)
+	assert.Equal(t, a.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+
+	other := testGenesis(validator)
+	other.Alloc[crypto.GeneratePrivateKey().PublicKey().Address().String()] = 1
+	c, err := other.Block()
+	assert.Nil(t, err)
+	assert.NotEqual(t, a.Hash(BlockHasher{}), c.Hash(BlockHasher{}))
+}
+
+func TestLoadGenesis(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	data := []byte(`{
+		"chainId": 7,
+		"timestamp": 1000,
+		"alloc": {"996fb92427ae41e4649b934ca495991b7852b855": 500},
+		"validators": [],
+		"collections": [{"fee": 10, "metadata": "genesis collection"}],
+		"blockReward": 5,
+		"maxSupply": 1000
+	}`)
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+	// PoA 创世配置必须有验证者
+	_, err := LoadGenesis(path)
+	assert.NotNil(t, err)
+
+	g, err := ReadGenesis(path)
+	assert.Nil(t, err)
+	g.Validators = []string{crypto.GeneratePrivateKey().PublicKey().String()}
+	assert.Nil(t, g.Validate())
+	assert.Equal(t, uint32(7), g.ChainID)
+	assert.Equal(t, uint64(500), g.Alloc["996fb92427ae41e4649b934ca495991b7852b855"])
+	assert.Equal(t, uint64(5), g.BlockReward)
+	assert.Equal(t, uint64(1000), g.MaxSupply)
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"alloc": {"zz": 1}}`), 0o644))
+	_, err = LoadGenesis(path)
+	assert.NotNil(t, err)
+}
+
+func TestNewBlockchainFromGenesis(t *testing.T) {
+	validator := crypto.GeneratePrivateKey().PublicKey()
+	g := testGenesis(validator)
+	g.Collections = []GenesisCollection{{Fee: 1, MetaData: "collection"}}
+
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), g)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), bc.Height())
+
+	genesis, err := g.Block()
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash(BlockHasher{}), bc.GenesisHash())
+
+	balance, err := bc.accountState.GetBalance(validator.Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), balance)
+
+	assert.Equal(t, []crypto.PublicKey{validator}, bc.Validators())
+
+	_, ok := bc.collectionState[g.CollectionHash(0)]
+	assert.True(t, ok)
+}
+
+func testGenesis(validator crypto.PublicKey) *Genesis {
+	return &Genesis{
+		ChainID:   1,
+		Timestamp: 1000,
+		Alloc: map[string]uint64{
+			validator.Address().String(): 1000,
+		},
+		Validators: []string{validator.String()},
+	}
+}
@@ -0,0 +1,393 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+var (
+	ErrNotValidator      = errors.New("sender is not an active validator")
+	ErrInvalidGovernance = errors.New("invalid validator set proposal")
+)
+
+// 创世配置没有指定 epoch 长度时使用
+const DefaultEpochLength = 100
+
+type ValidatorAction byte
+
+const (
+	ValidatorAdd ValidatorAction = iota
+	ValidatorRemove
+)
+
+func (a ValidatorAction) String() string {
+	switch a {
+	case ValidatorAdd:
+		return "add"
+	case ValidatorRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("action(%d)", byte(a))
+	}
+}
+
+// ValidatorProposalTx 提议在下一个 epoch 加入或者移除一个验证者, 交易 hash 是提议的 ID
+// 提议者必须是当前的验证者, 提议同时算作提议者的赞成票, 到下一个 epoch 仍然没有结果的提议被删除
+type ValidatorProposalTx struct {
+	Action    ValidatorAction
+	Validator crypto.PublicKey
+}
+
+// ValidatorVoteTx 是当前验证者对提议的投票
+type ValidatorVoteTx struct {
+	Proposal types.Hash
+	Approve  bool
+}
+
+type ProposalStatus byte
+
+const (
+	ProposalPending ProposalStatus = iota
+	// 通过, 等待 EffectiveHeight 生效
+	ProposalApproved
+	// 被否决, 或者生效时已经不能应用
+	ProposalRejected
+	ProposalApplied
+)
+
+func (s ProposalStatus) String() string {
+	switch s {
+	case ProposalPending:
+		return "pending"
+	case ProposalApproved:
+		return "approved"
+	case ProposalRejected:
+		return "rejected"
+	case ProposalApplied:
+		return "applied"
+	default:
+		return fmt.Sprintf("status(%d)", byte(s))
+	}
+}
+
+// ValidatorProposal 是提议在链上的状态, 修改时整体替换, 不在原对象上修改
+type ValidatorProposal struct {
+	Action     ValidatorAction
+	Validator  crypto.PublicKey
+	Proposer   crypto.PublicKey
+	Height     uint32
+	Approvals  []crypto.PublicKey
+	Rejections []crypto.PublicKey
+	Status     ProposalStatus
+	// 通过之后生效的高度, 是下一个 epoch 的第一个区块
+	EffectiveHeight uint32
+	// 到这个高度仍然没有通过或者否决时从状态中删除, 同样是下一个 epoch 的第一个区块
+	ExpiryHeight uint32
+}
+
+func (p *ValidatorProposal) hasVoted(pubKey crypto.PublicKey) bool {
+	return containsKey(p.Approvals, pubKey) || containsKey(p.Rejections, pubKey)
+}
+
+// 从 height 开始生效的验证者集合, weights 是出块权重, schedule 是由权重得到的出块顺序
+type validatorSet struct {
+	height     uint32
+	validators []crypto.PublicKey
+	weights    []uint64
+	schedule   []int
+}
+
+// weights 为 nil 时每个验证者的权重都是 1
+func newValidatorSet(height uint32, validators []crypto.PublicKey, weights []uint64) validatorSet {
+	if weights == nil {
+		weights = make([]uint64, len(validators))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	return validatorSet{
+		height:     height,
+		validators: validators,
+		weights:    weights,
+		schedule:   proposerSchedule(weights),
+	}
+}
+
+func containsKey(keys []crypto.PublicKey, pubKey crypto.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// 在 keys 中出现的 votes 数量
+func countKeys(votes, keys []crypto.PublicKey) int {
+	n := 0
+	for _, v := range votes {
+		if containsKey(keys, v) {
+			n++
+		}
+	}
+	return n
+}
+
+// ValidatorsAt 返回出高度为 height 的区块的验证者集合
+// height 之后的集合变化还没有确定时返回最新的集合
+func (bc *Blockchain) ValidatorsAt(height uint32) []crypto.PublicKey {
+	return bc.validatorSetAt(height).validators
+}
+
+// IsValidator 判断 pubKey 是否在高度为 height 的验证者集合中
+func (bc *Blockchain) IsValidator(height uint32, pubKey crypto.PublicKey) bool {
+	return containsKey(bc.ValidatorsAt(height), pubKey)
+}
+
+func (bc *Blockchain) validatorSetAt(height uint32) validatorSet {
+	bc.validatorLock.RLock()
+	defer bc.validatorLock.RUnlock()
+
+	for i := len(bc.validatorSets) - 1; i >= 0; i-- {
+		if bc.validatorSets[i].height <= height {
+			return bc.validatorSets[i]
+		}
+	}
+
+	return validatorSet{}
+}
+
+// Validators 返回下一个区块的验证者集合
+func (bc *Blockchain) Validators() []crypto.PublicKey {
+	return bc.ValidatorsAt(bc.Height() + 1)
+}
+
+func (bc *Blockchain) EpochLength() uint32 {
+	return bc.epochLength
+}
+
+// EpochStart 返回 height 之后下一个 epoch 的第一个区块高度
+func (bc *Blockchain) EpochStart(height uint32) uint32 {
+	return (height/bc.epochLength + 1) * bc.epochLength
+}
+
+// GetValidatorProposal 返回提议的拷贝
+func (bc *Blockchain) GetValidatorProposal(hash types.Hash) (*ValidatorProposal, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	p, ok := bc.proposals[hash]
+	if !ok {
+		return nil, fmt.Errorf("validator proposal (%s) not found", hash)
+	}
+
+	cp := *p
+	return &cp, nil
+}
+
+// 执行高度为 height 的区块中的治理交易, 调用者需要持有 stateLock
+func (bc *Blockchain) handleGovernance(tx *Transaction, height uint32) error {
+	hash := tx.Hash(TxHasher{})
+	validators := bc.ValidatorsAt(height)
+
+	if !containsKey(validators, tx.From) {
+		return fmt.Errorf("tx (%s) from (%s): %w", hash, tx.From, ErrNotValidator)
+	}
+
+	switch t := tx.TxInner.(type) {
+	case ValidatorProposalTx:
+		if err := checkValidatorChange(validators, t.Action, t.Validator); err != nil {
+			return fmt.Errorf("proposal (%s): %w", hash, err)
+		}
+
+		p := &ValidatorProposal{
+			Action:       t.Action,
+			Validator:    t.Validator,
+			Proposer:     tx.From,
+			Height:       height,
+			Approvals:    []crypto.PublicKey{tx.From},
+			ExpiryHeight: bc.EpochStart(height),
+		}
+		bc.tallyProposal(p, validators, height)
+		bc.setProposal(hash, p)
+
+		bc.logger.Log("msg", "new validator proposal", "hash", hash, "action", t.Action, "validator", t.Validator, "status", p.Status)
+	case ValidatorVoteTx:
+		prev, ok := bc.proposals[t.Proposal]
+		if !ok {
+			return fmt.Errorf("vote for unknown proposal (%s): %w", t.Proposal, ErrInvalidGovernance)
+		}
+		if prev.Status != ProposalPending {
+			return fmt.Errorf("vote for proposal (%s) with status (%s): %w", t.Proposal, prev.Status, ErrInvalidGovernance)
+		}
+		if prev.hasVoted(tx.From) {
+			return fmt.Errorf("validator (%s) already voted for proposal (%s): %w", tx.From, t.Proposal, ErrInvalidGovernance)
+		}
+
+		p := *prev
+		if t.Approve {
+			p.Approvals = append(append([]crypto.PublicKey{}, prev.Approvals...), tx.From)
+		} else {
+			p.Rejections = append(append([]crypto.PublicKey{}, prev.Rejections...), tx.From)
+		}
+		bc.tallyProposal(&p, validators, height)
+		bc.setProposal(t.Proposal, &p)
+
+		bc.logger.Log("msg", "validator proposal vote", "hash", t.Proposal, "approve", t.Approve, "status", p.Status)
+	default:
+		return fmt.Errorf("unsupported tx type %v", t)
+	}
+
+	return nil
+}
+
+// 只统计当前验证者的票, 赞成票达到 Quorum 时通过, 反对票多到不可能通过时否决
+func (bc *Blockchain) tallyProposal(p *ValidatorProposal, validators []crypto.PublicKey, height uint32) {
+	quorum := Quorum(len(validators))
+
+	switch {
+	case countKeys(p.Approvals, validators) >= quorum:
+		p.Status = ProposalApproved
+		p.EffectiveHeight = bc.EpochStart(height)
+	case countKeys(p.Rejections, validators) > len(validators)-quorum:
+		p.Status = ProposalRejected
+	}
+}
+
+func checkValidatorChange(validators []crypto.PublicKey, action ValidatorAction, validator crypto.PublicKey) error {
+	switch action {
+	case ValidatorAdd:
+		if len(validator) != 33 {
+			return fmt.Errorf("validator public key with length (%d): %w", len(validator), ErrInvalidGovernance)
+		}
+		if containsKey(validators, validator) {
+			return fmt.Errorf("validator (%s) already active: %w", validator, ErrInvalidGovernance)
+		}
+	case ValidatorRemove:
+		if !containsKey(validators, validator) {
+			return fmt.Errorf("validator (%s) not active: %w", validator, ErrInvalidGovernance)
+		}
+		if len(validators) == 1 {
+			return fmt.Errorf("removing the last validator (%s): %w", validator, ErrInvalidGovernance)
+		}
+	default:
+		return fmt.Errorf("validator action (%d): %w", action, ErrInvalidGovernance)
+	}
+
+	return nil
+}
+
+// 在一个 epoch 的最后一个区块执行之后确定下一个 epoch 的验证者集合和出块权重,
+// 集合和权重都没有变化时不记录新的集合, 调用者需要持有 stateLock
+func (bc *Blockchain) applyEpoch(height uint32) {
+	next := height + 1
+	if next%bc.epochLength != 0 {
+		return
+	}
+
+	current := bc.validatorSetAt(next)
+	validators := bc.applyValidatorChanges(next, current.validators)
+	bc.expireProposals(next)
+	weights := bc.stakeWeights(validators)
+
+	if keysEqual(validators, current.validators) && weightsEqual(weights, current.weights) {
+		return
+	}
+
+	bc.validatorLock.RLock()
+	sets := append([]validatorSet{}, bc.validatorSets...)
+	bc.validatorLock.RUnlock()
+
+	bc.setValidatorSets(append(sets, newValidatorSet(next, validators, weights)))
+
+	bc.logger.Log("msg", "validator set changed", "height", next, "validators", len(validators))
+}
+
+// 按提议 hash 的顺序应用在 next 生效的提议, 返回新的验证者列表, 不修改 validators
+func (bc *Blockchain) applyValidatorChanges(next uint32, validators []crypto.PublicKey) []crypto.PublicKey {
+	hashes := []types.Hash{}
+	for hash, p := range bc.proposals {
+		if p.Status == ProposalApproved && p.EffectiveHeight == next {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	validators = append([]crypto.PublicKey{}, validators...)
+	for _, hash := range hashes {
+		p := *bc.proposals[hash]
+
+		// 同一个 epoch 中通过的提议可能互相冲突
+		if err := checkValidatorChange(validators, p.Action, p.Validator); err != nil {
+			p.Status = ProposalRejected
+			bc.setProposal(hash, &p)
+			bc.logger.Log("msg", "skipping validator proposal", "hash", hash, "err", err)
+			continue
+		}
+
+		if p.Action == ValidatorAdd {
+			validators = append(validators, p.Validator)
+		} else {
+			for i, v := range validators {
+				if bytes.Equal(v, p.Validator) {
+					validators = append(validators[:i:i], validators[i+1:]...)
+					break
+				}
+			}
+		}
+		p.Status = ProposalApplied
+		bc.setProposal(hash, &p)
+	}
+
+	return validators
+}
+
+// 删除在 next 过期并且仍然没有结果的提议, 之后对它们的投票按未知提议处理, 调用者需要持有 stateLock
+func (bc *Blockchain) expireProposals(next uint32) {
+	hashes := []types.Hash{}
+	for hash, p := range bc.proposals {
+		if p.Status == ProposalPending && p.ExpiryHeight <= next {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	for _, hash := range hashes {
+		bc.setProposal(hash, nil)
+		bc.logger.Log("msg", "validator proposal expired", "hash", hash, "height", next)
+	}
+}
+
+func keysEqual(a, b []crypto.PublicKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func weightsEqual(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// 验证者轮流出块的测试链, keys 包括之后才加入的验证者
+type governanceChain struct {
+	bc      *Blockchain
+	genesis *Genesis
+	keys    []crypto.PrivateKey
+	// 已经生成但可能还没有上链的交易之后的 nonce
+	nonces map[types.Address]uint64
+}
+
+func newGovernanceChain(t *testing.T, validators, extra int) *governanceChain {
+	keys := make([]crypto.PrivateKey, validators+extra)
+	for i := range keys {
+		keys[i] = crypto.GeneratePrivateKey()
+	}
+
+	g := testGenesis(keys[0].PublicKey())
+	g.Validators = nil
+	for _, key := range keys[:validators] {
+		g.Validators = append(g.Validators, key.PublicKey().String())
+	}
+	g.EpochLength = 4
+	g.UnbondingPeriod = 3
+	for _, key := range keys {
+		g.Alloc[key.PublicKey().Address().String()] = 1000
+	}
+
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), g)
+	assert.Nil(t, err)
+
+	return &governanceChain{bc: bc, genesis: g, keys: keys, nonces: make(map[types.Address]uint64)}
+}
+
+func (c *governanceChain) tx(t *testing.T, key crypto.PrivateKey, inner any) *Transaction {
+	tx := NewTransaction(nil)
+	tx.ChainID = c.bc.ChainID()
+	tx.TxInner = inner
+	address := key.PublicKey().Address()
+	tx.Nonce = c.bc.NextNonce(address)
+	if n := c.nonces[address]; n > tx.Nonce {
+		tx.Nonce = n
+	}
+	c.nonces[address] = tx.Nonce + 1
+	assert.Nil(t, tx.Sign(key))
+
+	return tx
+}
+
+// 由当前高度的出块者打包 txs 并加入链
+func (c *governanceChain) addBlock(t *testing.T, txs ...*Transaction) {
+	header, err := c.bc.GetHeader(c.bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(header, txs)
+	assert.Nil(t, err)
+
+	proposer, ok := c.bc.ProposerAt(b.Height, 0)
+	assert.True(t, ok)
+	for _, key := range c.keys {
+		if bytes.Equal(key.PublicKey(), proposer) {
+			sealBlock(t, c.bc, b, key)
+		}
+	}
+
+	assert.Nil(t, c.bc.AddBlock(b))
+}
+
+func TestValidatorProposalAppliedAtEpoch(t *testing.T) {
+	c := newGovernanceChain(t, 3, 1)
+	newcomer := c.keys[3].PublicKey()
+
+	propose := c.tx(t, c.keys[0], ValidatorProposalTx{Action: ValidatorAdd, Validator: newcomer})
+	hash := propose.Hash(TxHasher{})
+	c.addBlock(t, propose)
+
+	p, err := c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalPending, p.Status)
+
+	// 3 个验证者需要 3 票
+	c.addBlock(t,
+		c.tx(t, c.keys[1], ValidatorVoteTx{Proposal: hash, Approve: true}),
+		c.tx(t, c.keys[2], ValidatorVoteTx{Proposal: hash, Approve: true}),
+	)
+
+	p, err = c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalApproved, p.Status)
+	assert.Equal(t, uint32(4), p.EffectiveHeight)
+	assert.Len(t, c.bc.Validators(), 3)
+
+	// 第一个 epoch 的最后一个区块执行之后生效
+	c.addBlock(t)
+	assert.Len(t, c.bc.ValidatorsAt(3), 3)
+	assert.Len(t, c.bc.ValidatorsAt(4), 4)
+	assert.Contains(t, c.bc.Validators(), newcomer)
+
+	p, err = c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalApplied, p.Status)
+
+	for i := 0; i < 4; i++ {
+		c.addBlock(t)
+	}
+	assert.Equal(t, uint32(7), c.bc.Height())
+
+	// 撤销到生效之前恢复原来的集合
+	assert.Nil(t, c.bc.RevertTo(2))
+	assert.Len(t, c.bc.Validators(), 3)
+
+	p, err = c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalApproved, p.Status)
+}
+
+func TestValidatorProposalRejected(t *testing.T) {
+	c := newGovernanceChain(t, 3, 0)
+	removed := c.keys[1].PublicKey()
+
+	propose := c.tx(t, c.keys[0], ValidatorProposalTx{Action: ValidatorRemove, Validator: removed})
+	hash := propose.Hash(TxHasher{})
+	reject := c.tx(t, c.keys[1], ValidatorVoteTx{Proposal: hash, Approve: false})
+	c.addBlock(t, propose, reject)
+
+	p, err := c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalRejected, p.Status)
+
+	// 已经否决的提议不能再投票
+	vote := c.tx(t, c.keys[2], ValidatorVoteTx{Proposal: hash, Approve: true})
+	c.addBlock(t, vote)
+
+	receipt, err := c.bc.GetReceipt(vote.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+
+	for i := 0; i < 4; i++ {
+		c.addBlock(t)
+	}
+	assert.Contains(t, c.bc.Validators(), removed)
+}
+
+func TestValidatorProposalExpires(t *testing.T) {
+	c := newGovernanceChain(t, 3, 1)
+	newcomer := c.keys[3].PublicKey()
+
+	propose := c.tx(t, c.keys[0], ValidatorProposalTx{Action: ValidatorAdd, Validator: newcomer})
+	hash := propose.Hash(TxHasher{})
+	c.addBlock(t, propose)
+
+	p, err := c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalPending, p.Status)
+	assert.Equal(t, uint32(4), p.ExpiryHeight)
+
+	// 票数不够, 第一个 epoch 结束时提议被删除
+	c.addBlock(t, c.tx(t, c.keys[1], ValidatorVoteTx{Proposal: hash, Approve: true}))
+	root := c.bc.StateRoot()
+	c.addBlock(t)
+	_, err = c.bc.GetValidatorProposal(hash)
+	assert.NotNil(t, err)
+	assert.NotContains(t, c.bc.Validators(), newcomer)
+
+	// 过期之后的投票失败
+	vote := c.tx(t, c.keys[2], ValidatorVoteTx{Proposal: hash, Approve: true})
+	c.addBlock(t, vote)
+	receipt, err := c.bc.GetReceipt(vote.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+	assert.Equal(t, ReceiptCodeGovernance, receipt.Code)
+
+	// 撤销到过期之前恢复提议
+	assert.Nil(t, c.bc.RevertTo(2))
+	assert.Equal(t, root, c.bc.StateRoot())
+	p, err = c.bc.GetValidatorProposal(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ProposalPending, p.Status)
+}
+
+func TestGovernanceRequiresValidator(t *testing.T) {
+	c := newGovernanceChain(t, 1, 1)
+	outsider := c.keys[1]
+
+	propose := c.tx(t, outsider, ValidatorProposalTx{Action: ValidatorAdd, Validator: outsider.PublicKey()})
+	invalid := c.tx(t, c.keys[0], ValidatorProposalTx{Action: ValidatorRemove, Validator: c.keys[0].PublicKey()})
+	c.addBlock(t, propose, invalid)
+
+	for _, tx := range []*Transaction{propose, invalid} {
+		receipt, err := c.bc.GetReceipt(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, ReceiptFailed, receipt.Status)
+
+		_, err = c.bc.GetValidatorProposal(tx.Hash(TxHasher{}))
+		assert.NotNil(t, err)
+	}
+}
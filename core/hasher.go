@@ -3,7 +3,6 @@ package core
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
 
 	"project-bee/types"
 )
@@ -12,6 +11,24 @@ type Hasher[T any] interface {
 	Hash(T) types.Hash
 }
 
+// 签名的用途前缀, 区块头签名和交易签名不能互相替代
+const (
+	blockSigningDomain    = "project-bee/block"
+	txSigningDomain       = "project-bee/tx"
+	voteSigningDomain     = "project-bee/vote"
+	proposalSigningDomain = "project-bee/proposal"
+)
+
+// 实际被签名的数据: sha256(domain || hash)
+func signingHash(domain string, hash types.Hash) []byte {
+	buf := make([]byte, 0, len(domain)+len(hash))
+	buf = append(buf, domain...)
+	buf = append(buf, hash[:]...)
+
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
 type BlockHasher struct{}
 
 // 椭圆曲线签名 对 bytes 进行哈西
@@ -22,15 +39,12 @@ func (BlockHasher) Hash(b *Header) types.Hash {
 
 type TxHasher struct{}
 
-// 对 txData 进行哈希
+// 对交易的全部签名内容的规范编码进行哈希, 包括 TxInner
 func (TxHasher) Hash(tx *Transaction) types.Hash {
 	buf := new(bytes.Buffer)
-
-	binary.Write(buf, binary.LittleEndian, tx.Data)
-	binary.Write(buf, binary.LittleEndian, tx.To)
-	binary.Write(buf, binary.LittleEndian, tx.Value)
-	binary.Write(buf, binary.LittleEndian, tx.From)
-	binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	w := newCodecWriter(buf)
+	w.u8(CodecVersion)
+	encodeTxBody(w, tx)
 
 	return types.Hash(sha256.Sum256(buf.Bytes()))
 }
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"project-bee/types"
+)
+
+// AddressTx 是一个地址参与的一笔交易, 同一笔交易对每个地址只记录一次
+// 执行失败的交易只记录发送者, 手续费仍然被收取, 但是转账和铸造没有发生
+type AddressTx struct {
+	Hash        types.Hash
+	BlockHeight uint32
+	// 交易在区块中的位置
+	Index  uint32
+	Status ReceiptStatus
+	// Sent 是交易的发送者, Received 是转账的接收者, Minted 是铸造 NFT 的集合所有者
+	Sent     bool
+	Received bool
+	Minted   bool
+}
+
+// TxIndexer 按地址索引规范链上的交易, 通过 Blockchain.Subscribe 跟随提交和撤销的区块
+type TxIndexer struct {
+	lock sync.RWMutex
+	// 每个地址的交易按高度和区块中的位置从旧到新排列
+	txs map[types.Address][]AddressTx
+}
+
+func NewTxIndexer() *TxIndexer {
+	return &TxIndexer{
+		txs: make(map[types.Address][]AddressTx),
+	}
+}
+
+// Rebuild 丢弃已有的索引, 从 store 中的规范链重新建立, 需要在 Subscribe 之前调用
+func (ix *TxIndexer) Rebuild(store Storage) error {
+	ix.lock.Lock()
+	defer ix.lock.Unlock()
+
+	ix.txs = make(map[types.Address][]AddressTx)
+
+	return store.Iterate(func(b *Block) error {
+		receipts, err := store.GetReceipts(b.Hash(BlockHasher{}))
+		if err != nil {
+			return fmt.Errorf("rebuild tx index at block (%s) height (%d): %w", b.Hash(BlockHasher{}), b.Height, err)
+		}
+		// store 中的执行结果可能与区块不符, 提交的区块不会
+		if len(receipts) != len(b.Transactions) {
+			return fmt.Errorf("rebuild tx index at block (%s) with (%d) transactions => (%d) receipts", b.Hash(BlockHasher{}), len(b.Transactions), len(receipts))
+		}
+		ix.index(b, receipts)
+		return nil
+	})
+}
+
+// OnCommit 索引提交的区块, executeBlock 为每笔交易生成执行结果, 包括执行失败的交易, 所以索引不会失败
+func (ix *TxIndexer) OnCommit(b *Block, receipts []*Receipt) {
+	ix.lock.Lock()
+	defer ix.lock.Unlock()
+
+	ix.index(b, receipts)
+}
+
+// OnRevert 去掉区块中的交易, 区块总是规范链最高的区块, 所以只需要截掉每个地址末尾的记录
+func (ix *TxIndexer) OnRevert(b *Block) {
+	ix.lock.Lock()
+	defer ix.lock.Unlock()
+
+	// 按成功的交易取出所有可能的地址, 没有这个区块记录的地址不受影响
+	for _, tx := range b.Transactions {
+		for address := range txAddresses(tx, ReceiptSuccess) {
+			txs := ix.txs[address]
+			n := len(txs)
+			for n > 0 && txs[n-1].BlockHeight >= b.Height {
+				n--
+			}
+
+			if n == 0 {
+				delete(ix.txs, address)
+			} else {
+				ix.txs[address] = txs[:n]
+			}
+		}
+	}
+}
+
+// AddressTxs 从最新的交易开始返回地址的第 offset 笔之后最多 limit 笔交易, 以及交易总数
+func (ix *TxIndexer) AddressTxs(address types.Address, offset, limit int) ([]AddressTx, int) {
+	ix.lock.RLock()
+	defer ix.lock.RUnlock()
+
+	txs := ix.txs[address]
+	total := len(txs)
+
+	out := []AddressTx{}
+	for i := total - 1 - offset; i >= 0 && len(out) < limit; i-- {
+		out = append(out, txs[i])
+	}
+
+	return out, total
+}
+
+// receipts[i] 是 b.Transactions[i] 的执行结果, 调用者需要持有 lock
+func (ix *TxIndexer) index(b *Block, receipts []*Receipt) {
+	for i, tx := range b.Transactions {
+		status := receipts[i].Status
+		for address, entry := range txAddresses(tx, status) {
+			entry.Hash = tx.Hash(TxHasher{})
+			entry.BlockHeight = b.Height
+			entry.Index = uint32(i)
+			entry.Status = status
+			ix.txs[address] = append(ix.txs[address], entry)
+		}
+	}
+}
+
+// txAddresses 返回交易涉及的地址和每个地址的角色, 执行失败的交易只有发送者
+func txAddresses(tx *Transaction, status ReceiptStatus) map[types.Address]AddressTx {
+	entries := make(map[types.Address]AddressTx)
+
+	if len(tx.From) > 0 {
+		entry := entries[tx.From.Address()]
+		entry.Sent = true
+		entries[tx.From.Address()] = entry
+	}
+
+	if status != ReceiptSuccess {
+		return entries
+	}
+
+	if len(tx.To) > 0 {
+		entry := entries[tx.To.Address()]
+		entry.Received = true
+		entries[tx.To.Address()] = entry
+	}
+
+	if mint, ok := tx.TxInner.(MintTx); ok && len(mint.CollectionOwner) > 0 {
+		entry := entries[mint.CollectionOwner.Address()]
+		entry.Minted = true
+		entries[mint.CollectionOwner.Address()] = entry
+	}
+
+	return entries
+}
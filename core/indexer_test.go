@@ -0,0 +1,168 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (c *governanceChain) transfer(t *testing.T, from crypto.PrivateKey, to crypto.PublicKey, value uint64) *Transaction {
+	tx := NewTransaction(nil)
+	tx.ChainID = c.bc.ChainID()
+	tx.To = to
+	tx.Value = value
+	address := from.PublicKey().Address()
+	tx.Nonce = c.bc.NextNonce(address)
+	if n := c.nonces[address]; n > tx.Nonce {
+		tx.Nonce = n
+	}
+	c.nonces[address] = tx.Nonce + 1
+	assert.Nil(t, tx.Sign(from))
+
+	return tx
+}
+
+func txHashes(txs []AddressTx) []types.Hash {
+	hashes := make([]types.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash
+	}
+
+	return hashes
+}
+
+func TestTxIndexerFollowsChain(t *testing.T) {
+	c := newGovernanceChain(t, 1, 2)
+	ix := NewTxIndexer()
+	assert.Nil(t, ix.Rebuild(c.bc.store))
+	c.bc.Subscribe(ix)
+
+	owner, minter, receiver := c.keys[0], c.keys[1], c.keys[2]
+
+	collection := c.tx(t, owner, CollectionTx{Fee: 1, MetaData: []byte("collection")})
+	send := c.transfer(t, minter, receiver.PublicKey(), 10)
+	c.addBlock(t, collection, send)
+
+	mint := c.tx(t, minter, MintTx{
+		Fee:             1,
+		NFT:             types.Hash{0x01},
+		Collection:      collection.Hash(TxHasher{}),
+		MetaData:        []byte("mint"),
+		CollectionOwner: owner.PublicKey(),
+	})
+	// 转给自己只记录一次
+	self := c.transfer(t, receiver, receiver.PublicKey(), 5)
+	c.addBlock(t, mint, self)
+
+	txs, total := ix.AddressTxs(minter.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []types.Hash{mint.Hash(TxHasher{}), send.Hash(TxHasher{})}, txHashes(txs))
+	assert.Equal(t, AddressTx{Hash: send.Hash(TxHasher{}), BlockHeight: 1, Index: 1, Status: ReceiptSuccess, Sent: true}, txs[1])
+
+	txs, total = ix.AddressTxs(owner.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, AddressTx{Hash: mint.Hash(TxHasher{}), BlockHeight: 2, Index: 0, Status: ReceiptSuccess, Minted: true}, txs[0])
+	assert.True(t, txs[1].Sent)
+
+	txs, total = ix.AddressTxs(receiver.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, AddressTx{Hash: self.Hash(TxHasher{}), BlockHeight: 2, Index: 1, Status: ReceiptSuccess, Sent: true, Received: true}, txs[0])
+	assert.Equal(t, AddressTx{Hash: send.Hash(TxHasher{}), BlockHeight: 1, Index: 1, Status: ReceiptSuccess, Received: true}, txs[1])
+
+	// 从存储重建的索引与跟随链的索引相同
+	rebuilt := NewTxIndexer()
+	assert.Nil(t, rebuilt.Rebuild(c.bc.store))
+	assert.Equal(t, ix.txs, rebuilt.txs)
+
+	// 撤销的区块从索引中去掉
+	assert.Nil(t, c.bc.RevertTo(1))
+	txs, total = ix.AddressTxs(minter.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []types.Hash{send.Hash(TxHasher{})}, txHashes(txs))
+
+	txs, total = ix.AddressTxs(owner.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []types.Hash{collection.Hash(TxHasher{})}, txHashes(txs))
+
+	assert.Nil(t, c.bc.RevertTo(0))
+	_, total = ix.AddressTxs(receiver.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 0, total)
+	assert.Empty(t, ix.txs)
+}
+
+func TestTxIndexerPagination(t *testing.T) {
+	c := newGovernanceChain(t, 1, 1)
+	ix := NewTxIndexer()
+	c.bc.Subscribe(ix)
+
+	sender, receiver := c.keys[1], c.keys[0].PublicKey()
+	hashes := []types.Hash{}
+	for i := 0; i < 5; i++ {
+		tx := c.transfer(t, sender, receiver, 1)
+		c.addBlock(t, tx)
+		hashes = append([]types.Hash{tx.Hash(TxHasher{})}, hashes...)
+	}
+
+	address := sender.PublicKey().Address()
+	txs, total := ix.AddressTxs(address, 0, 2)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, hashes[:2], txHashes(txs))
+
+	txs, _ = ix.AddressTxs(address, 2, 2)
+	assert.Equal(t, hashes[2:4], txHashes(txs))
+
+	txs, _ = ix.AddressTxs(address, 4, 2)
+	assert.Equal(t, hashes[4:], txHashes(txs))
+
+	txs, total = ix.AddressTxs(address, 5, 2)
+	assert.Equal(t, 5, total)
+	assert.Empty(t, txs)
+}
+
+func TestTxIndexerFailedTransfer(t *testing.T) {
+	c := newGovernanceChain(t, 1, 1)
+	ix := NewTxIndexer()
+	c.bc.Subscribe(ix)
+
+	sender, receiver := c.keys[1], c.keys[0]
+	// 合约先执行并且 panic, 转账被撤销
+	failed := NewTransaction([]byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f, 0x0b})
+	failed.ChainID = c.bc.ChainID()
+	failed.To = receiver.PublicKey()
+	failed.Value = 10
+	assert.Nil(t, failed.Sign(sender))
+	c.addBlock(t, failed)
+
+	receipt, err := c.bc.GetReceipt(failed.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+
+	txs, total := ix.AddressTxs(sender.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, AddressTx{Hash: failed.Hash(TxHasher{}), BlockHeight: 1, Index: 0, Status: ReceiptFailed, Sent: true}, txs[0])
+
+	// 接收者没有收到转账
+	_, total = ix.AddressTxs(receiver.PublicKey().Address(), 0, 10)
+	assert.Equal(t, 0, total)
+
+	rebuilt := NewTxIndexer()
+	assert.Nil(t, rebuilt.Rebuild(c.bc.store))
+	assert.Equal(t, ix.txs, rebuilt.txs)
+
+	assert.Nil(t, c.bc.RevertTo(0))
+	assert.Empty(t, ix.txs)
+}
+
+func TestTxIndexerRebuildRejectsMissingReceipts(t *testing.T) {
+	c := newGovernanceChain(t, 1, 1)
+	c.addBlock(t, c.transfer(t, c.keys[0], c.keys[1].PublicKey(), 10))
+
+	// store 中的执行结果与区块中的交易不符时返回错误, 不会越界
+	b, err := c.bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Nil(t, c.bc.store.PutReceipts(b.Hash(BlockHasher{}), nil))
+	assert.NotNil(t, NewTxIndexer().Rebuild(c.bc.store))
+}
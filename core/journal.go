@@ -0,0 +1,313 @@
+package core
+
+import (
+	"project-bee/types"
+)
+
+// journalEntry 记录一次状态修改之前的值, revert 把状态恢复到修改之前
+// revert 通过普通的状态写入完成, 因此撤销操作本身也会被记录到当前激活的 journal 中
+// updateTrie 把修改过的 key 的当前状态写入状态树, 执行和撤销之后都需要调用
+type journalEntry interface {
+	revert(bc *Blockchain)
+	updateTrie(bc *Blockchain)
+}
+
+// 账户修改之前的拷贝, prev 为 nil 表示账户之前不存在
+type accountChange struct {
+	address types.Address
+	prev    *Account
+}
+
+func (c accountChange) revert(bc *Blockchain) {
+	bc.accountState.setAccount(c.address, c.prev)
+}
+
+func (c accountChange) updateTrie(bc *Blockchain) {
+	bc.updateAccountLeaf(c.address)
+}
+
+// 合约状态修改之前的值, prev 为 nil 表示 key 之前不存在
+type storageChange struct {
+	key  []byte
+	prev []byte
+}
+
+func (c storageChange) revert(bc *Blockchain) {
+	bc.contractState.set(c.key, c.prev)
+}
+
+func (c storageChange) updateTrie(bc *Blockchain) {
+	bc.updateContractLeaf(c.key)
+}
+
+type collectionChange struct {
+	hash types.Hash
+	prev *CollectionTx
+}
+
+func (c collectionChange) revert(bc *Blockchain) {
+	bc.setCollection(c.hash, c.prev)
+}
+
+func (c collectionChange) updateTrie(bc *Blockchain) {
+	bc.updateCollectionLeaf(c.hash)
+}
+
+type mintChange struct {
+	hash types.Hash
+	prev *MintTx
+}
+
+func (c mintChange) revert(bc *Blockchain) {
+	bc.setMint(c.hash, c.prev)
+}
+
+func (c mintChange) updateTrie(bc *Blockchain) {
+	bc.updateMintLeaf(c.hash)
+}
+
+type proposalChange struct {
+	hash types.Hash
+	prev *ValidatorProposal
+}
+
+func (c proposalChange) revert(bc *Blockchain) {
+	bc.setProposal(c.hash, c.prev)
+}
+
+func (c proposalChange) updateTrie(bc *Blockchain) {
+	bc.updateProposalLeaf(c.hash)
+}
+
+// next 是修改之后的集合历史, 撤销时用来找到需要从状态树中删除的集合
+type validatorSetChange struct {
+	prev []validatorSet
+	next []validatorSet
+}
+
+func (c validatorSetChange) revert(bc *Blockchain) {
+	bc.setValidatorSets(c.prev)
+}
+
+func (c validatorSetChange) updateTrie(bc *Blockchain) {
+	bc.updateValidatorSetLeaves(c.prev)
+	bc.updateValidatorSetLeaves(c.next)
+}
+
+type bondChange struct {
+	key  bondKey
+	prev uint64
+}
+
+func (c bondChange) revert(bc *Blockchain) {
+	bc.setBond(c.key, c.prev)
+}
+
+func (c bondChange) updateTrie(bc *Blockchain) {
+	bc.updateBondLeaf(c.key)
+}
+
+type unbondingChange struct {
+	prev []Unbonding
+}
+
+func (c unbondingChange) revert(bc *Blockchain) {
+	bc.setUnbonding(c.prev)
+}
+
+func (c unbondingChange) updateTrie(bc *Blockchain) {
+	bc.updateUnbondingLeaf()
+}
+
+type slashChange struct {
+	key  slashKey
+	prev bool
+}
+
+func (c slashChange) revert(bc *Blockchain) {
+	bc.setSlashed(c.key, c.prev)
+}
+
+func (c slashChange) updateTrie(bc *Blockchain) {
+	bc.updateSlashLeaf(c.key)
+}
+
+type supplyChange struct {
+	prev uint64
+}
+
+func (c supplyChange) revert(bc *Blockchain) {
+	bc.setSupply(c.prev)
+}
+
+func (c supplyChange) updateTrie(bc *Blockchain) {
+	bc.updateSupplyLeaf()
+}
+
+// journal 是一个区块的撤销日志
+type journal struct {
+	entries []journalEntry
+}
+
+func newJournal() *journal {
+	return &journal{
+		entries: []journalEntry{},
+	}
+}
+
+func (j *journal) append(entry journalEntry) {
+	j.entries = append(j.entries, entry)
+}
+
+func (j *journal) snapshot() int {
+	return len(j.entries)
+}
+
+// snapshot 之后写入过的合约状态 key, 去重并保持第一次写入的顺序
+func (j *journal) storageKeys(snapshot int) [][]byte {
+	var (
+		keys = [][]byte{}
+		seen = make(map[string]bool)
+	)
+	for _, entry := range j.entries[snapshot:] {
+		change, ok := entry.(storageChange)
+		if !ok || seen[string(change.key)] {
+			continue
+		}
+
+		seen[string(change.key)] = true
+		keys = append(keys, change.key)
+	}
+
+	return keys
+}
+
+// undo 按相反顺序撤销全部修改并更新状态树, j 本身不变, 不能在 j 激活时调用
+func (j *journal) undo(bc *Blockchain) {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		j.entries[i].revert(bc)
+	}
+	j.updateTrie(bc)
+}
+
+// updateTrie 把 j 修改过的 key 写入状态树, 同一个 key 的多次修改只在值变化时更新
+func (j *journal) updateTrie(bc *Blockchain) {
+	for _, entry := range j.entries {
+		entry.updateTrie(bc)
+	}
+}
+
+// revertToSnapshot 撤销 snapshot 之后的修改并丢弃对应的记录, 用于激活中的 journal
+func (j *journal) revertToSnapshot(bc *Blockchain, snapshot int) {
+	active := bc.journal
+	bc.setJournal(nil)
+
+	for i := len(j.entries) - 1; i >= snapshot; i-- {
+		j.entries[i].revert(bc)
+	}
+	j.entries = j.entries[:snapshot]
+
+	bc.setJournal(active)
+}
+
+// setJournal 设置接收状态修改记录的 journal, nil 表示不记录, 调用者需要持有 stateLock
+func (bc *Blockchain) setJournal(j *journal) {
+	bc.journal = j
+	bc.accountState.journal = j
+	bc.contractState.journal = j
+}
+
+// 调用者需要持有 stateLock
+func (bc *Blockchain) setCollection(hash types.Hash, c *CollectionTx) {
+	if bc.journal != nil {
+		bc.journal.append(collectionChange{hash: hash, prev: bc.collectionState[hash]})
+	}
+
+	if c == nil {
+		delete(bc.collectionState, hash)
+		return
+	}
+	bc.collectionState[hash] = c
+}
+
+// 调用者需要持有 stateLock
+func (bc *Blockchain) setSupply(supply uint64) {
+	if bc.journal != nil {
+		bc.journal.append(supplyChange{prev: bc.supply})
+	}
+
+	bc.supply = supply
+}
+
+// 调用者需要持有 stateLock
+func (bc *Blockchain) setMint(hash types.Hash, m *MintTx) {
+	if bc.journal != nil {
+		bc.journal.append(mintChange{hash: hash, prev: bc.mintState[hash]})
+	}
+
+	if m == nil {
+		delete(bc.mintState, hash)
+		return
+	}
+	bc.mintState[hash] = m
+}
+
+// 调用者需要持有 stateLock
+func (bc *Blockchain) setProposal(hash types.Hash, p *ValidatorProposal) {
+	if bc.journal != nil {
+		bc.journal.append(proposalChange{hash: hash, prev: bc.proposals[hash]})
+	}
+
+	if p == nil {
+		delete(bc.proposals, hash)
+		return
+	}
+	bc.proposals[hash] = p
+}
+
+// sets 替换整个集合历史, 不能在原切片上修改, 调用者需要持有 stateLock
+func (bc *Blockchain) setValidatorSets(sets []validatorSet) {
+	bc.validatorLock.Lock()
+	defer bc.validatorLock.Unlock()
+
+	if bc.journal != nil {
+		bc.journal.append(validatorSetChange{prev: bc.validatorSets, next: sets})
+	}
+
+	bc.validatorSets = sets
+}
+
+// amount 为 0 时删除抵押, 调用者需要持有 stateLock
+func (bc *Blockchain) setBond(key bondKey, amount uint64) {
+	if bc.journal != nil {
+		bc.journal.append(bondChange{key: key, prev: bc.bonds[key]})
+	}
+
+	if amount == 0 {
+		delete(bc.bonds, key)
+		return
+	}
+	bc.bonds[key] = amount
+}
+
+// unbonding 替换整个解绑列表, 不能在原切片上修改, 调用者需要持有 stateLock
+func (bc *Blockchain) setUnbonding(unbonding []Unbonding) {
+	if bc.journal != nil {
+		bc.journal.append(unbondingChange{prev: bc.unbonding})
+	}
+
+	bc.unbonding = unbonding
+}
+
+// slashed 为 false 时删除罚没记录, 调用者需要持有 stateLock
+func (bc *Blockchain) setSlashed(key slashKey, slashed bool) {
+	if bc.journal != nil {
+		bc.journal.append(slashChange{key: key, prev: bc.slashed[key]})
+	}
+
+	if !slashed {
+		delete(bc.slashed, key)
+		return
+	}
+	bc.slashed[key] = true
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevertTo(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 1000
+	// 直接写入的状态不经过 journal, 需要重建状态树
+	bc.rebuildTrie()
+	genesisRoot := bc.StateRoot()
+
+	transferTx := NewTransaction(nil)
+	transferTx.To = privKeyAlice.PublicKey()
+	transferTx.Value = 60
+	assert.Nil(t, transferTx.Sign(privKeyBob))
+
+	collectionTx := NewTransaction(nil)
+	collectionTx.TxInner = CollectionTx{Fee: 200, MetaData: []byte("collection")}
+	collectionTx.Nonce = 1
+	assert.Nil(t, collectionTx.Sign(privKeyBob))
+
+	b1 := randomBlock(t, 1, bc.GenesisHash())
+	b1.AddTransaction(transferTx)
+	b1.AddTransaction(collectionTx)
+	sealBlock(t, bc, b1, testValidator)
+	assert.Nil(t, bc.AddBlock(b1))
+	b1Root := bc.StateRoot()
+
+	mintTx := NewTransaction(nil)
+	mintTx.TxInner = MintTx{
+		Fee:        200,
+		NFT:        types.Hash{0x01},
+		Collection: collectionTx.Hash(TxHasher{}),
+		MetaData:   []byte("mint"),
+	}
+	mintTx.Nonce = 2
+	assert.Nil(t, mintTx.Sign(privKeyBob))
+
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	b2.AddTransaction(mintTx)
+	sealBlock(t, bc, b2, testValidator)
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, 1, len(bc.mintState))
+
+	var dropped []*Transaction
+	bc.SetReorgHandler(func(txs []*Transaction) {
+		dropped = append(dropped, txs...)
+	})
+
+	assert.Nil(t, bc.RevertTo(1))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, b1Root, bc.StateRoot())
+	assert.Equal(t, 0, len(bc.mintState))
+	assert.Equal(t, 1, len(bc.collectionState))
+	assert.False(t, bc.HasBlockHash(b2.Hash(BlockHasher{})))
+
+	assert.Nil(t, bc.RevertTo(0))
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.Equal(t, genesisRoot, bc.StateRoot())
+	assert.Equal(t, 0, len(bc.collectionState))
+
+	_, err := bc.accountState.GetAccount(privKeyAlice.PublicKey().Address())
+	assert.Equal(t, ErrAccountNotFound, err)
+	balance, err := bc.accountState.GetBalance(privKeyBob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), balance)
+	assert.Equal(t, uint64(0), bc.NextNonce(privKeyBob.PublicKey().Address()))
+
+	_, err = bc.GetTxByHash(transferTx.Hash(TxHasher{}))
+	assert.NotNil(t, err)
+	assert.Equal(t, len(b1.Transactions)+len(b2.Transactions), len(dropped))
+
+	// 撤销之后可以重新接上区块
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, b1Root, bc.StateRoot())
+
+	assert.NotNil(t, bc.RevertTo(5))
+}
+
+func TestJournalRevertToSnapshot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	j := newJournal()
+	bc.setJournal(j)
+	defer bc.setJournal(nil)
+
+	assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("bar")))
+	snapshot := j.snapshot()
+
+	assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("baz")))
+	assert.Nil(t, bc.contractState.Put([]byte("other"), []byte("value")))
+	bc.setCollection(types.Hash{0x01}, &CollectionTx{Fee: 1})
+
+	j.revertToSnapshot(bc, snapshot)
+	assert.Equal(t, snapshot, j.snapshot())
+
+	value, err := bc.contractState.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	_, err = bc.contractState.Get([]byte("other"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(bc.collectionState))
+
+	bc.setJournal(nil)
+	j.undo(bc)
+	_, err = bc.contractState.Get([]byte("foo"))
+	assert.NotNil(t, err)
+}
+
+// 重新构建状态树得到的根, 之后恢复增量更新的状态树
+func rebuiltStateRoot(bc *Blockchain) types.Hash {
+	trie := bc.trie
+	bc.rebuildTrie()
+	root := bc.trie.Root()
+	bc.trie = trie
+
+	return root
+}
+
+func TestStateTrieFollowsJournal(t *testing.T) {
+	c := newGovernanceChain(t, 1, 2)
+	trie := c.bc.trie
+
+	for i := 0; i < 3; i++ {
+		c.addBlock(t,
+			c.transfer(t, c.keys[1], c.keys[2].PublicKey(), 10),
+			c.tx(t, c.keys[2], CollectionTx{Fee: 1, MetaData: []byte{byte(i)}}),
+		)
+		assert.Equal(t, rebuiltStateRoot(c.bc), c.bc.StateRoot())
+	}
+
+	// 撤销区块时按 journal 撤销状态树的修改
+	assert.Nil(t, c.bc.RevertTo(1))
+	assert.Equal(t, rebuiltStateRoot(c.bc), c.bc.StateRoot())
+	assert.Nil(t, c.bc.RevertTo(0))
+	assert.Equal(t, rebuiltStateRoot(c.bc), c.bc.StateRoot())
+
+	// 状态树一直是同一棵树
+	assert.True(t, trie == c.bc.trie)
+}
@@ -0,0 +1,440 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+)
+
+var (
+	ErrUnknownHeader  = errors.New("header not synced by the light chain")
+	ErrHeaderConflict = errors.New("header conflicts with the synced light chain")
+	ErrInvalidProof   = errors.New("invalid merkle proof")
+)
+
+// ValidatorSet 是验证者和出块权重, 区块之后验证者集合变化时发送给轻节点
+type ValidatorSet struct {
+	Validators []crypto.PublicKey
+	Weights    []uint64
+}
+
+func (s *ValidatorSet) Hash() types.Hash {
+	return validatorSet{validators: s.Validators, weights: s.Weights}.Hash()
+}
+
+func (s *ValidatorSet) validate() error {
+	if len(s.Validators) != len(s.Weights) {
+		return fmt.Errorf("validator set with (%d) validators and (%d) weights: %w", len(s.Validators), len(s.Weights), ErrInvalidValidators)
+	}
+
+	for i, w := range s.Weights {
+		if w == 0 {
+			return fmt.Errorf("validator (%s) with zero weight: %w", s.Validators[i], ErrInvalidValidators)
+		}
+		if containsKey(s.Validators[:i], s.Validators[i]) {
+			return fmt.Errorf("validator (%s) listed twice: %w", s.Validators[i], ErrInvalidValidators)
+		}
+	}
+
+	return nil
+}
+
+// LightHeader 是同步给轻节点的区块头和出块者签名, 不包括交易
+// NextValidators 只在区块之后验证者集合变化时设置, Commit 只在区块已经是最终的时候设置
+type LightHeader struct {
+	SignedHeader
+	NextValidators *ValidatorSet
+	Commit         *CommitCertificate
+}
+
+func (h *LightHeader) Encode(enc Encoder[*LightHeader]) error {
+	return enc.Encode(h)
+}
+
+func (h *LightHeader) Decode(dec Decoder[*LightHeader]) error {
+	return dec.Decode(h)
+}
+
+// GetLightHeader 返回规范链上高度为 height 的轻节点区块头
+func (bc *Blockchain) GetLightHeader(height uint32) (*LightHeader, error) {
+	signed, err := bc.signedHeader(height)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &LightHeader{SignedHeader: signed}
+
+	if height > 0 {
+		parent, err := bc.GetHeader(height - 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if parent.ValidatorsHash != signed.Header.ValidatorsHash {
+			set := bc.validatorSetAt(height + 1)
+			h.NextValidators = &ValidatorSet{Validators: set.validators, Weights: set.weights}
+		}
+	}
+
+	// 区块还不是最终的时候没有证书
+	h.Commit, _ = bc.GetCommit(signed.Hash())
+
+	return h, nil
+}
+
+// AccountProof 证明账户状态包含在区块头的 StateRoot 中
+type AccountProof struct {
+	Header  *Header
+	Account *Account
+	Proof   *SMTProof
+}
+
+func (p *AccountProof) Encode(enc Encoder[*AccountProof]) error {
+	return enc.Encode(p)
+}
+
+func (p *AccountProof) Decode(dec Decoder[*AccountProof]) error {
+	return dec.Decode(p)
+}
+
+func (p *AccountProof) Verify() error {
+	if p.Header == nil || p.Account == nil {
+		return fmt.Errorf("account proof without header or account: %w", ErrInvalidProof)
+	}
+
+	if !VerifySMTProof(p.Header.StateRoot, accountKey(p.Account.Address), p.Account.Bytes(), p.Proof) {
+		return fmt.Errorf("account (%s) is not included in state root (%s): %w", p.Account.Address, p.Header.StateRoot, ErrInvalidProof)
+	}
+
+	return nil
+}
+
+func (p *TxProof) Encode(enc Encoder[*TxProof]) error {
+	return enc.Encode(p)
+}
+
+func (p *TxProof) Decode(dec Decoder[*TxProof]) error {
+	return dec.Decode(p)
+}
+
+// GetAccountProof 返回账户当前状态包含在最高区块 StateRoot 中的证明
+// 状态树只能证明存在的 key, 账户不存在时返回错误
+func (bc *Blockchain) GetAccountProof(address types.Address) (*AccountProof, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	acc, err := bc.accountState.GetAccount(address)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := bc.GetHeader(bc.Height())
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := bc.trie.Prove(accountKey(address))
+	if err != nil {
+		return nil, err
+	}
+
+	cp := *acc
+	return &AccountProof{Header: header, Account: &cp, Proof: proof}, nil
+}
+
+// 按生效高度排序的验证者集合
+type validatorHistory []validatorSet
+
+func (s validatorHistory) at(height uint32) validatorSet {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].height <= height {
+			return s[i]
+		}
+	}
+
+	return validatorSet{}
+}
+
+// upTo 返回在 height 或者更早生效的验证者集合
+func (s validatorHistory) upTo(height uint32) validatorHistory {
+	i := len(s)
+	for i > 0 && s[i-1].height > height {
+		i--
+	}
+
+	return s[:i:i]
+}
+
+func (s validatorHistory) ProposerAt(height, round uint32) (crypto.PublicKey, bool) {
+	return s.at(height).proposerAt(height, round)
+}
+
+func (s validatorHistory) IsProposer(height, round uint32, pubKey crypto.PublicKey) bool {
+	proposer, ok := s.ProposerAt(height, round)
+
+	return ok && string(proposer) == string(pubKey)
+}
+
+// 只有区块头时轮次必须为 0
+func (s validatorHistory) CertifiedRound(h *Header) bool {
+	return false
+}
+
+// committedHistory 用于校验带有证书的区块头, 区块头的轮次由证书确定
+type committedHistory struct {
+	validatorHistory
+	commit *CommitCertificate
+}
+
+func (c committedHistory) CertifiedRound(h *Header) bool {
+	return BlockHasher{}.Hash(h) == c.commit.BlockHash && h.Round <= c.commit.Round
+}
+
+// LightChain 只保存区块头, 按创世配置和区块头中的 ValidatorsHash 跟踪验证者集合,
+// 用已经同步的区块头校验全节点提供的交易和账户证明
+// 最终确定的区块头不能替换, 还不是最终的区块头可以被接在同一个父区块头上的区块头替换,
+// 即轻节点跟随提供区块头的全节点的规范链
+type LightChain struct {
+	logger  log.Logger
+	chainID uint32
+	engine  ConsensusEngine
+
+	lock          sync.RWMutex
+	headers       []*Header
+	validatorSets validatorHistory
+	finalized     uint32
+}
+
+func NewLightChain(l log.Logger, g *Genesis) (*LightChain, error) {
+	genesis, err := g.Block()
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := g.Engine()
+	if err != nil {
+		return nil, err
+	}
+
+	validators, err := g.validators()
+	if err != nil {
+		return nil, err
+	}
+
+	return &LightChain{
+		logger:        l,
+		chainID:       g.ChainID,
+		engine:        engine,
+		headers:       []*Header{genesis.Header},
+		validatorSets: validatorHistory{newValidatorSet(0, validators, nil)},
+	}, nil
+}
+
+func (lc *LightChain) ChainID() uint32 {
+	return lc.chainID
+}
+
+func (lc *LightChain) Height() uint32 {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	return uint32(len(lc.headers) - 1)
+}
+
+func (lc *LightChain) FinalizedHeight() uint32 {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	return lc.finalized
+}
+
+func (lc *LightChain) GenesisHash() types.Hash {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	return BlockHasher{}.Hash(lc.headers[0])
+}
+
+func (lc *LightChain) GetHeader(height uint32) (*Header, error) {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	if height >= uint32(len(lc.headers)) {
+		return nil, fmt.Errorf("given height (%d) too high", height)
+	}
+
+	return lc.headers[height], nil
+}
+
+func (lc *LightChain) ValidatorsAt(height uint32) []crypto.PublicKey {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	return lc.validatorSets.at(height).validators
+}
+
+func (lc *LightChain) ProposerAt(height, round uint32) (crypto.PublicKey, bool) {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	return lc.validatorSets.ProposerAt(height, round)
+}
+
+func (lc *LightChain) IsProposer(height, round uint32, pubKey crypto.PublicKey) bool {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	return lc.validatorSets.IsProposer(height, round, pubKey)
+}
+
+// AddHeader 校验区块头接在最高区块头之后, 由当时的验证者签名, 并记录区块之后的验证者集合
+// 与还不是最终的区块头冲突时, 校验通过之后丢弃这个高度和之后的区块头
+func (lc *LightChain) AddHeader(lh *LightHeader) error {
+	h := lh.Header
+	if h == nil {
+		return fmt.Errorf("light header without header: %w", ErrInvalidProof)
+	}
+	hash := BlockHasher{}.Hash(h)
+
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+
+	tip := lc.headers[len(lc.headers)-1]
+	if h.Height <= tip.Height {
+		if (BlockHasher{}).Hash(lc.headers[h.Height]) == hash {
+			return ErrBlockKnown
+		}
+		if h.Height <= lc.finalized {
+			return fmt.Errorf("header (%s) with height (%d) => finalized height (%d): %w", hash, h.Height, lc.finalized, ErrHeaderConflict)
+		}
+		tip = lc.headers[h.Height-1]
+	}
+	// 被替换的区块头记录的验证者集合也要丢弃
+	sets := lc.validatorSets.upTo(h.Height)
+
+	if h.Height != tip.Height+1 || h.PrevBlockHash != (BlockHasher{}).Hash(tip) {
+		return fmt.Errorf("header (%s) with height (%d) => light chain height (%d): %w", hash, h.Height, tip.Height, ErrUnknownParent)
+	}
+
+	if !supportedVersions[h.Version] {
+		return fmt.Errorf("header (%s) with version (%d): %w", hash, h.Version, ErrUnsupportedVersion)
+	}
+
+	if h.ChainID != lc.chainID {
+		return fmt.Errorf("header (%s) with chain id (%d) => chain id (%d): %w", hash, h.ChainID, lc.chainID, ErrInvalidChainID)
+	}
+
+	if h.Timestamp <= tip.Timestamp {
+		return fmt.Errorf("header (%s) with timestamp (%d) => parent timestamp (%d): %w", hash, h.Timestamp, tip.Timestamp, ErrInvalidTimestamp)
+	}
+
+	if limit := time.Now().Add(MaxFutureDrift).UnixNano(); h.Timestamp > limit {
+		return fmt.Errorf("header (%s) with timestamp (%d) => latest accepted (%d): %w", hash, h.Timestamp, limit, ErrFutureBlock)
+	}
+
+	if lh.Signature == nil || !lh.Signature.Verify(lh.Validator, signingHash(blockSigningDomain, hash)) {
+		return fmt.Errorf("header (%s) has no valid signature", hash)
+	}
+
+	// 证书在区块头之前校验, 区块头的轮次需要证书确定
+	var chain ChainReader = sets
+	if c := lh.Commit; c != nil {
+		if c.Height != h.Height || c.BlockHash != hash || h.Round > c.Round {
+			return fmt.Errorf("commit for block (%s) height (%d) round (%d) => header (%s) height (%d) round (%d): %w", c.BlockHash, c.Height, c.Round, hash, h.Height, h.Round, ErrInvalidCommit)
+		}
+		if err := c.Verify(lc.chainID, sets.at(h.Height).validators); err != nil {
+			return err
+		}
+		chain = committedHistory{validatorHistory: sets, commit: c}
+	}
+
+	if err := lc.engine.VerifyHeader(chain, h, tip, lh.Validator); err != nil {
+		return err
+	}
+
+	if next := sets.at(h.Height + 1); next.Hash() != h.ValidatorsHash {
+		set := lh.NextValidators
+		if set == nil {
+			return fmt.Errorf("header (%s) changes validators hash (%s) without the new set: %w", hash, h.ValidatorsHash, ErrInvalidValidators)
+		}
+		if err := set.validate(); err != nil {
+			return err
+		}
+		if set.Hash() != h.ValidatorsHash {
+			return fmt.Errorf("header (%s) with validators hash (%s) => given set (%s): %w", hash, h.ValidatorsHash, set.Hash(), ErrInvalidValidators)
+		}
+
+		validators := append([]crypto.PublicKey{}, set.Validators...)
+		weights := append([]uint64{}, set.Weights...)
+		sets = append(sets[:len(sets):len(sets)], newValidatorSet(h.Height+1, validators, weights))
+	}
+
+	if lh.Commit != nil {
+		lc.finalized = h.Height
+	}
+
+	if len(sets) > 0 && sets[len(sets)-1].height == h.Height+1 {
+		lc.logger.Log("msg", "light chain validator set changed", "height", h.Height+1, "validators", len(sets[len(sets)-1].validators))
+	}
+
+	if dropped := len(lc.headers) - int(h.Height); dropped > 0 {
+		lc.logger.Log("msg", "light chain switched to a conflicting header", "height", h.Height, "hash", hash, "dropped", dropped)
+	}
+
+	lc.headers = append(lc.headers[:h.Height], h)
+	lc.validatorSets = sets
+
+	return nil
+}
+
+// 区块头必须是已经同步的区块头, 调用者需要持有 lock
+func (lc *LightChain) checkHeader(h *Header) error {
+	if h == nil {
+		return fmt.Errorf("proof without header: %w", ErrInvalidProof)
+	}
+
+	hash := BlockHasher{}.Hash(h)
+	if h.Height >= uint32(len(lc.headers)) {
+		return fmt.Errorf("header (%s) with height (%d) => light chain height (%d): %w", hash, h.Height, len(lc.headers)-1, ErrUnknownHeader)
+	}
+
+	if synced := (BlockHasher{}).Hash(lc.headers[h.Height]); synced != hash {
+		return fmt.Errorf("header (%s) with height (%d) => synced header (%s): %w", hash, h.Height, synced, ErrUnknownHeader)
+	}
+
+	return nil
+}
+
+// VerifyTxProof 校验交易包含在已经同步的区块中
+func (lc *LightChain) VerifyTxProof(p *TxProof) error {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	if err := lc.checkHeader(p.Header); err != nil {
+		return err
+	}
+
+	if err := p.Verify(); err != nil {
+		return fmt.Errorf("%s: %w", err, ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// VerifyAccountProof 校验账户状态包含在已经同步的区块头的 StateRoot 中
+func (lc *LightChain) VerifyAccountProof(p *AccountProof) error {
+	lc.lock.RLock()
+	defer lc.lock.RUnlock()
+
+	if err := lc.checkHeader(p.Header); err != nil {
+		return err
+	}
+
+	return p.Verify()
+}
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// 提议加入第 4 个验证者, 在高度 4 生效, 链的高度为 height
+func newValidatorChangeChain(t *testing.T, height uint32) *governanceChain {
+	c := newGovernanceChain(t, 3, 1)
+
+	propose := c.tx(t, c.keys[0], ValidatorProposalTx{Action: ValidatorAdd, Validator: c.keys[3].PublicKey()})
+	hash := propose.Hash(TxHasher{})
+	c.addBlock(t, propose)
+	c.addBlock(t,
+		c.tx(t, c.keys[1], ValidatorVoteTx{Proposal: hash, Approve: true}),
+		c.tx(t, c.keys[2], ValidatorVoteTx{Proposal: hash, Approve: true}),
+	)
+	for c.bc.Height() < height {
+		c.addBlock(t)
+	}
+
+	return c
+}
+
+func newLightChain(t *testing.T, c *governanceChain) *LightChain {
+	lc, err := NewLightChain(log.NewNopLogger(), c.genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, c.bc.GenesisHash(), lc.GenesisHash())
+
+	return lc
+}
+
+// 把 [from, to] 的区块头加入轻节点的链
+func syncHeaders(t *testing.T, lc *LightChain, bc *Blockchain, from, to uint32) {
+	for height := from; height <= to; height++ {
+		lh, err := bc.GetLightHeader(height)
+		assert.Nil(t, err)
+		assert.Nil(t, lc.AddHeader(lh))
+	}
+}
+
+func TestLightChainSyncsValidatorChange(t *testing.T) {
+	c := newValidatorChangeChain(t, 6)
+	lc := newLightChain(t, c)
+
+	// 只有改变验证者集合的区块头带有新的集合
+	for height := uint32(1); height <= 6; height++ {
+		lh, err := c.bc.GetLightHeader(height)
+		assert.Nil(t, err)
+		assert.Equal(t, height == 3, lh.NextValidators != nil)
+	}
+
+	syncHeaders(t, lc, c.bc, 1, 6)
+	assert.Equal(t, uint32(6), lc.Height())
+	assert.Len(t, lc.ValidatorsAt(3), 3)
+	assert.Equal(t, c.bc.ValidatorsAt(4), lc.ValidatorsAt(4))
+
+	for height := uint32(1); height <= 6; height++ {
+		proposer, ok := lc.ProposerAt(height, 0)
+		assert.True(t, ok)
+		assert.True(t, c.bc.IsProposer(height, 0, proposer))
+	}
+
+	header, err := lc.GetHeader(6)
+	assert.Nil(t, err)
+	expected, err := c.bc.GetHeader(6)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, header)
+
+	lh, err := c.bc.GetLightHeader(6)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, lc.AddHeader(lh), ErrBlockKnown)
+}
+
+func TestLightChainRejectsValidatorChange(t *testing.T) {
+	c := newValidatorChangeChain(t, 4)
+	lc := newLightChain(t, c)
+	syncHeaders(t, lc, c.bc, 1, 2)
+
+	lh, err := c.bc.GetLightHeader(3)
+	assert.Nil(t, err)
+	set := lh.NextValidators
+
+	// 没有新的集合
+	lh.NextValidators = nil
+	assert.ErrorIs(t, lc.AddHeader(lh), ErrInvalidValidators)
+
+	// 与区块头中的 hash 不同的集合
+	lh.NextValidators = &ValidatorSet{Validators: set.Validators[:3], Weights: set.Weights[:3]}
+	assert.ErrorIs(t, lc.AddHeader(lh), ErrInvalidValidators)
+
+	lh.NextValidators = &ValidatorSet{Validators: set.Validators, Weights: set.Weights[:3]}
+	assert.ErrorIs(t, lc.AddHeader(lh), ErrInvalidValidators)
+	assert.Equal(t, uint32(2), lc.Height())
+
+	lh.NextValidators = set
+	assert.Nil(t, lc.AddHeader(lh))
+	assert.Len(t, lc.ValidatorsAt(4), 4)
+}
+
+func TestLightChainRejectsInvalidHeaders(t *testing.T) {
+	c := newValidatorChangeChain(t, 3)
+	lc := newLightChain(t, c)
+	syncHeaders(t, lc, c.bc, 1, 1)
+
+	// 跳过高度
+	lh, err := c.bc.GetLightHeader(3)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, lc.AddHeader(lh), ErrUnknownParent)
+
+	// 不是出块者签名
+	lh, err = c.bc.GetLightHeader(2)
+	assert.Nil(t, err)
+	for _, key := range c.keys {
+		if bytes.Equal(key.PublicKey(), lh.Validator) {
+			continue
+		}
+		b := &Block{Header: lh.Header}
+		assert.Nil(t, b.Sign(key))
+		forged := &LightHeader{SignedHeader: SignedHeaderOf(b)}
+		assert.NotNil(t, lc.AddHeader(forged))
+	}
+
+	// 签名与区块头不符
+	header := *lh.Header
+	header.Timestamp++
+	forged := &LightHeader{SignedHeader: SignedHeader{Header: &header, Validator: lh.Validator, Signature: lh.Signature}}
+	assert.NotNil(t, lc.AddHeader(forged))
+	assert.Equal(t, uint32(1), lc.Height())
+
+	// 与最终确定的创世区块头冲突
+	genesis, err := c.bc.GetLightHeader(0)
+	assert.Nil(t, err)
+	header = *genesis.Header
+	header.Timestamp++
+	b := &Block{Header: &header}
+	assert.Nil(t, b.Sign(c.keys[0]))
+	assert.ErrorIs(t, lc.AddHeader(&LightHeader{SignedHeader: SignedHeaderOf(b)}), ErrHeaderConflict)
+}
+
+// 由出块者在 parent 之后打包一个不加入规范链的区块
+func sideHeader(t *testing.T, c *governanceChain, parent *Header) *LightHeader {
+	b, err := NewBlockFromPrevHeader(parent, nil)
+	assert.Nil(t, err)
+	b.Timestamp = parent.Timestamp + 1
+
+	proposer, ok := c.bc.ProposerAt(b.Height, 0)
+	assert.True(t, ok)
+	for _, key := range c.keys {
+		if bytes.Equal(key.PublicKey(), proposer) {
+			sealBlock(t, c.bc, b, key)
+		}
+	}
+
+	return &LightHeader{SignedHeader: SignedHeaderOf(b)}
+}
+
+func TestLightChainSwitchesNonFinalHeaders(t *testing.T) {
+	c := newGovernanceChain(t, 2, 0)
+	for i := 0; i < 3; i++ {
+		c.addBlock(t)
+	}
+	lc := newLightChain(t, c)
+	syncHeaders(t, lc, c.bc, 1, 3)
+
+	// 还不是最终的区块头被冲突的区块头替换, 之后的区块头被丢弃
+	parent, err := c.bc.GetHeader(1)
+	assert.Nil(t, err)
+	side := sideHeader(t, c, parent)
+	assert.Nil(t, lc.AddHeader(side))
+	assert.Equal(t, uint32(2), lc.Height())
+	synced, err := lc.GetHeader(2)
+	assert.Nil(t, err)
+	assert.Equal(t, side.Hash(), BlockHasher{}.Hash(synced))
+
+	// 再切换回全节点的规范链
+	syncHeaders(t, lc, c.bc, 2, 3)
+	assert.Equal(t, uint32(3), lc.Height())
+
+	// 带有证书的区块头是最终的, 不能再替换
+	c.addBlock(t)
+	lh, err := c.bc.GetLightHeader(4)
+	assert.Nil(t, err)
+	lh.Commit = testCommit(t, c.bc.ChainID(), 4, 0, lh.Hash(), c.keys...)
+	assert.Nil(t, lc.AddHeader(lh))
+	assert.Equal(t, uint32(4), lc.FinalizedHeight())
+
+	parent, err = c.bc.GetHeader(3)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, lc.AddHeader(sideHeader(t, c, parent)), ErrHeaderConflict)
+	parent, err = c.bc.GetHeader(2)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, lc.AddHeader(sideHeader(t, c, parent)), ErrHeaderConflict)
+	assert.Equal(t, uint32(4), lc.Height())
+}
+
+func TestLightChainRoundNeedsCommit(t *testing.T) {
+	c := newGovernanceChain(t, 2, 0)
+	lc := newLightChain(t, c)
+
+	proposer, ok := c.bc.ProposerAt(1, 1)
+	assert.True(t, ok)
+	header, err := c.bc.GetHeader(0)
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(header, nil)
+	assert.Nil(t, err)
+	b.Round = 1
+	for _, key := range c.keys {
+		if key.PublicKey().String() == proposer.String() {
+			sealBlock(t, c.bc, b, key)
+		}
+	}
+	commit := testCommit(t, c.bc.ChainID(), 1, 1, b.Hash(BlockHasher{}), c.keys...)
+	assert.Nil(t, c.bc.AddCommittedBlock(b, commit))
+
+	lh, err := c.bc.GetLightHeader(1)
+	assert.Nil(t, err)
+	assert.NotNil(t, lh.Commit)
+
+	// 没有证书时轮次必须为 0
+	withoutCommit := &LightHeader{SignedHeader: lh.SignedHeader}
+	assert.ErrorIs(t, lc.AddHeader(withoutCommit), ErrUncertifiedRound)
+
+	assert.Nil(t, lc.AddHeader(lh))
+	assert.Equal(t, uint32(1), lc.Height())
+}
+
+func TestLightChainVerifyProofs(t *testing.T) {
+	c := newGovernanceChain(t, 2, 0)
+	stake := c.tx(t, c.keys[1], StakeTx{Amount: 100})
+	c.addBlock(t, stake)
+	c.addBlock(t)
+
+	lc := newLightChain(t, c)
+	syncHeaders(t, lc, c.bc, 1, 1)
+
+	txProof, err := c.bc.GetTxProof(stake.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Nil(t, lc.VerifyTxProof(txProof))
+
+	tampered := *txProof
+	tampered.TxHash = types.Hash{0x01}
+	assert.ErrorIs(t, lc.VerifyTxProof(&tampered), ErrInvalidProof)
+
+	// 账户证明针对全节点最高的区块, 轻节点还没有同步
+	accProof, err := c.bc.GetAccountProof(c.keys[1].PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(900), accProof.Account.Balance)
+	assert.ErrorIs(t, lc.VerifyAccountProof(accProof), ErrUnknownHeader)
+
+	syncHeaders(t, lc, c.bc, 2, 2)
+	assert.Nil(t, lc.VerifyAccountProof(accProof))
+
+	account := *accProof.Account
+	account.Balance = 1000
+	forged := &AccountProof{Header: accProof.Header, Account: &account, Proof: accProof.Proof}
+	assert.ErrorIs(t, lc.VerifyAccountProof(forged), ErrInvalidProof)
+}
+
+func TestLightCodecEncodeDecode(t *testing.T) {
+	c := newValidatorChangeChain(t, 3)
+	stake := c.tx(t, c.keys[1], StakeTx{Amount: 100})
+	c.addBlock(t, stake)
+
+	lh, err := c.bc.GetLightHeader(3)
+	assert.Nil(t, err)
+	assert.NotNil(t, lh.NextValidators)
+	lh.Commit = &CommitCertificate{Height: 3, BlockHash: lh.Hash()}
+
+	txProof, err := c.bc.GetTxProof(stake.Hash(TxHasher{}))
+	assert.Nil(t, err)
+
+	accProof, err := c.bc.GetAccountProof(c.keys[1].PublicKey().Address())
+	assert.Nil(t, err)
+
+	for _, binary := range []bool{true, false} {
+		buf := &bytes.Buffer{}
+		lhDecoded := new(LightHeader)
+		if binary {
+			assert.Nil(t, lh.Encode(NewBinaryLightHeaderEncoder(buf)))
+			assert.Nil(t, lhDecoded.Decode(NewBinaryLightHeaderDecoder(buf)))
+		} else {
+			assert.Nil(t, lh.Encode(NewProtoLightHeaderEncoder(buf)))
+			assert.Nil(t, lhDecoded.Decode(NewProtoLightHeaderDecoder(buf)))
+		}
+		assert.Equal(t, lh.Hash(), lhDecoded.Hash())
+		assert.Equal(t, lh.NextValidators, lhDecoded.NextValidators)
+		assert.Equal(t, lh.Commit.BlockHash, lhDecoded.Commit.BlockHash)
+		assert.Nil(t, lhDecoded.Verify())
+
+		buf.Reset()
+		txDecoded := new(TxProof)
+		if binary {
+			assert.Nil(t, txProof.Encode(NewBinaryTxProofEncoder(buf)))
+			assert.Nil(t, txDecoded.Decode(NewBinaryTxProofDecoder(buf)))
+		} else {
+			assert.Nil(t, txProof.Encode(NewProtoTxProofEncoder(buf)))
+			assert.Nil(t, txDecoded.Decode(NewProtoTxProofDecoder(buf)))
+		}
+		assert.Equal(t, txProof.TxHash, txDecoded.TxHash)
+		assert.Nil(t, txDecoded.Verify())
+
+		buf.Reset()
+		accDecoded := new(AccountProof)
+		if binary {
+			assert.Nil(t, accProof.Encode(NewBinaryAccountProofEncoder(buf)))
+			assert.Nil(t, accDecoded.Decode(NewBinaryAccountProofDecoder(buf)))
+		} else {
+			assert.Nil(t, accProof.Encode(NewProtoAccountProofEncoder(buf)))
+			assert.Nil(t, accDecoded.Decode(NewProtoAccountProofDecoder(buf)))
+		}
+		assert.Equal(t, accProof.Account, accDecoded.Account)
+		assert.Nil(t, accDecoded.Verify())
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"project-bee/types"
+)
+
+// 叶子和内部节点使用不同前缀, 防止把内部节点伪装成叶子
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+// MerkleProof 从叶子到根的兄弟节点, 奇数个节点时最后一个节点直接提升到上一层
+type MerkleProof struct {
+	Index  int
+	Total  int
+	Hashes []types.Hash
+}
+
+// TxProof 证明交易包含在某个区块头的 DataHash 中
+type TxProof struct {
+	TxHash    types.Hash
+	BlockHash types.Hash
+	Header    *Header
+	Proof     *MerkleProof
+}
+
+func (p *TxProof) Verify() error {
+	if (BlockHasher{}).Hash(p.Header) != p.BlockHash {
+		return fmt.Errorf("header does not match block hash (%s)", p.BlockHash)
+	}
+
+	if !VerifyMerkleProof(p.Header.DataHash, p.TxHash, p.Proof) {
+		return fmt.Errorf("tx (%s) is not included in block (%s)", p.TxHash, p.BlockHash)
+	}
+
+	return nil
+}
+
+func merkleLeafHash(leaf types.Hash) types.Hash {
+	buf := make([]byte, 0, 33)
+	buf = append(buf, merkleLeafPrefix)
+	buf = append(buf, leaf[:]...)
+
+	return types.Hash(sha256.Sum256(buf))
+}
+
+func merkleNodeHash(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 65)
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return types.Hash(sha256.Sum256(buf))
+}
+
+// MerkleRoot 计算二叉 Merkle 根, 没有叶子时返回零 hash
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+
+	return level[0]
+}
+
+func nextMerkleLevel(level []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(level)+1)/2)
+
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNodeHash(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+func NewMerkleProof(leaves []types.Hash, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("merkle proof index (%d) out of range (%d)", index, len(leaves))
+	}
+
+	proof := &MerkleProof{
+		Index:  index,
+		Total:  len(leaves),
+		Hashes: []types.Hash{},
+	}
+
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+
+	for idx := index; len(level) > 1; idx /= 2 {
+		if idx%2 == 1 {
+			proof.Hashes = append(proof.Hashes, level[idx-1])
+		} else if idx+1 < len(level) {
+			proof.Hashes = append(proof.Hashes, level[idx+1])
+		}
+
+		level = nextMerkleLevel(level)
+	}
+
+	return proof, nil
+}
+
+func VerifyMerkleProof(root, leaf types.Hash, proof *MerkleProof) bool {
+	if proof == nil || proof.Index < 0 || proof.Index >= proof.Total {
+		return false
+	}
+
+	var (
+		hash = merkleLeafHash(leaf)
+		idx  = proof.Index
+		n    = proof.Total
+		i    = 0
+	)
+
+	for ; n > 1; n = (n + 1) / 2 {
+		if idx%2 == 1 || idx+1 < n {
+			if i >= len(proof.Hashes) {
+				return false
+			}
+
+			if idx%2 == 1 {
+				hash = merkleNodeHash(proof.Hashes[i], hash)
+			} else {
+				hash = merkleNodeHash(hash, proof.Hashes[i])
+			}
+			i++
+		}
+
+		idx /= 2
+	}
+
+	return i == len(proof.Hashes) && hash == root
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleRootEmpty(t *testing.T) {
+	assert.Equal(t, types.Hash{}, MerkleRoot(nil))
+}
+
+func TestMerkleRootOrder(t *testing.T) {
+	leaves := testLeaves(4)
+	root := MerkleRoot(leaves)
+
+	leaves[0], leaves[1] = leaves[1], leaves[0]
+	assert.NotEqual(t, root, MerkleRoot(leaves))
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := testLeaves(n)
+		root := MerkleRoot(leaves)
+
+		for i := 0; i < n; i++ {
+			proof, err := NewMerkleProof(leaves, i)
+			assert.Nil(t, err)
+			assert.True(t, VerifyMerkleProof(root, leaves[i], proof))
+
+			// 错误的叶子
+			if n > 1 {
+				assert.False(t, VerifyMerkleProof(root, leaves[(i+1)%n], proof))
+			}
+		}
+	}
+
+	_, err := NewMerkleProof(testLeaves(3), 3)
+	assert.NotNil(t, err)
+}
+
+func TestMerkleProofTamper(t *testing.T) {
+	leaves := testLeaves(7)
+	root := MerkleRoot(leaves)
+
+	proof, err := NewMerkleProof(leaves, 2)
+	assert.Nil(t, err)
+
+	proof.Hashes[0] = types.Hash{}
+	assert.False(t, VerifyMerkleProof(root, leaves[2], proof))
+
+	proof, err = NewMerkleProof(leaves, 2)
+	assert.Nil(t, err)
+	proof.Index = 3
+	assert.False(t, VerifyMerkleProof(root, leaves[2], proof))
+}
+
+func TestBlockchainGetTxProof(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	block := nextBlock(t, bc)
+	assert.Nil(t, bc.AddBlock(block))
+
+	tx := block.Transactions[0]
+	proof, err := bc.GetTxProof(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Nil(t, proof.Verify())
+	assert.Equal(t, block.Hash(BlockHasher{}), proof.BlockHash)
+
+	_, err = bc.GetTxProof(types.Hash{})
+	assert.NotNil(t, err)
+}
+
+func testLeaves(n int) []types.Hash {
+	leaves := make([]types.Hash, n)
+	for i := 0; i < n; i++ {
+		leaves[i] = types.Hash(sha256.Sum256([]byte{byte(i)}))
+	}
+
+	return leaves
+}
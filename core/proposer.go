@@ -0,0 +1,77 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+
+	"project-bee/crypto"
+)
+
+var ErrWrongProposer = errors.New("block not signed by the scheduled proposer")
+
+// 出块顺序最多的位置数, 总权重更大时按比例缩小权重
+const maxScheduleSlots = 1024
+
+// ProposerAt 按高度和共识轮次在该高度的验证者集合的出块顺序中选择出块者, 出块者没有出块时下一轮换下一个位置
+// 没有配置验证者时返回 false
+func (bc *Blockchain) ProposerAt(height, round uint32) (crypto.PublicKey, bool) {
+	return bc.validatorSetAt(height).proposerAt(height, round)
+}
+
+func (s validatorSet) proposerAt(height, round uint32) (crypto.PublicKey, bool) {
+	if len(s.validators) == 0 {
+		return nil, false
+	}
+
+	slot := (uint64(height) + uint64(round)) % uint64(len(s.schedule))
+	return s.validators[s.schedule[slot]], true
+}
+
+// proposerSchedule 用平滑加权轮询把权重展开成出块顺序, 每个验证者出现的次数与权重成正比并且尽量分散
+// 权重相同时就是按顺序轮流
+func proposerSchedule(weights []uint64) []int {
+	var total uint64
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		return nil
+	}
+
+	scaled := make([]int64, len(weights))
+	unit := (total + maxScheduleSlots - 1) / maxScheduleSlots
+	var sum int64
+	for i, w := range weights {
+		scaled[i] = int64(w / unit)
+		if scaled[i] == 0 {
+			scaled[i] = 1
+		}
+		sum += scaled[i]
+	}
+
+	schedule := make([]int, sum)
+	current := make([]int64, len(weights))
+	for slot := range schedule {
+		best := 0
+		for i, w := range scaled {
+			current[i] += w
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= sum
+		schedule[slot] = best
+	}
+
+	return schedule
+}
+
+// IsProposer 判断 pubKey 是否可以出高度为 height, 轮次为 round 的区块
+func (bc *Blockchain) IsProposer(height, round uint32, pubKey crypto.PublicKey) bool {
+	proposer, ok := bc.ProposerAt(height, round)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(proposer, pubKey)
+}
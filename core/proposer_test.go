@@ -0,0 +1,75 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProposerRotation(t *testing.T) {
+	v1 := crypto.GeneratePrivateKey()
+	v2 := crypto.GeneratePrivateKey()
+	g := testGenesis(v1.PublicKey())
+	g.Validators = append(g.Validators, v2.PublicKey().String())
+
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), g)
+	assert.Nil(t, err)
+
+	proposer, ok := bc.ProposerAt(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, v2.PublicKey(), proposer)
+	proposer, _ = bc.ProposerAt(2, 0)
+	assert.Equal(t, v1.PublicKey(), proposer)
+	// 下一轮换下一个验证者
+	proposer, _ = bc.ProposerAt(1, 1)
+	assert.Equal(t, v1.PublicKey(), proposer)
+
+	// 不是轮到的验证者出的块
+	b := nextBlock(t, bc)
+	sealBlock(t, bc, b, v1)
+	assert.ErrorIs(t, bc.AddBlock(b), ErrWrongProposer)
+
+	b = nextBlock(t, bc)
+	sealBlock(t, bc, b, crypto.GeneratePrivateKey())
+	assert.ErrorIs(t, bc.AddBlock(b), ErrWrongProposer)
+
+	for _, v := range []crypto.PrivateKey{v2, v1, v2} {
+		b := nextBlock(t, bc)
+		sealBlock(t, bc, b, v)
+		assert.Nil(t, bc.AddBlock(b))
+	}
+	assert.Equal(t, uint32(3), bc.Height())
+}
+
+func TestProposerWithoutValidators(t *testing.T) {
+	bc, err := NewBlockchain(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	// 没有验证者时没有节点可以出块
+	_, ok := bc.ProposerAt(1, 0)
+	assert.False(t, ok)
+	assert.False(t, bc.IsProposer(1, 0, crypto.GeneratePrivateKey().PublicKey()))
+
+	b := randomBlock(t, 1, bc.GenesisHash())
+	assert.ErrorIs(t, bc.AddBlock(b), ErrWrongProposer)
+
+	g := testGenesis(crypto.GeneratePrivateKey().PublicKey())
+	g.Validators = nil
+	assert.NotNil(t, g.Validate())
+	g.Consensus = ConsensusPoW
+	g.Difficulty = 1
+	g.TargetBlockTime = 1000
+	assert.Nil(t, g.Validate())
+}
+
+func TestGenesisDuplicateValidator(t *testing.T) {
+	validator := crypto.GeneratePrivateKey().PublicKey()
+	g := testGenesis(validator)
+	g.Validators = append(g.Validators, validator.String())
+
+	assert.NotNil(t, g.Validate())
+}
@@ -0,0 +1,1057 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+
+	"project-bee/crypto"
+	"project-bee/proto"
+	"project-bee/types"
+)
+
+// protobuf 编码, 消息定义见 proto/core.proto
+// protobuf 消息不带长度, 解码时读取 reader 中的全部数据
+
+// 单个 protobuf 消息的最大长度
+const maxProtoSize = 1 << 24
+
+func readProto(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxProtoSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxProtoSize {
+		return nil, fmt.Errorf("protobuf message of more than (%d) bytes: %w", maxProtoSize, ErrCodecTooLarge)
+	}
+
+	return data, nil
+}
+
+func protoHash(f proto.Field) (types.Hash, error) {
+	b, err := f.Raw()
+	if err != nil {
+		return types.Hash{}, err
+	}
+	if len(b) != 32 {
+		return types.Hash{}, fmt.Errorf("field (%d) hash of (%d) bytes: %w", f.Num, len(b), proto.ErrInvalidWire)
+	}
+
+	return types.HashFromBytes(b), nil
+}
+
+func marshalHeaderProto(h *Header) []byte {
+	buf := &proto.Buffer{}
+	buf.Uint32(1, h.Version)
+	buf.Uint32(2, h.ChainID)
+	buf.Bytes(3, h.DataHash.ToSlice())
+	buf.Bytes(4, h.StateRoot.ToSlice())
+	buf.Bytes(5, h.ReceiptsRoot.ToSlice())
+	buf.Bytes(6, h.PrevBlockHash.ToSlice())
+	buf.Uint32(7, h.Height)
+	buf.Int64(8, h.Timestamp)
+	buf.Uint32(9, h.Round)
+	buf.Uint64(10, h.Difficulty)
+	buf.Uint64(11, h.Nonce)
+	buf.Bytes(12, h.ValidatorsHash.ToSlice())
+
+	return buf.Result()
+}
+
+func unmarshalHeaderProto(data []byte) (*Header, error) {
+	h := new(Header)
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			h.Version, err = f.Uint32()
+		case 2:
+			h.ChainID, err = f.Uint32()
+		case 3:
+			h.DataHash, err = protoHash(f)
+		case 4:
+			h.StateRoot, err = protoHash(f)
+		case 5:
+			h.ReceiptsRoot, err = protoHash(f)
+		case 6:
+			h.PrevBlockHash, err = protoHash(f)
+		case 7:
+			h.Height, err = f.Uint32()
+		case 8:
+			h.Timestamp, err = f.Int64()
+		case 9:
+			h.Round, err = f.Uint32()
+		case 10:
+			h.Difficulty, err = f.Uint64()
+		case 11:
+			h.Nonce, err = f.Uint64()
+		case 12:
+			h.ValidatorsHash, err = protoHash(f)
+		}
+		return err
+	})
+
+	return h, err
+}
+
+func bigIntBytes(n *big.Int) []byte {
+	if n == nil {
+		return nil
+	}
+	return n.Bytes()
+}
+
+func marshalSignatureProto(sig crypto.Signature) []byte {
+	buf := &proto.Buffer{}
+	buf.Bytes(1, bigIntBytes(sig.R))
+	buf.Bytes(2, bigIntBytes(sig.S))
+
+	return buf.Result()
+}
+
+// protoSignature 解码不能为空的签名
+func protoSignature(data []byte) (*crypto.Signature, error) {
+	sig, err := unmarshalSignatureProto(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSignature(&sig); err != nil {
+		return nil, err
+	}
+
+	return &sig, nil
+}
+
+func protoPublicKey(f proto.Field) (crypto.PublicKey, error) {
+	b, err := f.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, checkPublicKey(b)
+}
+
+func unmarshalSignatureProto(data []byte) (crypto.Signature, error) {
+	sig := crypto.Signature{}
+	err := proto.Parse(data, func(f proto.Field) error {
+		if f.Num != 1 && f.Num != 2 {
+			return nil
+		}
+		b, err := f.Raw()
+		if err != nil || b == nil {
+			return err
+		}
+		if f.Num == 1 {
+			sig.R = new(big.Int).SetBytes(b)
+		} else {
+			sig.S = new(big.Int).SetBytes(b)
+		}
+		return nil
+	})
+
+	return sig, err
+}
+
+func marshalTxProto(tx *Transaction) ([]byte, error) {
+	buf := &proto.Buffer{}
+	buf.Uint32(1, tx.ChainID)
+
+	switch t := tx.TxInner.(type) {
+	case nil:
+	case CollectionTx:
+		inner := &proto.Buffer{}
+		inner.Int64(1, t.Fee)
+		inner.Bytes(2, t.MetaData)
+		buf.Message(2, inner.Result())
+	case MintTx:
+		inner := &proto.Buffer{}
+		inner.Int64(1, t.Fee)
+		inner.Bytes(2, t.NFT.ToSlice())
+		inner.Bytes(3, t.Collection.ToSlice())
+		inner.Bytes(4, t.MetaData)
+		inner.Bytes(5, t.CollectionOwner)
+		inner.Message(6, marshalSignatureProto(t.Signature))
+		buf.Message(3, inner.Result())
+	case ValidatorProposalTx:
+		inner := &proto.Buffer{}
+		inner.Uint32(1, uint32(t.Action))
+		inner.Bytes(2, t.Validator)
+		buf.Message(11, inner.Result())
+	case ValidatorVoteTx:
+		inner := &proto.Buffer{}
+		inner.Bytes(1, t.Proposal.ToSlice())
+		inner.Bool(2, t.Approve)
+		buf.Message(12, inner.Result())
+	case StakeTx:
+		inner := &proto.Buffer{}
+		inner.Uint64(1, t.Amount)
+		buf.Message(13, inner.Result())
+	case UnstakeTx:
+		buf.Message(14, marshalBondProto(t.Validator, t.Amount))
+	case DelegateTx:
+		buf.Message(15, marshalBondProto(t.Validator, t.Amount))
+	case EvidenceTx:
+		if t.First.Header == nil || t.Second.Header == nil {
+			return nil, fmt.Errorf("evidence without header: %w", ErrInvalidEvidence)
+		}
+		inner := &proto.Buffer{}
+		inner.Message(1, marshalSignedHeaderProto(t.First))
+		inner.Message(2, marshalSignedHeaderProto(t.Second))
+		buf.Message(16, inner.Result())
+	default:
+		return nil, fmt.Errorf("tx inner (%T): %w", t, ErrUnknownTxInner)
+	}
+
+	buf.Bytes(4, tx.Data)
+	buf.Bytes(5, tx.To)
+	buf.Uint64(6, tx.Value)
+	buf.Bytes(7, tx.From)
+	if tx.Signature != nil {
+		buf.Message(8, marshalSignatureProto(*tx.Signature))
+	}
+	buf.Uint64(9, tx.Nonce)
+	buf.Uint64(10, tx.Fee)
+
+	return buf.Result(), nil
+}
+
+func unmarshalCollectionTxProto(data []byte) (CollectionTx, error) {
+	c := CollectionTx{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			c.Fee, err = f.Int64()
+		case 2:
+			c.MetaData, err = f.Raw()
+		}
+		return err
+	})
+
+	return c, err
+}
+
+func unmarshalMintTxProto(data []byte) (MintTx, error) {
+	m := MintTx{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			m.Fee, err = f.Int64()
+		case 2:
+			m.NFT, err = protoHash(f)
+		case 3:
+			m.Collection, err = protoHash(f)
+		case 4:
+			m.MetaData, err = f.Raw()
+		case 5:
+			m.CollectionOwner, err = protoPublicKey(f)
+		case 6:
+			var b []byte
+			if b, err = f.Raw(); err == nil {
+				m.Signature, err = unmarshalSignatureProto(b)
+			}
+		}
+		return err
+	})
+
+	return m, err
+}
+
+func unmarshalValidatorProposalTxProto(data []byte) (ValidatorProposalTx, error) {
+	p := ValidatorProposalTx{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			var action uint32
+			action, err = f.Uint32()
+			p.Action = ValidatorAction(action)
+		case 2:
+			p.Validator, err = f.Raw()
+		}
+		return err
+	})
+
+	return p, err
+}
+
+func unmarshalValidatorVoteTxProto(data []byte) (ValidatorVoteTx, error) {
+	v := ValidatorVoteTx{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			v.Proposal, err = protoHash(f)
+		case 2:
+			v.Approve, err = f.Bool()
+		}
+		return err
+	})
+
+	return v, err
+}
+
+func unmarshalStakeTxProto(data []byte) (amount uint64, err error) {
+	err = proto.Parse(data, func(f proto.Field) (err error) {
+		if f.Num == 1 {
+			amount, err = f.Uint64()
+		}
+		return err
+	})
+
+	return amount, err
+}
+
+// UnstakeTx 和 DelegateTx 的字段相同
+func marshalBondProto(validator crypto.PublicKey, amount uint64) []byte {
+	buf := &proto.Buffer{}
+	buf.Bytes(1, validator)
+	buf.Uint64(2, amount)
+	return buf.Result()
+}
+
+func unmarshalBondProto(data []byte) (validator crypto.PublicKey, amount uint64, err error) {
+	err = proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			validator, err = f.Raw()
+		case 2:
+			amount, err = f.Uint64()
+		}
+		return err
+	})
+
+	return validator, amount, err
+}
+
+func marshalSignedHeaderProto(h SignedHeader) []byte {
+	buf := &proto.Buffer{}
+	buf.Message(1, marshalHeaderProto(h.Header))
+	buf.Bytes(2, h.Validator)
+	if h.Signature != nil {
+		buf.Message(3, marshalSignatureProto(*h.Signature))
+	}
+	return buf.Result()
+}
+
+func unmarshalSignedHeaderProto(data []byte) (SignedHeader, error) {
+	h := SignedHeader{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			if b, err = f.Raw(); err == nil {
+				h.Header, err = unmarshalHeaderProto(b)
+			}
+		case 2:
+			h.Validator, err = protoPublicKey(f)
+		case 3:
+			if b, err = f.Raw(); err == nil {
+				h.Signature, err = protoSignature(b)
+			}
+		}
+		return err
+	})
+	if err == nil && h.Header == nil {
+		err = fmt.Errorf("signed header without header: %w", proto.ErrInvalidWire)
+	}
+
+	return h, err
+}
+
+func unmarshalEvidenceTxProto(data []byte) (EvidenceTx, error) {
+	e := EvidenceTx{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			if b, err = f.Raw(); err == nil {
+				e.First, err = unmarshalSignedHeaderProto(b)
+			}
+		case 2:
+			if b, err = f.Raw(); err == nil {
+				e.Second, err = unmarshalSignedHeaderProto(b)
+			}
+		}
+		return err
+	})
+	if err == nil && (e.First.Header == nil || e.Second.Header == nil) {
+		err = fmt.Errorf("evidence without header: %w", proto.ErrInvalidWire)
+	}
+
+	return e, err
+}
+
+func unmarshalTxProto(data []byte) (*Transaction, error) {
+	tx := new(Transaction)
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			tx.ChainID, err = f.Uint32()
+		case 2:
+			if b, err = f.Raw(); err == nil {
+				tx.TxInner, err = unmarshalCollectionTxProto(b)
+			}
+		case 3:
+			if b, err = f.Raw(); err == nil {
+				tx.TxInner, err = unmarshalMintTxProto(b)
+			}
+		case 4:
+			tx.Data, err = f.Raw()
+		case 5:
+			tx.To, err = f.Raw()
+		case 6:
+			tx.Value, err = f.Uint64()
+		case 7:
+			tx.From, err = protoPublicKey(f)
+		case 8:
+			if b, err = f.Raw(); err == nil {
+				tx.Signature, err = protoSignature(b)
+			}
+		case 9:
+			tx.Nonce, err = f.Uint64()
+		case 10:
+			tx.Fee, err = f.Uint64()
+		case 11:
+			if b, err = f.Raw(); err == nil {
+				tx.TxInner, err = unmarshalValidatorProposalTxProto(b)
+			}
+		case 12:
+			if b, err = f.Raw(); err == nil {
+				tx.TxInner, err = unmarshalValidatorVoteTxProto(b)
+			}
+		case 13:
+			if b, err = f.Raw(); err == nil {
+				var amount uint64
+				amount, err = unmarshalStakeTxProto(b)
+				tx.TxInner = StakeTx{Amount: amount}
+			}
+		case 14:
+			if b, err = f.Raw(); err == nil {
+				t := UnstakeTx{}
+				t.Validator, t.Amount, err = unmarshalBondProto(b)
+				tx.TxInner = t
+			}
+		case 15:
+			if b, err = f.Raw(); err == nil {
+				t := DelegateTx{}
+				t.Validator, t.Amount, err = unmarshalBondProto(b)
+				tx.TxInner = t
+			}
+		case 16:
+			if b, err = f.Raw(); err == nil {
+				tx.TxInner, err = unmarshalEvidenceTxProto(b)
+			}
+		}
+		return err
+	})
+
+	return tx, err
+}
+
+func marshalBlockProto(b *Block) ([]byte, error) {
+	if b.Header == nil {
+		return nil, fmt.Errorf("block has no header")
+	}
+
+	buf := &proto.Buffer{}
+	buf.Message(1, marshalHeaderProto(b.Header))
+	for _, tx := range b.Transactions {
+		data, err := marshalTxProto(tx)
+		if err != nil {
+			return nil, err
+		}
+		buf.Message(2, data)
+	}
+	buf.Bytes(3, b.Validator)
+	if b.Signature != nil {
+		buf.Message(4, marshalSignatureProto(*b.Signature))
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalBlockProto(data []byte) (*Block, error) {
+	b := new(Block)
+	err := proto.Parse(data, func(f proto.Field) error {
+		if f.Num < 1 || f.Num > 4 {
+			return nil
+		}
+
+		raw, err := f.Raw()
+		if err != nil {
+			return err
+		}
+
+		switch f.Num {
+		case 1:
+			b.Header, err = unmarshalHeaderProto(raw)
+		case 2:
+			var tx *Transaction
+			if tx, err = unmarshalTxProto(raw); err == nil {
+				b.Transactions = append(b.Transactions, tx)
+			}
+		case 3:
+			b.Validator, err = raw, checkPublicKey(raw)
+		case 4:
+			b.Signature, err = protoSignature(raw)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Header == nil {
+		return nil, fmt.Errorf("block has no header: %w", proto.ErrInvalidWire)
+	}
+
+	return b, nil
+}
+
+type ProtoTxEncoder struct {
+	w io.Writer
+}
+
+func NewProtoTxEncoder(w io.Writer) *ProtoTxEncoder {
+	return &ProtoTxEncoder{
+		w: w,
+	}
+}
+
+func (enc *ProtoTxEncoder) Encode(tx *Transaction) error {
+	data, err := marshalTxProto(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.w.Write(data)
+	return err
+}
+
+type ProtoTxDecoder struct {
+	r io.Reader
+}
+
+func NewProtoTxDecoder(r io.Reader) *ProtoTxDecoder {
+	return &ProtoTxDecoder{
+		r: r,
+	}
+}
+
+func (dec *ProtoTxDecoder) Decode(tx *Transaction) error {
+	data, err := readProto(dec.r)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := unmarshalTxProto(data)
+	if err != nil {
+		return err
+	}
+	*tx = *decoded
+
+	return nil
+}
+
+type ProtoBlockEncoder struct {
+	w io.Writer
+}
+
+func NewProtoBlockEncoder(w io.Writer) *ProtoBlockEncoder {
+	return &ProtoBlockEncoder{
+		w: w,
+	}
+}
+
+func (enc *ProtoBlockEncoder) Encode(b *Block) error {
+	data, err := marshalBlockProto(b)
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.w.Write(data)
+	return err
+}
+
+type ProtoBlockDecoder struct {
+	r io.Reader
+}
+
+func NewProtoBlockDecoder(r io.Reader) *ProtoBlockDecoder {
+	return &ProtoBlockDecoder{
+		r: r,
+	}
+}
+
+func (dec *ProtoBlockDecoder) Decode(b *Block) error {
+	data, err := readProto(dec.r)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := unmarshalBlockProto(data)
+	if err != nil {
+		return err
+	}
+	*b = *decoded
+
+	return nil
+}
+
+func marshalVoteProto(v *Vote) ([]byte, error) {
+	buf := &proto.Buffer{}
+	buf.Uint32(1, uint32(v.Type))
+	buf.Uint32(2, v.ChainID)
+	buf.Uint32(3, v.Height)
+	buf.Uint32(4, v.Round)
+	buf.Bytes(5, v.BlockHash.ToSlice())
+	buf.Bytes(6, v.Validator)
+	if v.Signature != nil {
+		buf.Message(7, marshalSignatureProto(*v.Signature))
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalVoteProto(data []byte, v *Vote) error {
+	*v = Vote{}
+	return proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			var t uint32
+			t, err = f.Uint32()
+			v.Type = VoteType(t)
+		case 2:
+			v.ChainID, err = f.Uint32()
+		case 3:
+			v.Height, err = f.Uint32()
+		case 4:
+			v.Round, err = f.Uint32()
+		case 5:
+			v.BlockHash, err = protoHash(f)
+		case 6:
+			v.Validator, err = protoPublicKey(f)
+		case 7:
+			var b []byte
+			if b, err = f.Raw(); err == nil {
+				v.Signature, err = protoSignature(b)
+			}
+		}
+		return err
+	})
+}
+
+func marshalProposalProto(p *Proposal) ([]byte, error) {
+	block, err := marshalBlockProto(p.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &proto.Buffer{}
+	buf.Uint32(1, p.Height)
+	buf.Uint32(2, p.Round)
+	buf.Int64(3, int64(p.POLRound))
+	buf.Message(4, block)
+	buf.Bytes(5, p.Proposer)
+	if p.Signature != nil {
+		buf.Message(6, marshalSignatureProto(*p.Signature))
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalProposalProto(data []byte, p *Proposal) error {
+	*p = Proposal{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			p.Height, err = f.Uint32()
+		case 2:
+			p.Round, err = f.Uint32()
+		case 3:
+			var r int64
+			r, err = f.Int64()
+			p.POLRound = int32(r)
+		case 4:
+			if b, err = f.Raw(); err == nil {
+				p.Block, err = unmarshalBlockProto(b)
+			}
+		case 5:
+			p.Proposer, err = protoPublicKey(f)
+		case 6:
+			if b, err = f.Raw(); err == nil {
+				p.Signature, err = protoSignature(b)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.Block == nil {
+		return fmt.Errorf("proposal has no block: %w", proto.ErrInvalidWire)
+	}
+
+	return nil
+}
+
+func marshalCommitProto(c *CommitCertificate) ([]byte, error) {
+	buf := &proto.Buffer{}
+	buf.Uint32(1, c.Height)
+	buf.Uint32(2, c.Round)
+	buf.Bytes(3, c.BlockHash.ToSlice())
+	for _, v := range c.Precommits {
+		data, err := marshalVoteProto(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Message(4, data)
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalCommitProto(data []byte, c *CommitCertificate) error {
+	*c = CommitCertificate{}
+	return proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			c.Height, err = f.Uint32()
+		case 2:
+			c.Round, err = f.Uint32()
+		case 3:
+			c.BlockHash, err = protoHash(f)
+		case 4:
+			var b []byte
+			if b, err = f.Raw(); err == nil {
+				v := new(Vote)
+				if err = unmarshalVoteProto(b, v); err == nil {
+					c.Precommits = append(c.Precommits, v)
+				}
+			}
+		}
+		return err
+	})
+}
+
+func marshalValidatorSetProto(s *ValidatorSet) ([]byte, error) {
+	if len(s.Validators) != len(s.Weights) {
+		return nil, fmt.Errorf("validator set with (%d) validators and (%d) weights: %w", len(s.Validators), len(s.Weights), ErrInvalidValidators)
+	}
+
+	buf := &proto.Buffer{}
+	for i, v := range s.Validators {
+		entry := &proto.Buffer{}
+		entry.Bytes(1, v)
+		entry.Uint64(2, s.Weights[i])
+		buf.Message(1, entry.Result())
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalValidatorSetProto(data []byte) (*ValidatorSet, error) {
+	s := &ValidatorSet{}
+	err := proto.Parse(data, func(f proto.Field) error {
+		if f.Num != 1 {
+			return nil
+		}
+		b, err := f.Raw()
+		if err != nil {
+			return err
+		}
+
+		var (
+			validator crypto.PublicKey
+			weight    uint64
+		)
+		err = proto.Parse(b, func(f proto.Field) (err error) {
+			switch f.Num {
+			case 1:
+				validator, err = f.Raw()
+			case 2:
+				weight, err = f.Uint64()
+			}
+			return err
+		})
+		s.Validators = append(s.Validators, validator)
+		s.Weights = append(s.Weights, weight)
+		return err
+	})
+
+	return s, err
+}
+
+func marshalLightHeaderProto(h *LightHeader) ([]byte, error) {
+	if h.Header == nil {
+		return nil, fmt.Errorf("light header without header: %w", ErrInvalidProof)
+	}
+
+	buf := &proto.Buffer{}
+	buf.Message(1, marshalSignedHeaderProto(h.SignedHeader))
+	if h.NextValidators != nil {
+		data, err := marshalValidatorSetProto(h.NextValidators)
+		if err != nil {
+			return nil, err
+		}
+		buf.Message(2, data)
+	}
+	if h.Commit != nil {
+		data, err := marshalCommitProto(h.Commit)
+		if err != nil {
+			return nil, err
+		}
+		buf.Message(3, data)
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalLightHeaderProto(data []byte, h *LightHeader) error {
+	*h = LightHeader{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			if b, err = f.Raw(); err == nil {
+				h.SignedHeader, err = unmarshalSignedHeaderProto(b)
+			}
+		case 2:
+			if b, err = f.Raw(); err == nil {
+				h.NextValidators, err = unmarshalValidatorSetProto(b)
+			}
+		case 3:
+			if b, err = f.Raw(); err == nil {
+				h.Commit = new(CommitCertificate)
+				err = unmarshalCommitProto(b, h.Commit)
+			}
+		}
+		return err
+	})
+	if err == nil && h.Header == nil {
+		err = fmt.Errorf("light header without header: %w", proto.ErrInvalidWire)
+	}
+
+	return err
+}
+
+func marshalTxProofProto(p *TxProof) ([]byte, error) {
+	if p.Header == nil || p.Proof == nil {
+		return nil, fmt.Errorf("tx proof without header or proof: %w", ErrInvalidProof)
+	}
+
+	proof := &proto.Buffer{}
+	proof.Uint64(1, uint64(p.Proof.Index))
+	proof.Uint64(2, uint64(p.Proof.Total))
+	for _, h := range p.Proof.Hashes {
+		proof.Bytes(3, h.ToSlice())
+	}
+
+	buf := &proto.Buffer{}
+	buf.Bytes(1, p.TxHash.ToSlice())
+	buf.Bytes(2, p.BlockHash.ToSlice())
+	buf.Message(3, marshalHeaderProto(p.Header))
+	buf.Message(4, proof.Result())
+
+	return buf.Result(), nil
+}
+
+func unmarshalMerkleProofProto(data []byte) (*MerkleProof, error) {
+	p := &MerkleProof{Hashes: []types.Hash{}}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			var v uint32
+			v, err = f.Uint32()
+			p.Index = int(v)
+		case 2:
+			var v uint32
+			v, err = f.Uint32()
+			p.Total = int(v)
+		case 3:
+			var h types.Hash
+			if h, err = protoHash(f); err == nil {
+				p.Hashes = append(p.Hashes, h)
+			}
+		}
+		return err
+	})
+
+	return p, err
+}
+
+func unmarshalTxProofProto(data []byte, p *TxProof) error {
+	*p = TxProof{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			p.TxHash, err = protoHash(f)
+		case 2:
+			p.BlockHash, err = protoHash(f)
+		case 3:
+			if b, err = f.Raw(); err == nil {
+				p.Header, err = unmarshalHeaderProto(b)
+			}
+		case 4:
+			if b, err = f.Raw(); err == nil {
+				p.Proof, err = unmarshalMerkleProofProto(b)
+			}
+		}
+		return err
+	})
+	if err == nil && (p.Header == nil || p.Proof == nil) {
+		err = fmt.Errorf("tx proof without header or proof: %w", proto.ErrInvalidWire)
+	}
+
+	return err
+}
+
+func marshalAccountProofProto(p *AccountProof) ([]byte, error) {
+	if p.Header == nil || p.Account == nil || p.Proof == nil {
+		return nil, fmt.Errorf("account proof without header, account or proof: %w", ErrInvalidProof)
+	}
+
+	account := &proto.Buffer{}
+	account.Bytes(1, p.Account.Address.ToSlice())
+	account.Uint64(2, p.Account.Balance)
+	account.Uint64(3, p.Account.Nonce)
+
+	buf := &proto.Buffer{}
+	buf.Message(1, marshalHeaderProto(p.Header))
+	buf.Message(2, account.Result())
+	for _, h := range p.Proof.Siblings {
+		buf.Bytes(3, h.ToSlice())
+	}
+
+	return buf.Result(), nil
+}
+
+func unmarshalAccountProto(data []byte) (*Account, error) {
+	acc := &Account{}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		switch f.Num {
+		case 1:
+			var b []byte
+			if b, err = f.Raw(); err == nil {
+				if len(b) != len(acc.Address) {
+					return fmt.Errorf("address of (%d) bytes: %w", len(b), proto.ErrInvalidWire)
+				}
+				copy(acc.Address[:], b)
+			}
+		case 2:
+			acc.Balance, err = f.Uint64()
+		case 3:
+			acc.Nonce, err = f.Uint64()
+		}
+		return err
+	})
+
+	return acc, err
+}
+
+func unmarshalAccountProofProto(data []byte, p *AccountProof) error {
+	*p = AccountProof{Proof: &SMTProof{Siblings: []types.Hash{}}}
+	err := proto.Parse(data, func(f proto.Field) (err error) {
+		var b []byte
+		switch f.Num {
+		case 1:
+			if b, err = f.Raw(); err == nil {
+				p.Header, err = unmarshalHeaderProto(b)
+			}
+		case 2:
+			if b, err = f.Raw(); err == nil {
+				p.Account, err = unmarshalAccountProto(b)
+			}
+		case 3:
+			var h types.Hash
+			if h, err = protoHash(f); err == nil {
+				p.Proof.Siblings = append(p.Proof.Siblings, h)
+			}
+		}
+		return err
+	})
+	if err == nil && (p.Header == nil || p.Account == nil) {
+		err = fmt.Errorf("account proof without header or account: %w", proto.ErrInvalidWire)
+	}
+
+	return err
+}
+
+// protoEncoder 把 marshal 的结果直接写入 writer
+type protoEncoder[T any] struct {
+	w       io.Writer
+	marshal func(T) ([]byte, error)
+}
+
+func (enc *protoEncoder[T]) Encode(v T) error {
+	data, err := enc.marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.w.Write(data)
+	return err
+}
+
+type protoDecoder[T any] struct {
+	r         io.Reader
+	unmarshal func([]byte, T) error
+}
+
+func (dec *protoDecoder[T]) Decode(v T) error {
+	data, err := readProto(dec.r)
+	if err != nil {
+		return err
+	}
+
+	return dec.unmarshal(data, v)
+}
+
+func NewProtoVoteEncoder(w io.Writer) Encoder[*Vote] {
+	return &protoEncoder[*Vote]{w: w, marshal: marshalVoteProto}
+}
+
+func NewProtoVoteDecoder(r io.Reader) Decoder[*Vote] {
+	return &protoDecoder[*Vote]{r: r, unmarshal: unmarshalVoteProto}
+}
+
+func NewProtoProposalEncoder(w io.Writer) Encoder[*Proposal] {
+	return &protoEncoder[*Proposal]{w: w, marshal: marshalProposalProto}
+}
+
+func NewProtoProposalDecoder(r io.Reader) Decoder[*Proposal] {
+	return &protoDecoder[*Proposal]{r: r, unmarshal: unmarshalProposalProto}
+}
+
+func NewProtoCommitEncoder(w io.Writer) Encoder[*CommitCertificate] {
+	return &protoEncoder[*CommitCertificate]{w: w, marshal: marshalCommitProto}
+}
+
+func NewProtoCommitDecoder(r io.Reader) Decoder[*CommitCertificate] {
+	return &protoDecoder[*CommitCertificate]{r: r, unmarshal: unmarshalCommitProto}
+}
+
+func NewProtoLightHeaderEncoder(w io.Writer) Encoder[*LightHeader] {
+	return &protoEncoder[*LightHeader]{w: w, marshal: marshalLightHeaderProto}
+}
+
+func NewProtoLightHeaderDecoder(r io.Reader) Decoder[*LightHeader] {
+	return &protoDecoder[*LightHeader]{r: r, unmarshal: unmarshalLightHeaderProto}
+}
+
+func NewProtoTxProofEncoder(w io.Writer) Encoder[*TxProof] {
+	return &protoEncoder[*TxProof]{w: w, marshal: marshalTxProofProto}
+}
+
+func NewProtoTxProofDecoder(r io.Reader) Decoder[*TxProof] {
+	return &protoDecoder[*TxProof]{r: r, unmarshal: unmarshalTxProofProto}
+}
+
+func NewProtoAccountProofEncoder(w io.Writer) Encoder[*AccountProof] {
+	return &protoEncoder[*AccountProof]{w: w, marshal: marshalAccountProofProto}
+}
+
+func NewProtoAccountProofDecoder(r io.Reader) Decoder[*AccountProof] {
+	return &protoDecoder[*AccountProof]{r: r, unmarshal: unmarshalAccountProofProto}
+}
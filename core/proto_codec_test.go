@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtoTxEncodeDecode(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	sig, err := privKey.Sign([]byte("collection"))
+	assert.Nil(t, err)
+	a, b := doubleSign(t, privKey, &Header{Version: 1, Height: 4, Timestamp: 100})
+
+	inners := []any{
+		nil,
+		CollectionTx{Fee: 10, MetaData: []byte("collection")},
+		MintTx{
+			Fee:             20,
+			NFT:             types.Hash{0x01},
+			Collection:      types.Hash{0x02},
+			MetaData:        []byte("mint"),
+			CollectionOwner: privKey.PublicKey(),
+			Signature:       *sig,
+		},
+		ValidatorProposalTx{Action: ValidatorRemove, Validator: privKey.PublicKey()},
+		ValidatorVoteTx{Proposal: types.Hash{0x03}, Approve: true},
+		StakeTx{Amount: 100},
+		UnstakeTx{Validator: privKey.PublicKey(), Amount: 50},
+		DelegateTx{Validator: privKey.PublicKey(), Amount: 25},
+		NewEvidence(SignedHeaderOf(a), SignedHeaderOf(b)),
+	}
+
+	for _, inner := range inners {
+		tx := NewTransaction([]byte("foo"))
+		tx.TxInner = inner
+		tx.Value = 5
+		tx.Nonce = 3
+		tx.Fee = 7
+		tx.ChainID = 9
+		assert.Nil(t, tx.Sign(privKey))
+		hash := tx.Hash(TxHasher{})
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, tx.Encode(NewProtoTxEncoder(buf)))
+
+		txDecoded := new(Transaction)
+		assert.Nil(t, txDecoded.Decode(NewProtoTxDecoder(buf)))
+		assert.Equal(t, inner, txDecoded.TxInner)
+		assert.Equal(t, hash, txDecoded.Hash(TxHasher{}))
+		assert.Nil(t, txDecoded.Verify(9))
+	}
+
+	tx := NewTransaction(nil)
+	tx.TxInner = "unknown"
+	assert.ErrorIs(t, tx.Encode(NewProtoTxEncoder(&bytes.Buffer{})), ErrUnknownTxInner)
+}
+
+func TestProtoBlockEncodeDecode(t *testing.T) {
+	b := randomBlock(t, 1, types.Hash{})
+	hash := b.Hash(BlockHasher{})
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewProtoBlockEncoder(buf)))
+	data := append([]byte(nil), buf.Bytes()...)
+
+	bDecode := new(Block)
+	assert.Nil(t, bDecode.Decode(NewProtoBlockDecoder(buf)))
+	assert.Equal(t, hash, bDecode.Hash(BlockHasher{}))
+	assert.Equal(t, b.Header, bDecode.Header)
+	assert.Equal(t, len(b.Transactions), len(bDecode.Transactions))
+	assert.Equal(t, b.Validator, bDecode.Validator)
+	assert.Equal(t, b.Signature, bDecode.Signature)
+	assert.Nil(t, bDecode.Verify())
+
+	// 截断的数据
+	assert.NotNil(t, new(Block).Decode(NewProtoBlockDecoder(bytes.NewReader(data[:len(data)-1]))))
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrBlockPruned = errors.New("block body pruned")
+
+// SetPruneDepth 设置之后只在内存中保留最近 depth 个规范链区块的区块体, 交易索引和撤销日志,
+// 更早的区块只保留区块头和签名, 不能再撤销到这些区块之前, depth 为 0 时保留全部区块
+// store 按自己的实现裁剪, 见 Storage.Prune
+func (bc *Blockchain) SetPruneDepth(depth uint32) {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.pruneDepth = depth
+	bc.prune()
+}
+
+func (bc *Blockchain) PruneDepth() uint32 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.pruneDepth
+}
+
+// RetainedRange 返回保留区块体的高度范围 [from, to], 没有裁剪时 from 为 0
+func (bc *Blockchain) RetainedRange() (uint32, uint32) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.retainFrom, uint32(len(bc.blocks) - 1)
+}
+
+// 裁剪超出 pruneDepth 的区块, 调用者需要持有 lock
+func (bc *Blockchain) prune() {
+	if bc.pruneDepth == 0 || uint32(len(bc.blocks)) <= bc.pruneDepth {
+		return
+	}
+
+	from := bc.retainFrom
+	to := uint32(len(bc.blocks)) - bc.pruneDepth
+	for height := from; height < to; height++ {
+		b := bc.blocks[height]
+		hash := b.Hash(BlockHasher{})
+
+		delete(bc.blockStore, hash)
+		delete(bc.journals, hash)
+		for _, tx := range b.Transactions {
+			delete(bc.txStore, tx.Hash(TxHasher{}))
+			delete(bc.txBlocks, tx.Hash(TxHasher{}))
+		}
+
+		// 区块树和规范链共用去掉交易的区块, isCanonical 仍然成立
+		stripped := &Block{Header: b.Header, Validator: b.Validator, Signature: b.Signature}
+		bc.blocks[height] = stripped
+		if node, ok := bc.nodes[hash]; ok {
+			node.block = stripped
+		}
+	}
+	bc.retainFrom = to
+
+	if err := bc.store.Prune(to); err != nil {
+		bc.logger.Log("msg", "failed to prune store", "height", to, "err", err)
+	}
+
+	bc.logger.Log("msg", "pruned block bodies", "from", from, "to", to-1)
+}
+
+// 撤销到 height 需要 height 之后所有区块的撤销日志
+func (bc *Blockchain) checkRevert(height uint32) error {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if height+1 < bc.retainFrom {
+		return fmt.Errorf("revert to height (%d) => retained height (%d): %w", height, bc.retainFrom, ErrBlockPruned)
+	}
+
+	return nil
+}
+
+// 节点与规范链的分叉点, 即节点在规范链上最近的祖先
+func (bc *Blockchain) forkPoint(node *blockNode) *blockNode {
+	for node != nil && !bc.isCanonical(node) {
+		node = node.parent
+	}
+
+	return node
+}
+
+// signedHeader 返回规范链上高度为 height 的区块头和签名, 区块体被裁剪之后仍然可用
+func (bc *Blockchain) signedHeader(height uint32) (SignedHeader, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if height >= uint32(len(bc.blocks)) {
+		return SignedHeader{}, fmt.Errorf("given height (%d) too high", height)
+	}
+
+	return SignedHeaderOf(bc.blocks[height]), nil
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneBlockBodies(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetPruneDepth(3)
+
+	blocks := []*Block{}
+	for i := 0; i < 6; i++ {
+		b := nextBlock(t, bc)
+		assert.Nil(t, bc.AddBlock(b))
+		blocks = append(blocks, b)
+	}
+
+	from, to := bc.RetainedRange()
+	assert.Equal(t, uint32(4), from)
+	assert.Equal(t, uint32(6), to)
+
+	_, err := bc.GetBlock(3)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	_, err = bc.GetBlockByHash(blocks[2].Hash(BlockHasher{}))
+	assert.ErrorIs(t, err, ErrBlockPruned)
+
+	b, err := bc.GetBlock(4)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[3], b)
+
+	// 默认的 MemoryStore 也丢弃被裁剪区块的交易和执行结果
+	stored, err := bc.store.GetByHeight(3)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[2].Header, stored.Header)
+	assert.Empty(t, stored.Transactions)
+	_, err = bc.store.GetReceipts(blocks[2].Hash(BlockHasher{}))
+	assert.NotNil(t, err)
+	stored, err = bc.store.GetByHeight(4)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[3], stored)
+
+	// 区块头和签名仍然保留
+	header, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[0].Header, header)
+	lh, err := bc.GetLightHeader(1)
+	assert.Nil(t, err)
+	assert.Nil(t, lh.Verify())
+
+	pruned := blocks[1].Transactions[0].Hash(TxHasher{})
+	_, err = bc.GetTxByHash(pruned)
+	assert.NotNil(t, err)
+	_, err = bc.GetTxProof(pruned)
+	assert.NotNil(t, err)
+	_, err = bc.GetReceipt(pruned)
+	assert.NotNil(t, err)
+
+	retained := blocks[4].Transactions[0].Hash(TxHasher{})
+	_, err = bc.GetTxByHash(retained)
+	assert.Nil(t, err)
+	_, err = bc.GetTxProof(retained)
+	assert.Nil(t, err)
+
+	// 被裁剪的区块没有撤销日志
+	assert.ErrorIs(t, bc.RevertTo(2), ErrBlockPruned)
+	assert.Nil(t, bc.RevertTo(3))
+	assert.Equal(t, uint32(3), bc.Height())
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+}
+
+func TestPruneRejectsDeepFork(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	// 已有的区块在设置之后立即被裁剪
+	bc.SetPruneDepth(2)
+	from, _ := bc.RetainedRange()
+	assert.Equal(t, uint32(4), from)
+	assert.Equal(t, uint32(2), bc.PruneDepth())
+
+	parent, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	signer := testValidator
+
+	b, err := NewBlockFromPrevHeader(parent, nil)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, bc.SealBlock(b, signer), ErrBlockPruned)
+
+	b.Timestamp = time.Now().UnixNano()
+	assert.Nil(t, b.Sign(signer))
+	assert.ErrorIs(t, bc.AddBlock(b), ErrBlockPruned)
+
+	// 在保留范围内分叉仍然可以
+	parent, err = bc.GetHeader(3)
+	assert.Nil(t, err)
+	b, err = NewBlockFromPrevHeader(parent, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, bc.SealBlock(b, signer))
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(5), bc.Height())
+}
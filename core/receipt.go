@@ -0,0 +1,171 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"project-bee/types"
+)
+
+var ErrContractPanic = errors.New("contract execution panicked")
+
+type ReceiptStatus byte
+
+const (
+	ReceiptFailed ReceiptStatus = iota
+	ReceiptSuccess
+)
+
+func (s ReceiptStatus) String() string {
+	if s == ReceiptSuccess {
+		return "success"
+	}
+
+	return "failed"
+}
+
+// ReceiptCode 是交易在哪一步执行失败, 参与 ReceiptsRoot 的计算
+type ReceiptCode byte
+
+const (
+	ReceiptCodeNone ReceiptCode = iota
+	ReceiptCodeContract
+	ReceiptCodeGovernance
+	ReceiptCodeStaking
+	ReceiptCodeEvidence
+	ReceiptCodeNFT
+	ReceiptCodeTransfer
+	ReceiptCodeUnknown
+)
+
+func (c ReceiptCode) String() string {
+	switch c {
+	case ReceiptCodeNone:
+		return "none"
+	case ReceiptCodeContract:
+		return "contract"
+	case ReceiptCodeGovernance:
+		return "governance"
+	case ReceiptCodeStaking:
+		return "staking"
+	case ReceiptCodeEvidence:
+		return "evidence"
+	case ReceiptCodeNFT:
+		return "nft"
+	case ReceiptCodeTransfer:
+		return "transfer"
+	default:
+		return "unknown"
+	}
+}
+
+// TxFailure 表示交易在 Code 对应的一步执行失败
+type TxFailure struct {
+	Code ReceiptCode
+	Err  error
+}
+
+func (e *TxFailure) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TxFailure) Unwrap() error {
+	return e.Err
+}
+
+func receiptCode(err error) ReceiptCode {
+	var failure *TxFailure
+	if errors.As(err, &failure) {
+		return failure.Code
+	}
+
+	return ReceiptCodeUnknown
+}
+
+// Receipt 记录区块中一笔交易的执行结果
+// 执行失败的交易仍然留在区块中, 但是它的状态修改全部被撤销
+type Receipt struct {
+	TxHash types.Hash
+	Status ReceiptStatus
+	Code   ReceiptCode
+	// Error 是失败原因的说明, 文字可能随实现变化, 不参与 ReceiptsRoot 的计算
+	Error       string
+	BlockHeight uint32
+	// 交易在区块中的位置
+	Index uint32
+	Fee   uint64
+	// 交易写入过的合约状态 key, 按第一次写入的顺序
+	StorageKeys [][]byte
+}
+
+// Bytes 是计算 ReceiptsRoot 时使用的编码
+func (r *Receipt) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(r.TxHash.ToSlice())
+	buf.WriteByte(byte(r.Status))
+	buf.WriteByte(byte(r.Code))
+	binary.Write(buf, binary.BigEndian, r.BlockHeight)
+	binary.Write(buf, binary.BigEndian, r.Index)
+	binary.Write(buf, binary.BigEndian, r.Fee)
+	binary.Write(buf, binary.BigEndian, uint32(len(r.StorageKeys)))
+	for _, k := range r.StorageKeys {
+		binary.Write(buf, binary.BigEndian, uint32(len(k)))
+		buf.Write(k)
+	}
+
+	return buf.Bytes()
+}
+
+func (r *Receipt) Hash() types.Hash {
+	return types.Hash(sha256.Sum256(r.Bytes()))
+}
+
+// CalculateReceiptsRoot 以执行结果的 hash 为叶子计算 Merkle 根
+func CalculateReceiptsRoot(receipts []*Receipt) types.Hash {
+	leaves := make([]types.Hash, len(receipts))
+	for i, r := range receipts {
+		leaves[i] = r.Hash()
+	}
+
+	return MerkleRoot(leaves)
+}
+
+// GetReceipts 返回规范链上区块中每笔交易的执行结果, 顺序与区块中的交易相同
+func (bc *Blockchain) GetReceipts(blockHash types.Hash) ([]*Receipt, error) {
+	bc.lock.RLock()
+	_, ok := bc.blockStore[blockHash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("block with hash (%s) not found", blockHash)
+	}
+
+	return bc.store.GetReceipts(blockHash)
+}
+
+// GetReceipt 返回规范链上交易的执行结果
+func (bc *Blockchain) GetReceipt(txHash types.Hash) (*Receipt, error) {
+	bc.lock.RLock()
+	blockHash, ok := bc.txBlocks[txHash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("could not find tx with hash (%s)", txHash)
+	}
+
+	receipts, err := bc.store.GetReceipts(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, receipt := range receipts {
+		if receipt.TxHash == txHash {
+			return receipt, nil
+		}
+	}
+
+	return nil, fmt.Errorf("receipt of tx (%s) not found in block (%s)", txHash, blockHash)
+}
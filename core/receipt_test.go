@@ -0,0 +1,115 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailedTxKeepsBlockBody(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 100
+
+	// 写入 FOO 之后执行 add, 栈上没有数据导致 VM panic
+	contractTx := NewTransaction([]byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f, 0x0b})
+	assert.Nil(t, contractTx.Sign(privKeyBob))
+
+	transferTx := NewTransaction(nil)
+	transferTx.To = privKeyAlice.PublicKey()
+	transferTx.Value = 40
+	transferTx.Nonce = 1
+	assert.Nil(t, transferTx.Sign(privKeyBob))
+
+	block := randomBlock(t, 1, bc.GenesisHash())
+	block.AddTransaction(contractTx)
+	block.AddTransaction(transferTx)
+	sealBlock(t, bc, block, testValidator)
+
+	var (
+		hash    = block.Hash(BlockHasher{})
+		txCount = len(block.Transactions)
+	)
+	assert.Nil(t, bc.AddBlock(block))
+
+	// 区块内容和 hash 不变
+	assert.Equal(t, txCount, len(block.Transactions))
+	block.hash = types.Hash{}
+	assert.Equal(t, hash, block.Hash(BlockHasher{}))
+	assert.Nil(t, block.Verify())
+
+	// 失败交易写入的合约状态被撤销
+	_, err := bc.contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+
+	receipt, err := bc.GetReceipt(contractTx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+	assert.Equal(t, ReceiptCodeContract, receipt.Code)
+	assert.Contains(t, receipt.Error, ErrContractPanic.Error())
+
+	// 失败原因的文字不参与 ReceiptsRoot 的计算
+	reworded := *receipt
+	reworded.Error = "reworded panic message"
+	assert.Equal(t, receipt.Hash(), reworded.Hash())
+	reworded.Code = ReceiptCodeTransfer
+	assert.NotEqual(t, receipt.Hash(), reworded.Hash())
+
+	receipt, err = bc.GetReceipt(transferTx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptSuccess, receipt.Status)
+
+	balance, err := bc.accountState.GetBalance(privKeyAlice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(40), balance)
+
+	receipts, err := bc.GetReceipts(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, txCount, len(receipts))
+}
+
+func TestReceiptFields(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	// 写入 FOO = 5
+	contractTx := NewTransaction([]byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f})
+	assert.Nil(t, contractTx.Sign(crypto.GeneratePrivateKey()))
+
+	block := randomBlock(t, 1, bc.GenesisHash())
+	block.AddTransaction(contractTx)
+	sealBlock(t, bc, block, testValidator)
+	assert.Nil(t, bc.AddBlock(block))
+
+	receipts, err := bc.GetReceipts(block.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, block.ReceiptsRoot, CalculateReceiptsRoot(receipts))
+
+	receipt, err := bc.GetReceipt(contractTx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptSuccess, receipt.Status)
+	assert.Equal(t, ReceiptCodeNone, receipt.Code)
+	assert.Equal(t, uint32(1), receipt.BlockHeight)
+	assert.Equal(t, uint32(len(block.Transactions)-1), receipt.Index)
+	assert.Equal(t, [][]byte{[]byte("FOO")}, receipt.StorageKeys)
+}
+
+func TestAddBlockInvalidReceiptsRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	signer := testValidator
+
+	block := randomBlock(t, 1, bc.GenesisHash())
+	sealBlock(t, bc, block, signer)
+
+	block.ReceiptsRoot = types.Hash{0x01}
+	block.hash = types.Hash{}
+	assert.Nil(t, block.Sign(signer))
+
+	assert.ErrorIs(t, bc.AddBlock(block), ErrInvalidReceipts)
+	assert.Equal(t, uint32(0), bc.Height())
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+var (
+	ErrInsufficientStake = errors.New("insufficient bonded stake")
+	ErrInvalidStake      = errors.New("invalid staking transaction")
+)
+
+// 创世配置没有指定解绑期时使用, 单位是区块数
+const DefaultUnbondingPeriod = 100
+
+// StakeTx 把发送者的 Amount 余额抵押在自己名下
+type StakeTx struct {
+	Amount uint64
+}
+
+// DelegateTx 把发送者的 Amount 余额委托给当前的验证者 Validator
+type DelegateTx struct {
+	Validator crypto.PublicKey
+	Amount    uint64
+}
+
+// UnstakeTx 解除发送者在 Validator 上的 Amount 抵押, 经过解绑期之后回到余额
+// Validator 为发送者自己时解除自己的抵押
+type UnstakeTx struct {
+	Validator crypto.PublicKey
+	Amount    uint64
+}
+
+// 委托者在验证者上的抵押, 自己的抵押 Delegator 与 Validator 相同
+type bondKey struct {
+	Validator types.Address
+	Delegator types.Address
+}
+
+// Unbonding 是解绑期中的抵押, 在 ReleaseHeight 的区块执行之后回到委托者的余额
+type Unbonding struct {
+	Validator     types.Address
+	Delegator     types.Address
+	Amount        uint64
+	ReleaseHeight uint32
+}
+
+type Delegation struct {
+	Delegator types.Address
+	Amount    uint64
+}
+
+// ValidatorStake 是验证者名下的全部抵押, Delegations 按委托者地址排序, 包括验证者自己
+type ValidatorStake struct {
+	Validator   types.Address
+	SelfStake   uint64
+	TotalStake  uint64
+	Delegations []Delegation
+}
+
+func (bc *Blockchain) UnbondingPeriod() uint32 {
+	return bc.unbondingPeriod
+}
+
+// ValidatorStake 返回验证者当前的抵押
+func (bc *Blockchain) ValidatorStake(validator types.Address) *ValidatorStake {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	stake := &ValidatorStake{
+		Validator:   validator,
+		Delegations: []Delegation{},
+	}
+	for key, amount := range bc.bonds {
+		if key.Validator != validator {
+			continue
+		}
+
+		stake.TotalStake += amount
+		if key.Delegator == validator {
+			stake.SelfStake = amount
+		}
+		stake.Delegations = append(stake.Delegations, Delegation{Delegator: key.Delegator, Amount: amount})
+	}
+
+	sort.Slice(stake.Delegations, func(i, j int) bool {
+		return bytes.Compare(stake.Delegations[i].Delegator[:], stake.Delegations[j].Delegator[:]) < 0
+	})
+
+	return stake
+}
+
+// Bonded 返回账户抵押中和解绑中的余额, 这部分不在 Account.Balance 中
+func (bc *Blockchain) Bonded(address types.Address) (bonded uint64, unbonding uint64) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	for key, amount := range bc.bonds {
+		if key.Delegator == address {
+			bonded += amount
+		}
+	}
+	for _, u := range bc.unbonding {
+		if u.Delegator == address {
+			unbonding += u.Amount
+		}
+	}
+
+	return bonded, unbonding
+}
+
+// 执行高度为 height 的区块中的抵押交易, 调用者需要持有 stateLock
+func (bc *Blockchain) handleStaking(tx *Transaction, height uint32) error {
+	hash := tx.Hash(TxHasher{})
+	from := tx.From.Address()
+
+	switch t := tx.TxInner.(type) {
+	case StakeTx:
+		return bc.bond(hash, bondKey{Validator: from, Delegator: from}, t.Amount)
+	case DelegateTx:
+		if !containsKey(bc.ValidatorsAt(height), t.Validator) {
+			return fmt.Errorf("tx (%s) delegates to (%s): %w", hash, t.Validator, ErrNotValidator)
+		}
+		return bc.bond(hash, bondKey{Validator: t.Validator.Address(), Delegator: from}, t.Amount)
+	case UnstakeTx:
+		key := bondKey{Validator: t.Validator.Address(), Delegator: from}
+		if t.Amount == 0 {
+			return fmt.Errorf("tx (%s) unstakes zero: %w", hash, ErrInvalidStake)
+		}
+		if bonded := bc.bonds[key]; bonded < t.Amount {
+			return fmt.Errorf("tx (%s) unstakes (%d) => bonded (%d): %w", hash, t.Amount, bonded, ErrInsufficientStake)
+		}
+
+		bc.setBond(key, bc.bonds[key]-t.Amount)
+		bc.setUnbonding(append(append([]Unbonding{}, bc.unbonding...), Unbonding{
+			Validator:     key.Validator,
+			Delegator:     key.Delegator,
+			Amount:        t.Amount,
+			ReleaseHeight: height + bc.unbondingPeriod,
+		}))
+
+		bc.logger.Log("msg", "unstake", "validator", key.Validator, "delegator", key.Delegator, "amount", t.Amount)
+	default:
+		return fmt.Errorf("unsupported tx type %v", t)
+	}
+
+	return nil
+}
+
+// 从委托者的余额中抵押 amount, 调用者需要持有 stateLock
+func (bc *Blockchain) bond(hash types.Hash, key bondKey, amount uint64) error {
+	if amount == 0 {
+		return fmt.Errorf("tx (%s) bonds zero: %w", hash, ErrInvalidStake)
+	}
+
+	bonded := bc.bonds[key] + amount
+	if bonded < amount {
+		return fmt.Errorf("tx (%s) bond overflow: %w", hash, ErrInvalidStake)
+	}
+
+	if err := bc.accountState.SubBalance(key.Delegator, amount); err != nil {
+		return fmt.Errorf("tx (%s) bonds (%d): %w", hash, amount, err)
+	}
+	bc.setBond(key, bonded)
+
+	bc.logger.Log("msg", "bond", "validator", key.Validator, "delegator", key.Delegator, "amount", amount)
+
+	return nil
+}
+
+// 把解绑期在 height 结束的抵押退回委托者的余额, 调用者需要持有 stateLock
+func (bc *Blockchain) releaseUnbonding(height uint32) error {
+	n := 0
+	for n < len(bc.unbonding) && bc.unbonding[n].ReleaseHeight <= height {
+		u := bc.unbonding[n]
+		if err := bc.accountState.AddBalance(u.Delegator, u.Amount); err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	bc.setUnbonding(append([]Unbonding{}, bc.unbonding[n:]...))
+
+	return nil
+}
+
+// 验证者的出块权重是名下的全部抵押, 没有抵押的验证者权重为 1
+func (bc *Blockchain) stakeWeights(validators []crypto.PublicKey) []uint64 {
+	index := make(map[types.Address]int, len(validators))
+	weights := make([]uint64, len(validators))
+	for i, v := range validators {
+		index[v.Address()] = i
+	}
+
+	for key, amount := range bc.bonds {
+		if i, ok := index[key.Validator]; ok {
+			weights[i] += amount
+		}
+	}
+
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+	}
+
+	return weights
+}
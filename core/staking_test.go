@@ -0,0 +1,113 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProposerSchedule(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2}, proposerSchedule([]uint64{1, 1, 1}))
+	assert.Equal(t, []int{0, 1, 0}, proposerSchedule([]uint64{2, 1}))
+	assert.Equal(t, []int{0, 1, 2, 0}, proposerSchedule([]uint64{2, 1, 1}))
+
+	// 总权重太大时按比例缩小
+	schedule := proposerSchedule([]uint64{3 << 40, 1 << 40})
+	assert.LessOrEqual(t, len(schedule), maxScheduleSlots)
+	counts := make([]int, 2)
+	for _, i := range schedule {
+		counts[i]++
+	}
+	assert.Equal(t, counts[0], 3*counts[1])
+}
+
+func TestStakeAndDelegate(t *testing.T) {
+	c := newGovernanceChain(t, 2, 1)
+	v0, v1, delegator := c.keys[0], c.keys[1], c.keys[2]
+
+	stake := c.tx(t, v0, StakeTx{Amount: 300})
+	delegate := c.tx(t, delegator, DelegateTx{Validator: v1.PublicKey(), Amount: 200})
+	notValidator := c.tx(t, v0, DelegateTx{Validator: delegator.PublicKey(), Amount: 10})
+	tooMuch := c.tx(t, delegator, DelegateTx{Validator: v1.PublicKey(), Amount: 5000})
+	c.addBlock(t, stake, delegate, notValidator, tooMuch)
+
+	for _, tx := range []*Transaction{notValidator, tooMuch} {
+		receipt, err := c.bc.GetReceipt(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, ReceiptFailed, receipt.Status)
+	}
+
+	account, err := c.bc.GetAccount(delegator.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(800), account.Balance)
+	bonded, unbonding := c.bc.Bonded(delegator.PublicKey().Address())
+	assert.Equal(t, uint64(200), bonded)
+	assert.Equal(t, uint64(0), unbonding)
+
+	vs := c.bc.ValidatorStake(v1.PublicKey().Address())
+	assert.Equal(t, uint64(0), vs.SelfStake)
+	assert.Equal(t, uint64(200), vs.TotalStake)
+	assert.Equal(t, []Delegation{{Delegator: delegator.PublicKey().Address(), Amount: 200}}, vs.Delegations)
+
+	vs = c.bc.ValidatorStake(v0.PublicKey().Address())
+	assert.Equal(t, uint64(300), vs.SelfStake)
+	assert.Equal(t, uint64(300), vs.TotalStake)
+
+	// 出块权重在下一个 epoch 生效
+	assert.Equal(t, []uint64{1, 1}, c.bc.validatorSetAt(3).weights)
+	c.addBlock(t)
+	c.addBlock(t)
+	assert.Equal(t, []uint64{300, 200}, c.bc.validatorSetAt(4).weights)
+
+	counts := make(map[string]int)
+	for round := uint32(0); round < 500; round++ {
+		proposer, ok := c.bc.ProposerAt(4, round)
+		assert.True(t, ok)
+		counts[proposer.String()]++
+	}
+	assert.Equal(t, 300, counts[v0.PublicKey().String()])
+	assert.Equal(t, 200, counts[v1.PublicKey().String()])
+}
+
+func TestUnstakeUnbondingPeriod(t *testing.T) {
+	c := newGovernanceChain(t, 1, 0)
+	v0 := c.keys[0]
+	address := v0.PublicKey().Address()
+
+	c.addBlock(t, c.tx(t, v0, StakeTx{Amount: 300}))
+
+	unstake := c.tx(t, v0, UnstakeTx{Validator: v0.PublicKey(), Amount: 100})
+	tooMuch := c.tx(t, v0, UnstakeTx{Validator: v0.PublicKey(), Amount: 201})
+	c.addBlock(t, unstake, tooMuch)
+
+	receipt, err := c.bc.GetReceipt(tooMuch.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+
+	bonded, unbonding := c.bc.Bonded(address)
+	assert.Equal(t, uint64(200), bonded)
+	assert.Equal(t, uint64(100), unbonding)
+
+	// 高度 2 解除, 解绑期 3 个区块, 高度 5 执行之后回到余额
+	for c.bc.Height() < 4 {
+		c.addBlock(t)
+	}
+	account, err := c.bc.GetAccount(address)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(700), account.Balance)
+
+	c.addBlock(t)
+	account, err = c.bc.GetAccount(address)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(800), account.Balance)
+	_, unbonding = c.bc.Bonded(address)
+	assert.Equal(t, uint64(0), unbonding)
+
+	// 撤销恢复抵押之前的状态
+	assert.Nil(t, c.bc.RevertTo(0))
+	account, err = c.bc.GetAccount(address)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), account.Balance)
+	bonded, _ = c.bc.Bonded(address)
+	assert.Equal(t, uint64(0), bonded)
+}
@@ -4,6 +4,8 @@ import "fmt"
 
 type State struct {
 	data map[string][]byte
+	// 不为 nil 时记录每次写入之前的值
+	journal *journal
 }
 
 func NewState() *State {
@@ -13,17 +15,32 @@ func NewState() *State {
 }
 
 func (s *State) Put(k, v []byte) error {
-	s.data[string(k)] = v
+	s.set(k, v)
 
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
-	delete(s.data, string(k))
-	
+	s.set(k, nil)
+
 	return nil
 }
 
+// set 写入 key, v 为 nil 时删除, 有 journal 时记录之前的值
+func (s *State) set(k, v []byte) {
+	key := string(k)
+
+	if s.journal != nil {
+		s.journal.append(storageChange{key: []byte(key), prev: s.data[key]})
+	}
+
+	if v == nil {
+		delete(s.data, key)
+		return
+	}
+	s.data[key] = v
+}
+
 func (s *State) Get(k []byte) ([]byte, error) {
 	key := string(k)
 	value, ok := s.data[string(key)]
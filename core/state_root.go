@@ -0,0 +1,305 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+// 状态树中不同种类状态的 key 使用不同前缀, 所有状态共用一个根
+func stateKey(prefix string, k []byte) types.Hash {
+	buf := make([]byte, 0, len(prefix)+len(k))
+	buf = append(buf, prefix...)
+	buf = append(buf, k...)
+
+	return types.Hash(sha256.Sum256(buf))
+}
+
+func accountKey(address types.Address) types.Hash {
+	return stateKey("account", address.ToSlice())
+}
+
+func contractKey(k []byte) types.Hash {
+	return stateKey("contract", k)
+}
+
+func collectionKey(hash types.Hash) types.Hash {
+	return stateKey("collection", hash.ToSlice())
+}
+
+func mintKey(hash types.Hash) types.Hash {
+	return stateKey("mint", hash.ToSlice())
+}
+
+func proposalKey(hash types.Hash) types.Hash {
+	return stateKey("proposal", hash.ToSlice())
+}
+
+func validatorSetKey(height uint32) types.Hash {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, height)
+
+	return stateKey("validators", k)
+}
+
+func bondKeyHash(key bondKey) types.Hash {
+	return stateKey("bond", append(key.Validator.ToSlice(), key.Delegator.ToSlice()...))
+}
+
+func unbondingKey() types.Hash {
+	return stateKey("unbonding", nil)
+}
+
+func slashKeyHash(key slashKey) types.Hash {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, key.Height)
+
+	return stateKey("slashed", append(key.Validator.ToSlice(), k...))
+}
+
+func supplyKey() types.Hash {
+	return stateKey("supply", nil)
+}
+
+func (a *Account) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(a.Address.ToSlice())
+	binary.Write(buf, binary.BigEndian, a.Balance)
+	binary.Write(buf, binary.BigEndian, a.Nonce)
+
+	return buf.Bytes()
+}
+
+func (c *CollectionTx) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, c.Fee)
+	buf.Write(c.MetaData)
+
+	return buf.Bytes()
+}
+
+func (m *MintTx) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, m.Fee)
+	buf.Write(m.NFT.ToSlice())
+	buf.Write(m.Collection.ToSlice())
+	binary.Write(buf, binary.BigEndian, uint32(len(m.MetaData)))
+	buf.Write(m.MetaData)
+	binary.Write(buf, binary.BigEndian, uint32(len(m.CollectionOwner)))
+	buf.Write(m.CollectionOwner)
+
+	// 零值签名编码为两个空的大整数
+	for _, n := range []*big.Int{m.Signature.R, m.Signature.S} {
+		var b []byte
+		if n != nil {
+			b = n.Bytes()
+		}
+		binary.Write(buf, binary.BigEndian, uint32(len(b)))
+		buf.Write(b)
+	}
+
+	return buf.Bytes()
+}
+
+func writeKeys(buf *bytes.Buffer, keys []crypto.PublicKey) {
+	binary.Write(buf, binary.BigEndian, uint32(len(keys)))
+	for _, k := range keys {
+		binary.Write(buf, binary.BigEndian, uint32(len(k)))
+		buf.Write(k)
+	}
+}
+
+func (p *ValidatorProposal) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(p.Action))
+	writeKeys(buf, []crypto.PublicKey{p.Validator, p.Proposer})
+	binary.Write(buf, binary.BigEndian, p.Height)
+	writeKeys(buf, p.Approvals)
+	writeKeys(buf, p.Rejections)
+	buf.WriteByte(byte(p.Status))
+	binary.Write(buf, binary.BigEndian, p.EffectiveHeight)
+	binary.Write(buf, binary.BigEndian, p.ExpiryHeight)
+
+	return buf.Bytes()
+}
+
+func (s validatorSet) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	writeKeys(buf, s.validators)
+	for _, w := range s.weights {
+		binary.Write(buf, binary.BigEndian, w)
+	}
+
+	return buf.Bytes()
+}
+
+func (s validatorSet) Hash() types.Hash {
+	return types.Hash(sha256.Sum256(s.Bytes()))
+}
+
+// rebuildTrie 由账户, 合约状态, NFT 状态, 验证者治理和抵押状态重新构建状态树,
+// 只在写入初始状态之后调用, 之后状态树由 journal 记录的修改增量更新, 调用者需要持有 stateLock
+func (bc *Blockchain) rebuildTrie() {
+	bc.trie = NewSparseMerkleTree()
+
+	bc.accountState.mu.RLock()
+	addresses := make([]types.Address, 0, len(bc.accountState.accounts))
+	for addr := range bc.accountState.accounts {
+		addresses = append(addresses, addr)
+	}
+	bc.accountState.mu.RUnlock()
+	for _, addr := range addresses {
+		bc.updateAccountLeaf(addr)
+	}
+
+	for k := range bc.contractState.data {
+		bc.updateContractLeaf([]byte(k))
+	}
+
+	for hash := range bc.collectionState {
+		bc.updateCollectionLeaf(hash)
+	}
+
+	for hash := range bc.mintState {
+		bc.updateMintLeaf(hash)
+	}
+
+	for hash := range bc.proposals {
+		bc.updateProposalLeaf(hash)
+	}
+
+	bc.updateValidatorSetLeaves(bc.validatorSets)
+
+	for key := range bc.bonds {
+		bc.updateBondLeaf(key)
+	}
+
+	bc.updateUnbondingLeaf()
+
+	for key := range bc.slashed {
+		bc.updateSlashLeaf(key)
+	}
+
+	bc.updateSupplyLeaf()
+}
+
+// 以下方法把一个 key 的当前状态写入状态树, 状态不存在时删除, 调用者需要持有 stateLock
+
+func (bc *Blockchain) updateAccountLeaf(address types.Address) {
+	bc.accountState.mu.RLock()
+	acc, ok := bc.accountState.accounts[address]
+	bc.accountState.mu.RUnlock()
+
+	if !ok {
+		bc.trie.Update(accountKey(address), nil)
+		return
+	}
+	bc.trie.Update(accountKey(address), acc.Bytes())
+}
+
+func (bc *Blockchain) updateContractLeaf(k []byte) {
+	v, ok := bc.contractState.data[string(k)]
+	if !ok {
+		bc.trie.Update(contractKey(k), nil)
+		return
+	}
+	bc.trie.Update(contractKey(k), v)
+}
+
+func (bc *Blockchain) updateCollectionLeaf(hash types.Hash) {
+	c, ok := bc.collectionState[hash]
+	if !ok {
+		bc.trie.Update(collectionKey(hash), nil)
+		return
+	}
+	bc.trie.Update(collectionKey(hash), c.Bytes())
+}
+
+func (bc *Blockchain) updateMintLeaf(hash types.Hash) {
+	m, ok := bc.mintState[hash]
+	if !ok {
+		bc.trie.Update(mintKey(hash), nil)
+		return
+	}
+	bc.trie.Update(mintKey(hash), m.Bytes())
+}
+
+func (bc *Blockchain) updateProposalLeaf(hash types.Hash) {
+	p, ok := bc.proposals[hash]
+	if !ok {
+		bc.trie.Update(proposalKey(hash), nil)
+		return
+	}
+	bc.trie.Update(proposalKey(hash), p.Bytes())
+}
+
+// sets 中每个集合的高度在当前集合历史中的状态
+func (bc *Blockchain) updateValidatorSetLeaves(sets []validatorSet) {
+	bc.validatorLock.RLock()
+	defer bc.validatorLock.RUnlock()
+
+	for _, s := range sets {
+		var value []byte
+		for _, current := range bc.validatorSets {
+			if current.height == s.height {
+				value = current.Bytes()
+				break
+			}
+		}
+		bc.trie.Update(validatorSetKey(s.height), value)
+	}
+}
+
+func (bc *Blockchain) updateBondLeaf(key bondKey) {
+	amount, ok := bc.bonds[key]
+	if !ok {
+		bc.trie.Update(bondKeyHash(key), nil)
+		return
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, amount)
+	bc.trie.Update(bondKeyHash(key), b)
+}
+
+func (bc *Blockchain) updateUnbondingLeaf() {
+	if len(bc.unbonding) == 0 {
+		bc.trie.Update(unbondingKey(), nil)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	for _, u := range bc.unbonding {
+		buf.Write(u.Validator.ToSlice())
+		buf.Write(u.Delegator.ToSlice())
+		binary.Write(buf, binary.BigEndian, u.Amount)
+		binary.Write(buf, binary.BigEndian, u.ReleaseHeight)
+	}
+	bc.trie.Update(unbondingKey(), buf.Bytes())
+}
+
+func (bc *Blockchain) updateSlashLeaf(key slashKey) {
+	if !bc.slashed[key] {
+		bc.trie.Update(slashKeyHash(key), nil)
+		return
+	}
+	bc.trie.Update(slashKeyHash(key), []byte{1})
+}
+
+func (bc *Blockchain) updateSupplyLeaf() {
+	supply := make([]byte, 8)
+	binary.BigEndian.PutUint64(supply, bc.supply)
+	bc.trie.Update(supplyKey(), supply)
+}
+
+// StateRoot 返回当前状态树的根
+func (bc *Blockchain) StateRoot() types.Hash {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.trie.Root()
+}
@@ -1,18 +1,195 @@
 package core
 
-import ()
+import (
+	"fmt"
+	"sync"
 
+	"project-bee/types"
+)
+
+// Storage 持久化规范链上的区块
+// Put 写入高度 h 的区块后, h 即为存储中的最高区块, 高于 h 的高度索引会被丢弃
 type Storage interface {
 	Put(*Block) error
+	Get(types.Hash) (*Block, error)
+	GetByHeight(uint32) (*Block, error)
+	Has(types.Hash) bool
+	// Iterate 按高度从低到高遍历规范链, fn 返回错误时停止遍历
+	Iterate(fn func(*Block) error) error
+	// Truncate 丢弃高于 height 的区块, 之后不能再按 hash 查询
+	Truncate(height uint32) error
+	// PutReceipts 保存区块中交易的执行结果, 按区块 hash 查询
+	PutReceipts(types.Hash, []*Receipt) error
+	GetReceipts(types.Hash) ([]*Receipt, error)
+	// PutCommit 保存区块的最终性证书, 按区块 hash 查询
+	PutCommit(types.Hash, *CommitCertificate) error
+	GetCommit(types.Hash) (*CommitCertificate, error)
+	// Prune 丢弃低于 height 的区块的交易和执行结果, 区块头和签名仍然可以查询,
+	// 重启时需要用区块重建状态的实现可以保留区块体
+	Prune(height uint32) error
 }
 
 type MemoryStore struct {
+	lock     sync.RWMutex
+	heights  []*Block
+	blocks   map[types.Hash]*Block
+	receipts map[types.Hash][]*Receipt
+	commits  map[types.Hash]*CommitCertificate
 }
 
 func NewMemorystore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		heights:  []*Block{},
+		blocks:   make(map[types.Hash]*Block),
+		receipts: make(map[types.Hash][]*Receipt),
+		commits:  make(map[types.Hash]*CommitCertificate),
+	}
 }
 
 func (s *MemoryStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(b.Height) > len(s.heights) {
+		return fmt.Errorf("cannot store block with height (%d) => stored height (%d)", b.Height, len(s.heights)-1)
+	}
+
+	// 同一高度的新区块替换旧的区块
+	s.truncate(b.Height)
+	s.heights = append(s.heights, b)
+	s.blocks[b.Hash(BlockHasher{})] = b
+
+	return nil
+}
+
+func (s *MemoryStore) Truncate(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.truncate(height + 1)
+
+	return nil
+}
+
+func (s *MemoryStore) truncate(height uint32) {
+	if int(height) >= len(s.heights) {
+		return
+	}
+
+	for _, b := range s.heights[height:] {
+		delete(s.blocks, b.Hash(BlockHasher{}))
+	}
+	s.heights = s.heights[:height]
+}
+
+// 内存中的区块不会用来在重启时重建状态, 裁剪之后直接丢弃区块体
+func (s *MemoryStore) Prune(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(height) > len(s.heights) {
+		height = uint32(len(s.heights))
+	}
+
+	for i, b := range s.heights[:height] {
+		if len(b.Transactions) == 0 {
+			continue
+		}
+
+		hash := b.Hash(BlockHasher{})
+		stripped := &Block{Header: b.Header, Validator: b.Validator, Signature: b.Signature}
+		s.heights[i] = stripped
+		s.blocks[hash] = stripped
+		delete(s.receipts, hash)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) PutReceipts(hash types.Hash, receipts []*Receipt) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.receipts[hash] = receipts
+
+	return nil
+}
+
+func (s *MemoryStore) GetReceipts(hash types.Hash) ([]*Receipt, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	receipts, ok := s.receipts[hash]
+	if !ok {
+		return nil, fmt.Errorf("receipts of block (%s) not found", hash)
+	}
+
+	return receipts, nil
+}
+
+func (s *MemoryStore) PutCommit(hash types.Hash, c *CommitCertificate) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.commits[hash] = c
+
+	return nil
+}
+
+func (s *MemoryStore) GetCommit(hash types.Hash) (*CommitCertificate, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	c, ok := s.commits[hash]
+	if !ok {
+		return nil, fmt.Errorf("commit of block (%s) not found", hash)
+	}
+
+	return c, nil
+}
+
+func (s *MemoryStore) Get(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	b, ok := s.blocks[hash]
+	if !ok {
+		return nil, fmt.Errorf("block with hash (%s) not found", hash)
+	}
+
+	return b, nil
+}
+
+func (s *MemoryStore) GetByHeight(height uint32) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.heights) {
+		return nil, fmt.Errorf("block with height (%d) not found", height)
+	}
+
+	return s.heights[height], nil
+}
+
+func (s *MemoryStore) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.blocks[hash]
+	return ok
+}
+
+func (s *MemoryStore) Iterate(fn func(*Block) error) error {
+	s.lock.RLock()
+	blocks := make([]*Block, len(s.heights))
+	copy(blocks, s.heights)
+	s.lock.RUnlock()
+
+	for _, b := range blocks {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorePutGet(t *testing.T) {
+	testStorePutGet(t, NewMemorystore())
+}
+
+func TestMemoryStoreTruncate(t *testing.T) {
+	s := NewMemorystore()
+	blocks := putRandomBlocks(t, s, 5)
+	assert.Nil(t, s.Truncate(2))
+
+	_, err := s.GetByHeight(3)
+	assert.NotNil(t, err)
+	assert.False(t, s.Has(blocks[3].Hash(BlockHasher{})))
+	assert.True(t, s.Has(blocks[2].Hash(BlockHasher{})))
+}
+
+func TestDiskStorePutGet(t *testing.T) {
+	s, err := NewDiskStore(t.TempDir())
+	assert.Nil(t, err)
+	defer s.Close()
+
+	testStorePutGet(t, s)
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir)
+	assert.Nil(t, err)
+
+	blocks := putRandomBlocks(t, s, 10)
+	assert.Nil(t, s.Close())
+
+	s, err = NewDiskStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	for _, b := range blocks {
+		assert.True(t, s.Has(b.Hash(BlockHasher{})))
+
+		fetched, err := s.GetByHeight(b.Height)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+	}
+}
+
+func TestDiskStoreRecoverIndex(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir)
+	assert.Nil(t, err)
+
+	blocks := putRandomBlocks(t, s, 5)
+	// 模拟区块写入后索引写入前崩溃
+	assert.Nil(t, s.indexFile.Truncate(2*indexRecordSize))
+	assert.Nil(t, s.Close())
+
+	s, err = NewDiskStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	for _, b := range blocks {
+		fetched, err := s.GetByHeight(b.Height)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+	}
+}
+
+func TestDiskStoreTruncate(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir)
+	assert.Nil(t, err)
+
+	blocks := putRandomBlocks(t, s, 5)
+	assert.Nil(t, s.Truncate(2))
+	_, err = s.GetByHeight(3)
+	assert.NotNil(t, err)
+	assert.Nil(t, s.Close())
+
+	s, err = NewDiskStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	_, err = s.GetByHeight(3)
+	assert.NotNil(t, err)
+	// 被截断的区块不能再按 hash 查询
+	assert.False(t, s.Has(blocks[4].Hash(BlockHasher{})))
+	_, err = s.Get(blocks[3].Hash(BlockHasher{}))
+	assert.NotNil(t, err)
+	assert.True(t, s.Has(blocks[2].Hash(BlockHasher{})))
+
+	b := randomBlock(t, 3, blocks[2].Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(b))
+	fetched, err := s.GetByHeight(3)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+
+	// 替换同一高度的区块
+	replaced := randomBlock(t, 3, blocks[2].Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(replaced))
+	assert.False(t, s.Has(b.Hash(BlockHasher{})))
+	assert.True(t, s.Has(replaced.Hash(BlockHasher{})))
+}
+
+func TestDiskStoreReceipts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir)
+	assert.Nil(t, err)
+
+	receipts := []*Receipt{
+		{TxHash: types.Hash{0x01}, Status: ReceiptSuccess, StorageKeys: [][]byte{[]byte("foo")}},
+		{TxHash: types.Hash{0x02}, Status: ReceiptFailed, Error: "failed", Index: 1},
+	}
+	assert.Nil(t, s.PutReceipts(types.Hash{0xaa}, receipts))
+	assert.Nil(t, s.PutReceipts(types.Hash{0xbb}, []*Receipt{}))
+	assert.Nil(t, s.Close())
+
+	s, err = NewDiskStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	fetched, err := s.GetReceipts(types.Hash{0xaa})
+	assert.Nil(t, err)
+	assert.Equal(t, receipts, fetched)
+
+	fetched, err = s.GetReceipts(types.Hash{0xbb})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(fetched))
+
+	_, err = s.GetReceipts(types.Hash{0xcc})
+	assert.NotNil(t, err)
+}
+
+func TestBlockchainRestoreFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskStore(dir)
+	assert.Nil(t, err)
+
+	genesis := &Genesis{Validators: []string{testValidator.PublicKey().String()}}
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+
+	for i := 1; i <= 10; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+	assert.Nil(t, store.Close())
+
+	store, err = NewDiskStore(dir)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	restored, err := NewBlockchainFromGenesis(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Height(), restored.Height())
+
+	for i := uint32(0); i <= bc.Height(); i++ {
+		b, err := bc.GetBlock(i)
+		assert.Nil(t, err)
+
+		fetched, err := restored.GetBlockByHash(b.Hash(BlockHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, b.Height, fetched.Height)
+
+		for _, tx := range b.Transactions {
+			_, err := restored.GetTxByHash(tx.Hash(TxHasher{}))
+			assert.Nil(t, err)
+		}
+	}
+
+	_, err = NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.NotNil(t, err)
+}
+
+func testStorePutGet(t *testing.T, s Storage) {
+	blocks := putRandomBlocks(t, s, 10)
+
+	for _, b := range blocks {
+		fetched, err := s.Get(b.Hash(BlockHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+
+		fetched, err = s.GetByHeight(b.Height)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+	}
+
+	heights := []uint32{}
+	assert.Nil(t, s.Iterate(func(b *Block) error {
+		heights = append(heights, b.Height)
+		return nil
+	}))
+	assert.Equal(t, len(blocks), len(heights))
+
+	// 高度超过存储高度 + 1
+	assert.NotNil(t, s.Put(randomBlock(t, 100, types.Hash{})))
+
+	// 在已有高度写入, 更高的高度被丢弃
+	assert.Nil(t, s.Put(randomBlock(t, 5, types.Hash{})))
+	_, err := s.GetByHeight(6)
+	assert.NotNil(t, err)
+	assert.False(t, s.Has(blocks[9].Hash(BlockHasher{})))
+	assert.False(t, s.Has(blocks[5].Hash(BlockHasher{})))
+}
+
+func putRandomBlocks(t *testing.T, s Storage, n int) []*Block {
+	blocks := []*Block{}
+	prevHash := types.Hash{}
+
+	for i := 0; i < n; i++ {
+		b := randomBlock(t, uint32(i), prevHash)
+		assert.Nil(t, s.Put(b))
+
+		prevHash = b.Hash(BlockHasher{})
+		blocks = append(blocks, b)
+	}
+
+	return blocks
+}
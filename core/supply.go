@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"project-bee/types"
+)
+
+var ErrMaxSupply = errors.New("max supply exceeded")
+
+// mint 发行新的代币, 总量不能超过 maxSupply, 调用者需要持有 stateLock
+func (bc *Blockchain) mint(address types.Address, amount uint64) error {
+	supply := bc.supply + amount
+	if supply < bc.supply {
+		return fmt.Errorf("supply overflow: %w", ErrMaxSupply)
+	}
+	if bc.maxSupply > 0 && supply > bc.maxSupply {
+		return fmt.Errorf("minting (%d) on supply (%d) => max supply (%d): %w", amount, bc.supply, bc.maxSupply, ErrMaxSupply)
+	}
+
+	if err := bc.accountState.AddBalance(address, amount); err != nil {
+		return err
+	}
+	bc.setSupply(supply)
+
+	return nil
+}
+
+// 把出块奖励铸造给区块的验证者, 接近上限时只发行剩余的部分
+func (bc *Blockchain) mintBlockReward(b *Block) error {
+	reward := bc.blockReward
+	if b.Height == 0 || reward == 0 {
+		return nil
+	}
+
+	if bc.maxSupply > 0 && bc.maxSupply-bc.supply < reward {
+		reward = bc.maxSupply - bc.supply
+	}
+	if reward == 0 {
+		return nil
+	}
+
+	return bc.mint(b.Validator.Address(), reward)
+}
+
+// Supply 返回已经发行的代币总量
+func (bc *Blockchain) Supply() uint64 {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.supply
+}
+
+func (bc *Blockchain) MaxSupply() uint64 {
+	return bc.maxSupply
+}
+
+func (bc *Blockchain) BlockReward() uint64 {
+	return bc.blockReward
+}
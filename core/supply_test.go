@@ -0,0 +1,60 @@
+package core
+
+import (
+	"testing"
+
+	"project-bee/crypto"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockRewardMaxSupply(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	g := testGenesis(validator.PublicKey())
+	g.BlockReward = 10
+	g.MaxSupply = 1025
+
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), g)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), bc.Supply())
+
+	// 第三个区块只发行剩余的 5, 之后不再发行
+	for _, supply := range []uint64{1010, 1020, 1025, 1025} {
+		b := nextBlock(t, bc)
+		sealBlock(t, bc, b, validator)
+		assert.Nil(t, bc.AddBlock(b))
+		assert.Equal(t, supply, bc.Supply())
+	}
+
+	balance, err := bc.accountState.GetBalance(validator.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1025), balance)
+
+	assert.Nil(t, bc.RevertTo(1))
+	assert.Equal(t, uint64(1010), bc.Supply())
+}
+
+func TestGenesisAllocExceedsMaxSupply(t *testing.T) {
+	g := testGenesis(crypto.GeneratePrivateKey().PublicKey())
+	g.MaxSupply = 999
+
+	assert.ErrorIs(t, g.Validate(), ErrMaxSupply)
+
+	_, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemorystore(), g)
+	assert.ErrorIs(t, err, ErrMaxSupply)
+}
+
+func TestMintMaxSupply(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.maxSupply = 100
+
+	address := crypto.GeneratePrivateKey().PublicKey().Address()
+	assert.Nil(t, bc.mint(address, 100))
+	assert.ErrorIs(t, bc.mint(address, 1), ErrMaxSupply)
+
+	balance, err := bc.accountState.GetBalance(address)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+	assert.Equal(t, uint64(100), bc.Supply())
+}
@@ -3,7 +3,6 @@ package core
 import (
 	"encoding/gob"
 	"fmt"
-	"math/rand"
 
 	"project-bee/crypto"
 	"project-bee/types"
@@ -12,13 +11,19 @@ import (
 type TxType byte
 
 const (
-	TxTypeCollection TxType = iota // 0x0
-	TxTypeMint                     // 0x01
+	TxTypeCollection        TxType = iota // 0x0
+	TxTypeMint                            // 0x01
+	TxTypeValidatorProposal               // 0x02
+	TxTypeValidatorVote                   // 0x03
+	TxTypeStake                           // 0x04
+	TxTypeUnstake                         // 0x05
+	TxTypeDelegate                        // 0x06
+	TxTypeEvidence                        // 0x07
 )
 
 type CollectionTx struct {
-	Fee        int64
-	MetaData   []byte
+	Fee      int64
+	MetaData []byte
 }
 
 type MintTx struct {
@@ -31,13 +36,18 @@ type MintTx struct {
 }
 
 type Transaction struct {
+	// ChainID 参与签名, 一条链上签名的交易在其他链上无效
+	ChainID   uint32
 	TxInner   any
 	Data      []byte
 	To        crypto.PublicKey
 	Value     uint64
 	From      crypto.PublicKey
 	Signature *crypto.Signature
-	Nonce     int64
+	// Nonce 必须等于发送者账户的下一个 nonce, 同一个 nonce 只能执行一次
+	Nonce uint64
+	// Fee 支付给打包区块的验证者, 交易执行失败时同样收取
+	Fee uint64
 
 	// cached version of the tx data hash
 	hash types.Hash
@@ -45,8 +55,7 @@ type Transaction struct {
 
 func NewTransaction(data []byte) *Transaction {
 	return &Transaction{
-		Data:  data,
-		Nonce: rand.Int63n(1000000000000000),
+		Data: data,
 	}
 }
 
@@ -59,31 +68,61 @@ func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 }
 
 func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
+	// From 参与 hash, 必须在计算 hash 之前设置
+	tx.From = privKey.PublicKey()
+	tx.hash = types.Hash{}
+
 	hash := tx.Hash(TxHasher{})
-	sig, err := privKey.Sign(hash.ToSlice())
+	sig, err := privKey.Sign(signingHash(txSigningDomain, hash))
 	if err != nil {
 		return err
 	}
 
-	tx.From = privKey.PublicKey()
 	tx.Signature = sig
 
 	return nil
 }
 
-func (tx *Transaction) Verify() error {
+// Verify 校验交易签名, 以及交易属于 chainID 这条链
+func (tx *Transaction) Verify(chainID uint32) error {
 	if tx.Signature == nil {
 		return fmt.Errorf("transaction has no signature")
 	}
 
 	hash := tx.Hash(TxHasher{})
-	if !tx.Signature.Verify(tx.From, hash.ToSlice()) {
+	if tx.ChainID != chainID {
+		return fmt.Errorf("tx (%s) with chain id (%d) => chain id (%d): %w", hash, tx.ChainID, chainID, ErrInvalidChainID)
+	}
+
+	if !tx.Signature.Verify(tx.From, signingHash(txSigningDomain, hash)) {
 		return fmt.Errorf("invalid transaction signature")
 	}
 
 	return nil
 }
 
+// TotalFee 是交易需要支付的全部手续费, 包括 NFT 交易自带的 Fee
+func (tx *Transaction) TotalFee() (uint64, error) {
+	var inner int64
+	switch t := tx.TxInner.(type) {
+	case CollectionTx:
+		inner = t.Fee
+	case MintTx:
+		inner = t.Fee
+	}
+
+	if inner < 0 {
+		return 0, fmt.Errorf("negative fee (%d)", inner)
+	}
+
+	fee := tx.Fee + uint64(inner)
+	if fee < tx.Fee {
+		return 0, fmt.Errorf("fee overflow")
+	}
+
+	return fee, nil
+}
+
 func (tx *Transaction) Decode(dec Decoder[*Transaction]) error {
 	return dec.Decode(tx)
 }
@@ -95,4 +134,10 @@ func (tx *Transaction) Encode(enc Encoder[*Transaction]) error {
 func init() {
 	gob.Register(CollectionTx{})
 	gob.Register(MintTx{})
+	gob.Register(ValidatorProposalTx{})
+	gob.Register(ValidatorVoteTx{})
+	gob.Register(StakeTx{})
+	gob.Register(UnstakeTx{})
+	gob.Register(DelegateTx{})
+	gob.Register(EvidenceTx{})
 }
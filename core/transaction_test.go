@@ -27,7 +27,7 @@ func TestVerifyTransactionWithTamper(t *testing.T) {
 
 	tx.To = hackerPrivKey.PublicKey()
 
-	assert.NotNil(t, tx.Verify())
+	assert.NotNil(t, tx.Verify(0))
 }
 
 func TestNFTTransaction(t *testing.T) {
@@ -80,22 +80,22 @@ func TestVerifyTransaction(t *testing.T) {
 	}
 
 	assert.Nil(t, tx.Sign(privKey))
-	assert.Nil(t, tx.Verify())
+	assert.Nil(t, tx.Verify(0))
 
 	otherPrivKey := crypto.GeneratePrivateKey()
 	tx.From = otherPrivKey.PublicKey()
 
-	assert.NotNil(t, tx.Verify())
+	assert.NotNil(t, tx.Verify(0))
 }
 
 func TestTxEncodeDecode(t *testing.T) {
 	tx := randomTxWithSignature(t)
 	buf := &bytes.Buffer{}
-	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
 	tx.hash = types.Hash{}
 
 	txDecoded := new(Transaction)
-	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, txDecoded.Decode(NewBinaryTxDecoder(buf)))
 	assert.Equal(t, &tx, txDecoded)
 }
 func randomTxWithSignature(t *testing.T) Transaction {
@@ -107,3 +107,59 @@ func randomTxWithSignature(t *testing.T) Transaction {
 
 	return tx
 }
+
+func TestVerifyTransactionChainID(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := NewTransaction([]byte("foo"))
+	tx.ChainID = 1
+
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.Verify(1))
+	assert.ErrorIs(t, tx.Verify(2), ErrInvalidChainID)
+
+	// 改写 ChainID 之后签名失效
+	tx.ChainID = 2
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify(2))
+}
+
+func TestVerifyTransactionWithTamperedInner(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := NewTransaction(nil)
+	tx.TxInner = MintTx{
+		Fee:        200,
+		NFT:        types.Hash{0x01},
+		Collection: types.Hash{0x02},
+		MetaData:   []byte("green"),
+	}
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.Verify(0))
+
+	dataHash, err := CalculateDataHash([]*Transaction{tx})
+	assert.Nil(t, err)
+
+	tampered := []MintTx{
+		{Fee: 200, NFT: types.Hash{0x01}, Collection: types.Hash{0x02}, MetaData: []byte("red")},
+		{Fee: 200, NFT: types.Hash{0x03}, Collection: types.Hash{0x02}, MetaData: []byte("green")},
+		{Fee: 200, NFT: types.Hash{0x01}, Collection: types.Hash{0x04}, MetaData: []byte("green")},
+		{Fee: 1, NFT: types.Hash{0x01}, Collection: types.Hash{0x02}, MetaData: []byte("green")},
+	}
+	for _, inner := range tampered {
+		tx.TxInner = inner
+		tx.hash = types.Hash{}
+		assert.NotNil(t, tx.Verify(0))
+
+		tamperedHash, err := CalculateDataHash([]*Transaction{tx})
+		assert.Nil(t, err)
+		assert.NotEqual(t, dataHash, tamperedHash)
+	}
+
+	// 换成其他类型的 TxInner
+	tx.TxInner = CollectionTx{Fee: 200, MetaData: []byte("green")}
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify(0))
+
+	tx.TxInner = nil
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify(0))
+}
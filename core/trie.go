@@ -0,0 +1,217 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+
+	"project-bee/types"
+)
+
+const (
+	trieLeafPrefix byte = 0x00
+	trieNodePrefix byte = 0x01
+)
+
+// SparseMerkleTree 256 位 key 的稀疏 Merkle 树
+// 空子树的 hash 为零 hash, 只有一个叶子的子树直接使用叶子 hash, 因此证明长度只和叶子数量有关
+// 内部节点的 hash 被缓存, 修改一个 key 只需要重新计算它路径上的节点
+type SparseMerkleTree struct {
+	lock   sync.Mutex
+	leaves map[types.Hash]types.Hash
+	// 按 key 排序, 写入和删除时保持有序
+	keys  []types.Hash
+	nodes map[trieNode]types.Hash
+}
+
+// trieNode 是深度为 depth 的子树, prefix 是子树中 key 共同的前 depth 位
+type trieNode struct {
+	prefix types.Hash
+	depth  int
+}
+
+// SMTProof 从根到叶子的兄弟节点
+type SMTProof struct {
+	Siblings []types.Hash
+}
+
+func NewSparseMerkleTree() *SparseMerkleTree {
+	return &SparseMerkleTree{
+		leaves: make(map[types.Hash]types.Hash),
+		keys:   []types.Hash{},
+		nodes:  make(map[trieNode]types.Hash),
+	}
+}
+
+// Update 写入 key 对应的值, value 为 nil 时删除
+func (t *SparseMerkleTree) Update(key types.Hash, value []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	prev, ok := t.leaves[key]
+	i := sort.Search(len(t.keys), func(i int) bool {
+		return bytes.Compare(t.keys[i][:], key[:]) >= 0
+	})
+
+	if value == nil {
+		if !ok {
+			return
+		}
+		delete(t.leaves, key)
+		t.keys = append(t.keys[:i], t.keys[i+1:]...)
+		t.invalidate(key)
+		return
+	}
+
+	hash := types.Hash(sha256.Sum256(value))
+	if ok && prev == hash {
+		return
+	}
+
+	if !ok {
+		t.keys = append(t.keys, types.Hash{})
+		copy(t.keys[i+1:], t.keys[i:])
+		t.keys[i] = key
+	}
+	t.leaves[key] = hash
+	t.invalidate(key)
+}
+
+func (t *SparseMerkleTree) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return len(t.leaves)
+}
+
+func (t *SparseMerkleTree) Root() types.Hash {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.subtreeHash(t.keys, 0)
+}
+
+func (t *SparseMerkleTree) Prove(key types.Hash) (*SMTProof, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.leaves[key]; !ok {
+		return nil, fmt.Errorf("key (%s) not found in trie", key)
+	}
+
+	var (
+		keys  = t.keys
+		proof = &SMTProof{Siblings: []types.Hash{}}
+	)
+
+	for depth := 0; len(keys) > 1; depth++ {
+		left, right := splitKeys(keys, depth)
+
+		if trieBit(key, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, t.subtreeHash(right, depth+1))
+			keys = left
+		} else {
+			proof.Siblings = append(proof.Siblings, t.subtreeHash(left, depth+1))
+			keys = right
+		}
+	}
+
+	return proof, nil
+}
+
+func VerifySMTProof(root, key types.Hash, value []byte, proof *SMTProof) bool {
+	if proof == nil || len(proof.Siblings) > 256 {
+		return false
+	}
+
+	hash := trieLeafHash(key, types.Hash(sha256.Sum256(value)))
+
+	for depth := len(proof.Siblings) - 1; depth >= 0; depth-- {
+		if trieBit(key, depth) == 0 {
+			hash = trieNodeHash(hash, proof.Siblings[depth])
+		} else {
+			hash = trieNodeHash(proof.Siblings[depth], hash)
+		}
+	}
+
+	return hash == root
+}
+
+func (t *SparseMerkleTree) subtreeHash(keys []types.Hash, depth int) types.Hash {
+	switch len(keys) {
+	case 0:
+		return types.Hash{}
+	case 1:
+		return trieLeafHash(keys[0], t.leaves[keys[0]])
+	}
+
+	node := trieNode{prefix: triePrefix(keys[0], depth), depth: depth}
+	if hash, ok := t.nodes[node]; ok {
+		return hash
+	}
+
+	left, right := splitKeys(keys, depth)
+	hash := trieNodeHash(t.subtreeHash(left, depth+1), t.subtreeHash(right, depth+1))
+	t.nodes[node] = hash
+
+	return hash
+}
+
+// 去掉 key 路径上缓存的节点, 调用者需要持有 lock
+func (t *SparseMerkleTree) invalidate(key types.Hash) {
+	if len(t.nodes) == 0 {
+		return
+	}
+
+	for depth := 0; depth < 256; depth++ {
+		delete(t.nodes, trieNode{prefix: triePrefix(key, depth), depth: depth})
+	}
+}
+
+// 只保留 key 的前 depth 位
+func triePrefix(key types.Hash, depth int) types.Hash {
+	var prefix types.Hash
+	copy(prefix[:depth/8], key[:depth/8])
+	if r := depth % 8; r > 0 {
+		prefix[depth/8] = key[depth/8] & (0xff << uint(8-r))
+	}
+
+	return prefix
+}
+
+// 已排序的 key 按第 depth 位分成左右两部分
+func splitKeys(keys []types.Hash, depth int) ([]types.Hash, []types.Hash) {
+	i := sort.Search(len(keys), func(i int) bool {
+		return trieBit(keys[i], depth) == 1
+	})
+
+	return keys[:i], keys[i:]
+}
+
+func trieBit(key types.Hash, depth int) byte {
+	return (key[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+func trieLeafHash(key, valueHash types.Hash) types.Hash {
+	buf := make([]byte, 0, 65)
+	buf = append(buf, trieLeafPrefix)
+	buf = append(buf, key[:]...)
+	buf = append(buf, valueHash[:]...)
+
+	return types.Hash(sha256.Sum256(buf))
+}
+
+func trieNodeHash(left, right types.Hash) types.Hash {
+	if left.IsZero() && right.IsZero() {
+		return types.Hash{}
+	}
+
+	buf := make([]byte, 0, 65)
+	buf = append(buf, trieNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return types.Hash(sha256.Sum256(buf))
+}
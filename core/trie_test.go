@@ -0,0 +1,92 @@
+package core
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparseMerkleTreeRoot(t *testing.T) {
+	a := NewSparseMerkleTree()
+	b := NewSparseMerkleTree()
+	assert.Equal(t, types.Hash{}, a.Root())
+
+	// 写入顺序不影响根
+	for i := 0; i < 50; i++ {
+		a.Update(testTrieKey(i), []byte{byte(i)})
+	}
+	for i := 49; i >= 0; i-- {
+		b.Update(testTrieKey(i), []byte{byte(i)})
+	}
+	assert.Equal(t, a.Root(), b.Root())
+
+	root := a.Root()
+	a.Update(testTrieKey(3), []byte("changed"))
+	assert.NotEqual(t, root, a.Root())
+
+	a.Update(testTrieKey(3), []byte{3})
+	assert.Equal(t, root, a.Root())
+
+	a.Update(testTrieKey(3), nil)
+	assert.Equal(t, 49, a.Len())
+	assert.NotEqual(t, root, a.Root())
+}
+
+func TestSparseMerkleTreeProof(t *testing.T) {
+	trie := NewSparseMerkleTree()
+	for i := 0; i < 100; i++ {
+		trie.Update(testTrieKey(i), []byte{byte(i)})
+	}
+	root := trie.Root()
+
+	for i := 0; i < 100; i++ {
+		proof, err := trie.Prove(testTrieKey(i))
+		assert.Nil(t, err)
+		assert.True(t, VerifySMTProof(root, testTrieKey(i), []byte{byte(i)}, proof))
+		assert.False(t, VerifySMTProof(root, testTrieKey(i), []byte("tampered"), proof))
+	}
+
+	_, err := trie.Prove(testTrieKey(1000))
+	assert.NotNil(t, err)
+}
+
+func TestSparseMerkleTreeSingleLeaf(t *testing.T) {
+	trie := NewSparseMerkleTree()
+	trie.Update(testTrieKey(1), []byte("foo"))
+
+	proof, err := trie.Prove(testTrieKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(proof.Siblings))
+	assert.True(t, VerifySMTProof(trie.Root(), testTrieKey(1), []byte("foo"), proof))
+}
+
+func TestSparseMerkleTreeIncremental(t *testing.T) {
+	trie := NewSparseMerkleTree()
+	values := make(map[int][]byte)
+
+	// 缓存的节点在每次修改之后与重新构建的树一致
+	for round := 0; round < 200; round++ {
+		i := (round * 7) % 60
+		if round%5 == 4 {
+			delete(values, i)
+			trie.Update(testTrieKey(i), nil)
+		} else {
+			values[i] = []byte{byte(round)}
+			trie.Update(testTrieKey(i), values[i])
+		}
+
+		fresh := NewSparseMerkleTree()
+		for k, v := range values {
+			fresh.Update(testTrieKey(k), v)
+		}
+		assert.Equal(t, fresh.Root(), trie.Root())
+		assert.Equal(t, fresh.Len(), trie.Len())
+	}
+}
+
+func testTrieKey(i int) types.Hash {
+	return types.Hash(sha256.Sum256([]byte{byte(i), byte(i >> 8)}))
+}
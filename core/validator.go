@@ -3,12 +3,63 @@ package core
 import (
 	"errors"
 	"fmt"
+	"time"
+
+	"project-bee/types"
+)
+
+var (
+	ErrBlockKnown         = errors.New("block already known")
+	ErrInvalidStateRoot   = errors.New("invalid state root")
+	ErrInvalidReceipts    = errors.New("invalid receipts root")
+	ErrInvalidValidators  = errors.New("invalid validators hash")
+	ErrInvalidNonce       = errors.New("invalid transaction nonce")
+	ErrInvalidChainID     = errors.New("invalid chain id")
+	ErrInvalidTimestamp   = errors.New("block timestamp not after parent")
+	ErrFutureBlock        = errors.New("block timestamp too far in the future")
+	ErrBlockTooLarge      = errors.New("block too large")
+	ErrTooManyTxs         = errors.New("too many transactions in block")
+	ErrDuplicateTx        = errors.New("duplicate transaction")
+	ErrUnsupportedVersion = errors.New("unsupported block version")
+)
+
+const (
+	// 区块二进制编码的最大字节数
+	MaxBlockSize = 1 << 20
+	MaxBlockTxs  = 4096
+	// 区块时间戳最多可以比本地时间晚多少
+	MaxFutureDrift = 15 * time.Second
 )
 
-var ErrBlockKnown = errors.New("block already known")
+// 支持的区块头版本
+var supportedVersions = map[uint32]bool{
+	1: true,
+}
+
+// InvalidTxError 表示区块中第 Index 笔交易使整个区块无效
+type InvalidTxError struct {
+	Index int
+	Err   error
+}
+
+func (e *InvalidTxError) Error() string {
+	return fmt.Sprintf("invalid tx at index (%d): %s", e.Index, e.Err)
+}
+
+func (e *InvalidTxError) Unwrap() error {
+	return e.Err
+}
 
 type Validator interface {
 	ValidateBlock(*Block) error
+	// ValidateState 在区块交易执行之后校验执行结果
+	ValidateState(*Block, types.Hash) error
+	// ValidateReceipts 校验交易执行结果与区块头中的 ReceiptsRoot 一致
+	ValidateReceipts(*Block, []*Receipt) error
+	// ValidateValidators 校验执行之后下一个区块的验证者集合与区块头中的 ValidatorsHash 一致
+	ValidateValidators(*Block, types.Hash) error
+	// ValidateTx 在执行交易之前用发送者账户当前的状态校验交易
+	ValidateTx(*Transaction, *Account) error
 }
 
 type BlockValidator struct {
@@ -21,31 +72,140 @@ func NewBlockchainValidator(bc *Blockchain) *BlockValidator {
 	}
 }
 
+// ValidateBlock 只校验区块本身和它与父区块的关系, 父区块可以在侧链上
 func (v *BlockValidator) ValidateBlock(b *Block) error {
+	hash := b.Hash(BlockHasher{})
+
 	// 有区块内容
-	if v.bc.HasBlock(b.Height) {
+	if v.bc.HasBlockHash(hash) {
 		return ErrBlockKnown
 	}
 
+	parent, ok := v.bc.getNode(b.PrevBlockHash)
+	if !ok {
+		return fmt.Errorf("block (%s) with height (%d) => current height (%d): %w", hash, b.Height, v.bc.Height(), ErrUnknownParent)
+	}
+
+	// 不能在最终区块之前分叉
+	if !v.bc.extendsFinalized(parent) {
+		return fmt.Errorf("block (%s) with height (%d) => finalized height (%d): %w", hash, b.Height, v.bc.FinalizedHeight(), ErrFinalityConflict)
+	}
+
+	// 分叉点之后的区块必须还保留着撤销日志
+	if fork := v.bc.forkPoint(parent); fork != nil {
+		if err := v.bc.checkRevert(fork.block.Height); err != nil {
+			return fmt.Errorf("block (%s) with height (%d): %w", hash, b.Height, err)
+		}
+	}
+
 	// 区块高度正确
-	if b.Height != v.bc.Height()+1 {
-		return fmt.Errorf("block (%s) with height (%d) is too high => current height (%d)", b.Hash(BlockHasher{}), b.Height, v.bc.Height())
+	if b.Height != parent.block.Height+1 {
+		return fmt.Errorf("block (%s) with height (%d) does not follow parent height (%d)", hash, b.Height, parent.block.Height)
 	}
 
-	prevHeader, err := v.bc.GetHeader(b.Height - 1)
-	if err != nil {
-		return err
+	if !supportedVersions[b.Version] {
+		return fmt.Errorf("block (%s) with version (%d): %w", hash, b.Version, ErrUnsupportedVersion)
+	}
+
+	if b.ChainID != v.bc.ChainID() {
+		return fmt.Errorf("block (%s) with chain id (%d) => chain id (%d): %w", hash, b.ChainID, v.bc.ChainID(), ErrInvalidChainID)
+	}
+
+	if b.Timestamp <= parent.block.Timestamp {
+		return fmt.Errorf("block (%s) with timestamp (%d) => parent timestamp (%d): %w", hash, b.Timestamp, parent.block.Timestamp, ErrInvalidTimestamp)
+	}
+
+	if limit := time.Now().Add(MaxFutureDrift).UnixNano(); b.Timestamp > limit {
+		return fmt.Errorf("block (%s) with timestamp (%d) => latest accepted (%d): %w", hash, b.Timestamp, limit, ErrFutureBlock)
+	}
+
+	if len(b.Transactions) > MaxBlockTxs {
+		return fmt.Errorf("block (%s) with (%d) transactions => max (%d): %w", hash, len(b.Transactions), MaxBlockTxs, ErrTooManyTxs)
 	}
 
-	// 验证区块头签名
-	hash := BlockHasher{}.Hash(prevHeader)
-	if hash != b.PrevBlockHash {
-		return fmt.Errorf("the hash of the previous block (%s) is invalid", b.PrevBlockHash)
+	if size := b.Size(); size > MaxBlockSize {
+		return fmt.Errorf("block (%s) with size (%d) => max (%d): %w", hash, size, MaxBlockSize, ErrBlockTooLarge)
 	}
 
 	if err := b.Verify(); err != nil {
 		return err
 	}
 
+	if err := v.bc.engine.VerifyHeader(v.bc, b.Header, parent.block.Header, b.Validator); err != nil {
+		return err
+	}
+
+	txHashes := make([]types.Hash, len(b.Transactions))
+	seen := make(map[types.Hash]bool, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		txHash := tx.Hash(TxHasher{})
+		if seen[txHash] {
+			return fmt.Errorf("block (%s) contains tx (%s) twice: %w", hash, txHash, ErrDuplicateTx)
+		}
+		seen[txHash] = true
+		txHashes[i] = txHash
+	}
+
+	if txHash, ok := v.bc.findAncestorTx(parent, txHashes); ok {
+		return fmt.Errorf("block (%s) contains tx (%s) already in the chain: %w", hash, txHash, ErrDuplicateTx)
+	}
+
+	// 同一个发送者在区块中的 nonce 不能重复
+	nonces := make(map[types.Address]map[uint64]bool)
+	for _, tx := range b.Transactions {
+		from := tx.From.Address()
+		if nonces[from] == nil {
+			nonces[from] = make(map[uint64]bool)
+		}
+		if nonces[from][tx.Nonce] {
+			return fmt.Errorf("block (%s) contains nonce (%d) of account (%s) twice: %w", hash, tx.Nonce, from, ErrInvalidNonce)
+		}
+		nonces[from][tx.Nonce] = true
+	}
+
+	return nil
+}
+
+func (v *BlockValidator) ValidateState(b *Block, stateRoot types.Hash) error {
+	if stateRoot != b.StateRoot {
+		return fmt.Errorf("block (%s) state root (%s) does not match executed state (%s): %w", b.Hash(BlockHasher{}), b.StateRoot, stateRoot, ErrInvalidStateRoot)
+	}
+
+	return nil
+}
+
+func (v *BlockValidator) ValidateReceipts(b *Block, receipts []*Receipt) error {
+	if root := CalculateReceiptsRoot(receipts); root != b.ReceiptsRoot {
+		return fmt.Errorf("block (%s) receipts root (%s) does not match executed receipts (%s): %w", b.Hash(BlockHasher{}), b.ReceiptsRoot, root, ErrInvalidReceipts)
+	}
+
+	return nil
+}
+
+func (v *BlockValidator) ValidateValidators(b *Block, validatorsHash types.Hash) error {
+	if validatorsHash != b.ValidatorsHash {
+		return fmt.Errorf("block (%s) validators hash (%s) does not match executed validator set (%s): %w", b.Hash(BlockHasher{}), b.ValidatorsHash, validatorsHash, ErrInvalidValidators)
+	}
+
+	return nil
+}
+
+// ValidateTx 校验 nonce, 以及发送者余额足够支付转账金额和手续费
+func (v *BlockValidator) ValidateTx(tx *Transaction, sender *Account) error {
+	hash := tx.Hash(TxHasher{})
+
+	if tx.Nonce != sender.Nonce {
+		return fmt.Errorf("tx (%s) with nonce (%d) => account nonce (%d): %w", hash, tx.Nonce, sender.Nonce, ErrInvalidNonce)
+	}
+
+	fee, err := tx.TotalFee()
+	if err != nil {
+		return fmt.Errorf("tx (%s): %w", hash, err)
+	}
+
+	if cost := tx.Value + fee; cost < fee || sender.Balance < cost {
+		return fmt.Errorf("tx (%s) costs value (%d) + fee (%d) => balance (%d): %w", hash, tx.Value, fee, sender.Balance, ErrInsufficientBalance)
+	}
+
 	return nil
 }
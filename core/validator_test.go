@@ -0,0 +1,92 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBlockVersion(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	b := randomBlock(t, 1, bc.GenesisHash())
+	b.Version = 2
+	resignBlock(t, b)
+
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b), ErrUnsupportedVersion)
+}
+
+func TestValidateBlockTimestamp(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+
+	b := randomBlock(t, 1, bc.GenesisHash())
+	b.Timestamp = genesis.Timestamp
+	resignBlock(t, b)
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b), ErrInvalidTimestamp)
+
+	b.Timestamp = time.Now().Add(MaxFutureDrift + time.Minute).UnixNano()
+	resignBlock(t, b)
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b), ErrFutureBlock)
+
+	// 允许范围内的时钟偏差
+	b.Timestamp = time.Now().Add(MaxFutureDrift / 2).UnixNano()
+	resignBlock(t, b)
+	assert.Nil(t, bc.validator.ValidateBlock(b))
+}
+
+func TestValidateBlockLimits(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	b := randomBlock(t, 1, bc.GenesisHash())
+	for i := 0; i < MaxBlockTxs; i++ {
+		b.Transactions = append(b.Transactions, NewTransaction(nil))
+	}
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b), ErrTooManyTxs)
+
+	b = randomBlock(t, 1, bc.GenesisHash())
+	b.AddTransaction(NewTransaction(make([]byte, MaxBlockSize)))
+	resignBlock(t, b)
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b), ErrBlockTooLarge)
+}
+
+func TestValidateBlockDuplicateTx(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	b := randomBlock(t, 1, bc.GenesisHash())
+	b.AddTransaction(b.Transactions[0])
+	resignBlock(t, b)
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b), ErrDuplicateTx)
+
+	a1 := childBlock(t, bc, bc.GenesisHash(), 1)
+	assert.Nil(t, bc.AddBlock(a1))
+	b1 := childBlock(t, bc, bc.GenesisHash(), 1)
+	assert.Nil(t, bc.AddBlock(b1))
+	sideTx := b1.Transactions[0]
+
+	// 侧链上的交易不在规范链的祖先中
+	a2 := randomBlock(t, 2, a1.Hash(BlockHasher{}))
+	a2.AddTransaction(sideTx)
+	resignBlock(t, a2)
+	assert.Nil(t, bc.validator.ValidateBlock(a2))
+
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	b2.AddTransaction(sideTx)
+	resignBlock(t, b2)
+	assert.ErrorIs(t, bc.validator.ValidateBlock(b2), ErrDuplicateTx)
+
+	b2 = randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	b2.AddTransaction(a1.Transactions[0])
+	resignBlock(t, b2)
+	assert.Nil(t, bc.validator.ValidateBlock(b2))
+}
+
+// 修改区块头之后重新签名
+func resignBlock(t *testing.T, b *Block) {
+	b.hash = types.Hash{}
+	assert.Nil(t, b.Sign(testValidator))
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"project-bee/crypto"
+	"project-bee/types"
+)
+
+var (
+	ErrInvalidVote     = errors.New("invalid vote")
+	ErrInvalidProposal = errors.New("invalid proposal")
+	ErrInvalidCommit   = errors.New("invalid commit certificate")
+)
+
+type VoteType byte
+
+const (
+	VotePrevote VoteType = iota + 1
+	VotePrecommit
+)
+
+func (t VoteType) String() string {
+	switch t {
+	case VotePrevote:
+		return "prevote"
+	case VotePrecommit:
+		return "precommit"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(t))
+	}
+}
+
+// Vote 是验证者在某个高度的某一轮对区块的投票, BlockHash 为零值表示投给 nil
+type Vote struct {
+	Type      VoteType
+	ChainID   uint32
+	Height    uint32
+	Round     uint32
+	BlockHash types.Hash
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+// Hash 是投票内容的 hash, 不包括投票者和签名
+func (v *Vote) Hash() types.Hash {
+	buf := new(bytes.Buffer)
+	w := newCodecWriter(buf)
+	w.u8(CodecVersion)
+	w.u8(byte(v.Type))
+	w.u32(v.ChainID)
+	w.u32(v.Height)
+	w.u32(v.Round)
+	w.hash(v.BlockHash)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+func (v *Vote) IsNil() bool {
+	return v.BlockHash.IsZero()
+}
+
+func (v *Vote) Sign(privKey crypto.PrivateKey) error {
+	sig, err := privKey.Sign(signingHash(voteSigningDomain, v.Hash()))
+	if err != nil {
+		return err
+	}
+
+	v.Validator = privKey.PublicKey()
+	v.Signature = sig
+
+	return nil
+}
+
+func (v *Vote) Verify() error {
+	if v.Type != VotePrevote && v.Type != VotePrecommit {
+		return fmt.Errorf("vote type (%s): %w", v.Type, ErrInvalidVote)
+	}
+
+	if v.Signature == nil || !v.Signature.Verify(v.Validator, signingHash(voteSigningDomain, v.Hash())) {
+		return fmt.Errorf("%s at height (%d) round (%d) has an invalid signature: %w", v.Type, v.Height, v.Round, ErrInvalidVote)
+	}
+
+	return nil
+}
+
+func (v *Vote) Encode(enc Encoder[*Vote]) error {
+	return enc.Encode(v)
+}
+
+func (v *Vote) Decode(dec Decoder[*Vote]) error {
+	return dec.Decode(v)
+}
+
+// Proposal 是出块者在某个高度的某一轮提议的区块
+// 区块可以是之前某一轮的出块者打包的, POLRound 是区块获得 2/3 以上 prevote 的轮次, 没有时为 -1
+type Proposal struct {
+	Height    uint32
+	Round     uint32
+	POLRound  int32
+	Block     *Block
+	Proposer  crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+func (p *Proposal) Hash() types.Hash {
+	buf := new(bytes.Buffer)
+	w := newCodecWriter(buf)
+	w.u8(CodecVersion)
+	w.u32(p.Block.ChainID)
+	w.u32(p.Height)
+	w.u32(p.Round)
+	w.u32(uint32(p.POLRound))
+	w.hash(p.Block.Hash(BlockHasher{}))
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
+func (p *Proposal) Sign(privKey crypto.PrivateKey) error {
+	sig, err := privKey.Sign(signingHash(proposalSigningDomain, p.Hash()))
+	if err != nil {
+		return err
+	}
+
+	p.Proposer = privKey.PublicKey()
+	p.Signature = sig
+
+	return nil
+}
+
+// Verify 只校验提议本身, 提议者是否轮到出块由调用者检查
+func (p *Proposal) Verify() error {
+	if p.Block == nil || p.Block.Header == nil {
+		return fmt.Errorf("proposal has no block: %w", ErrInvalidProposal)
+	}
+
+	if p.Block.Height != p.Height || p.Block.Round > p.Round {
+		return fmt.Errorf("proposal for height (%d) round (%d) => block height (%d) round (%d): %w", p.Height, p.Round, p.Block.Height, p.Block.Round, ErrInvalidProposal)
+	}
+
+	if p.POLRound < -1 || p.POLRound >= int32(p.Round) {
+		return fmt.Errorf("proposal for round (%d) with pol round (%d): %w", p.Round, p.POLRound, ErrInvalidProposal)
+	}
+
+	if p.Signature == nil || !p.Signature.Verify(p.Proposer, signingHash(proposalSigningDomain, p.Hash())) {
+		return fmt.Errorf("proposal for height (%d) round (%d) has an invalid signature: %w", p.Height, p.Round, ErrInvalidProposal)
+	}
+
+	return p.Block.Verify()
+}
+
+func (p *Proposal) Encode(enc Encoder[*Proposal]) error {
+	return enc.Encode(p)
+}
+
+func (p *Proposal) Decode(dec Decoder[*Proposal]) error {
+	return dec.Decode(p)
+}
+
+// CommitCertificate 是 2/3 以上验证者在同一轮对同一个区块的 precommit, 有证书的区块是最终的
+type CommitCertificate struct {
+	Height     uint32
+	Round      uint32
+	BlockHash  types.Hash
+	Precommits []*Vote
+}
+
+// Quorum 是 n 个验证者中需要的最少票数, 超过 2/3
+func Quorum(n int) int {
+	return n*2/3 + 1
+}
+
+// Verify 校验证书中的 precommit 来自 validators 中不同的验证者, 并且数量达到 Quorum
+func (c *CommitCertificate) Verify(chainID uint32, validators []crypto.PublicKey) error {
+	if len(validators) == 0 {
+		return fmt.Errorf("no validators configured: %w", ErrInvalidCommit)
+	}
+
+	if c.BlockHash.IsZero() {
+		return fmt.Errorf("commit for height (%d) has no block: %w", c.Height, ErrInvalidCommit)
+	}
+
+	known := make(map[string]bool, len(validators))
+	for _, v := range validators {
+		known[string(v)] = true
+	}
+
+	signed := make(map[string]bool)
+	for _, vote := range c.Precommits {
+		if vote.Type != VotePrecommit || vote.ChainID != chainID || vote.Height != c.Height || vote.Round != c.Round || vote.BlockHash != c.BlockHash {
+			return fmt.Errorf("commit for block (%s) contains a vote for another block: %w", c.BlockHash, ErrInvalidCommit)
+		}
+
+		if !known[string(vote.Validator)] {
+			return fmt.Errorf("commit for block (%s) signed by unknown validator (%s): %w", c.BlockHash, vote.Validator, ErrInvalidCommit)
+		}
+
+		if signed[string(vote.Validator)] {
+			return fmt.Errorf("commit for block (%s) signed twice by (%s): %w", c.BlockHash, vote.Validator, ErrInvalidCommit)
+		}
+
+		if err := vote.Verify(); err != nil {
+			return fmt.Errorf("commit for block (%s): %s: %w", c.BlockHash, err, ErrInvalidCommit)
+		}
+
+		signed[string(vote.Validator)] = true
+	}
+
+	if quorum := Quorum(len(validators)); len(signed) < quorum {
+		return fmt.Errorf("commit for block (%s) with (%d) precommits => quorum (%d): %w", c.BlockHash, len(signed), quorum, ErrInvalidCommit)
+	}
+
+	return nil
+}
+
+func (c *CommitCertificate) Encode(enc Encoder[*CommitCertificate]) error {
+	return enc.Encode(c)
+}
+
+func (c *CommitCertificate) Decode(dec Decoder[*CommitCertificate]) error {
+	return dec.Decode(c)
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoteSignVerify(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	v := &Vote{
+		Type:      VotePrevote,
+		ChainID:   1,
+		Height:    3,
+		Round:     1,
+		BlockHash: types.Hash{0x01},
+	}
+	assert.Nil(t, v.Sign(privKey))
+	assert.Nil(t, v.Verify())
+	assert.Equal(t, privKey.PublicKey(), v.Validator)
+
+	// prevote 的签名不能当作 precommit 使用
+	v.Type = VotePrecommit
+	assert.ErrorIs(t, v.Verify(), ErrInvalidVote)
+
+	v.Type = VotePrevote
+	v.Round = 2
+	assert.ErrorIs(t, v.Verify(), ErrInvalidVote)
+}
+
+func TestCommitCertificateVerify(t *testing.T) {
+	keys := []crypto.PrivateKey{}
+	validators := []crypto.PublicKey{}
+	for i := 0; i < 4; i++ {
+		keys = append(keys, crypto.GeneratePrivateKey())
+		validators = append(validators, keys[i].PublicKey())
+	}
+	assert.Equal(t, 3, Quorum(4))
+
+	hash := types.Hash{0x01}
+	c := testCommit(t, 1, 5, 0, hash, keys[:2]...)
+	assert.ErrorIs(t, c.Verify(1, validators), ErrInvalidCommit)
+
+	c = testCommit(t, 1, 5, 0, hash, keys[:3]...)
+	assert.Nil(t, c.Verify(1, validators))
+	assert.ErrorIs(t, c.Verify(2, validators), ErrInvalidCommit)
+
+	// 同一个验证者的票只算一次
+	dup := testCommit(t, 1, 5, 0, hash, keys[0], keys[1], keys[1])
+	assert.ErrorIs(t, dup.Verify(1, validators), ErrInvalidCommit)
+
+	unknown := testCommit(t, 1, 5, 0, hash, keys[0], keys[1], crypto.GeneratePrivateKey())
+	assert.ErrorIs(t, unknown.Verify(1, validators), ErrInvalidCommit)
+
+	other := testCommit(t, 1, 5, 0, hash, keys[:3]...)
+	other.Precommits[2] = testCommit(t, 1, 5, 0, types.Hash{0x02}, keys[2]).Precommits[0]
+	assert.ErrorIs(t, other.Verify(1, validators), ErrInvalidCommit)
+
+	assert.ErrorIs(t, c.Verify(1, nil), ErrInvalidCommit)
+}
+
+func TestProposalVerify(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 1, types.Hash{})
+	b.Round = 1
+	assert.Nil(t, b.Sign(privKey))
+
+	// 之前轮次的区块可以在后面的轮次由其他验证者重新提议
+	p := &Proposal{Height: 1, Round: 2, POLRound: -1, Block: b}
+	proposer := crypto.GeneratePrivateKey()
+	assert.Nil(t, p.Sign(proposer))
+	assert.Nil(t, p.Verify())
+	assert.Equal(t, proposer.PublicKey(), p.Proposer)
+
+	p.Proposer = privKey.PublicKey()
+	assert.ErrorIs(t, p.Verify(), ErrInvalidProposal)
+
+	p.POLRound = 2
+	assert.Nil(t, p.Sign(proposer))
+	assert.ErrorIs(t, p.Verify(), ErrInvalidProposal)
+
+	p.POLRound = 1
+	assert.Nil(t, p.Sign(proposer))
+	assert.Nil(t, p.Verify())
+
+	p.Round = 0
+	p.POLRound = -1
+	assert.Nil(t, p.Sign(proposer))
+	assert.ErrorIs(t, p.Verify(), ErrInvalidProposal)
+}
+
+func TestConsensusCodecs(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 1, types.Hash{})
+	assert.Nil(t, b.Sign(privKey))
+	p := &Proposal{Height: 1, POLRound: -1, Block: b}
+	assert.Nil(t, p.Sign(privKey))
+	c := testCommit(t, 1, 1, 0, b.Hash(BlockHasher{}), privKey)
+	nilVote := &Vote{Type: VotePrevote, ChainID: 1, Height: 1}
+	assert.Nil(t, nilVote.Sign(privKey))
+
+	codecs := []struct {
+		name          string
+		voteEnc       func(*bytes.Buffer) Encoder[*Vote]
+		voteDec       func(*bytes.Buffer) Decoder[*Vote]
+		proposalEnc   func(*bytes.Buffer) Encoder[*Proposal]
+		proposalDec   func(*bytes.Buffer) Decoder[*Proposal]
+		commitEncoder func(*bytes.Buffer) Encoder[*CommitCertificate]
+		commitDecoder func(*bytes.Buffer) Decoder[*CommitCertificate]
+	}{
+		{
+			"binary",
+			func(b *bytes.Buffer) Encoder[*Vote] { return NewBinaryVoteEncoder(b) },
+			func(b *bytes.Buffer) Decoder[*Vote] { return NewBinaryVoteDecoder(b) },
+			func(b *bytes.Buffer) Encoder[*Proposal] { return NewBinaryProposalEncoder(b) },
+			func(b *bytes.Buffer) Decoder[*Proposal] { return NewBinaryProposalDecoder(b) },
+			func(b *bytes.Buffer) Encoder[*CommitCertificate] { return NewBinaryCommitEncoder(b) },
+			func(b *bytes.Buffer) Decoder[*CommitCertificate] { return NewBinaryCommitDecoder(b) },
+		},
+		{
+			"proto",
+			func(b *bytes.Buffer) Encoder[*Vote] { return NewProtoVoteEncoder(b) },
+			func(b *bytes.Buffer) Decoder[*Vote] { return NewProtoVoteDecoder(b) },
+			func(b *bytes.Buffer) Encoder[*Proposal] { return NewProtoProposalEncoder(b) },
+			func(b *bytes.Buffer) Decoder[*Proposal] { return NewProtoProposalDecoder(b) },
+			func(b *bytes.Buffer) Encoder[*CommitCertificate] { return NewProtoCommitEncoder(b) },
+			func(b *bytes.Buffer) Decoder[*CommitCertificate] { return NewProtoCommitDecoder(b) },
+		},
+	}
+
+	for _, codec := range codecs {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, nilVote.Encode(codec.voteEnc(buf)), codec.name)
+		v := new(Vote)
+		assert.Nil(t, v.Decode(codec.voteDec(buf)), codec.name)
+		assert.Nil(t, v.Verify(), codec.name)
+		assert.True(t, v.IsNil(), codec.name)
+
+		buf.Reset()
+		assert.Nil(t, p.Encode(codec.proposalEnc(buf)), codec.name)
+		decoded := new(Proposal)
+		assert.Nil(t, decoded.Decode(codec.proposalDec(buf)), codec.name)
+		assert.Equal(t, int32(-1), decoded.POLRound, codec.name)
+		assert.Nil(t, decoded.Verify(), codec.name)
+
+		buf.Reset()
+		assert.Nil(t, c.Encode(codec.commitEncoder(buf)), codec.name)
+		cert := new(CommitCertificate)
+		assert.Nil(t, cert.Decode(codec.commitDecoder(buf)), codec.name)
+		assert.Nil(t, cert.Verify(1, []crypto.PublicKey{privKey.PublicKey()}), codec.name)
+	}
+}
+
+// 由 keys 对 hash 签名 precommit 生成的证书
+func testCommit(t *testing.T, chainID, height, round uint32, hash types.Hash, keys ...crypto.PrivateKey) *CommitCertificate {
+	c := &CommitCertificate{
+		Height:    height,
+		Round:     round,
+		BlockHash: hash,
+	}
+
+	for _, key := range keys {
+		v := &Vote{
+			Type:      VotePrecommit,
+			ChainID:   chainID,
+			Height:    height,
+			Round:     round,
+			BlockHash: hash,
+		}
+		assert.Nil(t, v.Sign(key))
+		c.Precommits = append(c.Precommits, v)
+	}
+
+	return c
+}
@@ -52,6 +52,12 @@ func (k PublicKey) String() string {
 	return hex.EncodeToString(k)
 }
 
+// Valid 判断 k 是否是 P256 曲线上的压缩公钥
+func (k PublicKey) Valid() bool {
+	x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k)
+	return x != nil
+}
+
 func (k PublicKey) Address() types.Address {
 	h := sha256.Sum256(k)
 
@@ -68,8 +74,16 @@ func (sig Signature) String() string {
 	return hex.EncodeToString(b)
 }
 
+// 签名或者公钥不完整时返回 false
 func (sig Signature) Verify(pubKey PublicKey, data []byte) bool {
+	if sig.R == nil || sig.S == nil || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return false
+	}
+
 	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+	if x == nil {
+		return false
+	}
 	key := &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     x,
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +34,19 @@ func TestKeypairSignVerifyFail(t *testing.T) {
 	assert.False(t, sig.Verify(otherPublicKey, msg))
 	assert.False(t, sig.Verify(PublicKey, []byte("xxxxxx")))
 }
+
+func TestSignatureVerifyInvalidInput(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("hello world")
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+
+	// 不完整的签名和公钥返回 false, 不会 panic
+	assert.False(t, Signature{}.Verify(privKey.PublicKey(), msg))
+	assert.False(t, Signature{R: sig.R}.Verify(privKey.PublicKey(), msg))
+	assert.False(t, Signature{R: big.NewInt(0), S: sig.S}.Verify(privKey.PublicKey(), msg))
+	assert.False(t, sig.Verify(nil, msg))
+	assert.False(t, sig.Verify(PublicKey{0x02, 0x01}, msg))
+	assert.False(t, PublicKey{0x02, 0x01}.Valid())
+	assert.True(t, privKey.PublicKey().Valid())
+}
@@ -15,8 +15,21 @@ import (
 	"project-bee/util"
 )
 
+var genesis *core.Genesis
+
 func main() {
+	g, err := core.ReadGenesis("genesis.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	genesis = g
+
 	validatorPrivKey := crypto.GeneratePrivateKey()
+	// 本地节点是唯一的验证者, 所有节点使用同一份创世配置
+	genesis.Validators = append(genesis.Validators, validatorPrivKey.PublicKey().String())
+	if err := genesis.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	localNode := makeServer("LOCAL_NODE", &validatorPrivKey, ":3000", []string{":4000"}, ":9000")
 	go localNode.Start()
@@ -59,6 +72,7 @@ func sendTransaction(privKey crypto.PrivateKey) error {
 	toPrivKey := crypto.GeneratePrivateKey()
 
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
 	tx.To = toPrivKey.PublicKey()
 	tx.Value = 666
 	if err := tx.Sign(privKey); err != nil {
@@ -66,7 +80,7 @@ func sendTransaction(privKey crypto.PrivateKey) error {
 	}
 
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		panic(err)
 	}
 
@@ -87,6 +101,8 @@ func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []strin
 		ListenAddr:  addr,
 		PrivateKey:  pk,
 		ID:          id,
+		Genesis:     genesis,
+		BFT:         genesis.Consensus != core.ConsensusPoW,
 	}
 
 	s, err := network.NewServer(opts)
@@ -99,6 +115,7 @@ func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []strin
 
 func createCollectionTx(privKey crypto.PrivateKey) types.Hash {
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
 	tx.TxInner = core.CollectionTx{
 		Fee:      200,
 		MetaData: []byte("chicken and egg collection!"),
@@ -106,7 +123,7 @@ func createCollectionTx(privKey crypto.PrivateKey) types.Hash {
 	tx.Sign(privKey)
 
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		panic(err)
 	}
 
@@ -137,6 +154,7 @@ func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
 		panic(err)
 	}
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
 	tx.TxInner = core.MintTx{
 		Fee:             200,
 		NFT:             util.RandomHash(),
@@ -147,7 +165,7 @@ func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
 	tx.Sign(privKey)
 
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		panic(err)
 	}
 
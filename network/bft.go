@@ -0,0 +1,654 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"project-bee/core"
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+)
+
+// 缓存的下一个高度的共识消息的最大数量
+const maxFutureMessages = 1024
+
+type bftStep byte
+
+const (
+	stepPropose bftStep = iota
+	stepPrevote
+	stepPrecommit
+	// 区块已经最终确定, 等待 Commit 超时后进入下一个高度
+	stepCommit
+)
+
+func (s bftStep) String() string {
+	switch s {
+	case stepPropose:
+		return "propose"
+	case stepPrevote:
+		return "prevote"
+	case stepPrecommit:
+		return "precommit"
+	default:
+		return "commit"
+	}
+}
+
+// BFTTimeouts 是每一步的超时时间, 第 r 轮的超时时间为 基础时间 + r*Delta
+type BFTTimeouts struct {
+	Propose   time.Duration
+	Prevote   time.Duration
+	Precommit time.Duration
+	Delta     time.Duration
+	// 区块最终确定之后等待多久开始下一个高度
+	Commit time.Duration
+}
+
+func DefaultBFTTimeouts(blockTime time.Duration) BFTTimeouts {
+	return BFTTimeouts{
+		Propose:   3 * time.Second,
+		Prevote:   time.Second,
+		Precommit: time.Second,
+		Delta:     500 * time.Millisecond,
+		Commit:    blockTime,
+	}
+}
+
+func (t BFTTimeouts) timeout(step bftStep, round uint32) time.Duration {
+	delta := time.Duration(round) * t.Delta
+
+	switch step {
+	case stepPropose:
+		return t.Propose + delta
+	case stepPrevote:
+		return t.Prevote + delta
+	case stepPrecommit:
+		return t.Precommit + delta
+	default:
+		return t.Commit
+	}
+}
+
+type BFTConfig struct {
+	Logger log.Logger
+	Chain  *core.Blockchain
+	// PrivateKey 为 nil 时只跟随共识, 不提议也不投票
+	PrivateKey *crypto.PrivateKey
+	Timeouts   BFTTimeouts
+	// BuildBlock 在当前最高区块之后打包并签名一个轮次为 round 的区块
+	BuildBlock func(round uint32) (*core.Block, error)
+	// Broadcast 把自己产生或者第一次收到的 *core.Proposal 和 *core.Vote 发给其他节点
+	Broadcast func(msg any)
+	// OnCommit 在区块最终确定之后调用
+	OnCommit func(*core.Block)
+}
+
+type timeoutInfo struct {
+	height uint32
+	round  uint32
+	step   bftStep
+}
+
+// voteSet 是同一高度同一轮次同一类型的投票, 每个验证者只计第一票
+type voteSet struct {
+	votes  map[string]*core.Vote
+	counts map[types.Hash]int
+}
+
+func newVoteSet() *voteSet {
+	return &voteSet{
+		votes:  make(map[string]*core.Vote),
+		counts: make(map[types.Hash]int),
+	}
+}
+
+func (vs *voteSet) add(v *core.Vote) bool {
+	if _, ok := vs.votes[string(v.Validator)]; ok {
+		return false
+	}
+
+	vs.votes[string(v.Validator)] = v
+	vs.counts[v.BlockHash]++
+
+	return true
+}
+
+func (vs *voteSet) size() int {
+	return len(vs.votes)
+}
+
+func (vs *voteSet) count(hash types.Hash) int {
+	return vs.counts[hash]
+}
+
+func (vs *voteSet) votesFor(hash types.Hash) []*core.Vote {
+	votes := []*core.Vote{}
+	for _, v := range vs.votes {
+		if v.BlockHash == hash {
+			votes = append(votes, v)
+		}
+	}
+
+	return votes
+}
+
+// roundState 是某一轮收到的投票, 以及这一轮中只能触发一次的规则
+type roundState struct {
+	prevotes   *voteSet
+	precommits *voteSet
+
+	prevoteTimeout   bool
+	precommitTimeout bool
+	polHandled       bool
+}
+
+// BFTEngine 是 Tendermint 风格的共识: 每个高度分若干轮, 每轮 propose => prevote => precommit
+// 2/3 以上验证者 precommit 同一个区块时区块最终确定, 证书和区块一起保存, 之后不会被重组
+// 验证者在某一轮 precommit 区块之后锁定该区块, 之后的轮次只 prevote 锁定的区块,
+// 除非看到更高轮次中其他区块获得 2/3 以上的 prevote
+// 所有状态只在事件循环中访问
+type BFTEngine struct {
+	BFTConfig
+
+	msgCh     chan any
+	timeoutCh chan timeoutInfo
+	quitCh    chan struct{}
+
+	// 已经处理过的消息 => 消息的高度, 用于去重和转发
+	seenLock sync.Mutex
+	seen     map[string]uint32
+
+	height      uint32
+	round       uint32
+	step        bftStep
+	lockedRound int32
+	lockedBlock *core.Block
+	validRound  int32
+	validBlock  *core.Block
+	proposals   map[uint32]*core.Proposal
+	blocks      map[types.Hash]*core.Proposal // 区块 hash => 第一个提议该区块的提议
+	valid       map[types.Hash]bool
+	rounds      map[uint32]*roundState
+	future      []any
+}
+
+func NewBFTEngine(cfg BFTConfig) *BFTEngine {
+	return &BFTEngine{
+		BFTConfig: cfg,
+		msgCh:     make(chan any, 1024),
+		timeoutCh: make(chan timeoutInfo, 64),
+		quitCh:    make(chan struct{}),
+		seen:      make(map[string]uint32),
+	}
+}
+
+func (e *BFTEngine) Start() {
+	go e.loop()
+}
+
+func (e *BFTEngine) Stop() {
+	close(e.quitCh)
+}
+
+// AddProposal 校验提议并交给事件循环, 第一次收到的提议会被转发
+func (e *BFTEngine) AddProposal(p *core.Proposal) error {
+	if p.Height <= e.Chain.FinalizedHeight() {
+		return nil
+	}
+
+	if err := p.Verify(); err != nil {
+		return err
+	}
+
+	if !e.Chain.IsProposer(p.Height, p.Round, p.Proposer) {
+		proposer, _ := e.Chain.ProposerAt(p.Height, p.Round)
+		return fmt.Errorf("proposal for height (%d) round (%d) from (%s) => proposer (%s): %w", p.Height, p.Round, p.Proposer, proposer, core.ErrWrongProposer)
+	}
+
+	hash := p.Hash()
+	if !e.markSeen(string(hash[:])+string(p.Proposer), p.Height) {
+		return nil
+	}
+
+	e.Broadcast(p)
+	e.send(p)
+
+	return nil
+}
+
+// AddVote 校验投票并交给事件循环, 第一次收到的投票会被转发
+func (e *BFTEngine) AddVote(v *core.Vote) error {
+	if v.Height <= e.Chain.FinalizedHeight() {
+		return nil
+	}
+
+	if v.ChainID != e.Chain.ChainID() {
+		return fmt.Errorf("%s with chain id (%d) => chain id (%d): %w", v.Type, v.ChainID, e.Chain.ChainID(), core.ErrInvalidVote)
+	}
+
+	if !e.isValidator(v.Height, v.Validator) {
+		return fmt.Errorf("%s from (%s) which is not a validator: %w", v.Type, v.Validator, core.ErrInvalidVote)
+	}
+
+	if err := v.Verify(); err != nil {
+		return err
+	}
+
+	hash := v.Hash()
+	if !e.markSeen(string(hash[:])+string(v.Validator), v.Height) {
+		return nil
+	}
+
+	e.Broadcast(v)
+	e.send(v)
+
+	return nil
+}
+
+func (e *BFTEngine) send(msg any) {
+	select {
+	case e.msgCh <- msg:
+	case <-e.quitCh:
+	}
+}
+
+func (e *BFTEngine) markSeen(key string, height uint32) bool {
+	e.seenLock.Lock()
+	defer e.seenLock.Unlock()
+
+	if _, ok := e.seen[key]; ok {
+		return false
+	}
+	e.seen[key] = height
+
+	return true
+}
+
+// 删除已经最终确定的高度的消息记录
+func (e *BFTEngine) pruneSeen(height uint32) {
+	e.seenLock.Lock()
+	defer e.seenLock.Unlock()
+
+	for key, h := range e.seen {
+		if h < height {
+			delete(e.seen, key)
+		}
+	}
+}
+
+func (e *BFTEngine) isValidator(height uint32, pubKey crypto.PublicKey) bool {
+	for _, v := range e.Chain.ValidatorsAt(height) {
+		if string(v) == string(pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BFTEngine) loop() {
+	e.Logger.Log("msg", "starting BFT consensus", "validator", e.PrivateKey != nil)
+
+	e.newHeight()
+	e.applyRules()
+
+	for {
+		select {
+		case msg := <-e.msgCh:
+			e.syncHeight()
+			e.handleMessage(msg)
+		case ti := <-e.timeoutCh:
+			e.syncHeight()
+			e.handleTimeout(ti)
+		case <-e.quitCh:
+			return
+		}
+
+		e.applyRules()
+	}
+}
+
+// 通过同步已经得到当前高度的最终区块时直接进入下一个高度
+func (e *BFTEngine) syncHeight() {
+	height := e.Chain.Height()
+	if height > e.height || (height == e.height && e.step != stepCommit) {
+		e.newHeight()
+	}
+}
+
+func (e *BFTEngine) newHeight() {
+	e.height = e.Chain.Height() + 1
+	e.lockedRound = -1
+	e.lockedBlock = nil
+	e.validRound = -1
+	e.validBlock = nil
+	e.proposals = make(map[uint32]*core.Proposal)
+	e.blocks = make(map[types.Hash]*core.Proposal)
+	e.valid = make(map[types.Hash]bool)
+	e.rounds = make(map[uint32]*roundState)
+	e.pruneSeen(e.height)
+
+	future := e.future
+	e.future = nil
+
+	e.startRound(0)
+
+	for _, msg := range future {
+		e.handleMessage(msg)
+	}
+}
+
+func (e *BFTEngine) startRound(round uint32) {
+	e.round = round
+	e.step = stepPropose
+	e.scheduleTimeout(stepPropose)
+
+	if e.PrivateKey == nil || !e.Chain.IsProposer(e.height, round, e.PrivateKey.PublicKey()) {
+		return
+	}
+
+	// 有 2/3 以上 prevote 的区块时重新提议它
+	block, polRound := e.validBlock, e.validRound
+	if block == nil {
+		var err error
+		if block, err = e.BuildBlock(round); err != nil {
+			e.Logger.Log("msg", "failed to build proposal block", "height", e.height, "round", round, "err", err)
+			return
+		}
+	}
+
+	p := &core.Proposal{
+		Height:   e.height,
+		Round:    round,
+		POLRound: polRound,
+		Block:    block,
+	}
+	if err := p.Sign(*e.PrivateKey); err != nil {
+		e.Logger.Log("msg", "failed to sign proposal", "err", err)
+		return
+	}
+
+	hash := p.Hash()
+	e.markSeen(string(hash[:])+string(p.Proposer), p.Height)
+	e.Broadcast(p)
+	e.handleMessage(p)
+}
+
+func (e *BFTEngine) scheduleTimeout(step bftStep) {
+	ti := timeoutInfo{
+		height: e.height,
+		round:  e.round,
+		step:   step,
+	}
+
+	time.AfterFunc(e.Timeouts.timeout(step, e.round), func() {
+		select {
+		case e.timeoutCh <- ti:
+		case <-e.quitCh:
+		}
+	})
+}
+
+func (e *BFTEngine) roundState(round uint32) *roundState {
+	rs, ok := e.rounds[round]
+	if !ok {
+		rs = &roundState{
+			prevotes:   newVoteSet(),
+			precommits: newVoteSet(),
+		}
+		e.rounds[round] = rs
+	}
+
+	return rs
+}
+
+func (e *BFTEngine) handleMessage(msg any) {
+	var height uint32
+	switch m := msg.(type) {
+	case *core.Proposal:
+		height = m.Height
+	case *core.Vote:
+		height = m.Height
+	}
+
+	if height == e.height+1 && len(e.future) < maxFutureMessages {
+		e.future = append(e.future, msg)
+	}
+	if height != e.height {
+		return
+	}
+
+	switch m := msg.(type) {
+	case *core.Proposal:
+		if _, ok := e.proposals[m.Round]; ok {
+			return
+		}
+		e.proposals[m.Round] = m
+		if _, ok := e.blocks[m.Block.Hash(core.BlockHasher{})]; !ok {
+			e.blocks[m.Block.Hash(core.BlockHasher{})] = m
+		}
+	case *core.Vote:
+		rs := e.roundState(m.Round)
+		if m.Type == core.VotePrevote {
+			rs.prevotes.add(m)
+		} else {
+			rs.precommits.add(m)
+		}
+	}
+}
+
+func (e *BFTEngine) handleTimeout(ti timeoutInfo) {
+	if ti.height != e.height {
+		return
+	}
+
+	if ti.step == stepCommit {
+		if e.step == stepCommit {
+			e.newHeight()
+		}
+		return
+	}
+
+	if ti.round != e.round || e.step == stepCommit {
+		return
+	}
+
+	switch {
+	case ti.step == stepPropose && e.step == stepPropose:
+		e.vote(core.VotePrevote, types.Hash{})
+		e.step = stepPrevote
+	case ti.step == stepPrevote && e.step == stepPrevote:
+		e.vote(core.VotePrecommit, types.Hash{})
+		e.step = stepPrecommit
+	case ti.step == stepPrecommit:
+		e.startRound(e.round + 1)
+	}
+}
+
+// applyRules 重复执行共识规则, 直到没有规则可以触发
+func (e *BFTEngine) applyRules() {
+	for e.applyRule() {
+	}
+}
+
+func (e *BFTEngine) applyRule() bool {
+	if e.step == stepCommit {
+		return false
+	}
+
+	n := len(e.Chain.ValidatorsAt(e.height))
+	quorum := core.Quorum(n)
+
+	// 任意一轮有 2/3 以上 precommit 同一个区块时确定该区块
+	for round, rs := range e.rounds {
+		for hash, count := range rs.precommits.counts {
+			if hash.IsZero() || count < quorum {
+				continue
+			}
+			if p, ok := e.blocks[hash]; ok && e.isValid(p) && e.commit(round, p.Block) {
+				return true
+			}
+		}
+	}
+
+	// 超过 1/3 的验证者已经进入更高的轮次
+	for round, rs := range e.rounds {
+		if round > e.round && e.distinctVoters(rs) >= n-quorum+1 {
+			e.startRound(round)
+			return true
+		}
+	}
+
+	rs := e.roundState(e.round)
+	p := e.proposals[e.round]
+
+	if e.step == stepPropose && p != nil {
+		hash := p.Block.Hash(core.BlockHasher{})
+
+		if p.POLRound == -1 {
+			if e.isValid(p) && (e.lockedRound == -1 || e.isLocked(hash)) {
+				e.vote(core.VotePrevote, hash)
+			} else {
+				e.vote(core.VotePrevote, types.Hash{})
+			}
+			e.step = stepPrevote
+			return true
+		}
+
+		if pol, ok := e.rounds[uint32(p.POLRound)]; ok && pol.prevotes.count(hash) >= quorum {
+			if e.isValid(p) && (e.lockedRound <= p.POLRound || e.isLocked(hash)) {
+				e.vote(core.VotePrevote, hash)
+			} else {
+				e.vote(core.VotePrevote, types.Hash{})
+			}
+			e.step = stepPrevote
+			return true
+		}
+	}
+
+	if e.step >= stepPrevote && p != nil && !rs.polHandled {
+		hash := p.Block.Hash(core.BlockHasher{})
+		if rs.prevotes.count(hash) >= quorum && e.isValid(p) {
+			rs.polHandled = true
+			if e.step == stepPrevote {
+				e.lockedRound = int32(e.round)
+				e.lockedBlock = p.Block
+				e.vote(core.VotePrecommit, hash)
+				e.step = stepPrecommit
+			}
+			e.validRound = int32(e.round)
+			e.validBlock = p.Block
+			return true
+		}
+	}
+
+	if e.step == stepPrevote && rs.prevotes.count(types.Hash{}) >= quorum {
+		e.vote(core.VotePrecommit, types.Hash{})
+		e.step = stepPrecommit
+		return true
+	}
+
+	if e.step == stepPrevote && rs.prevotes.size() >= quorum && !rs.prevoteTimeout {
+		rs.prevoteTimeout = true
+		e.scheduleTimeout(stepPrevote)
+		return true
+	}
+
+	// 在任何一步收到 2/3 以上的 precommit 都开始 precommit 超时
+	if rs.precommits.size() >= quorum && !rs.precommitTimeout {
+		rs.precommitTimeout = true
+		e.scheduleTimeout(stepPrecommit)
+		return true
+	}
+
+	return false
+}
+
+func (e *BFTEngine) isLocked(hash types.Hash) bool {
+	return e.lockedBlock != nil && e.lockedBlock.Hash(core.BlockHasher{}) == hash
+}
+
+// 在某一轮投过票的不同验证者数量
+func (e *BFTEngine) distinctVoters(rs *roundState) int {
+	voters := make(map[string]bool)
+	for validator := range rs.prevotes.votes {
+		voters[validator] = true
+	}
+	for validator := range rs.precommits.votes {
+		voters[validator] = true
+	}
+
+	return len(voters)
+}
+
+// 在当前链的状态上执行提议的区块, 结果按区块 hash 缓存
+func (e *BFTEngine) isValid(p *core.Proposal) bool {
+	b := p.Block
+	hash := b.Hash(core.BlockHasher{})
+	if valid, ok := e.valid[hash]; ok {
+		return valid
+	}
+
+	err := e.Chain.CheckProposal(p)
+	valid := err == nil || errors.Is(err, core.ErrBlockKnown)
+	if !valid {
+		e.Logger.Log("msg", "invalid proposal block", "hash", hash, "height", b.Height, "err", err)
+	}
+	e.valid[hash] = valid
+
+	return valid
+}
+
+// 签名投票, 计入自己的票并广播, 没有私钥时什么也不做
+func (e *BFTEngine) vote(t core.VoteType, hash types.Hash) {
+	if e.PrivateKey == nil || !e.isValidator(e.height, e.PrivateKey.PublicKey()) {
+		return
+	}
+
+	v := &core.Vote{
+		Type:      t,
+		ChainID:   e.Chain.ChainID(),
+		Height:    e.height,
+		Round:     e.round,
+		BlockHash: hash,
+	}
+	if err := v.Sign(*e.PrivateKey); err != nil {
+		e.Logger.Log("msg", "failed to sign vote", "err", err)
+		return
+	}
+
+	voteHash := v.Hash()
+	e.markSeen(string(voteHash[:])+string(v.Validator), v.Height)
+	e.Broadcast(v)
+	e.handleMessage(v)
+}
+
+// commit 保存区块和证书, 失败时把区块标记为无效, 这一高度不再尝试提交它, 否则规则会一直触发
+func (e *BFTEngine) commit(round uint32, b *core.Block) bool {
+	hash := b.Hash(core.BlockHasher{})
+	cert := &core.CommitCertificate{
+		Height:     e.height,
+		Round:      round,
+		BlockHash:  hash,
+		Precommits: e.rounds[round].precommits.votesFor(hash),
+	}
+
+	if err := e.Chain.AddCommittedBlock(b, cert); err != nil {
+		e.Logger.Log("msg", "failed to add committed block", "hash", hash, "err", err)
+		e.valid[hash] = false
+		return false
+	}
+
+	if e.OnCommit != nil {
+		e.OnCommit(b)
+	}
+
+	e.step = stepCommit
+	e.scheduleTimeout(stepCommit)
+
+	return true
+}
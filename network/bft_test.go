@@ -0,0 +1,254 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"project-bee/core"
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+var testBFTTimeouts = BFTTimeouts{
+	Propose:   200 * time.Millisecond,
+	Prevote:   100 * time.Millisecond,
+	Precommit: 100 * time.Millisecond,
+	Delta:     50 * time.Millisecond,
+	Commit:    10 * time.Millisecond,
+}
+
+// 内存中互相直接投递消息的一组节点
+type bftTestNet struct {
+	engines []*BFTEngine
+	chains  []*core.Blockchain
+}
+
+func newBFTTestNet(t *testing.T, keys []crypto.PrivateKey, observers int) *bftTestNet {
+	g := &core.Genesis{
+		ChainID:   1,
+		Timestamp: 1000,
+	}
+	for _, key := range keys {
+		g.Validators = append(g.Validators, key.PublicKey().String())
+	}
+
+	n := &bftTestNet{}
+	for i := 0; i < len(keys)+observers; i++ {
+		chain, err := core.NewBlockchainFromGenesis(log.NewNopLogger(), core.NewMemorystore(), g)
+		assert.Nil(t, err)
+
+		var privKey *crypto.PrivateKey
+		if i < len(keys) {
+			privKey = &keys[i]
+		}
+
+		i := i
+		n.chains = append(n.chains, chain)
+		n.engines = append(n.engines, NewBFTEngine(BFTConfig{
+			Logger:     log.NewNopLogger(),
+			Chain:      chain,
+			PrivateKey: privKey,
+			Timeouts:   testBFTTimeouts,
+			BuildBlock: func(round uint32) (*core.Block, error) {
+				return buildTestBlock(chain, round, *privKey)
+			},
+			Broadcast: func(msg any) {
+				n.deliver(i, msg)
+			},
+		}))
+	}
+
+	return n
+}
+
+func (n *bftTestNet) deliver(from int, msg any) {
+	for i, e := range n.engines {
+		if i == from {
+			continue
+		}
+
+		go func(e *BFTEngine) {
+			switch m := msg.(type) {
+			case *core.Proposal:
+				e.AddProposal(m)
+			case *core.Vote:
+				e.AddVote(m)
+			}
+		}(e)
+	}
+}
+
+func (n *bftTestNet) start(indexes ...int) {
+	for _, i := range indexes {
+		n.engines[i].Start()
+	}
+}
+
+func (n *bftTestNet) stop(indexes ...int) {
+	for _, i := range indexes {
+		n.engines[i].Stop()
+	}
+}
+
+// 等待 indexes 中所有节点的最终高度达到 height
+func (n *bftTestNet) waitFinalized(t *testing.T, height uint32, indexes ...int) {
+	assert.Eventually(t, func() bool {
+		for _, i := range indexes {
+			if n.chains[i].FinalizedHeight() < height {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func buildTestBlock(chain *core.Blockchain, round uint32, privKey crypto.PrivateKey) (*core.Block, error) {
+	header, err := chain.GetHeader(chain.Height())
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := core.NewBlockFromPrevHeader(header, nil)
+	if err != nil {
+		return nil, err
+	}
+	b.Round = round
+
+	return b, chain.SealBlock(b, privKey)
+}
+
+func generateKeys(n int) []crypto.PrivateKey {
+	keys := make([]crypto.PrivateKey, n)
+	for i := range keys {
+		keys[i] = crypto.GeneratePrivateKey()
+	}
+	return keys
+}
+
+func TestBFTCommit(t *testing.T) {
+	keys := generateKeys(4)
+	n := newBFTTestNet(t, keys, 1)
+	all := []int{0, 1, 2, 3, 4}
+	n.start(all...)
+	defer n.stop(all...)
+
+	n.waitFinalized(t, 3, all...)
+
+	for height := uint32(1); height <= 3; height++ {
+		expected, err := n.chains[0].GetBlock(height)
+		assert.Nil(t, err)
+		hash := expected.Hash(core.BlockHasher{})
+
+		for _, chain := range n.chains {
+			b, err := chain.GetBlock(height)
+			assert.Nil(t, err)
+			assert.Equal(t, hash, b.Hash(core.BlockHasher{}))
+
+			commit, err := chain.GetCommit(hash)
+			assert.Nil(t, err)
+			assert.Nil(t, commit.Verify(chain.ChainID(), chain.Validators()))
+		}
+	}
+}
+
+func TestBFTOfflineValidator(t *testing.T) {
+	keys := generateKeys(4)
+	n := newBFTTestNet(t, keys, 0)
+	// 第 4 个验证者不在线, 轮到它出块的高度需要进入下一轮
+	online := []int{0, 1, 2}
+	n.start(online...)
+	defer n.stop(online...)
+
+	n.waitFinalized(t, 5, online...)
+
+	rounds := 0
+	for height := uint32(1); height <= 5; height++ {
+		b, err := n.chains[0].GetBlock(height)
+		assert.Nil(t, err)
+		rounds += int(b.Round)
+	}
+	assert.Greater(t, rounds, 0)
+}
+
+func TestBFTRejectsInvalidMessages(t *testing.T) {
+	keys := generateKeys(4)
+	n := newBFTTestNet(t, keys, 0)
+	e := n.engines[0]
+
+	v := &core.Vote{Type: core.VotePrevote, ChainID: 1, Height: 1}
+	assert.Nil(t, v.Sign(crypto.GeneratePrivateKey()))
+	assert.ErrorIs(t, e.AddVote(v), core.ErrInvalidVote)
+
+	v.ChainID = 2
+	assert.Nil(t, v.Sign(keys[1]))
+	assert.ErrorIs(t, e.AddVote(v), core.ErrInvalidVote)
+
+	// 高度 1 第 0 轮的出块者是第 2 个验证者
+	b, err := buildTestBlock(n.chains[0], 0, keys[0])
+	assert.Nil(t, err)
+	p := &core.Proposal{Height: 1, POLRound: -1, Block: b}
+	assert.Nil(t, p.Sign(keys[0]))
+	assert.ErrorIs(t, e.AddProposal(p), core.ErrWrongProposer)
+}
+
+// 保存最终性证书总是失败的存储
+type failingCommitStore struct {
+	*core.MemoryStore
+}
+
+func (s failingCommitStore) PutCommit(types.Hash, *core.CommitCertificate) error {
+	return errors.New("disk full")
+}
+
+func TestBFTCommitFailureDoesNotSpin(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	g := &core.Genesis{
+		ChainID:    1,
+		Timestamp:  1000,
+		Validators: []string{key.PublicKey().String()},
+	}
+	chain, err := core.NewBlockchainFromGenesis(log.NewNopLogger(), failingCommitStore{core.NewMemorystore()}, g)
+	assert.Nil(t, err)
+
+	var (
+		lock  sync.Mutex
+		votes []*core.Vote
+	)
+	e := NewBFTEngine(BFTConfig{
+		Logger:     log.NewNopLogger(),
+		Chain:      chain,
+		PrivateKey: &key,
+		Timeouts:   testBFTTimeouts,
+		BuildBlock: func(round uint32) (*core.Block, error) {
+			return buildTestBlock(chain, round, key)
+		},
+		Broadcast: func(msg any) {
+			if v, ok := msg.(*core.Vote); ok {
+				lock.Lock()
+				votes = append(votes, v)
+				lock.Unlock()
+			}
+		},
+	})
+	e.Start()
+	defer e.Stop()
+
+	// 提交失败之后事件循环继续处理消息和超时, 在之后的轮次或者高度继续投票
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, v := range votes {
+			if v.Round > 0 || v.Height > 1 {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint32(0), chain.FinalizedHeight())
+}
@@ -0,0 +1,351 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+
+	"project-bee/core"
+)
+
+// Codec 是消息 payload 的编码, 写在每个 Message 的头部
+type Codec byte
+
+const (
+	CodecBinary Codec = iota
+	CodecProto
+	CodecGob
+)
+
+// DefaultCodecs 是节点默认支持的编码, 按优先级排列
+var DefaultCodecs = []Codec{CodecBinary, CodecProto, CodecGob}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecBinary:
+		return "binary"
+	case CodecProto:
+		return "protobuf"
+	case CodecGob:
+		return "gob"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+func (c Codec) valid() bool {
+	return c <= CodecGob
+}
+
+// NegotiateCodec 返回 local 中优先级最高并且对方也支持的编码
+// 对方没有声明编码时认为它只支持 binary
+func NegotiateCodec(local, remote []Codec) (Codec, error) {
+	if len(remote) == 0 {
+		remote = []Codec{CodecBinary}
+	}
+
+	for _, c := range local {
+		for _, r := range remote {
+			if c == r {
+				return c, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no common codec in (%v) and (%v)", local, remote)
+}
+
+func encodeTx(c Codec, tx *core.Transaction) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = tx.Encode(core.NewBinaryTxEncoder(buf))
+	case CodecProto:
+		err = tx.Encode(core.NewProtoTxEncoder(buf))
+	case CodecGob:
+		err = tx.Encode(core.NewGobTxEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeTx(c Codec, data []byte) (*core.Transaction, error) {
+	tx := new(core.Transaction)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = tx.Decode(core.NewBinaryTxDecoder(r))
+	case CodecProto:
+		err = tx.Decode(core.NewProtoTxDecoder(r))
+	case CodecGob:
+		err = tx.Decode(core.NewGobTxDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return tx, err
+}
+
+func encodeBlock(c Codec, b *core.Block) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = b.Encode(core.NewBinaryBlockEncoder(buf))
+	case CodecProto:
+		err = b.Encode(core.NewProtoBlockEncoder(buf))
+	case CodecGob:
+		err = b.Encode(core.NewGobBlockEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeBlock(c Codec, data []byte) (*core.Block, error) {
+	b := new(core.Block)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = b.Decode(core.NewBinaryBlockDecoder(r))
+	case CodecProto:
+		err = b.Decode(core.NewProtoBlockDecoder(r))
+	case CodecGob:
+		err = b.Decode(core.NewGobBlockDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return b, err
+}
+
+func encodeProposal(c Codec, p *core.Proposal) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = p.Encode(core.NewBinaryProposalEncoder(buf))
+	case CodecProto:
+		err = p.Encode(core.NewProtoProposalEncoder(buf))
+	case CodecGob:
+		err = p.Encode(core.NewGobProposalEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeProposal(c Codec, data []byte) (*core.Proposal, error) {
+	p := new(core.Proposal)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = p.Decode(core.NewBinaryProposalDecoder(r))
+	case CodecProto:
+		err = p.Decode(core.NewProtoProposalDecoder(r))
+	case CodecGob:
+		err = p.Decode(core.NewGobProposalDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return p, err
+}
+
+func encodeVote(c Codec, v *core.Vote) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = v.Encode(core.NewBinaryVoteEncoder(buf))
+	case CodecProto:
+		err = v.Encode(core.NewProtoVoteEncoder(buf))
+	case CodecGob:
+		err = v.Encode(core.NewGobVoteEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeVote(c Codec, data []byte) (*core.Vote, error) {
+	v := new(core.Vote)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = v.Decode(core.NewBinaryVoteDecoder(r))
+	case CodecProto:
+		err = v.Decode(core.NewProtoVoteDecoder(r))
+	case CodecGob:
+		err = v.Decode(core.NewGobVoteDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return v, err
+}
+
+func encodeCommit(c Codec, cert *core.CommitCertificate) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = cert.Encode(core.NewBinaryCommitEncoder(buf))
+	case CodecProto:
+		err = cert.Encode(core.NewProtoCommitEncoder(buf))
+	case CodecGob:
+		err = cert.Encode(core.NewGobCommitEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeCommit(c Codec, data []byte) (*core.CommitCertificate, error) {
+	cert := new(core.CommitCertificate)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = cert.Decode(core.NewBinaryCommitDecoder(r))
+	case CodecProto:
+		err = cert.Decode(core.NewProtoCommitDecoder(r))
+	case CodecGob:
+		err = cert.Decode(core.NewGobCommitDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return cert, err
+}
+
+func encodeLightHeader(c Codec, h *core.LightHeader) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = h.Encode(core.NewBinaryLightHeaderEncoder(buf))
+	case CodecProto:
+		err = h.Encode(core.NewProtoLightHeaderEncoder(buf))
+	case CodecGob:
+		err = h.Encode(core.NewGobLightHeaderEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeLightHeader(c Codec, data []byte) (*core.LightHeader, error) {
+	h := new(core.LightHeader)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = h.Decode(core.NewBinaryLightHeaderDecoder(r))
+	case CodecProto:
+		err = h.Decode(core.NewProtoLightHeaderDecoder(r))
+	case CodecGob:
+		err = h.Decode(core.NewGobLightHeaderDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return h, err
+}
+
+func encodeTxProof(c Codec, p *core.TxProof) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = p.Encode(core.NewBinaryTxProofEncoder(buf))
+	case CodecProto:
+		err = p.Encode(core.NewProtoTxProofEncoder(buf))
+	case CodecGob:
+		err = p.Encode(core.NewGobTxProofEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeTxProof(c Codec, data []byte) (*core.TxProof, error) {
+	p := new(core.TxProof)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = p.Decode(core.NewBinaryTxProofDecoder(r))
+	case CodecProto:
+		err = p.Decode(core.NewProtoTxProofDecoder(r))
+	case CodecGob:
+		err = p.Decode(core.NewGobTxProofDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return p, err
+}
+
+func encodeAccountProof(c Codec, p *core.AccountProof) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = p.Encode(core.NewBinaryAccountProofEncoder(buf))
+	case CodecProto:
+		err = p.Encode(core.NewProtoAccountProofEncoder(buf))
+	case CodecGob:
+		err = p.Encode(core.NewGobAccountProofEncoder(buf))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return buf.Bytes(), err
+}
+
+func decodeAccountProof(c Codec, data []byte) (*core.AccountProof, error) {
+	p := new(core.AccountProof)
+	r := bytes.NewReader(data)
+
+	var err error
+	switch c {
+	case CodecBinary:
+		err = p.Decode(core.NewBinaryAccountProofDecoder(r))
+	case CodecProto:
+		err = p.Decode(core.NewProtoAccountProofDecoder(r))
+	case CodecGob:
+		err = p.Decode(core.NewGobAccountProofDecoder(r))
+	default:
+		err = fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return p, err
+}
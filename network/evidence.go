@@ -0,0 +1,72 @@
+package network
+
+import (
+	"sync"
+
+	"project-bee/core"
+	"project-bee/types"
+)
+
+// 只保留最近这么多个高度的区块头, 更早的双签在链上已经不能被罚没
+const evidenceWindow = 100
+
+type evidenceSlot struct {
+	validator types.Address
+	height    uint32
+	round     uint32
+}
+
+// EvidencePool 记录从网络收到的每个验证者在每个高度和轮次签名的第一个区块头,
+// 收到同一位置上不同的区块头时生成双签证据
+type EvidencePool struct {
+	lock     sync.Mutex
+	headers  map[evidenceSlot]core.SignedHeader
+	reported map[evidenceSlot]bool
+}
+
+func NewEvidencePool() *EvidencePool {
+	return &EvidencePool{
+		headers:  make(map[evidenceSlot]core.SignedHeader),
+		reported: make(map[evidenceSlot]bool),
+	}
+}
+
+// Add 记录区块 b 的签名区块头, 与之前看到的区块头冲突并且还没有报告过时返回证据
+// 签名无效的区块头被忽略, height 是本地链的高度, 用来清理旧的记录
+func (p *EvidencePool) Add(b *core.Block, height uint32) (core.EvidenceTx, bool) {
+	header := core.SignedHeaderOf(b)
+	if header.Verify() != nil {
+		return core.EvidenceTx{}, false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.prune(height)
+	if b.Height+evidenceWindow < height {
+		return core.EvidenceTx{}, false
+	}
+
+	slot := evidenceSlot{validator: b.Validator.Address(), height: b.Height, round: b.Round}
+	first, ok := p.headers[slot]
+	if !ok {
+		p.headers[slot] = header
+		return core.EvidenceTx{}, false
+	}
+
+	if first.Hash() == header.Hash() || p.reported[slot] {
+		return core.EvidenceTx{}, false
+	}
+	p.reported[slot] = true
+
+	return core.NewEvidence(first, header), true
+}
+
+func (p *EvidencePool) prune(height uint32) {
+	for slot := range p.headers {
+		if slot.height+evidenceWindow < height {
+			delete(p.headers, slot)
+			delete(p.reported, slot)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"testing"
+
+	"project-bee/core"
+	"project-bee/crypto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signedBlock(t *testing.T, key crypto.PrivateKey, height uint32, timestamp int64) *core.Block {
+	b, err := core.NewBlock(&core.Header{Version: 1, Height: height, Timestamp: timestamp}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(key))
+	return b
+}
+
+func TestEvidencePoolDetectsDoubleSign(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	p := NewEvidencePool()
+
+	a := signedBlock(t, key, 5, 100)
+	_, ok := p.Add(a, 4)
+	assert.False(t, ok)
+	_, ok = p.Add(a, 4)
+	assert.False(t, ok)
+
+	// 其他验证者在同一高度签名不算冲突
+	_, ok = p.Add(signedBlock(t, crypto.GeneratePrivateKey(), 5, 200), 4)
+	assert.False(t, ok)
+
+	b := signedBlock(t, key, 5, 200)
+	evidence, ok := p.Add(b, 4)
+	assert.True(t, ok)
+	assert.Nil(t, evidence.Verify(0))
+	assert.Equal(t, key.PublicKey(), evidence.Offender())
+
+	// 每个位置只报告一次
+	_, ok = p.Add(signedBlock(t, key, 5, 300), 4)
+	assert.False(t, ok)
+
+	// 签名无效的区块头被忽略
+	c := signedBlock(t, key, 6, 100)
+	c.Timestamp++
+	_, ok = p.Add(c, 4)
+	assert.False(t, ok)
+	_, ok = p.Add(signedBlock(t, key, 6, 200), 4)
+	assert.False(t, ok)
+}
+
+func TestEvidencePoolPrunes(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	p := NewEvidencePool()
+
+	_, ok := p.Add(signedBlock(t, key, 1, 100), 1)
+	assert.False(t, ok)
+
+	// 超出窗口的记录被清理, 旧高度的区块也不再记录
+	_, ok = p.Add(signedBlock(t, key, 1, 200), evidenceWindow+2)
+	assert.False(t, ok)
+	assert.Len(t, p.headers, 0)
+}
@@ -0,0 +1,364 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"project-bee/core"
+	"project-bee/types"
+)
+
+var (
+	ErrNoFullPeer    = errors.New("no full node peer to request proofs from")
+	ErrProofNotFound = errors.New("proof not found by the full node")
+	ErrProofTimeout  = errors.New("timed out waiting for proof")
+)
+
+// 一个 HeadersMessage 中最多的区块头数量
+const maxHeadersPerMessage = 1000
+
+// 轻节点等待全节点回复证明的时间
+var proofTimeout = 5 * time.Second
+
+func newLightServer(opts ServerOpts) (*Server, error) {
+	// 轻节点不参与共识, BFT 模式下只用区块头附带的证书确定最终性
+	if opts.PrivateKey != nil {
+		return nil, fmt.Errorf("light server (%s) cannot produce blocks", opts.ID)
+	}
+
+	light, err := core.NewLightChain(opts.Logger, opts.Genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	peerCh := make(chan *TCPPeer)
+	tr := NewTCPTransport(opts.ListenAddr, peerCh)
+
+	s := &Server{
+		TCPTransport: tr,
+		peerCh:       peerCh,
+		peerMap:      make(map[net.Addr]*TCPPeer),
+		peerCodecs:   make(map[net.Addr]Codec),
+		peerLight:    make(map[net.Addr]bool),
+		peerRetained: make(map[net.Addr]uint32),
+		scores:       newPeerScores(),
+		ServerOpts:   opts,
+		light:        light,
+		proofs:       newProofWaiters(),
+		rpcCh:        make(chan RPC),
+		quitCh:       make(chan struct{}, 1),
+		txChan:       make(chan *core.Transaction),
+	}
+
+	if s.RPCProcessor == nil {
+		s.RPCProcessor = s
+	}
+
+	if len(opts.APIListener) > 0 {
+		s.Logger.Log("msg", "light server does not serve the JSON API", "port", opts.APIListener)
+	}
+
+	return s, nil
+}
+
+// LightChain 返回轻节点同步的区块头, 全节点返回 nil
+func (s *Server) LightChain() *core.LightChain {
+	return s.light
+}
+
+// 轻节点只处理状态, 区块头和证明消息
+func (s *Server) processLightMessage(msg *DecodedMessage) error {
+	switch t := msg.Data.(type) {
+	case *GetStatusMessage:
+		return s.processGetStatusMessage(msg.From, msg.Codec)
+	case *StatusMessage:
+		return s.processStatusMessage(msg.From, t)
+	case *HeadersMessage:
+		return s.processHeadersMessage(msg.From, t)
+	case *ProofMessage:
+		return s.processProofMessage(msg.From, t)
+	}
+
+	return nil
+}
+
+// processGetHeadersMessage 回复从 From 开始的区块头, 每次最多 maxHeadersPerMessage 个
+func (s *Server) processGetHeadersMessage(from net.Addr, data *GetHeadersMessage) error {
+	s.Logger.Log("msg", "received getHeaders message", "from", from)
+
+	to := s.chain.Height()
+	if data.To != 0 && data.To < to {
+		to = data.To
+	}
+
+	headers := []*core.LightHeader{}
+	for height := data.From; height <= to && len(headers) < maxHeadersPerMessage; height++ {
+		h, err := s.chain.GetLightHeader(height)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, h)
+	}
+
+	return s.sendTo(from, MessageTypeHeaders, (&HeadersMessage{Headers: headers}).Encode)
+}
+
+// processGetProofMessage 回复交易或者账户的证明, 找不到交易或者账户时回复没有证明的消息
+func (s *Server) processGetProofMessage(from net.Addr, data *GetProofMessage) error {
+	reply := &ProofMessage{Type: data.Type, Key: data.Key}
+
+	switch data.Type {
+	case ProofTypeTx:
+		if len(data.Key) != 32 {
+			return fmt.Errorf("tx proof request with key of (%d) bytes", len(data.Key))
+		}
+		// 交易可能还没有上链
+		reply.TxProof, _ = s.chain.GetTxProof(types.HashFromBytes(data.Key))
+	case ProofTypeAccount:
+		if len(data.Key) != 20 {
+			return fmt.Errorf("account proof request with key of (%d) bytes", len(data.Key))
+		}
+		// 状态树只能证明存在的账户, 账户不存在时回复没有证明的消息, 轻节点不用等到超时
+		proof, err := s.chain.GetAccountProof(types.AddressFromBytes(data.Key))
+		if err != nil && !errors.Is(err, core.ErrAccountNotFound) {
+			return err
+		}
+		reply.AccountProof = proof
+	default:
+		return fmt.Errorf("proof request with type (%s)", data.Type)
+	}
+
+	return s.sendTo(from, MessageTypeProof, reply.Encode)
+}
+
+// processHeadersMessage 按顺序把区块头加入轻节点的链, 已经同步的区块头被跳过
+// 对方的链在还不是最终的高度分叉时, 从最终高度之后重新请求区块头, 由 AddHeader 切换到对方的链
+func (s *Server) processHeadersMessage(from net.Addr, data *HeadersMessage) error {
+	for _, h := range data.Headers {
+		if err := s.light.AddHeader(h); err != nil {
+			if errors.Is(err, core.ErrBlockKnown) {
+				continue
+			}
+
+			finalized := s.light.FinalizedHeight()
+			if errors.Is(err, core.ErrUnknownParent) && h.Header.Height > finalized+1 {
+				s.Logger.Log("msg", "light chain forked from peer", "height", h.Header.Height, "finalized", finalized, "peer", from)
+				return s.sendTo(from, MessageTypeGetHeaders, (&GetHeadersMessage{From: finalized + 1}).Encode)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// processProofMessage 用同步的区块头校验证明, 把结果交给等待的请求
+func (s *Server) processProofMessage(from net.Addr, data *ProofMessage) error {
+	key := proofKey(data.Type, data.Key)
+
+	var err error
+	switch {
+	case data.TxProof != nil:
+		if data.Type != ProofTypeTx || string(data.TxProof.TxHash[:]) != string(data.Key) {
+			err = fmt.Errorf("tx proof for (%s) => requested (%x): %w", data.TxProof.TxHash, data.Key, core.ErrInvalidProof)
+			break
+		}
+		err = s.light.VerifyTxProof(data.TxProof)
+	case data.AccountProof != nil:
+		if data.Type != ProofTypeAccount || data.AccountProof.Account == nil ||
+			string(data.AccountProof.Account.Address[:]) != string(data.Key) {
+			err = fmt.Errorf("account proof => requested (%x): %w", data.Key, core.ErrInvalidProof)
+			break
+		}
+		err = s.light.VerifyAccountProof(data.AccountProof)
+	default:
+		err = fmt.Errorf("%s proof for (%x): %w", data.Type, data.Key, ErrProofNotFound)
+	}
+
+	if !s.proofs.deliver(key, proofResult{msg: data, err: err}) {
+		return nil
+	}
+
+	// 证明对应的区块头可能还没有同步, 不是对方的错误
+	if errors.Is(err, core.ErrInvalidProof) {
+		return err
+	}
+
+	return nil
+}
+
+// RequestTxProof 向一个全节点请求交易的证明, 返回的证明已经用同步的区块头校验
+func (s *Server) RequestTxProof(hash types.Hash) (*core.TxProof, error) {
+	res, err := s.requestProof(ProofTypeTx, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return res.TxProof, nil
+}
+
+// RequestAccountProof 向一个全节点请求账户在它最新区块中的状态和证明
+// 轻节点还没有同步这个区块头时返回 core.ErrUnknownHeader, 账户不存在时返回 ErrProofNotFound
+func (s *Server) RequestAccountProof(address types.Address) (*core.AccountProof, error) {
+	res, err := s.requestProof(ProofTypeAccount, address[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return res.AccountProof, nil
+}
+
+func (s *Server) requestProof(t ProofType, key []byte) (*ProofMessage, error) {
+	if s.light == nil {
+		return nil, fmt.Errorf("server (%s) is not a light node", s.ID)
+	}
+
+	peer, ok := s.fullPeer()
+	if !ok {
+		return nil, ErrNoFullPeer
+	}
+
+	ch := s.proofs.add(proofKey(t, key))
+	defer s.proofs.remove(proofKey(t, key), ch)
+
+	if err := s.sendTo(peer, MessageTypeGetProof, (&GetProofMessage{Type: t, Key: key}).Encode); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return res.msg, nil
+	case <-time.After(proofTimeout):
+		return nil, fmt.Errorf("%s proof for (%x) from peer %s: %w", t, key, peer, ErrProofTimeout)
+	}
+}
+
+// fullPeer 返回一个已经交换过状态的全节点
+func (s *Server) fullPeer() (net.Addr, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.codecLock.RLock()
+	defer s.codecLock.RUnlock()
+
+	for addr := range s.peerMap {
+		if light, ok := s.peerLight[addr]; ok && !light {
+			return addr, true
+		}
+	}
+
+	return nil, false
+}
+
+// sendTo 用与节点协商的编码发送消息
+func (s *Server) sendTo(addr net.Addr, t MessageType, encode func(Codec) ([]byte, error)) error {
+	codec := s.peerCodec(addr)
+	payload, err := encode(codec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	peer, ok := s.peerMap[addr]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("peer %s not known", addr)
+	}
+
+	return peer.Send(NewMessage(t, codec, payload).Bytes())
+}
+
+func (s *Server) requestHeadersLoop(peer net.Addr) error {
+	ticker := time.NewTicker(3 * time.Second)
+
+	for {
+		ourHeight := s.light.Height()
+
+		s.Logger.Log("msg", "requesting new headers", "requesting height", ourHeight+1)
+
+		getHeaders := &GetHeadersMessage{From: ourHeight + 1}
+		if err := s.sendTo(peer, MessageTypeGetHeaders, getHeaders.Encode); err != nil {
+			s.Logger.Log("error", "failed to send to peer", "err", err, "peer", peer)
+			return err
+		}
+
+		<-ticker.C
+	}
+}
+
+type proofResult struct {
+	msg *ProofMessage
+	err error
+}
+
+func proofKey(t ProofType, key []byte) string {
+	return string(append([]byte{byte(t)}, key...))
+}
+
+// proofWaiters 记录等待证明的请求, 同一个证明的多个请求都会收到结果
+type proofWaiters struct {
+	lock    sync.Mutex
+	waiters map[string][]chan proofResult
+}
+
+func newProofWaiters() *proofWaiters {
+	return &proofWaiters{
+		waiters: make(map[string][]chan proofResult),
+	}
+}
+
+func (w *proofWaiters) add(key string) chan proofResult {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	ch := make(chan proofResult, 1)
+	w.waiters[key] = append(w.waiters[key], ch)
+
+	return ch
+}
+
+func (w *proofWaiters) remove(key string, ch chan proofResult) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	chans := w.waiters[key]
+	for i, c := range chans {
+		if c == ch {
+			chans = append(chans[:i:i], chans[i+1:]...)
+			break
+		}
+	}
+
+	if len(chans) == 0 {
+		delete(w.waiters, key)
+	} else {
+		w.waiters[key] = chans
+	}
+}
+
+// deliver 把结果交给等待 key 的请求, 没有请求在等待时返回 false
+func (w *proofWaiters) deliver(key string, res proofResult) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	chans, ok := w.waiters[key]
+	if !ok {
+		return false
+	}
+
+	for _, ch := range chans {
+		select {
+		case ch <- res:
+		default:
+		}
+	}
+	delete(w.waiters, key)
+
+	return true
+}
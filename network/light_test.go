@@ -0,0 +1,217 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"project-bee/core"
+	"project-bee/crypto"
+	"project-bee/types"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// 用 net.Pipe 连接两个节点, 每个节点在自己的 goroutine 中处理收到的消息
+func linkServers(t *testing.T, a, b *Server) (*TCPPeer, *TCPPeer) {
+	connA, connB := net.Pipe()
+	t.Cleanup(func() {
+		connA.Close()
+		connB.Close()
+	})
+
+	peerA, peerB := &TCPPeer{conn: connA}, &TCPPeer{conn: connB}
+	a.peerMap[connA.RemoteAddr()] = peerA
+	b.peerMap[connB.RemoteAddr()] = peerB
+
+	// net.Pipe 没有缓冲, 读和处理分开, 避免两边同时发送时互相等待
+	serve := func(s *Server, conn net.Conn) {
+		msgCh := make(chan *DecodedMessage, 100)
+		go func() {
+			defer close(msgCh)
+			for {
+				msg, err := DefaultRPCDecodeFunc(RPC{From: conn.RemoteAddr(), Payload: conn})
+				if err != nil {
+					return
+				}
+				msgCh <- msg
+			}
+		}()
+		for msg := range msgCh {
+			s.ProcessMessage(msg)
+		}
+	}
+	go serve(a, connA)
+	go serve(b, connB)
+
+	return peerA, peerB
+}
+
+func TestLightServerSyncsHeadersAndProofs(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	g := &core.Genesis{
+		ChainID:    1,
+		Timestamp:  1000,
+		Validators: []string{key.PublicKey().String()},
+		Alloc:      map[string]uint64{key.PublicKey().Address().String(): 1000},
+	}
+
+	full, err := NewServer(ServerOpts{ID: "FULL", Logger: log.NewNopLogger(), Genesis: g})
+	assert.Nil(t, err)
+	light, err := NewServer(ServerOpts{ID: "LIGHT", Logger: log.NewNopLogger(), Genesis: g, Light: true})
+	assert.Nil(t, err)
+	assert.Nil(t, full.LightChain())
+
+	_, err = NewServer(ServerOpts{Genesis: g, Light: true, PrivateKey: &key})
+	assert.NotNil(t, err)
+
+	tx := core.NewTransaction([]byte("light"))
+	tx.ChainID = 1
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	for i := 0; i < 3; i++ {
+		header, err := full.chain.GetHeader(full.chain.Height())
+		assert.Nil(t, err)
+
+		txs := []*core.Transaction{}
+		if i == 1 {
+			txs = append(txs, tx)
+		}
+		b, err := core.NewBlockFromPrevHeader(header, txs)
+		assert.Nil(t, err)
+		assert.Nil(t, full.chain.SealBlock(b, key))
+		assert.Nil(t, full.chain.AddBlock(b))
+	}
+
+	// 没有全节点时不能请求证明
+	_, err = light.RequestTxProof(tx.Hash(core.TxHasher{}))
+	assert.ErrorIs(t, err, ErrNoFullPeer)
+
+	toFull, toLight := linkServers(t, light, full)
+	assert.Nil(t, light.sendGetStatusMessage(toFull))
+	assert.Nil(t, full.sendGetStatusMessage(toLight))
+
+	assert.Eventually(t, func() bool {
+		return light.LightChain().Height() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 全节点不向轻节点请求区块
+	full.codecLock.RLock()
+	assert.True(t, full.peerLight[toLight.conn.RemoteAddr()])
+	full.codecLock.RUnlock()
+
+	txProof, err := light.RequestTxProof(tx.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, tx.Hash(core.TxHasher{}), txProof.TxHash)
+	assert.Equal(t, uint32(2), txProof.Header.Height)
+
+	_, err = light.RequestTxProof(types.Hash{0x01})
+	assert.ErrorIs(t, err, ErrProofNotFound)
+
+	accProof, err := light.RequestAccountProof(key.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), accProof.Account.Balance)
+	assert.Equal(t, uint32(3), accProof.Header.Height)
+
+	// 不存在的账户立即回复没有证明, 不用等到超时
+	start := time.Now()
+	_, err = light.RequestAccountProof(types.Address{0x01})
+	assert.ErrorIs(t, err, ErrProofNotFound)
+	assert.Less(t, time.Since(start), proofTimeout)
+}
+
+func TestLightServerFollowsPeerFork(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	g := &core.Genesis{
+		ChainID:    1,
+		Timestamp:  1000,
+		Validators: []string{key.PublicKey().String()},
+	}
+
+	light, err := NewServer(ServerOpts{ID: "LIGHT", Logger: log.NewNopLogger(), Genesis: g, Light: true})
+	assert.Nil(t, err)
+	chainA, err := core.NewBlockchainFromGenesis(log.NewNopLogger(), core.NewMemorystore(), g)
+	assert.Nil(t, err)
+	chainB, err := core.NewBlockchainFromGenesis(log.NewNopLogger(), core.NewMemorystore(), g)
+	assert.Nil(t, err)
+
+	// 两条链共用区块 1, 之后分叉
+	for i := 0; i < 3; i++ {
+		b, err := buildTestBlock(chainA, 0, key)
+		assert.Nil(t, err)
+		assert.Nil(t, chainA.AddBlock(b))
+		if i == 0 {
+			assert.Nil(t, chainB.AddBlock(b))
+		}
+	}
+	for i := 0; i < 3; i++ {
+		b, err := buildTestBlock(chainB, 0, key)
+		assert.Nil(t, err)
+		assert.Nil(t, chainB.AddBlock(b))
+	}
+
+	lightHeaders := func(chain *core.Blockchain, from, to uint32) *HeadersMessage {
+		msg := &HeadersMessage{}
+		for height := from; height <= to; height++ {
+			lh, err := chain.GetLightHeader(height)
+			assert.Nil(t, err)
+			msg.Headers = append(msg.Headers, lh)
+		}
+		return msg
+	}
+
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+	peer := conn.RemoteAddr()
+	light.peerMap[peer] = &TCPPeer{conn: conn}
+
+	assert.Nil(t, light.processHeadersMessage(peer, lightHeaders(chainA, 1, 3)))
+	assert.Equal(t, uint32(3), light.LightChain().Height())
+
+	// 对方的区块头接不上时从最终高度之后重新请求
+	go light.processHeadersMessage(peer, lightHeaders(chainB, 4, 4))
+	msg, err := DefaultRPCDecodeFunc(RPC{Payload: remote})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), msg.Data.(*GetHeadersMessage).From)
+
+	assert.Nil(t, light.processHeadersMessage(peer, lightHeaders(chainB, 1, 4)))
+	assert.Equal(t, uint32(4), light.LightChain().Height())
+	header, err := light.LightChain().GetHeader(4)
+	assert.Nil(t, err)
+	expected, err := chainB.GetHeader(4)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, header)
+}
+
+func TestLightServerRejectsInvalidProof(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	g := &core.Genesis{
+		ChainID:    1,
+		Timestamp:  1000,
+		Validators: []string{key.PublicKey().String()},
+	}
+
+	light, err := NewServer(ServerOpts{ID: "LIGHT", Logger: log.NewNopLogger(), Genesis: g, Light: true})
+	assert.Nil(t, err)
+
+	hash := types.Hash{0x01}
+	ch := light.proofs.add(proofKey(ProofTypeTx, hash[:]))
+
+	// 证明的交易与请求的不同
+	msg := &ProofMessage{
+		Type:    ProofTypeTx,
+		Key:     hash[:],
+		TxProof: &core.TxProof{TxHash: types.Hash{0x02}},
+	}
+	err = light.ProcessMessage(&DecodedMessage{Data: msg})
+	assert.ErrorIs(t, err, core.ErrInvalidProof)
+	assert.Equal(t, 50, misbehaviorPenalty(err))
+
+	res := <-ch
+	assert.ErrorIs(t, res.err, core.ErrInvalidProof)
+
+	// 没有请求时忽略
+	assert.Nil(t, light.ProcessMessage(&DecodedMessage{Data: msg}))
+}
@@ -1,6 +1,21 @@
 package network
 
-import "project-bee/core"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"project-bee/core"
+	"project-bee/proto"
+	"project-bee/types"
+)
+
+// 单个消息的最大长度
+const maxMessageSize = 1 << 24
+
+// 消息的 protobuf 定义见 proto/network.proto
 
 type GetBlocksMessage struct {
 	From uint32
@@ -8,8 +23,201 @@ type GetBlocksMessage struct {
 	To uint32
 }
 
+// binary 编码: [From][To]
+func (m *GetBlocksMessage) Encode(c Codec) ([]byte, error) {
+	switch c {
+	case CodecBinary:
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint32(buf, m.From)
+		binary.BigEndian.PutUint32(buf[4:], m.To)
+		return buf, nil
+	case CodecProto:
+		buf := &proto.Buffer{}
+		buf.Uint32(1, m.From)
+		buf.Uint32(2, m.To)
+		return buf.Result(), nil
+	default:
+		return gobEncode(c, m)
+	}
+}
+
+func (m *GetBlocksMessage) Decode(c Codec, data []byte) error {
+	switch c {
+	case CodecBinary:
+		return binary.Read(bytes.NewReader(data), binary.BigEndian, m)
+	case CodecProto:
+		*m = GetBlocksMessage{}
+		return proto.Parse(data, func(f proto.Field) (err error) {
+			switch f.Num {
+			case 1:
+				m.From, err = f.Uint32()
+			case 2:
+				m.To, err = f.Uint32()
+			}
+			return err
+		})
+	default:
+		return gobDecode(c, data, m)
+	}
+}
+
 type BlocksMessage struct {
 	Blocks []*core.Block
+	// Commits[i] 是 Blocks[i] 的最终性证书, 区块还不是最终的时候为 nil
+	Commits []*core.CommitCertificate
+}
+
+// binary 编码: [u32 区块数]([u32 长度][区块])...[u32 证书数]([u32 长度][证书])...
+// 证书部分可以省略, 长度为 0 的证书表示没有
+func (m *BlocksMessage) Encode(c Codec) ([]byte, error) {
+	switch c {
+	case CodecBinary:
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.BigEndian, uint32(len(m.Blocks)))
+		for _, b := range m.Blocks {
+			data, err := encodeBlock(c, b)
+			if err != nil {
+				return nil, err
+			}
+			binary.Write(buf, binary.BigEndian, uint32(len(data)))
+			buf.Write(data)
+		}
+		binary.Write(buf, binary.BigEndian, uint32(len(m.Commits)))
+		for _, cert := range m.Commits {
+			var data []byte
+			if cert != nil {
+				var err error
+				if data, err = encodeCommit(c, cert); err != nil {
+					return nil, err
+				}
+			}
+			binary.Write(buf, binary.BigEndian, uint32(len(data)))
+			buf.Write(data)
+		}
+		return buf.Bytes(), nil
+	case CodecProto:
+		buf := &proto.Buffer{}
+		for _, b := range m.Blocks {
+			data, err := encodeBlock(c, b)
+			if err != nil {
+				return nil, err
+			}
+			buf.Message(1, data)
+		}
+		for _, cert := range m.Commits {
+			var data []byte
+			if cert != nil {
+				var err error
+				if data, err = encodeCommit(c, cert); err != nil {
+					return nil, err
+				}
+			}
+			buf.Message(2, data)
+		}
+		return buf.Result(), nil
+	default:
+		// gob 不能编码 slice 中的 nil
+		commits := make([]*core.CommitCertificate, len(m.Commits))
+		for i, cert := range m.Commits {
+			commits[i] = cert
+			if cert == nil {
+				commits[i] = &core.CommitCertificate{}
+			}
+		}
+		return gobEncode(c, &BlocksMessage{Blocks: m.Blocks, Commits: commits})
+	}
+}
+
+func (m *BlocksMessage) Decode(c Codec, data []byte) error {
+	*m = BlocksMessage{}
+
+	switch c {
+	case CodecBinary:
+		r := bytes.NewReader(data)
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			blockData, err := readSized(r)
+			if err != nil {
+				return err
+			}
+			b, err := decodeBlock(c, blockData)
+			if err != nil {
+				return err
+			}
+			m.Blocks = append(m.Blocks, b)
+		}
+		if r.Len() == 0 {
+			return nil
+		}
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			certData, err := readSized(r)
+			if err != nil {
+				return err
+			}
+			if err := m.addCommit(c, certData); err != nil {
+				return err
+			}
+		}
+		return nil
+	case CodecProto:
+		return proto.Parse(data, func(f proto.Field) error {
+			if f.Num != 1 && f.Num != 2 {
+				return nil
+			}
+			raw, err := f.Raw()
+			if err != nil {
+				return err
+			}
+			if f.Num == 2 {
+				return m.addCommit(c, raw)
+			}
+			b, err := decodeBlock(c, raw)
+			if err != nil {
+				return err
+			}
+			m.Blocks = append(m.Blocks, b)
+			return nil
+		})
+	default:
+		if err := gobDecode(c, data, m); err != nil {
+			return err
+		}
+		for i, cert := range m.Commits {
+			if cert.BlockHash.IsZero() {
+				m.Commits[i] = nil
+			}
+		}
+		return nil
+	}
+}
+
+func (m *BlocksMessage) addCommit(c Codec, data []byte) error {
+	if len(data) == 0 {
+		m.Commits = append(m.Commits, nil)
+		return nil
+	}
+
+	cert, err := decodeCommit(c, data)
+	if err != nil {
+		return err
+	}
+	m.Commits = append(m.Commits, cert)
+
+	return nil
+}
+
+// CommitFor 返回第 i 个区块的证书
+func (m *BlocksMessage) CommitFor(i int) *core.CommitCertificate {
+	if i < len(m.Commits) {
+		return m.Commits[i]
+	}
+	return nil
 }
 
 type GetStatusMessage struct{}
@@ -18,4 +226,520 @@ type StatusMessage struct {
 	ID            string
 	Version       uint32
 	CurrentHeight uint32
+	// 创世 hash 不同的节点之间不同步
+	GenesisHash types.Hash
+	// 节点支持的 payload 编码, 按优先级排列
+	Codecs []Codec
+	// 轻节点只有区块头, 不能提供区块和证明
+	Light bool
+	// 裁剪节点保留区块体的最低高度, 只能提供 [RetainedFrom, CurrentHeight] 的区块, 0 表示保留全部区块
+	RetainedFrom uint32
+}
+
+// binary 编码: [u32 长度][ID][Version][CurrentHeight][GenesisHash][u32 长度][Codecs][Light][RetainedFrom]
+// 末尾的字段可以省略, 省略时为零值
+func (m *StatusMessage) Encode(c Codec) ([]byte, error) {
+	codecs := make([]byte, len(m.Codecs))
+	for i, codec := range m.Codecs {
+		codecs[i] = byte(codec)
+	}
+
+	switch c {
+	case CodecBinary:
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.BigEndian, uint32(len(m.ID)))
+		buf.WriteString(m.ID)
+		binary.Write(buf, binary.BigEndian, m.Version)
+		binary.Write(buf, binary.BigEndian, m.CurrentHeight)
+		buf.Write(m.GenesisHash.ToSlice())
+		binary.Write(buf, binary.BigEndian, uint32(len(codecs)))
+		buf.Write(codecs)
+		if m.Light || m.RetainedFrom > 0 {
+			light := byte(0)
+			if m.Light {
+				light = 1
+			}
+			buf.WriteByte(light)
+		}
+		if m.RetainedFrom > 0 {
+			binary.Write(buf, binary.BigEndian, m.RetainedFrom)
+		}
+		return buf.Bytes(), nil
+	case CodecProto:
+		buf := &proto.Buffer{}
+		buf.String(1, m.ID)
+		buf.Uint32(2, m.Version)
+		buf.Uint32(3, m.CurrentHeight)
+		buf.Bytes(4, m.GenesisHash.ToSlice())
+		values := make([]uint32, len(codecs))
+		for i, codec := range codecs {
+			values[i] = uint32(codec)
+		}
+		buf.PackedUint32(5, values)
+		buf.Bool(6, m.Light)
+		buf.Uint32(7, m.RetainedFrom)
+		return buf.Result(), nil
+	default:
+		return gobEncode(c, m)
+	}
+}
+
+func (m *StatusMessage) Decode(c Codec, data []byte) error {
+	*m = StatusMessage{}
+
+	switch c {
+	case CodecBinary:
+		r := bytes.NewReader(data)
+		id, err := readSized(r)
+		if err != nil {
+			return err
+		}
+		m.ID = string(id)
+		if err := binary.Read(r, binary.BigEndian, &m.Version); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &m.CurrentHeight); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, m.GenesisHash[:]); err != nil {
+			return err
+		}
+		codecs, err := readSized(r)
+		if err != nil {
+			return err
+		}
+		for _, codec := range codecs {
+			m.Codecs = append(m.Codecs, Codec(codec))
+		}
+		if r.Len() == 0 {
+			return nil
+		}
+		light, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if light > 1 {
+			return fmt.Errorf("status light flag (%d)", light)
+		}
+		m.Light = light == 1
+		if r.Len() == 0 {
+			return nil
+		}
+		return binary.Read(r, binary.BigEndian, &m.RetainedFrom)
+	case CodecProto:
+		return proto.Parse(data, func(f proto.Field) (err error) {
+			switch f.Num {
+			case 1:
+				var id []byte
+				id, err = f.Raw()
+				m.ID = string(id)
+			case 2:
+				m.Version, err = f.Uint32()
+			case 3:
+				m.CurrentHeight, err = f.Uint32()
+			case 4:
+				var hash []byte
+				if hash, err = f.Raw(); err == nil {
+					if len(hash) != len(m.GenesisHash) {
+						return fmt.Errorf("genesis hash of (%d) bytes: %w", len(hash), proto.ErrInvalidWire)
+					}
+					copy(m.GenesisHash[:], hash)
+				}
+			case 5:
+				var values []uint32
+				values, err = f.PackedUint32()
+				for _, v := range values {
+					if v > 0xff {
+						return fmt.Errorf("codec (%d): %w", v, proto.ErrInvalidWire)
+					}
+					m.Codecs = append(m.Codecs, Codec(v))
+				}
+			case 6:
+				m.Light, err = f.Bool()
+			case 7:
+				m.RetainedFrom, err = f.Uint32()
+			}
+			return err
+		})
+	default:
+		return gobDecode(c, data, m)
+	}
+}
+
+// ProposalMessage 的 payload 是 core.Proposal
+type ProposalMessage struct {
+	Proposal *core.Proposal
+}
+
+func (m *ProposalMessage) Encode(c Codec) ([]byte, error) {
+	return encodeProposal(c, m.Proposal)
+}
+
+func (m *ProposalMessage) Decode(c Codec, data []byte) error {
+	p, err := decodeProposal(c, data)
+	if err != nil {
+		return err
+	}
+	m.Proposal = p
+
+	return nil
+}
+
+type PrevoteMessage struct {
+	Vote *core.Vote
+}
+
+func (m *PrevoteMessage) Encode(c Codec) ([]byte, error) {
+	return encodeVote(c, m.Vote)
+}
+
+func (m *PrevoteMessage) Decode(c Codec, data []byte) error {
+	v, err := decodeTypedVote(c, data, core.VotePrevote)
+	if err != nil {
+		return err
+	}
+	m.Vote = v
+
+	return nil
+}
+
+type PrecommitMessage struct {
+	Vote *core.Vote
+}
+
+func (m *PrecommitMessage) Encode(c Codec) ([]byte, error) {
+	return encodeVote(c, m.Vote)
+}
+
+func (m *PrecommitMessage) Decode(c Codec, data []byte) error {
+	v, err := decodeTypedVote(c, data, core.VotePrecommit)
+	if err != nil {
+		return err
+	}
+	m.Vote = v
+
+	return nil
+}
+
+// 投票类型必须和消息类型一致
+func decodeTypedVote(c Codec, data []byte, t core.VoteType) (*core.Vote, error) {
+	v, err := decodeVote(c, data)
+	if err != nil {
+		return nil, err
+	}
+	if v.Type != t {
+		return nil, fmt.Errorf("%s in %s message: %w", v.Type, t, core.ErrInvalidVote)
+	}
+
+	return v, nil
+}
+
+// GetHeadersMessage 请求 [From, To] 的区块头, 轻节点用来同步
+type GetHeadersMessage GetBlocksMessage
+
+func (m *GetHeadersMessage) Encode(c Codec) ([]byte, error) {
+	return (*GetBlocksMessage)(m).Encode(c)
+}
+
+func (m *GetHeadersMessage) Decode(c Codec, data []byte) error {
+	return (*GetBlocksMessage)(m).Decode(c, data)
+}
+
+type HeadersMessage struct {
+	Headers []*core.LightHeader
+}
+
+// binary 编码: [u32 区块头数]([u32 长度][区块头])...
+func (m *HeadersMessage) Encode(c Codec) ([]byte, error) {
+	switch c {
+	case CodecBinary:
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.BigEndian, uint32(len(m.Headers)))
+		for _, h := range m.Headers {
+			data, err := encodeLightHeader(c, h)
+			if err != nil {
+				return nil, err
+			}
+			binary.Write(buf, binary.BigEndian, uint32(len(data)))
+			buf.Write(data)
+		}
+		return buf.Bytes(), nil
+	case CodecProto:
+		buf := &proto.Buffer{}
+		for _, h := range m.Headers {
+			data, err := encodeLightHeader(c, h)
+			if err != nil {
+				return nil, err
+			}
+			buf.Message(1, data)
+		}
+		return buf.Result(), nil
+	default:
+		return gobEncode(c, m)
+	}
+}
+
+func (m *HeadersMessage) Decode(c Codec, data []byte) error {
+	*m = HeadersMessage{}
+
+	switch c {
+	case CodecBinary:
+		r := bytes.NewReader(data)
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			headerData, err := readSized(r)
+			if err != nil {
+				return err
+			}
+			h, err := decodeLightHeader(c, headerData)
+			if err != nil {
+				return err
+			}
+			m.Headers = append(m.Headers, h)
+		}
+		return nil
+	case CodecProto:
+		return proto.Parse(data, func(f proto.Field) error {
+			if f.Num != 1 {
+				return nil
+			}
+			raw, err := f.Raw()
+			if err != nil {
+				return err
+			}
+			h, err := decodeLightHeader(c, raw)
+			if err != nil {
+				return err
+			}
+			m.Headers = append(m.Headers, h)
+			return nil
+		})
+	default:
+		return gobDecode(c, data, m)
+	}
+}
+
+type ProofType byte
+
+const (
+	// Key 是交易 hash
+	ProofTypeTx ProofType = iota
+	// Key 是账户地址, 证明针对对方最新区块的状态根
+	ProofTypeAccount
+)
+
+func (t ProofType) String() string {
+	switch t {
+	case ProofTypeTx:
+		return "tx"
+	case ProofTypeAccount:
+		return "account"
+	default:
+		return fmt.Sprintf("proof(%d)", byte(t))
+	}
+}
+
+// GetProofMessage 向全节点请求交易或者账户的 Merkle 证明
+type GetProofMessage struct {
+	Type ProofType
+	Key  []byte
+}
+
+// binary 编码: [Type][u32 长度][Key]
+func (m *GetProofMessage) Encode(c Codec) ([]byte, error) {
+	switch c {
+	case CodecBinary:
+		buf := new(bytes.Buffer)
+		buf.WriteByte(byte(m.Type))
+		binary.Write(buf, binary.BigEndian, uint32(len(m.Key)))
+		buf.Write(m.Key)
+		return buf.Bytes(), nil
+	case CodecProto:
+		buf := &proto.Buffer{}
+		buf.Uint32(1, uint32(m.Type))
+		buf.Bytes(2, m.Key)
+		return buf.Result(), nil
+	default:
+		return gobEncode(c, m)
+	}
+}
+
+func (m *GetProofMessage) Decode(c Codec, data []byte) error {
+	*m = GetProofMessage{}
+
+	switch c {
+	case CodecBinary:
+		r := bytes.NewReader(data)
+		t, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		m.Type = ProofType(t)
+		m.Key, err = readSized(r)
+		return err
+	case CodecProto:
+		return proto.Parse(data, func(f proto.Field) (err error) {
+			switch f.Num {
+			case 1:
+				var t uint32
+				t, err = f.Uint32()
+				m.Type = ProofType(t)
+			case 2:
+				m.Key, err = f.Raw()
+			}
+			return err
+		})
+	default:
+		return gobDecode(c, data, m)
+	}
+}
+
+// ProofMessage 是对 GetProofMessage 的回复, 全节点找不到交易时两个证明都为 nil
+type ProofMessage struct {
+	Type         ProofType
+	Key          []byte
+	TxProof      *core.TxProof
+	AccountProof *core.AccountProof
+}
+
+// binary 编码: [Type][u32 长度][Key][u32 长度][证明], 长度为 0 的证明表示没有
+func (m *ProofMessage) Encode(c Codec) ([]byte, error) {
+	var (
+		proof []byte
+		err   error
+	)
+	switch {
+	case m.TxProof != nil:
+		proof, err = encodeTxProof(c, m.TxProof)
+	case m.AccountProof != nil:
+		proof, err = encodeAccountProof(c, m.AccountProof)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case CodecBinary:
+		buf := new(bytes.Buffer)
+		buf.WriteByte(byte(m.Type))
+		binary.Write(buf, binary.BigEndian, uint32(len(m.Key)))
+		buf.Write(m.Key)
+		binary.Write(buf, binary.BigEndian, uint32(len(proof)))
+		buf.Write(proof)
+		return buf.Bytes(), nil
+	case CodecProto:
+		buf := &proto.Buffer{}
+		buf.Uint32(1, uint32(m.Type))
+		buf.Bytes(2, m.Key)
+		switch {
+		case m.TxProof != nil:
+			buf.Message(3, proof)
+		case m.AccountProof != nil:
+			buf.Message(4, proof)
+		}
+		return buf.Result(), nil
+	default:
+		return gobEncode(c, m)
+	}
+}
+
+func (m *ProofMessage) Decode(c Codec, data []byte) error {
+	*m = ProofMessage{}
+
+	switch c {
+	case CodecBinary:
+		r := bytes.NewReader(data)
+		t, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		m.Type = ProofType(t)
+		if m.Key, err = readSized(r); err != nil {
+			return err
+		}
+		proof, err := readSized(r)
+		if err != nil || len(proof) == 0 {
+			return err
+		}
+		return m.setProof(c, m.Type, proof)
+	case CodecProto:
+		return proto.Parse(data, func(f proto.Field) (err error) {
+			switch f.Num {
+			case 1:
+				var t uint32
+				t, err = f.Uint32()
+				m.Type = ProofType(t)
+			case 2:
+				m.Key, err = f.Raw()
+			case 3, 4:
+				var raw []byte
+				if raw, err = f.Raw(); err != nil {
+					return err
+				}
+				t := ProofTypeTx
+				if f.Num == 4 {
+					t = ProofTypeAccount
+				}
+				err = m.setProof(c, t, raw)
+			}
+			return err
+		})
+	default:
+		return gobDecode(c, data, m)
+	}
+}
+
+func (m *ProofMessage) setProof(c Codec, t ProofType, data []byte) (err error) {
+	switch t {
+	case ProofTypeTx:
+		m.TxProof, err = decodeTxProof(c, data)
+	case ProofTypeAccount:
+		m.AccountProof, err = decodeAccountProof(c, data)
+	default:
+		err = fmt.Errorf("proof message with type (%s)", t)
+	}
+
+	return err
+}
+
+func gobEncode(c Codec, v any) ([]byte, error) {
+	if c != CodecGob {
+		return nil, fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gobDecode(c Codec, data []byte, v any) error {
+	if c != CodecGob {
+		return fmt.Errorf("unknown codec (%s)", c)
+	}
+
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 读取 [u32 长度][内容]
+func readSized(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("message field of (%d) bytes exceeds (%d)", n, maxMessageSize)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
 }
@@ -0,0 +1,352 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"project-bee/core"
+	"project-bee/crypto"
+	"project-bee/types"
+	"project-bee/util"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeRPC(t *testing.T, msg *Message) *DecodedMessage {
+	decoded, err := DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(msg.Bytes())})
+	assert.Nil(t, err)
+	assert.Equal(t, msg.Codec, decoded.Codec)
+
+	return decoded
+}
+
+func TestStatusMessageRoundTrip(t *testing.T) {
+	status := &StatusMessage{
+		ID:            "LOCAL",
+		Version:       1,
+		CurrentHeight: 42,
+		GenesisHash:   types.Hash{0x01},
+		Codecs:        []Codec{CodecProto, CodecBinary},
+	}
+	getBlocks := &GetBlocksMessage{From: 3, To: 7}
+
+	for _, c := range DefaultCodecs {
+		payload, err := status.Encode(c)
+		assert.Nil(t, err)
+		decoded := decodeRPC(t, NewMessage(MessageTypeStatus, c, payload))
+		assert.Equal(t, status, decoded.Data)
+
+		payload, err = getBlocks.Encode(c)
+		assert.Nil(t, err)
+		decoded = decodeRPC(t, NewMessage(MessageTypeGetBlocks, c, payload))
+		assert.Equal(t, getBlocks, decoded.Data)
+
+		decoded = decodeRPC(t, NewMessage(MessageTypeGetStatus, c, nil))
+		assert.Equal(t, &GetStatusMessage{}, decoded.Data)
+	}
+}
+
+func TestLightStatusMessageRoundTrip(t *testing.T) {
+	status := &StatusMessage{
+		ID:            "LIGHT",
+		CurrentHeight: 7,
+		GenesisHash:   types.Hash{0x01},
+		Codecs:        []Codec{CodecBinary},
+		Light:         true,
+	}
+	getHeaders := &GetHeadersMessage{From: 3}
+
+	for _, c := range DefaultCodecs {
+		payload, err := status.Encode(c)
+		assert.Nil(t, err)
+		decoded := decodeRPC(t, NewMessage(MessageTypeStatus, c, payload))
+		assert.Equal(t, status, decoded.Data)
+
+		payload, err = getHeaders.Encode(c)
+		assert.Nil(t, err)
+		decoded = decodeRPC(t, NewMessage(MessageTypeGetHeaders, c, payload))
+		assert.Equal(t, getHeaders, decoded.Data)
+	}
+}
+
+func TestPrunedStatusMessageRoundTrip(t *testing.T) {
+	status := &StatusMessage{
+		ID:            "PRUNED",
+		CurrentHeight: 200,
+		GenesisHash:   types.Hash{0x01},
+		Codecs:        []Codec{CodecBinary},
+		RetainedFrom:  101,
+	}
+
+	for _, c := range DefaultCodecs {
+		payload, err := status.Encode(c)
+		assert.Nil(t, err)
+		decoded := decodeRPC(t, NewMessage(MessageTypeStatus, c, payload))
+		assert.Equal(t, status, decoded.Data)
+	}
+}
+
+func TestHeadersMessageRoundTrip(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b1 := util.NewRandomBlockWithSignature(t, privKey, 1, types.Hash{})
+	b2 := util.NewRandomBlockWithSignature(t, privKey, 2, b1.Hash(core.BlockHasher{}))
+
+	v := &core.Vote{Type: core.VotePrecommit, ChainID: 1, Height: 2, BlockHash: b2.Hash(core.BlockHasher{})}
+	assert.Nil(t, v.Sign(privKey))
+
+	headers := []*core.LightHeader{
+		{SignedHeader: core.SignedHeaderOf(b1)},
+		{
+			SignedHeader:   core.SignedHeaderOf(b2),
+			NextValidators: &core.ValidatorSet{Validators: []crypto.PublicKey{privKey.PublicKey()}, Weights: []uint64{3}},
+			Commit:         &core.CommitCertificate{Height: 2, BlockHash: v.BlockHash, Precommits: []*core.Vote{v}},
+		},
+	}
+
+	for _, c := range DefaultCodecs {
+		payload, err := (&HeadersMessage{Headers: headers}).Encode(c)
+		assert.Nil(t, err)
+
+		decoded := decodeRPC(t, NewMessage(MessageTypeHeaders, c, payload)).Data.(*HeadersMessage)
+		assert.Len(t, decoded.Headers, 2)
+		assert.Equal(t, b1.Hash(core.BlockHasher{}), decoded.Headers[0].Hash(), c)
+		assert.Nil(t, decoded.Headers[0].NextValidators, c)
+		assert.Nil(t, decoded.Headers[0].Commit, c)
+		assert.Nil(t, decoded.Headers[1].Verify(), c)
+		assert.Equal(t, headers[1].NextValidators, decoded.Headers[1].NextValidators, c)
+		assert.Nil(t, decoded.Headers[1].Commit.Verify(1, []crypto.PublicKey{privKey.PublicKey()}), c)
+	}
+}
+
+func TestProofMessageRoundTrip(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	g := &core.Genesis{
+		ChainID:    1,
+		Timestamp:  1000,
+		Validators: []string{privKey.PublicKey().String()},
+		Alloc:      map[string]uint64{privKey.PublicKey().Address().String(): 500},
+	}
+	chain, err := core.NewBlockchainFromGenesis(log.NewNopLogger(), core.NewMemorystore(), g)
+	assert.Nil(t, err)
+
+	address := privKey.PublicKey().Address()
+	accProof, err := chain.GetAccountProof(address)
+	assert.Nil(t, err)
+
+	b := util.NewRandomBlockWithSignature(t, privKey, 1, types.Hash{})
+	txProof, err := b.NewTxProof(0)
+	assert.Nil(t, err)
+
+	getProof := &GetProofMessage{Type: ProofTypeAccount, Key: address[:]}
+	messages := []*ProofMessage{
+		{Type: ProofTypeTx, Key: txProof.TxHash[:], TxProof: txProof},
+		{Type: ProofTypeAccount, Key: address[:], AccountProof: accProof},
+		{Type: ProofTypeTx, Key: []byte{0x01}},
+	}
+
+	for _, c := range DefaultCodecs {
+		payload, err := getProof.Encode(c)
+		assert.Nil(t, err)
+		decoded := decodeRPC(t, NewMessage(MessageTypeGetProof, c, payload))
+		assert.Equal(t, getProof, decoded.Data)
+
+		for _, msg := range messages {
+			payload, err := msg.Encode(c)
+			assert.Nil(t, err)
+
+			proof := decodeRPC(t, NewMessage(MessageTypeProof, c, payload)).Data.(*ProofMessage)
+			assert.Equal(t, msg.Type, proof.Type, c)
+			assert.Equal(t, msg.Key, proof.Key, c)
+			assert.Equal(t, msg.TxProof == nil, proof.TxProof == nil, c)
+			assert.Equal(t, msg.AccountProof == nil, proof.AccountProof == nil, c)
+			if proof.TxProof != nil {
+				assert.Nil(t, proof.TxProof.Verify(), c)
+			}
+			if proof.AccountProof != nil {
+				assert.Equal(t, uint64(500), proof.AccountProof.Account.Balance, c)
+				assert.Nil(t, proof.AccountProof.Verify(), c)
+			}
+		}
+	}
+}
+
+func TestBlocksMessageRoundTrip(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b1 := util.NewRandomBlockWithSignature(t, privKey, 1, types.Hash{})
+	b2 := util.NewRandomBlockWithSignature(t, privKey, 2, b1.Hash(core.BlockHasher{}))
+
+	for _, c := range DefaultCodecs {
+		payload, err := (&BlocksMessage{Blocks: []*core.Block{b1, b2}}).Encode(c)
+		assert.Nil(t, err)
+
+		decoded := decodeRPC(t, NewMessage(MessageTypeBlocks, c, payload))
+		blocks := decoded.Data.(*BlocksMessage).Blocks
+		assert.Equal(t, 2, len(blocks))
+		assert.Equal(t, b1.Hash(core.BlockHasher{}), blocks[0].Hash(core.BlockHasher{}))
+		assert.Equal(t, b2.Hash(core.BlockHasher{}), blocks[1].Hash(core.BlockHasher{}))
+		assert.Nil(t, blocks[1].Verify())
+	}
+}
+
+func TestBlocksMessageCommits(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b1 := util.NewRandomBlockWithSignature(t, privKey, 1, types.Hash{})
+	b2 := util.NewRandomBlockWithSignature(t, privKey, 2, b1.Hash(core.BlockHasher{}))
+
+	v := &core.Vote{Type: core.VotePrecommit, ChainID: 1, Height: 2, BlockHash: b2.Hash(core.BlockHasher{})}
+	assert.Nil(t, v.Sign(privKey))
+	commit := &core.CommitCertificate{Height: 2, BlockHash: v.BlockHash, Precommits: []*core.Vote{v}}
+
+	for _, c := range DefaultCodecs {
+		msg := &BlocksMessage{
+			Blocks:  []*core.Block{b1, b2},
+			Commits: []*core.CommitCertificate{nil, commit},
+		}
+		payload, err := msg.Encode(c)
+		assert.Nil(t, err)
+
+		decoded := decodeRPC(t, NewMessage(MessageTypeBlocks, c, payload)).Data.(*BlocksMessage)
+		assert.Nil(t, decoded.CommitFor(0), c)
+		assert.Nil(t, decoded.CommitFor(1).Verify(1, []crypto.PublicKey{privKey.PublicKey()}), c)
+		assert.Nil(t, decoded.CommitFor(2), c)
+	}
+}
+
+func TestConsensusMessageRoundTrip(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := util.NewRandomBlockWithSignature(t, privKey, 1, types.Hash{})
+	p := &core.Proposal{Height: 1, POLRound: -1, Block: b}
+	assert.Nil(t, p.Sign(privKey))
+	prevote := &core.Vote{Type: core.VotePrevote, ChainID: 1, Height: 1, BlockHash: b.Hash(core.BlockHasher{})}
+	assert.Nil(t, prevote.Sign(privKey))
+
+	for _, c := range DefaultCodecs {
+		payload, err := (&ProposalMessage{Proposal: p}).Encode(c)
+		assert.Nil(t, err)
+		decoded := decodeRPC(t, NewMessage(MessageTypeProposal, c, payload))
+		assert.Nil(t, decoded.Data.(*ProposalMessage).Proposal.Verify(), c)
+
+		payload, err = (&PrevoteMessage{Vote: prevote}).Encode(c)
+		assert.Nil(t, err)
+		decoded = decodeRPC(t, NewMessage(MessageTypePrevote, c, payload))
+		assert.Equal(t, prevote, decoded.Data.(*PrevoteMessage).Vote, c)
+
+		// prevote 不能作为 precommit 消息发送
+		_, err = DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(NewMessage(MessageTypePrecommit, c, payload).Bytes())})
+		assert.ErrorIs(t, err, core.ErrInvalidVote, c)
+	}
+}
+
+func TestTxMessageRoundTrip(t *testing.T) {
+	tx := util.NewRandomTransactionWithSignature(t, crypto.GeneratePrivateKey(), 32)
+
+	for _, c := range DefaultCodecs {
+		payload, err := encodeTx(c, tx)
+		assert.Nil(t, err)
+
+		decoded := decodeRPC(t, NewMessage(MessageTypeTx, c, payload))
+		assert.Equal(t, tx.Hash(core.TxHasher{}), decoded.Data.(*core.Transaction).Hash(core.TxHasher{}))
+	}
+}
+
+func TestDecodeMessageRejectsInvalidInput(t *testing.T) {
+	payload, err := (&GetBlocksMessage{From: 1}).Encode(CodecBinary)
+	assert.Nil(t, err)
+	data := NewMessage(MessageTypeGetBlocks, CodecBinary, payload).Bytes()
+
+	_, err = DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(data[:len(data)-1])})
+	assert.NotNil(t, err)
+
+	unknownCodec := append([]byte(nil), data...)
+	unknownCodec[2] = 0xff
+	_, err = DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(unknownCodec)})
+	assert.NotNil(t, err)
+
+	data[0] = core.CodecVersion + 1
+	_, err = DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(data)})
+	assert.ErrorIs(t, err, core.ErrCodecVersion)
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	codec, err := NegotiateCodec(DefaultCodecs, []Codec{CodecGob, CodecProto})
+	assert.Nil(t, err)
+	assert.Equal(t, CodecProto, codec)
+
+	// 只支持 gob 的节点
+	codec, err = NegotiateCodec([]Codec{CodecProto, CodecGob}, []Codec{CodecGob})
+	assert.Nil(t, err)
+	assert.Equal(t, CodecGob, codec)
+
+	// 没有声明编码的节点只支持 binary
+	codec, err = NegotiateCodec(DefaultCodecs, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CodecBinary, codec)
+
+	_, err = NegotiateCodec([]Codec{CodecProto}, []Codec{CodecGob})
+	assert.NotNil(t, err)
+}
+
+func TestEveryMessageTypeInEveryCodec(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := util.NewRandomTransactionWithSignature(t, privKey, 32)
+	b := util.NewRandomBlockWithSignature(t, privKey, 1, types.Hash{})
+	b.AddTransaction(tx)
+	assert.Nil(t, b.Sign(privKey))
+
+	p := &core.Proposal{Height: 1, Round: 1, POLRound: 0, Block: b}
+	assert.Nil(t, p.Sign(privKey))
+	prevote := &core.Vote{Type: core.VotePrevote, ChainID: 1, Height: 1, Round: 1, BlockHash: b.Hash(core.BlockHasher{})}
+	assert.Nil(t, prevote.Sign(privKey))
+	// 没有区块的 precommit
+	precommit := &core.Vote{Type: core.VotePrecommit, ChainID: 1, Height: 1}
+	assert.Nil(t, precommit.Sign(privKey))
+
+	// 每种消息类型都需要在每种编码下往返
+	messages := map[MessageType]func(Codec) ([]byte, error){
+		MessageTypeTx: func(c Codec) ([]byte, error) {
+			return encodeTx(c, tx)
+		},
+		MessageTypeBock: func(c Codec) ([]byte, error) {
+			return encodeBlock(c, b)
+		},
+		MessageTypeGetBlocks: (&GetBlocksMessage{From: 1, To: 2}).Encode,
+		MessageTypeStatus:    (&StatusMessage{ID: "node", Version: 1, CurrentHeight: 3, Codecs: DefaultCodecs}).Encode,
+		MessageTypeGetStatus: func(c Codec) ([]byte, error) {
+			return nil, nil
+		},
+		MessageTypeBlocks:     (&BlocksMessage{Blocks: []*core.Block{b}}).Encode,
+		MessageTypeProposal:   (&ProposalMessage{Proposal: p}).Encode,
+		MessageTypePrevote:    (&PrevoteMessage{Vote: prevote}).Encode,
+		MessageTypePrecommit:  (&PrecommitMessage{Vote: precommit}).Encode,
+		MessageTypeGetHeaders: (&GetHeadersMessage{From: 1}).Encode,
+		MessageTypeHeaders:    (&HeadersMessage{Headers: []*core.LightHeader{{SignedHeader: core.SignedHeaderOf(b)}}}).Encode,
+		MessageTypeGetProof:   (&GetProofMessage{Type: ProofTypeTx, Key: []byte{0x01}}).Encode,
+		MessageTypeProof:      (&ProofMessage{Type: ProofTypeTx, Key: []byte{0x01}}).Encode,
+	}
+
+	for mt := MessageTypeTx; mt <= MessageTypeProof; mt++ {
+		encode, ok := messages[mt]
+		assert.True(t, ok, mt)
+
+		for _, c := range DefaultCodecs {
+			payload, err := encode(c)
+			assert.Nil(t, err, "%x %s", mt, c)
+
+			decoded, err := DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(NewMessage(mt, c, payload).Bytes())})
+			assert.Nil(t, err, "%x %s", mt, c)
+			if err != nil {
+				continue
+			}
+
+			switch data := decoded.Data.(type) {
+			case *ProposalMessage:
+				assert.Nil(t, data.Proposal.Verify(), c)
+			case *PrevoteMessage:
+				assert.Equal(t, prevote, data.Vote, c)
+			case *PrecommitMessage:
+				assert.Equal(t, precommit, data.Vote, c)
+			}
+		}
+	}
+}
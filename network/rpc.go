@@ -2,11 +2,10 @@ package network
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
-	"crypto/elliptic"
 
 	"project-bee/core"
 
@@ -16,12 +15,19 @@ import (
 type MessageType byte
 
 const (
-	MessageTypeTx        MessageType = 0x1
-	MessageTypeBock      MessageType = 0x2
-	MessageTypeGetBlocks MessageType = 0x3
-	MessageTypeStatus    MessageType = 0x4
-	MessageTypeGetStatus MessageType = 0x5
-	MessageTypeBlocks    MessageType = 0x6
+	MessageTypeTx         MessageType = 0x1
+	MessageTypeBock       MessageType = 0x2
+	MessageTypeGetBlocks  MessageType = 0x3
+	MessageTypeStatus     MessageType = 0x4
+	MessageTypeGetStatus  MessageType = 0x5
+	MessageTypeBlocks     MessageType = 0x6
+	MessageTypeProposal   MessageType = 0x7
+	MessageTypePrevote    MessageType = 0x8
+	MessageTypePrecommit  MessageType = 0x9
+	MessageTypeGetHeaders MessageType = 0xa
+	MessageTypeHeaders    MessageType = 0xb
+	MessageTypeGetProof   MessageType = 0xc
+	MessageTypeProof      MessageType = 0xd
 )
 
 type RPC struct {
@@ -31,106 +37,219 @@ type RPC struct {
 
 type Message struct {
 	Header MessageType
-	Data   []byte
+	// Data 使用的编码
+	Codec Codec
+	Data  []byte
 }
 
-func NewMessage(t MessageType, data []byte) *Message {
+func NewMessage(t MessageType, c Codec, data []byte) *Message {
 	return &Message{
 		Header: t,
+		Codec:  c,
 		Data:   data,
 	}
 }
 
+// 编码: [版本][Header][Codec][u32 长度][Data]
 func (msg *Message) Bytes() []byte {
 	buf := &bytes.Buffer{}
-	gob.NewEncoder(buf).Encode(msg)
+	buf.WriteByte(core.CodecVersion)
+	buf.WriteByte(byte(msg.Header))
+	buf.WriteByte(byte(msg.Codec))
+	binary.Write(buf, binary.BigEndian, uint32(len(msg.Data)))
+	buf.Write(msg.Data)
 	return buf.Bytes()
 }
 
+func decodeMessage(r io.Reader) (*Message, error) {
+	prefix := make([]byte, 3)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	if prefix[0] != core.CodecVersion {
+		return nil, fmt.Errorf("message version (%d) => (%d): %w", prefix[0], core.CodecVersion, core.ErrCodecVersion)
+	}
+	if codec := Codec(prefix[2]); !codec.valid() {
+		return nil, fmt.Errorf("message codec (%s): %w", codec, core.ErrCodecVersion)
+	}
+
+	data, err := readSized(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessage(MessageType(prefix[1]), Codec(prefix[2]), data), nil
+}
+
 type DecodedMessage struct {
 	From net.Addr
-	Data any
+	// Codec 是对方发送这个消息时使用的编码
+	Codec Codec
+	Data  any
 }
 
 type RPCDecodeFunc func(RPC) (*DecodedMessage, error)
 
 // 解码 RPC 请求
 func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
-	msg := Message{}
-	if err := gob.NewDecoder(rpc.Payload).Decode(&msg); err != nil {
-		return nil, fmt.Errorf("failed to decode message from %s: %s", rpc.From, err)
+	msg, err := decodeMessage(rpc.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message from %s: %w", rpc.From, err)
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"from": rpc.From,
-		"type": msg.Header,
+		"from":  rpc.From,
+		"type":  msg.Header,
+		"codec": msg.Codec,
 	}).Debug("new incoming message")
 
-
 	// 解码请求中的 payload
 	switch msg.Header {
 
 	// /tx/:
 	case MessageTypeTx:
-		tx := new(core.Transaction)
-		if err := tx.Decode(core.NewGobTxDecoder(bytes.NewReader(msg.Data))); err != nil {
+		tx, err := decodeTx(msg.Codec, msg.Data)
+		if err != nil {
 			return nil, err
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: tx,
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  tx,
 		}, nil
 
 	// /block/:
-	case MessageTypeBock:  // 接收区块？
-		block := new(core.Block)
-		if err := block.Decode(core.NewGobBlockDecoder(bytes.NewReader(msg.Data))); err != nil {
+	case MessageTypeBock: // 接收区块？
+		block, err := decodeBlock(msg.Codec, msg.Data)
+		if err != nil {
 			return nil, err
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: block,
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  block,
 		}, nil
 
 	case MessageTypeGetStatus:
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: &GetStatusMessage{},
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  &GetStatusMessage{},
 		}, nil
 
 	case MessageTypeStatus: // 同步状态消息
 		statusMessage := new(StatusMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(statusMessage); err != nil {
+		if err := statusMessage.Decode(msg.Codec, msg.Data); err != nil {
 			return nil, err
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: statusMessage,
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  statusMessage,
 		}, nil
-		
+
 	case MessageTypeGetBlocks: // 同步区块信息
 		getBlocks := new(GetBlocksMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlocks); err != nil {
+		if err := getBlocks.Decode(msg.Codec, msg.Data); err != nil {
 			return nil, err
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: getBlocks,
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  getBlocks,
 		}, nil
 
 	case MessageTypeBlocks: // 同步区块高度
 		blocks := new(BlocksMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(blocks); err != nil {
+		if err := blocks.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  blocks,
+		}, nil
+
+	case MessageTypeProposal:
+		proposal := new(ProposalMessage)
+		if err := proposal.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  proposal,
+		}, nil
+
+	case MessageTypePrevote:
+		prevote := new(PrevoteMessage)
+		if err := prevote.Decode(msg.Codec, msg.Data); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: blocks,
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  prevote,
+		}, nil
+
+	case MessageTypePrecommit:
+		precommit := new(PrecommitMessage)
+		if err := precommit.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  precommit,
+		}, nil
+
+	case MessageTypeGetHeaders: // 轻节点同步区块头
+		getHeaders := new(GetHeadersMessage)
+		if err := getHeaders.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  getHeaders,
+		}, nil
+
+	case MessageTypeHeaders:
+		headers := new(HeadersMessage)
+		if err := headers.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  headers,
+		}, nil
+
+	case MessageTypeGetProof: // 轻节点请求 Merkle 证明
+		getProof := new(GetProofMessage)
+		if err := getProof.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  getProof,
+		}, nil
+
+	case MessageTypeProof:
+		proof := new(ProofMessage)
+		if err := proof.Decode(msg.Codec, msg.Data); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From:  rpc.From,
+			Codec: msg.Codec,
+			Data:  proof,
 		}, nil
 
 	default:
@@ -141,7 +260,3 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 type RPCProcessor interface {
 	ProcessMessage(*DecodedMessage) error
 }
-
-func init() {
-	gob.Register(elliptic.P256())
-}
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"project-bee/core"
+)
+
+const (
+	initialPeerScore = 100
+	// 分数不高于 minPeerScore 的节点被断开
+	minPeerScore = 0
+)
+
+// misbehaviorPenalty 返回节点发送的数据触发 err 时扣的分数
+// 分叉和同步中正常出现的错误不扣分
+func misbehaviorPenalty(err error) int {
+	switch {
+	case errors.Is(err, core.ErrBlockKnown), errors.Is(err, core.ErrUnknownParent):
+		return 0
+	// 可能只是时钟偏差
+	case errors.Is(err, core.ErrFutureBlock):
+		return 5
+	case errors.Is(err, core.ErrInvalidTimestamp),
+		errors.Is(err, core.ErrBlockTooLarge),
+		errors.Is(err, core.ErrTooManyTxs),
+		errors.Is(err, core.ErrDuplicateTx),
+		errors.Is(err, core.ErrUnsupportedVersion),
+		errors.Is(err, core.ErrInvalidChainID),
+		errors.Is(err, core.ErrInvalidNonce),
+		errors.Is(err, core.ErrFinalityConflict),
+		errors.Is(err, core.ErrHeaderConflict):
+		return 20
+	case errors.Is(err, core.ErrInvalidStateRoot),
+		errors.Is(err, core.ErrInvalidReceipts),
+		errors.Is(err, core.ErrInvalidValidators),
+		errors.Is(err, core.ErrWrongProposer),
+		errors.Is(err, core.ErrInvalidDifficulty),
+		errors.Is(err, core.ErrInvalidPoW),
+		errors.Is(err, core.ErrInvalidVote),
+		errors.Is(err, core.ErrInvalidProposal),
+		errors.Is(err, core.ErrInvalidCommit),
+		errors.Is(err, core.ErrInvalidEvidence),
+		errors.Is(err, core.ErrInvalidProof):
+		return 50
+	default:
+		return 0
+	}
+}
+
+// peerScores 记录每个节点的分数, 新节点从 initialPeerScore 开始
+type peerScores struct {
+	lock   sync.Mutex
+	scores map[net.Addr]int
+}
+
+func newPeerScores() *peerScores {
+	return &peerScores{
+		scores: make(map[net.Addr]int),
+	}
+}
+
+// penalize 扣分并返回节点当前的分数
+func (p *peerScores) penalize(addr net.Addr, penalty int) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	score, ok := p.scores[addr]
+	if !ok {
+		score = initialPeerScore
+	}
+	score -= penalty
+	p.scores[addr] = score
+
+	return score
+}
+
+func (p *peerScores) score(addr net.Addr) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if score, ok := p.scores[addr]; ok {
+		return score
+	}
+	return initialPeerScore
+}
+
+func (p *peerScores) remove(addr net.Addr) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.scores, addr)
+}
@@ -0,0 +1,47 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"project-bee/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMisbehaviorPenalty(t *testing.T) {
+	assert.Equal(t, 0, misbehaviorPenalty(core.ErrBlockKnown))
+	assert.Equal(t, 0, misbehaviorPenalty(fmt.Errorf("block: %w", core.ErrUnknownParent)))
+	assert.Equal(t, 0, misbehaviorPenalty(fmt.Errorf("some error")))
+
+	assert.Greater(t, misbehaviorPenalty(fmt.Errorf("block: %w", core.ErrDuplicateTx)), 0)
+	assert.Greater(t, misbehaviorPenalty(fmt.Errorf("block: %w", core.ErrTooManyTxs)), 0)
+	assert.Greater(t, misbehaviorPenalty(fmt.Errorf("block: %w", core.ErrInvalidTimestamp)), misbehaviorPenalty(core.ErrFutureBlock))
+}
+
+func TestPeerScores(t *testing.T) {
+	scores := newPeerScores()
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3000}
+
+	assert.Equal(t, initialPeerScore, scores.score(addr))
+	assert.Equal(t, initialPeerScore-20, scores.penalize(addr, 20))
+	assert.Equal(t, initialPeerScore-20, scores.score(addr))
+
+	scores.remove(addr)
+	assert.Equal(t, initialPeerScore, scores.score(addr))
+}
+
+func TestLimitBlockTxs(t *testing.T) {
+	txs := make([]*core.Transaction, core.MaxBlockTxs+10)
+	for i := range txs {
+		txs[i] = core.NewTransaction(nil)
+	}
+	assert.Equal(t, core.MaxBlockTxs, len(limitBlockTxs(txs)))
+
+	large := []*core.Transaction{
+		core.NewTransaction(make([]byte, core.MaxBlockSize/2)),
+		core.NewTransaction(make([]byte, core.MaxBlockSize/2)),
+	}
+	assert.Equal(t, 1, len(limitBlockTxs(large)))
+}
@@ -1,8 +1,6 @@
 package network
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"net"
 	"os"
@@ -30,6 +28,21 @@ type ServerOpts struct {
 	RPCProcessor  RPCProcessor
 	BlockTime     time.Duration
 	PrivateKey    *crypto.PrivateKey
+	// DataDir 不为空时区块持久化到磁盘, 重启后从磁盘恢复
+	DataDir string
+	Genesis *core.Genesis
+	// Codecs 是节点支持的消息编码, 按优先级排列, 为空时使用 DefaultCodecs
+	Codecs []Codec
+	// BFT 为 true 时使用 BFTEngine 出块, 区块有 2/3 以上验证者的证书之后才上链
+	// 没有私钥的节点只跟随共识
+	BFT bool
+	// BFTTimeouts 为零值时使用 DefaultBFTTimeouts
+	BFTTimeouts BFTTimeouts
+	// Light 为 true 时节点只同步区块头, 需要时向全节点请求交易和账户的 Merkle 证明
+	// 轻节点没有私钥, 不参与共识, 区块头只保存在内存中
+	Light bool
+	// PruneDepth 不为 0 时只保留最近 PruneDepth 个区块的区块体和交易索引, 不能向其他节点提供更早的区块
+	PruneDepth uint32
 }
 
 type Server struct {
@@ -39,9 +52,22 @@ type Server struct {
 	mu      sync.RWMutex
 	peerMap map[net.Addr]*TCPPeer
 
+	// 与每个节点协商出的编码
+	codecLock  sync.RWMutex
+	peerCodecs map[net.Addr]Codec
+	// 对方是否为轻节点, 收到状态消息之后记录
+	peerLight map[net.Addr]bool
+	// 对方保留区块体的最低高度, 更早的区块不能向它请求
+	peerRetained map[net.Addr]uint32
+	scores       *peerScores
+
 	ServerOpts
 	mempool     *TxPool
+	evidence    *EvidencePool
 	chain       *core.Blockchain
+	light       *core.LightChain
+	proofs      *proofWaiters
+	bft         *BFTEngine
 	isValidator bool
 	rpcCh       chan RPC
 	quitCh      chan struct{}
@@ -62,10 +88,44 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		opts.Logger = log.With(opts.Logger, "addr", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	if len(opts.Codecs) == 0 {
+		opts.Codecs = DefaultCodecs
+	}
+
+	if opts.Genesis == nil {
+		return nil, fmt.Errorf("server (%s) has no genesis configured", opts.ID)
+	}
+
+	// BFT 只能在 PoA 验证者集合上运行
+	if opts.BFT && opts.Genesis.Consensus == core.ConsensusPoW {
+		return nil, fmt.Errorf("server (%s) cannot run BFT with %s consensus", opts.ID, opts.Genesis.Consensus)
+	}
+
+	if opts.Light {
+		return newLightServer(opts)
+	}
+
+	var store core.Storage = core.NewMemorystore()
+	if len(opts.DataDir) > 0 {
+		diskStore, err := core.NewDiskStore(opts.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		store = diskStore
+	}
+
+	chain, err := core.NewBlockchainFromGenesis(opts.Logger, store, opts.Genesis)
 	if err != nil {
 		return nil, err
 	}
+	chain.SetPruneDepth(opts.PruneDepth)
+
+	// 交易历史索引从 store 重建, 之后跟随规范链的变化
+	indexer := core.NewTxIndexer()
+	if err := indexer.Rebuild(store); err != nil {
+		return nil, err
+	}
+	chain.Subscribe(indexer)
 
 	// api
 	// channel用在 json RPC server 上
@@ -75,6 +135,7 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		apiServerCfg := api.ServerConfig{
 			Logger:     opts.Logger,
 			ListenAddr: opts.APIListener,
+			Indexer:    indexer,
 		}
 
 		apiServer := api.NewServer(apiServerCfg, chain, txChan)
@@ -90,9 +151,14 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		TCPTransport: tr,
 		peerCh:       peerCh,
 		peerMap:      make(map[net.Addr]*TCPPeer),
+		peerCodecs:   make(map[net.Addr]Codec),
+		peerLight:    make(map[net.Addr]bool),
+		peerRetained: make(map[net.Addr]uint32),
+		scores:       newPeerScores(),
 		ServerOpts:   opts,
 		chain:        chain,
 		mempool:      NewTxPool(1000),
+		evidence:     NewEvidencePool(),
 		isValidator:  opts.PrivateKey != nil,
 		rpcCh:        make(chan RPC),
 		quitCh:       make(chan struct{}, 1),
@@ -100,13 +166,44 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	}
 
 	s.TCPTransport.peerCh = peerCh
+
+	// 验证者节点不会在同一高度和轮次签名两个不同的区块
+	if s.isValidator {
+		chain.SetSignGuard(core.NewSignGuard())
+	}
+
+	// 重组时旧分支上的交易重新回到交易池
+	chain.SetReorgHandler(func(dropped []*core.Transaction) {
+		for _, tx := range dropped {
+			s.mempool.Restore(tx)
+		}
+	})
+
 	// If we dont got any processor from the server options, we going to use
 	// the server as default.
 	if s.RPCProcessor == nil {
 		s.RPCProcessor = s
 	}
 
-	if s.isValidator {
+	if s.BFT {
+		timeouts := s.BFTTimeouts
+		if timeouts == (BFTTimeouts{}) {
+			timeouts = DefaultBFTTimeouts(s.BlockTime)
+		}
+
+		s.bft = NewBFTEngine(BFTConfig{
+			Logger:     s.Logger,
+			Chain:      chain,
+			PrivateKey: s.PrivateKey,
+			Timeouts:   timeouts,
+			BuildBlock: s.buildBlock,
+			Broadcast:  s.broadcastConsensus,
+			OnCommit: func(*core.Block) {
+				s.mempool.Prune(s.chain.NextNonce)
+			},
+		})
+		s.bft.Start()
+	} else if s.isValidator {
 		go s.validatorLoop()
 	}
 
@@ -172,6 +269,7 @@ free:
 				if err != core.ErrBlockKnown {
 					s.Logger.Log("error", err)
 				}
+				s.penalizePeer(msg.From, err)
 			}
 
 		case <-s.quitCh:
@@ -189,7 +287,7 @@ func (s *Server) validatorLoop() {
 
 	for {
 		// fmt.Println("creating new block")
-		
+
 		if err := s.createNewBlock(); err != nil {
 			s.Logger.Log("create block error", err)
 		}
@@ -200,19 +298,36 @@ func (s *Server) validatorLoop() {
 
 // 解析 Message 然后处理
 func (s *Server) ProcessMessage(msg *DecodedMessage) error {
+	if s.light != nil {
+		return s.processLightMessage(msg)
+	}
+
 	switch t := msg.Data.(type) {
 	case *core.Transaction:
 		return s.processTransaction(t)
 	case *core.Block:
 		return s.processBlock(t)
 	case *GetStatusMessage:
-		return s.processGetStatusMessage(msg.From)
+		return s.processGetStatusMessage(msg.From, msg.Codec)
 	case *StatusMessage:
 		return s.processStatusMessage(msg.From, t)
 	case *GetBlocksMessage:
 		return s.processGetBlocksMessage(msg.From, t)
 	case *BlocksMessage: // add block to blockchain
 		return s.processBlocksMessage(msg.From, t)
+	case *ProposalMessage:
+		if t.Proposal != nil && t.Proposal.Block != nil {
+			s.checkEvidence(t.Proposal.Block)
+		}
+		return s.processConsensus(func(e *BFTEngine) error { return e.AddProposal(t.Proposal) })
+	case *PrevoteMessage:
+		return s.processConsensus(func(e *BFTEngine) error { return e.AddVote(t.Vote) })
+	case *PrecommitMessage:
+		return s.processConsensus(func(e *BFTEngine) error { return e.AddVote(t.Vote) })
+	case *GetHeadersMessage:
+		return s.processGetHeadersMessage(msg.From, t)
+	case *GetProofMessage:
+		return s.processGetProofMessage(msg.From, t)
 	}
 
 	return nil
@@ -223,9 +338,19 @@ func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage)
 
 	var (
 		blocks    = []*core.Block{}
+		commits   = []*core.CommitCertificate{}
 		ourHeight = s.chain.Height()
 	)
 
+	// 裁剪节点只提供保留的区块, 回复状态消息告诉对方 RetainedFrom, 对方收到之后停止请求
+	if retainedFrom, _ := s.chain.RetainedRange(); data.From < retainedFrom {
+		if err := s.sendStatus(from, s.peerCodec(from)); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("peer %s requested blocks from height (%d) => retained height (%d): %w", from, data.From, retainedFrom, core.ErrBlockPruned)
+	}
+
 	if data.To == 0 {
 		// 拿到高度
 		for i := int(data.From); i <= int(ourHeight); i++ {
@@ -234,25 +359,30 @@ func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage)
 				return err
 			}
 			blocks = append(blocks, block)
+
+			// 区块还不是最终的时候没有证书
+			commit, _ := s.chain.GetCommit(block.Hash(core.BlockHasher{}))
+			commits = append(commits, commit)
 		}
 	}
 
 	blocksMsg := &BlocksMessage{
-		Blocks: blocks,
+		Blocks:  blocks,
+		Commits: commits,
 	}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(blocksMsg); err != nil {
+	payload, err := blocksMsg.Encode(s.peerCodec(from))
+	if err != nil {
 		return err
 	}
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	msg := NewMessage(MessageTypeBlocks, buf.Bytes())
+	msg := NewMessage(MessageTypeBlocks, s.peerCodec(from), payload)
 	peer, ok := s.peerMap[from]
 	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
+		return fmt.Errorf("peer %s not known", from)
 	}
 
 	// 广播到全网
@@ -263,7 +393,21 @@ func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage)
 func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error {
 	// s.Logger.Log("msg", "received BLOCKS!!!!!!!!", "from", from)
 
-	for _, block := range data.Blocks {
+	for i, block := range data.Blocks {
+		s.checkEvidence(block)
+
+		commit := data.CommitFor(i)
+		if err := s.checkCommit(block, commit); err != nil {
+			return err
+		}
+
+		if commit != nil {
+			if err := s.chain.AddCommittedBlock(block, commit); err != nil {
+				return err
+			}
+			continue
+		}
+
 		if err := s.chain.AddBlock(block); err != nil {
 			s.Logger.Log("error", err.Error())
 			return err
@@ -272,29 +416,96 @@ func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error
 	return nil
 }
 
+// BFT 模式下同步的区块必须带有证书, 证书由 AddCommittedBlock 校验
+func (s *Server) checkCommit(b *core.Block, commit *core.CommitCertificate) error {
+	if commit == nil && s.bft != nil {
+		return fmt.Errorf("block (%s) without commit in BFT mode: %w", b.Hash(core.BlockHasher{}), core.ErrInvalidCommit)
+	}
+
+	return nil
+}
+
+func (s *Server) processConsensus(add func(*BFTEngine) error) error {
+	if s.bft == nil {
+		return nil
+	}
+
+	return add(s.bft)
+}
+
 func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
 	s.Logger.Log("msg", "received STATUS message", "from", from)
 
-	if data.CurrentHeight <= s.chain.Height() {
-		s.Logger.Log("msg", "cannot sync blockHeight to low", "ourHeight", s.chain.Height(), "theirHeight", data.CurrentHeight, "addr", from)
+	height, genesisHash := s.status()
+	if data.GenesisHash != genesisHash {
+		return fmt.Errorf("peer %s has a different genesis (%s) => our genesis (%s)", from, data.GenesisHash, genesisHash)
+	}
+
+	codec, err := NegotiateCodec(s.Codecs, data.Codecs)
+	if err != nil {
+		return fmt.Errorf("peer %s: %w", from, err)
+	}
+
+	s.codecLock.Lock()
+	s.peerCodecs[from] = codec
+	s.peerLight[from] = data.Light
+	s.peerRetained[from] = data.RetainedFrom
+	s.codecLock.Unlock()
+
+	s.Logger.Log("msg", "negotiated codec", "peer", from, "codec", codec, "light", data.Light)
+
+	// 轻节点没有区块可以同步
+	if data.Light {
 		return nil
 	}
 
-	go s.requestBlocksLoop(from)
+	if data.CurrentHeight <= height {
+		s.Logger.Log("msg", "cannot sync blockHeight to low", "ourHeight", height, "theirHeight", data.CurrentHeight, "addr", from)
+		return nil
+	}
+
+	// 轻节点只需要区块头, 区块体被裁剪不影响
+	if s.light == nil && data.RetainedFrom > height+1 {
+		s.Logger.Log("msg", "cannot sync from pruned peer", "ourHeight", height, "retainedFrom", data.RetainedFrom, "addr", from)
+		return nil
+	}
+
+	if s.light != nil {
+		go s.requestHeadersLoop(from)
+	} else {
+		go s.requestBlocksLoop(from)
+	}
 
 	return nil
 }
 
-func (s *Server) processGetStatusMessage(from net.Addr) error {
+// 还没有协商编码, 如果支持对方请求使用的编码就用它回复
+func (s *Server) processGetStatusMessage(from net.Addr, requested Codec) error {
 	s.Logger.Log("msg", "received getStatus message", "from", from)
 
+	codec := s.Codecs[0]
+	if s.supportsCodec(requested) {
+		codec = requested
+	}
+
+	return s.sendStatus(from, codec)
+}
+
+func (s *Server) sendStatus(from net.Addr, codec Codec) error {
+	height, genesisHash := s.status()
 	statusMessage := &StatusMessage{
-		CurrentHeight: s.chain.Height(),
+		CurrentHeight: height,
+		GenesisHash:   genesisHash,
 		ID:            s.ID,
+		Codecs:        s.Codecs,
+		Light:         s.light != nil,
+	}
+	if s.chain != nil {
+		statusMessage.RetainedFrom, _ = s.chain.RetainedRange()
 	}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(statusMessage); err != nil {
+	payload, err := statusMessage.Encode(codec)
+	if err != nil {
 		return err
 	}
 
@@ -303,50 +514,125 @@ func (s *Server) processGetStatusMessage(from net.Addr) error {
 
 	peer, ok := s.peerMap[from]
 	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
+		return fmt.Errorf("peer %s not known", from)
 	}
 
-	msg := NewMessage(MessageTypeStatus, buf.Bytes())
+	msg := NewMessage(MessageTypeStatus, codec, payload)
 
 	return peer.Send(msg.Bytes())
 }
 
+// status 返回状态消息中的高度和创世 hash, 轻节点使用同步的区块头
+func (s *Server) status() (uint32, types.Hash) {
+	if s.light != nil {
+		return s.light.Height(), s.light.GenesisHash()
+	}
+
+	return s.chain.Height(), s.chain.GenesisHash()
+}
+
 // 节点之间同步高度
 func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
-	var (
-		/*
-			ID            string
-			Version       uint32
-			CurrentHeight uint32
-		*/
-		getStatusMsg = new(GetStatusMessage)
-		buf          = new(bytes.Buffer)
-	)
-	if err := gob.NewEncoder(buf).Encode(getStatusMsg); err != nil {
-		return err
+	// 节点之间同步 message, GetStatusMessage 没有内容
+	msg := NewMessage(MessageTypeGetStatus, s.Codecs[0], nil)
+	return peer.Send(msg.Bytes())
+}
+
+// penalizePeer 按错误类型给节点扣分, 分数过低时断开连接
+func (s *Server) penalizePeer(addr net.Addr, err error) {
+	penalty := misbehaviorPenalty(err)
+	if addr == nil || penalty == 0 {
+		return
 	}
 
-	// 节点之间同步 message
-	msg := NewMessage(MessageTypeGetStatus, buf.Bytes())
-	return peer.Send(msg.Bytes())
+	score := s.scores.penalize(addr, penalty)
+	s.Logger.Log("msg", "peer misbehaved", "peer", addr, "penalty", penalty, "score", score)
+
+	if score <= minPeerScore {
+		s.dropPeer(addr)
+	}
 }
 
-// 广播 message	 到所有节点
-func (s *Server) broadcast(payload []byte) error {
+func (s *Server) dropPeer(addr net.Addr) {
+	s.mu.Lock()
+	peer, ok := s.peerMap[addr]
+	delete(s.peerMap, addr)
+	s.mu.Unlock()
+
+	s.codecLock.Lock()
+	delete(s.peerCodecs, addr)
+	delete(s.peerLight, addr)
+	delete(s.peerRetained, addr)
+	s.codecLock.Unlock()
+
+	s.scores.remove(addr)
+
+	if ok {
+		peer.conn.Close()
+		s.Logger.Log("msg", "peer dropped", "peer", addr)
+	}
+}
+
+func (s *Server) supportsCodec(c Codec) bool {
+	for _, codec := range s.Codecs {
+		if codec == c {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCodec 返回与节点协商的编码, 还没有协商时使用自己优先级最高的编码
+func (s *Server) peerCodec(addr net.Addr) Codec {
+	s.codecLock.RLock()
+	defer s.codecLock.RUnlock()
+
+	if codec, ok := s.peerCodecs[addr]; ok {
+		return codec
+	}
+	return s.Codecs[0]
+}
+
+func (s *Server) peerRetainedFrom(addr net.Addr) uint32 {
+	s.codecLock.RLock()
+	defer s.codecLock.RUnlock()
+
+	return s.peerRetained[addr]
+}
+
+// 广播 message	 到所有节点, 每个节点使用与它协商的编码
+func (s *Server) broadcast(t MessageType, encode func(Codec) ([]byte, error)) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	payloads := make(map[Codec][]byte)
 	for netAddr, peer := range s.peerMap {
-		if err := peer.Send(payload); err != nil {
+		codec := s.peerCodec(netAddr)
+		if _, ok := payloads[codec]; !ok {
+			data, err := encode(codec)
+			if err != nil {
+				return err
+			}
+			payloads[codec] = NewMessage(t, codec, data).Bytes()
+		}
+
+		if err := peer.Send(payloads[codec]); err != nil {
 			fmt.Printf("peer send error => addr %s [err: %s]\n", netAddr, err)
 			return err
 		}
 	}
-	
+
 	return nil
 }
 
 func (s *Server) processBlock(b *core.Block) error {
+	s.checkEvidence(b)
+
+	// BFT 模式下区块只能通过共识或者带证书的同步上链
+	if s.bft != nil {
+		return fmt.Errorf("block (%s) without commit in BFT mode: %w", b.Hash(core.BlockHasher{}), core.ErrInvalidCommit)
+	}
+
 	if err := s.chain.AddBlock(b); err != nil {
 		return err
 	}
@@ -356,6 +642,48 @@ func (s *Server) processBlock(b *core.Block) error {
 	return nil
 }
 
+// checkEvidence 记录验证者签名的区块头, 发现双签时提交证据交易
+// 双签的区块可能是合法的分叉, 所以在区块上链之前检查
+func (s *Server) checkEvidence(b *core.Block) {
+	if b.Header == nil || !s.chain.IsValidator(b.Height, b.Validator) {
+		return
+	}
+
+	evidence, ok := s.evidence.Add(b, s.chain.Height())
+	if !ok {
+		return
+	}
+
+	s.Logger.Log("msg", "double sign detected", "validator", b.Validator, "height", b.Height, "round", b.Round)
+
+	if err := s.submitEvidence(evidence); err != nil {
+		s.Logger.Log("msg", "failed to submit evidence", "err", err)
+	}
+}
+
+// submitEvidence 用本节点的账户签名证据交易并放入交易池, 没有私钥的节点只记录日志
+func (s *Server) submitEvidence(evidence core.EvidenceTx) error {
+	if s.PrivateKey == nil {
+		return nil
+	}
+
+	from := s.PrivateKey.PublicKey().Address()
+	nonce := s.chain.NextNonce(from)
+	for s.mempool.HasNonce(from, nonce) {
+		nonce++
+	}
+
+	tx := core.NewTransaction(nil)
+	tx.TxInner = evidence
+	tx.Nonce = nonce
+	tx.ChainID = s.chain.ChainID()
+	if err := tx.Sign(*s.PrivateKey); err != nil {
+		return err
+	}
+
+	return s.processTransaction(tx)
+}
+
 func (s *Server) processTransaction(tx *core.Transaction) error {
 	hash := tx.Hash(core.TxHasher{})
 
@@ -363,10 +691,19 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 		return nil
 	}
 
-	if err := tx.Verify(); err != nil {
+	if err := tx.Verify(s.chain.ChainID()); err != nil {
+		return err
+	}
+
+	if err := s.chain.ValidatePendingTx(tx); err != nil {
 		return err
 	}
 
+	from := tx.From.Address()
+	if s.mempool.HasNonce(from, tx.Nonce) {
+		return fmt.Errorf("tx (%s) with nonce (%d) of account (%s) is already pending: %w", hash, tx.Nonce, from, core.ErrInvalidNonce)
+	}
+
 	// s.Logger.Log(
 	// 	"msg", "adding new tx to mempool",
 	// 	"hash", hash.ToHexString(),
@@ -386,6 +723,12 @@ func (s *Server) requestBlocksLoop(peer net.Addr) error {
 	for {
 		ourHeight := s.chain.Height()
 
+		// 对方已经裁剪了需要的区块, 再请求也只会被拒绝
+		if retainedFrom := s.peerRetainedFrom(peer); retainedFrom > ourHeight+1 {
+			s.Logger.Log("msg", "stop requesting blocks from pruned peer", "ourHeight", ourHeight, "retainedFrom", retainedFrom, "peer", peer)
+			return nil
+		}
+
 		s.Logger.Log("msg", "requesting new blocks", "requesting height", ourHeight+1)
 
 		getBlocksMessage := &GetBlocksMessage{
@@ -393,21 +736,23 @@ func (s *Server) requestBlocksLoop(peer net.Addr) error {
 			To:   0,
 		}
 
-		buf := new(bytes.Buffer)
-		if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
+		codec := s.peerCodec(peer)
+		payload, err := getBlocksMessage.Encode(codec)
+		if err != nil {
 			return err
 		}
 
-		s.mu.RLock()
-		defer s.mu.RUnlock()
+		msg := NewMessage(MessageTypeGetBlocks, codec, payload)
 
-		msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
-		peer, ok := s.peerMap[peer]
+		// 每轮释放锁, 否则断开节点时拿不到写锁
+		s.mu.RLock()
+		tcpPeer, ok := s.peerMap[peer]
+		s.mu.RUnlock()
 		if !ok {
-			return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
+			return fmt.Errorf("peer %s not known", peer)
 		}
 
-		if err := peer.Send(msg.Bytes()); err != nil {
+		if err := tcpPeer.Send(msg.Bytes()); err != nil {
 			s.Logger.Log("error", "failed to send to peer", "err", err, "peer", peer)
 		}
 
@@ -416,82 +761,99 @@ func (s *Server) requestBlocksLoop(peer net.Addr) error {
 }
 
 func (s *Server) broadcastBlock(b *core.Block) error {
-	buf := &bytes.Buffer{}
-	if err := b.Encode(core.NewGobBlockEncoder(buf)); err != nil {
-		return err
-	}
+	return s.broadcast(MessageTypeBock, func(c Codec) ([]byte, error) {
+		return encodeBlock(c, b)
+	})
+}
 
-	msg := NewMessage(MessageTypeBock, buf.Bytes())
+// broadcastConsensus 广播 BFTEngine 的提议和投票
+func (s *Server) broadcastConsensus(msg any) {
+	var err error
+	switch m := msg.(type) {
+	case *core.Proposal:
+		err = s.broadcast(MessageTypeProposal, (&ProposalMessage{Proposal: m}).Encode)
+	case *core.Vote:
+		if m.Type == core.VotePrevote {
+			err = s.broadcast(MessageTypePrevote, (&PrevoteMessage{Vote: m}).Encode)
+		} else {
+			err = s.broadcast(MessageTypePrecommit, (&PrecommitMessage{Vote: m}).Encode)
+		}
+	}
 
-	return s.broadcast(msg.Bytes())
+	if err != nil {
+		s.Logger.Log("msg", "failed to broadcast consensus message", "err", err)
+	}
 }
 
 func (s *Server) broadcastTx(tx *core.Transaction) error {
-	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
-		return err
-	}
+	return s.broadcast(MessageTypeTx, func(c Codec) ([]byte, error) {
+		return encodeTx(c, tx)
+	})
+}
 
-	msg := NewMessage(MessageTypeTx, buf.Bytes())
+// limitBlockTxs 截取不超过区块交易数和大小限制的前缀, 同一发送者的 nonce 保持连续
+func limitBlockTxs(txs []*core.Transaction) []*core.Transaction {
+	// 预留区块头, 验证者和签名的空间
+	size := 1024
+	for i, tx := range txs {
+		size += tx.Size()
+		if i >= core.MaxBlockTxs || size > core.MaxBlockSize {
+			return txs[:i]
+		}
+	}
 
-	return s.broadcast(msg.Bytes())
+	return txs
 }
 
 func (s *Server) createNewBlock() error {
-	currentHeader, err := s.chain.GetHeader(s.chain.Height())
-	if err != nil {
-		return err
+	// PoA 只在轮到自己时出块, PoW 任何节点都可以出块
+	_, poa := s.chain.Engine().(*core.PoAEngine)
+	if poa && !s.chain.IsProposer(s.chain.Height()+1, 0, s.PrivateKey.PublicKey()) {
+		return nil
 	}
 
-	// For now we are going to use all transactions that are in the mempool
-	// Later on when we know the internal structure of our transaction
-	// we will implement some kind of complexity function to determine how
-	// many transactions can be included in a block.
-	txs := s.mempool.Pending()
-
-	block, err := core.NewBlockFromPrevHeader(currentHeader, txs)
+	block, err := s.buildBlock(0)
 	if err != nil {
 		return err
 	}
 
-	if err := block.Sign(*s.PrivateKey); err != nil {
-		return err
-	}
-
 	if err := s.chain.AddBlock(block); err != nil {
 		return err
 	}
 
-	// ppending pool of tx 映射在 validator 的节点 
+	// ppending pool of tx 映射在 validator 的节点
 	// 普通节点没有 pending pool
-	s.mempool.ClearPending()
+	// 删除已经上链的交易, nonce 还没有轮到的交易留在 pending 中
+	s.mempool.Prune(s.chain.NextNonce)
 
 	go s.broadcastBlock(block)
 
 	return nil
 }
 
-func genesisBlock() *core.Block {
-	header := &core.Header{
-		Version:   1,
-		DataHash:  types.Hash{},
-		Height:    0,
-		Timestamp: 000000,
+// buildBlock 用交易池中可以执行的交易在最高区块之后打包并签名一个轮次为 round 的区块
+func (s *Server) buildBlock(round uint32) (*core.Block, error) {
+	currentHeader, err := s.chain.GetHeader(s.chain.Height())
+	if err != nil {
+		return nil, err
 	}
 
-	b, _ := core.NewBlock(header, nil)
+	// For now we are going to use all transactions that are in the mempool
+	// Later on when we know the internal structure of our transaction
+	// we will implement some kind of complexity function to determine how
+	// many transactions can be included in a block.
+	s.mempool.Prune(s.chain.NextNonce)
+	txs := limitBlockTxs(s.mempool.Executable(s.chain.NextNonce))
 
-	coinbase := crypto.PublicKey{}
-	tx := core.NewTransaction(nil)
-	tx.From = coinbase
-	tx.To = coinbase
-	tx.Value = 10_000_000
-	b.Transactions = append(b.Transactions, tx)
+	block, err := core.NewBlockFromPrevHeader(currentHeader, txs)
+	if err != nil {
+		return nil, err
+	}
+	block.Round = round
 
-	privKey := crypto.GeneratePrivateKey()
-	if err := b.Sign(privKey); err != nil {
-		panic(err)
+	if err := s.chain.SealBlock(block, *s.PrivateKey); err != nil {
+		return nil, err
 	}
 
-	return b
+	return block, nil
 }
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"project-bee/core"
+	"project-bee/crypto"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrunedServerRefusesOldBlocks(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	g := &core.Genesis{
+		ChainID:    1,
+		Timestamp:  1000,
+		Validators: []string{key.PublicKey().String()},
+	}
+
+	s, err := NewServer(ServerOpts{ID: "PRUNED", Logger: log.NewNopLogger(), Genesis: g, PruneDepth: 2})
+	assert.Nil(t, err)
+
+	for i := 0; i < 4; i++ {
+		b, err := buildTestBlock(s.chain, 0, key)
+		assert.Nil(t, err)
+		assert.Nil(t, s.chain.AddBlock(b))
+	}
+
+	from, to := s.chain.RetainedRange()
+	assert.Equal(t, uint32(3), from)
+	assert.Equal(t, uint32(4), to)
+
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+	s.peerMap[conn.RemoteAddr()] = &TCPPeer{conn: conn}
+
+	// 拒绝时回复状态消息, 告诉对方保留的范围
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.processGetBlocksMessage(conn.RemoteAddr(), &GetBlocksMessage{From: 2}) }()
+	msg, err := DefaultRPCDecodeFunc(RPC{Payload: remote})
+	assert.Nil(t, err)
+	refused := msg.Data.(*StatusMessage)
+	assert.Equal(t, uint32(3), refused.RetainedFrom)
+	assert.ErrorIs(t, <-errCh, core.ErrBlockPruned)
+
+	// 请求方收到之后不再请求已经裁剪的区块
+	syncing, err := NewServer(ServerOpts{ID: "SYNCING", Logger: log.NewNopLogger(), Genesis: g})
+	assert.Nil(t, err)
+	addr := remote.LocalAddr()
+	syncing.peerMap[addr] = &TCPPeer{conn: remote}
+	assert.Nil(t, syncing.processStatusMessage(addr, refused))
+	assert.Equal(t, uint32(3), syncing.peerRetainedFrom(addr))
+	assert.Nil(t, syncing.requestBlocksLoop(addr))
+
+	// 不认识的节点返回错误
+	assert.NotNil(t, s.processGetBlocksMessage(&net.TCPAddr{}, &GetBlocksMessage{From: 3}))
+	assert.NotNil(t, s.processGetStatusMessage(&net.TCPAddr{}, CodecBinary))
+
+	// 状态消息中带有保留的范围
+	go s.processGetStatusMessage(conn.RemoteAddr(), CodecBinary)
+	msg, err = DefaultRPCDecodeFunc(RPC{Payload: remote})
+	assert.Nil(t, err)
+	status := msg.Data.(*StatusMessage)
+	assert.Equal(t, uint32(4), status.CurrentHeight)
+	assert.Equal(t, uint32(3), status.RetainedFrom)
+
+	go s.processGetBlocksMessage(conn.RemoteAddr(), &GetBlocksMessage{From: 3})
+	msg, err = DefaultRPCDecodeFunc(RPC{Payload: remote})
+	assert.Nil(t, err)
+	assert.Len(t, msg.Data.(*BlocksMessage).Blocks, 2)
+}
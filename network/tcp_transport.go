@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -25,6 +26,10 @@ func (p *TCPPeer) readLoop(rpcCh chan RPC) {
 		if err == io.EOF {
 			continue
 		}
+		// 连接被本地关闭, 例如节点被断开
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			fmt.Printf("read error: %s", err)
 			continue
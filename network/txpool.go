@@ -1,6 +1,7 @@
 package network
 
 import (
+	"sort"
 	"sync"
 
 	"project-bee/core"
@@ -37,6 +38,12 @@ func (p *TxPool) Add(tx *core.Transaction) {
 	}
 }
 
+// Restore 把重组时被丢弃的交易放回 pending, 即使它们之前已经被看到过
+func (p *TxPool) Restore(tx *core.Transaction) {
+	p.all.Add(tx)
+	p.pending.Add(tx)
+}
+
 func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
@@ -46,6 +53,66 @@ func (p *TxPool) Pending() []*core.Transaction {
 	return p.pending.txs.Data
 }
 
+// HasNonce pending 中是否已经有 from 发出的 nonce 相同的交易
+func (p *TxPool) HasNonce(from types.Address, nonce uint64) bool {
+	for _, tx := range p.Pending() {
+		if tx.From.Address() == from && tx.Nonce == nonce {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Executable 返回可以按顺序打包的 pending 交易
+// 每个发送者的交易按 nonce 排序, 只取从 nextNonce 开始连续的部分
+func (p *TxPool) Executable(nextNonce func(types.Address) uint64) []*core.Transaction {
+	var (
+		senders  = []types.Address{}
+		bySender = make(map[types.Address][]*core.Transaction)
+	)
+	for _, tx := range p.Pending() {
+		from := tx.From.Address()
+		if _, ok := bySender[from]; !ok {
+			senders = append(senders, from)
+		}
+		bySender[from] = append(bySender[from], tx)
+	}
+
+	txs := []*core.Transaction{}
+	for _, from := range senders {
+		senderTxs := bySender[from]
+		sort.SliceStable(senderTxs, func(i, j int) bool {
+			return senderTxs[i].Nonce < senderTxs[j].Nonce
+		})
+
+		nonce := nextNonce(from)
+		for _, tx := range senderTxs {
+			if tx.Nonce != nonce {
+				continue
+			}
+			txs = append(txs, tx)
+			nonce++
+		}
+	}
+
+	return txs
+}
+
+// Prune 从 pending 中删除 nonce 已经被使用过的交易, 包括已经上链的交易
+func (p *TxPool) Prune(nextNonce func(types.Address) uint64) {
+	stale := []types.Hash{}
+	for _, tx := range p.Pending() {
+		if tx.Nonce < nextNonce(tx.From.Address()) {
+			stale = append(stale, tx.Hash(core.TxHasher{}))
+		}
+	}
+
+	for _, hash := range stale {
+		p.pending.Remove(hash)
+	}
+}
+
 func (p *TxPool) ClearPending() {
 	p.pending.Clear()
 }
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"project-bee/core"
+	"project-bee/crypto"
+	"project-bee/types"
 	"project-bee/util"
 
 	"github.com/stretchr/testify/assert"
@@ -105,3 +107,65 @@ func TestTxSortedMapRemove(t *testing.T) {
 	assert.Equal(t, m.Count(), 0)
 	assert.False(t, m.Contains(tx.Hash(core.TxHasher{})))
 }
+
+func TestTxPoolRestore(t *testing.T) {
+	p := NewTxPool(10)
+	tx := util.NewRandomTransaction(100)
+
+	p.Add(tx)
+	p.ClearPending()
+	p.Add(tx)
+	assert.Equal(t, 0, p.PendingCount())
+
+	p.Restore(tx)
+	assert.Equal(t, 1, p.PendingCount())
+	assert.Equal(t, 1, p.all.Count())
+}
+
+func TestTxPoolExecutable(t *testing.T) {
+	p := NewTxPool(10)
+	privKeyA := crypto.GeneratePrivateKey()
+	privKeyB := crypto.GeneratePrivateKey()
+
+	// A 的交易乱序到达, 缺少 nonce 3
+	for _, nonce := range []uint64{1, 0, 2, 4} {
+		p.Add(newTxWithNonce(t, privKeyA, nonce))
+	}
+	p.Add(newTxWithNonce(t, privKeyB, 5))
+
+	nextNonce := func(addr types.Address) uint64 {
+		if addr == privKeyB.PublicKey().Address() {
+			return 5
+		}
+		return 0
+	}
+
+	txs := p.Executable(nextNonce)
+	assert.Equal(t, 4, len(txs))
+	for i, nonce := range []uint64{0, 1, 2, 5} {
+		assert.Equal(t, nonce, txs[i].Nonce)
+	}
+}
+
+func TestTxPoolPrune(t *testing.T) {
+	p := NewTxPool(10)
+	privKey := crypto.GeneratePrivateKey()
+
+	for nonce := uint64(0); nonce < 4; nonce++ {
+		p.Add(newTxWithNonce(t, privKey, nonce))
+	}
+	assert.True(t, p.HasNonce(privKey.PublicKey().Address(), 1))
+
+	p.Prune(func(types.Address) uint64 { return 2 })
+	assert.Equal(t, 2, p.PendingCount())
+	assert.False(t, p.HasNonce(privKey.PublicKey().Address(), 1))
+	assert.True(t, p.HasNonce(privKey.PublicKey().Address(), 3))
+}
+
+func newTxWithNonce(t *testing.T, privKey crypto.PrivateKey, nonce uint64) *core.Transaction {
+	tx := util.NewRandomTransaction(10)
+	tx.Nonce = nonce
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
+}
@@ -0,0 +1,218 @@
+// Package proto 是 protobuf wire 格式的最小实现, 只支持 .proto 文件中用到的类型
+// 消息的字段编号和类型见同目录下的 core.proto 和 network.proto
+package proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type WireType byte
+
+const (
+	WireVarint  WireType = 0
+	WireFixed64 WireType = 1
+	WireBytes   WireType = 2
+	WireFixed32 WireType = 5
+)
+
+var ErrInvalidWire = errors.New("invalid protobuf wire data")
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// Buffer 按 proto3 的规则编码字段, 默认值不写入
+type Buffer struct {
+	buf []byte
+}
+
+func (b *Buffer) tag(field int, t WireType) {
+	b.buf = appendUvarint(b.buf, uint64(field)<<3|uint64(t))
+}
+
+func (b *Buffer) Uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, WireVarint)
+	b.buf = appendUvarint(b.buf, v)
+}
+
+func (b *Buffer) Uint32(field int, v uint32) {
+	b.Uint64(field, uint64(v))
+}
+
+// int64 按补码编码为 varint, 与 protobuf 的 int64 类型相同
+func (b *Buffer) Int64(field int, v int64) {
+	b.Uint64(field, uint64(v))
+}
+
+func (b *Buffer) Bool(field int, v bool) {
+	if v {
+		b.Uint64(field, 1)
+	}
+}
+
+func (b *Buffer) Bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.tag(field, WireBytes)
+	b.buf = appendUvarint(b.buf, uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *Buffer) String(field int, v string) {
+	b.Bytes(field, []byte(v))
+}
+
+// Message 写入嵌套消息, 空消息也会写入, 用来区分有和没有
+func (b *Buffer) Message(field int, m []byte) {
+	b.tag(field, WireBytes)
+	b.buf = appendUvarint(b.buf, uint64(len(m)))
+	b.buf = append(b.buf, m...)
+}
+
+// PackedUint32 写入 packed 编码的 repeated uint32
+func (b *Buffer) PackedUint32(field int, vs []uint32) {
+	if len(vs) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = appendUvarint(packed, uint64(v))
+	}
+	b.Bytes(field, packed)
+}
+
+func (b *Buffer) Result() []byte {
+	return b.buf
+}
+
+// Field 是解码出的一个字段, Varint 类型的值在 Varint 中, Bytes 类型的值在 Bytes 中
+type Field struct {
+	Num    int
+	Type   WireType
+	Varint uint64
+	Bytes  []byte
+}
+
+// Uint32 检查字段类型并返回 uint32 值
+func (f Field) Uint32() (uint32, error) {
+	if f.Type != WireVarint || f.Varint > 0xffffffff {
+		return 0, fmt.Errorf("field (%d) is not a uint32: %w", f.Num, ErrInvalidWire)
+	}
+	return uint32(f.Varint), nil
+}
+
+func (f Field) Uint64() (uint64, error) {
+	if f.Type != WireVarint {
+		return 0, fmt.Errorf("field (%d) is not a varint: %w", f.Num, ErrInvalidWire)
+	}
+	return f.Varint, nil
+}
+
+func (f Field) Int64() (int64, error) {
+	v, err := f.Uint64()
+	return int64(v), err
+}
+
+func (f Field) Bool() (bool, error) {
+	if f.Type != WireVarint || f.Varint > 1 {
+		return false, fmt.Errorf("field (%d) is not a bool: %w", f.Num, ErrInvalidWire)
+	}
+	return f.Varint == 1, nil
+}
+
+// Raw 返回 Bytes 类型字段的内容, 返回的切片是新分配的
+func (f Field) Raw() ([]byte, error) {
+	if f.Type != WireBytes {
+		return nil, fmt.Errorf("field (%d) is not length delimited: %w", f.Num, ErrInvalidWire)
+	}
+	if len(f.Bytes) == 0 {
+		return nil, nil
+	}
+	return append([]byte(nil), f.Bytes...), nil
+}
+
+// PackedUint32 同时接受 packed 和非 packed 的 repeated uint32
+func (f Field) PackedUint32() ([]uint32, error) {
+	if f.Type == WireVarint {
+		v, err := f.Uint32()
+		return []uint32{v}, err
+	}
+	if f.Type != WireBytes {
+		return nil, fmt.Errorf("field (%d) is not a repeated uint32: %w", f.Num, ErrInvalidWire)
+	}
+
+	var vs []uint32
+	data := f.Bytes
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 || v > 0xffffffff {
+			return nil, fmt.Errorf("field (%d) has an invalid packed value: %w", f.Num, ErrInvalidWire)
+		}
+		vs = append(vs, uint32(v))
+		data = data[n:]
+	}
+	return vs, nil
+}
+
+// Parse 依次把每个字段交给 fn, 未知字段由 fn 忽略即可
+func Parse(data []byte, fn func(Field) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key: %w", ErrInvalidWire)
+		}
+		data = data[n:]
+
+		f := Field{
+			Num:  int(key >> 3),
+			Type: WireType(key & 7),
+		}
+		if f.Num <= 0 || key>>3 > 1<<29-1 {
+			return fmt.Errorf("invalid field number (%d): %w", key>>3, ErrInvalidWire)
+		}
+
+		switch f.Type {
+		case WireVarint:
+			f.Varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("field (%d) has an invalid varint: %w", f.Num, ErrInvalidWire)
+			}
+			data = data[n:]
+		case WireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return fmt.Errorf("field (%d) is truncated: %w", f.Num, ErrInvalidWire)
+			}
+			f.Bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case WireFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("field (%d) is truncated: %w", f.Num, ErrInvalidWire)
+			}
+			f.Bytes = data[:8]
+			data = data[8:]
+		case WireFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("field (%d) is truncated: %w", f.Num, ErrInvalidWire)
+			}
+			f.Bytes = data[:4]
+			data = data[4:]
+		default:
+			return fmt.Errorf("field (%d) has unsupported wire type (%d): %w", f.Num, f.Type, ErrInvalidWire)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferParse(t *testing.T) {
+	buf := &Buffer{}
+	buf.Uint64(1, 300)
+	buf.Int64(2, -1)
+	buf.Bytes(3, []byte("foo"))
+	buf.Message(4, nil)
+	buf.PackedUint32(5, []uint32{1, 2, 3})
+	// 默认值不写入
+	buf.Uint64(6, 0)
+	buf.Bytes(7, nil)
+
+	fields := make(map[int]Field)
+	assert.Nil(t, Parse(buf.Result(), func(f Field) error {
+		fields[f.Num] = f
+		return nil
+	}))
+	assert.Equal(t, 5, len(fields))
+
+	v, err := fields[1].Uint64()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(300), v)
+
+	i, err := fields[2].Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), i)
+
+	b, err := fields[3].Raw()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("foo"), b)
+
+	assert.Equal(t, WireBytes, fields[4].Type)
+
+	vs, err := fields[5].PackedUint32()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, vs)
+
+	_, err = fields[3].Uint64()
+	assert.ErrorIs(t, err, ErrInvalidWire)
+}
+
+func TestParseInvalid(t *testing.T) {
+	buf := &Buffer{}
+	buf.Bytes(1, []byte("foo"))
+	data := buf.Result()
+
+	assert.ErrorIs(t, Parse(data[:len(data)-1], func(Field) error { return nil }), ErrInvalidWire)
+	// 字段编号 0
+	assert.ErrorIs(t, Parse([]byte{0x00, 0x01}, func(Field) error { return nil }), ErrInvalidWire)
+	// 不支持的 wire 类型
+	assert.ErrorIs(t, Parse([]byte{0x0b}, func(Field) error { return nil }), ErrInvalidWire)
+}